@@ -11,6 +11,7 @@ import (
 
 	"github.com/spicery/nutmeg-compiler/pkg/bundler"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/verifier"
 )
 
 // Version is injected at build time via ldflags.
@@ -19,7 +20,7 @@ var Version = "dev"
 const usage = `nutmeg-bundler - creates a SQLITE bundle for the Nutmeg runtime`
 
 func main() {
-	var showHelp, showVersion, migrate bool
+	var showHelp, showVersion, migrate, symbolic, verify bool
 	var bundleFile, inputFile, srcPath string
 	var trim int
 
@@ -32,6 +33,8 @@ func main() {
 	pflag.BoolVarP(&showHelp, "help", "h", false, "Show help")
 	pflag.BoolVar(&showVersion, "version", false, "Show version")
 	pflag.BoolVar(&migrate, "migrate", false, "Perform database migration")
+	pflag.BoolVar(&symbolic, "symbolic", false, "Store functions with labels instead of assembling them (for debugging)")
+	pflag.BoolVar(&verify, "verify", false, "Verify the function objects in an existing bundle instead of adding to it")
 	pflag.StringVarP(&bundleFile, "bundle", "b", "", "Bundle file path (required)")
 	pflag.StringVarP(&inputFile, "input", "i", "", "Input file (defaults to stdin)")
 	pflag.StringVar(&srcPath, "src-path", "", "Source path to annotate the unit with origin")
@@ -59,6 +62,10 @@ func main() {
 	// Check if the bundle file exists.
 	_, err := os.Stat(bundleFile)
 	fileExists := err == nil
+	if verify && !fileExists {
+		fmt.Fprintf(os.Stderr, "Error: bundle file %s does not exist\n", bundleFile)
+		os.Exit(1)
+	}
 
 	// Create bundler.
 	b, err := bundler.NewBundler(bundleFile)
@@ -67,6 +74,7 @@ func main() {
 		os.Exit(1)
 	}
 	defer b.Close()
+	b.SetSymbolic(symbolic)
 
 	// Check if migration is needed.
 	upToDate, err := b.CheckMigration()
@@ -101,6 +109,11 @@ func main() {
 		}
 	}
 
+	if verify {
+		verifyBundle(b)
+		return
+	}
+
 	// Open input.
 	var input io.Reader
 	if inputFile == "" {
@@ -129,7 +142,12 @@ func main() {
 		input = f
 	}
 
-	// Read and parse JSON input.
+	// Read and parse JSON input. All the units are bundled in one
+	// transaction, so that a failure leaves the bundle as it was.
+	if err := b.Begin(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 	decoder := json.NewDecoder(input)
 	for {
 		var node common.Node
@@ -137,16 +155,41 @@ func main() {
 			if err == io.EOF {
 				break
 			}
+			_ = b.Rollback()
 			fmt.Fprintf(os.Stderr, "Error: failed to decode JSON: %v\n", err)
 			os.Exit(1)
 		}
 
 		// Process the unit node.
 		if err := b.ProcessUnit(&node); err != nil {
+			_ = b.Rollback()
 			fmt.Fprintf(os.Stderr, "Error: failed to process unit: %v\n", err)
 			os.Exit(1)
 		}
 	}
+	if err := b.Commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	fmt.Fprintf(os.Stderr, "Bundling completed successfully.\n")
 }
+
+// verifyBundle checks every function object in the bundle, listing the
+// violations and exiting with a failure status if there are any.
+func verifyBundle(b *bundler.Bundler) {
+	bindings, err := b.LoadBindings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	violations := verifier.VerifyBindings(bindings)
+	for _, violation := range violations {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", violation)
+	}
+	if len(violations) > 0 {
+		fmt.Fprintf(os.Stderr, "Verification failed with %d violation(s).\n", len(violations))
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Verification completed successfully.\n")
+}
@@ -8,8 +8,8 @@ import (
 
 	pflag "github.com/spf13/pflag"
 
-	"github.com/spicery/nutmeg-compiler/pkg/checker"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 )
 
 // Version is injected at build time via ldflags.
@@ -94,9 +94,14 @@ func main() {
 	}
 
 	// Perform syntax checking.
-	c := checker.NewChecker()
-	if !c.Check(&tree) {
-		c.ReportErrors()
+	p, err := pipeline.New(pipeline.Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	diagnostics := p.Check(&tree)
+	pipeline.WriteDiagnostics(os.Stderr, diagnostics)
+	if pipeline.HasErrors(diagnostics) {
 		os.Exit(1)
 	}
 
@@ -7,8 +7,8 @@ import (
 
 	pflag "github.com/spf13/pflag"
 
-	"github.com/spicery/nutmeg-compiler/pkg/codegen"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 )
 
 // Version is injected at build time via ldflags.
@@ -22,6 +22,8 @@ func main() {
 	var srcPath = pflag.String("src-path", "", "Source path to annotate the unit with origin")
 	var trim = pflag.Int("trim", 0, "Trim names for display purposes")
 	var noSpans = pflag.Bool("no-spans", false, "Suppress span information in output")
+	var builtinsFile = pflag.String("builtins", "", "YAML file containing the registry of builtins (optional)")
+	var noOptimize = pflag.Bool("no-optimize", false, "Skip the peephole optimizer (for debugging)")
 	var version = pflag.Bool("version", false, "Print version and exit")
 
 	pflag.Parse()
@@ -48,11 +50,21 @@ func main() {
 		root.Options["src"] = *srcPath
 	}
 
-	// Create code generator and process the tree.
-	cg := codegen.NewCodeGenerator()
-	err := cg.Generate(&root)
+	opts := pipeline.Options{NoOptimize: *noOptimize}
+	if err := opts.LoadFiles("", "", *builtinsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	p, err := pipeline.New(opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error during code generation: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Generate code for the tree.
+	diagnostics := p.Generate(&root)
+	pipeline.WriteDiagnostics(os.Stderr, diagnostics)
+	if pipeline.HasErrors(diagnostics) {
 		os.Exit(1)
 	}
 
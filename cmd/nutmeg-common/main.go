@@ -7,11 +7,8 @@ import (
 
 	pflag "github.com/spf13/pflag"
 
-	"github.com/spicery/nutmeg-compiler/pkg/checker"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
-	"github.com/spicery/nutmeg-compiler/pkg/parser"
-	"github.com/spicery/nutmeg-compiler/pkg/rewriter"
-	"github.com/spicery/nutmeg-compiler/pkg/tokenizer"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 )
 
 // Version is injected at build time via ldflags.
@@ -32,7 +29,7 @@ const DEFAULT_FORMAT = "JSON"
 
 func main() {
 	var showHelp, showVersion, noSpans, debug, skipOptional bool
-	var inputFile, outputFile, tokenRulesFile, rewriteRulesFile, format, srcPath string
+	var inputFile, outputFile, tokenRulesFile, rewriteRulesFile, builtinsFile, format, srcPath string
 	var trim int
 
 	pflag.Usage = func() {
@@ -49,6 +46,7 @@ func main() {
 	pflag.StringVar(&srcPath, "src-path", "", "Source path to annotate the unit node")
 	pflag.StringVar(&tokenRulesFile, "token-rules", "", "YAML file containing tokenizer rules (optional)")
 	pflag.StringVar(&rewriteRulesFile, "rewrite-rules", "", "YAML file containing rewrite rules (optional)")
+	pflag.StringVar(&builtinsFile, "builtins", "", "YAML file containing the registry of builtins (optional)")
 	pflag.StringVarP(&format, "format", "f", DEFAULT_FORMAT, "Output format (JSON, XML, etc.)")
 	pflag.IntVar(&trim, "trim", 0, "Trim names for display purposes")
 	pflag.BoolVar(&noSpans, "no-spans", false, "Suppress span information in output")
@@ -92,118 +90,28 @@ func main() {
 	}
 	inputString := string(inputBytes)
 
-	// Phase 1: Tokenization
-	var t *tokenizer.Tokenizer
-	if tokenRulesFile != "" {
-		rules, err := tokenizer.LoadRulesFile(tokenRulesFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading token rules file '%s': %v\n", tokenRulesFile, err)
-			os.Exit(1)
-		}
-
-		tokenizerRules, err := tokenizer.ApplyRulesToDefaults(rules)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error applying token rules: %v\n", err)
-			os.Exit(1)
-		}
-		t = tokenizer.NewTokenizerWithRules(inputString, tokenizerRules)
-	} else {
-		t = tokenizer.NewTokenizer(inputString)
+	opts := pipeline.Options{Debug: debug, SkipOptional: skipOptional, StopAfter: pipeline.PhaseRewrite}
+	if err := opts.LoadFiles(tokenRulesFile, rewriteRulesFile, builtinsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-
-	tokens, err := t.Tokenize()
+	p, err := pipeline.New(opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Tokenization error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Phase 2: Parsing - use the tokens directly without conversion.
-	p := parser.NewParserFromTokens(tokens, true)
-
-	tree := &common.Node{
-		Name:     "unit",
-		Options:  map[string]string{},
-		Children: []*common.Node{},
-	}
+	// Phases 1 to 4: tokenization, parsing, syntax checking and rewriting.
+	source := pipeline.SourceFile{SrcPath: inputFile, Contents: inputString}
 	if srcPath != "" {
-		tree.Options["src"] = srcPath
-	} else if inputFile != "" {
-		tree.Options["src"] = inputFile
-	}
-
-	var node *common.Node
-	for node, err = p.TryReadExpr(); node != nil; node, err = p.TryReadExpr() {
-		if len(tree.Children) == 0 {
-			tree.Span = node.Span
-		} else {
-			tree.Span = *tree.Span.ToSpan(&node.Span)
-		}
-		tree.Children = append(tree.Children, node)
-		// Check for semicolon separator.
-		isSemicolon := p.TryReadSemiColon()
-		if !isSemicolon {
-			if p.PeekToken() != nil {
-				fmt.Fprintf(os.Stderr, "Unexpected token at end of expression: `%s`\n", p.PeekToken().Text)
-				os.Exit(1)
-			}
-			break
-		}
+		source.SrcPath = srcPath
 	}
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Parse error: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Phase 2.5: Syntax checking
-	c := checker.NewChecker()
-	if !c.Check(tree) {
-		c.ReportErrors()
+	result := p.Compile([]pipeline.SourceFile{source})
+	pipeline.WriteDiagnostics(os.Stderr, result.Diagnostics)
+	if !result.OK() {
 		os.Exit(1)
 	}
-
-	// Phase 3: Rewriting (optional)
-	var rewriteConfig *rewriter.RewriteConfig
-	if rewriteRulesFile != "" {
-		rewriteConfig, err = rewriter.LoadRewriteConfig(rewriteRulesFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading rewrite configuration file: %v\n", err)
-			os.Exit(1)
-		}
-
-		r, err := rewriter.NewRewriterWithOptions(rewriteConfig, debug, skipOptional)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating rewriter: %v\n", err)
-			os.Exit(1)
-		}
-
-		tree, _ = r.Rewrite(tree)
-	} else {
-		// Use default rewrite rules.
-		rewriteConfig, err = rewriter.LoadRewriteConfigFromString(rewriter.DefaultRewriteRules)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading default rewrite rules: %v\n", err)
-			os.Exit(1)
-		}
-
-		r, err := rewriter.NewRewriterWithOptions(rewriteConfig, debug, skipOptional)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating rewriter: %v\n", err)
-			os.Exit(1)
-		}
-
-		tree, _ = r.Rewrite(tree)
-	}
-
-	// TODO: Should the checking be HERE?
-	// Phase 3.5: Syntax checking
-	{
-		// c := checker.NewChecker()
-		// if !c.Check(tree) {
-		// 	c.ReportErrors()
-		// 	os.Exit(1)
-		// }
-	}
+	tree := result.Units[0].Rewritten
 
 	// Determine output format.
 	printFunc := common.PickPrintFunc(format)
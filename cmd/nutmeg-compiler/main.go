@@ -2,19 +2,11 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os"
 
 	pflag "github.com/spf13/pflag"
 
-	"github.com/spicery/nutmeg-compiler/pkg/bundler"
-	"github.com/spicery/nutmeg-compiler/pkg/checker"
-	"github.com/spicery/nutmeg-compiler/pkg/codegen"
-	"github.com/spicery/nutmeg-compiler/pkg/common"
-	"github.com/spicery/nutmeg-compiler/pkg/parser"
-	"github.com/spicery/nutmeg-compiler/pkg/resolver"
-	"github.com/spicery/nutmeg-compiler/pkg/rewriter"
-	"github.com/spicery/nutmeg-compiler/pkg/tokenizer"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 )
 
 // Version is injected at build time via ldflags.
@@ -26,6 +18,14 @@ This command pipes together tokenization, parsing, syntax checking, rewriting,
 resolution, code generation, and bundling in memory, providing a complete
 compiler pipeline for Nutmeg source code.
 
+Either a single source file is compiled (--input) or a whole project
+(--project). In project mode every *.mod folder below the project directory
+is a module and every .nutmeg file inside it is compiled into the bundle.
+The imports.nutport file of each module says which other modules it can
+refer to, and bindings are named module::name in the bundle. Diagnostics
+for all files are reported together and nothing is written to the bundle if
+any file fails to compile.
+
 Usage:
   nutmeg-compiler [options]
 
@@ -35,8 +35,8 @@ Options:
 const DEFAULT_FORMAT = "JSON"
 
 func main() {
-	var showHelp, showVersion, debug, skipOptional bool
-	var inputFile, bundleFile, tokenRulesFile, rewriteRulesFile, format string
+	var showHelp, showVersion, debug, skipOptional, noOptimize, symbolic bool
+	var inputFile, projectDir, bundleFile, tokenRulesFile, rewriteRulesFile, builtinsFile, format string
 
 	pflag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s\n", usage)
@@ -47,10 +47,14 @@ func main() {
 	pflag.BoolVar(&showVersion, "version", false, "Show version")
 	pflag.BoolVar(&debug, "debug", false, "Enable debug output to stderr")
 	pflag.BoolVar(&skipOptional, "skip-optional", false, "Skip optional rewrite passes")
+	pflag.BoolVar(&noOptimize, "no-optimize", false, "Skip the peephole optimizer (for debugging)")
+	pflag.BoolVar(&symbolic, "symbolic", false, "Store functions with labels instead of assembling them (for debugging)")
 	pflag.StringVarP(&inputFile, "input", "i", "", "Input file (does NOT default to stdin, used for srcPath)")
+	pflag.StringVarP(&projectDir, "project", "p", "", "Project directory containing *.mod module folders")
 	pflag.StringVarP(&bundleFile, "bundle", "b", "", "Bundle file path (required)")
 	pflag.StringVar(&tokenRulesFile, "token-rules", "", "YAML file containing tokenizer rules (optional)")
 	pflag.StringVar(&rewriteRulesFile, "rewrite-rules", "", "YAML file containing rewrite rules (optional)")
+	pflag.StringVar(&builtinsFile, "builtins", "", "YAML file containing the registry of builtins (optional)")
 	pflag.StringVarP(&format, "format", "f", DEFAULT_FORMAT, "Output format (JSON, XML, etc.)")
 
 	pflag.Parse()
@@ -72,9 +76,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Input file is mandatory.
-	if inputFile == "" {
-		fmt.Fprintf(os.Stderr, "Error: --input flag is required\n")
+	// Exactly one of the input file or the project directory is mandatory.
+	if inputFile == "" && projectDir == "" {
+		fmt.Fprintf(os.Stderr, "Error: --input or --project flag is required\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+	if inputFile != "" && projectDir != "" {
+		fmt.Fprintf(os.Stderr, "Error: --input and --project cannot be used together\n")
 		pflag.Usage()
 		os.Exit(1)
 	}
@@ -86,183 +95,52 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Open input file.
-	file, err := os.Open(inputFile) // #nosec G304 - CLI tool reads user-specified input files
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening input file: %v\n", err)
-		os.Exit(1)
-	}
-	defer file.Close()
-	srcPath := inputFile
-
-	// Read input into string.
-	inputBytes, err := io.ReadAll(file)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-		os.Exit(1)
-	}
-	inputString := string(inputBytes)
-
-	// Phase 1: Tokenization.
-	var t *tokenizer.Tokenizer
-	if tokenRulesFile != "" {
-		rules, err := tokenizer.LoadRulesFile(tokenRulesFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading token rules file '%s': %v\n", tokenRulesFile, err)
-			os.Exit(1)
-		}
-
-		tokenizerRules, err := tokenizer.ApplyRulesToDefaults(rules)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error applying token rules: %v\n", err)
-			os.Exit(1)
-		}
-		t = tokenizer.NewTokenizerWithRules(inputString, tokenizerRules)
-	} else {
-		t = tokenizer.NewTokenizer(inputString)
+	opts := pipeline.Options{
+		Debug:        debug,
+		SkipOptional: skipOptional,
+		NoOptimize:   noOptimize,
+		Symbolic:     symbolic,
+		Bundle:       bundleFile,
 	}
-
-	tokens, err := t.Tokenize()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Tokenization error: %v\n", err)
+	if err := opts.LoadFiles(tokenRulesFile, rewriteRulesFile, builtinsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Phase 2: Parsing.
-	p := parser.NewParserFromTokens(tokens, true)
-
-	tree := &common.Node{
-		Name:     "unit",
-		Options:  map[string]string{},
-		Children: []*common.Node{},
-	}
-	if srcPath != "" {
-		tree.Options["src"] = srcPath
-	}
-
-	var node *common.Node
-	for node, err = p.TryReadExpr(); node != nil; node, err = p.TryReadExpr() {
-		if len(tree.Children) == 0 {
-			tree.Span = node.Span
-		} else {
-			tree.Span = *tree.Span.ToSpan(&node.Span)
-		}
-		tree.Children = append(tree.Children, node)
-		// Check for semicolon separator.
-		isSemicolon := p.TryReadSemiColon()
-		if !isSemicolon {
-			if p.PeekToken() != nil {
-				fmt.Fprintf(os.Stderr, "Unexpected token at end of expression: `%s`\n", p.PeekToken().Text)
-				os.Exit(1)
-			}
-			break
-		}
-	}
-
+	p, err := pipeline.New(opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Parse error: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Phase 3: Syntax checking.
-	c := checker.NewChecker()
-	if !c.Check(tree) {
-		c.ReportErrors()
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Phase 4: Rewriting.
-	var rewriteConfig *rewriter.RewriteConfig
-	if rewriteRulesFile != "" {
-		rewriteConfig, err = rewriter.LoadRewriteConfig(rewriteRulesFile)
+	// Work out which source files make up this compilation.
+	var sources []pipeline.SourceFile
+	if projectDir != "" {
+		sources, err = pipeline.DiscoverProject(projectDir)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading rewrite configuration file: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-
-		r, err := rewriter.NewRewriterWithOptions(rewriteConfig, debug, skipOptional)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating rewriter: %v\n", err)
+		if len(sources) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: no .nutmeg files found in any *.mod folder of %s\n", projectDir)
 			os.Exit(1)
 		}
-
-		tree, _ = r.Rewrite(tree)
 	} else {
-		// Use default rewrite rules.
-		rewriteConfig, err = rewriter.LoadRewriteConfigFromString(rewriter.DefaultRewriteRules)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading default rewrite rules: %v\n", err)
-			os.Exit(1)
-		}
-
-		r, err := rewriter.NewRewriterWithOptions(rewriteConfig, debug, skipOptional)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating rewriter: %v\n", err)
-			os.Exit(1)
-		}
-
-		tree, _ = r.Rewrite(tree)
-	}
-
-	// Phase 5: Resolution.
-	res := resolver.NewResolver()
-	if err := res.Resolve(tree); err != nil {
-		fmt.Fprintf(os.Stderr, "Resolution error: %v\n", err)
-		os.Exit(1)
+		sources = []pipeline.SourceFile{{Path: inputFile, SrcPath: inputFile}}
 	}
 
-	// Phase 6: Code generation.
-	cg := codegen.NewCodeGenerator()
-	if err := cg.Generate(tree); err != nil {
-		fmt.Fprintf(os.Stderr, "Code generation error: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Phase 7: Bundling.
-	// Check if the bundle file exists.
-	_, err = os.Stat(bundleFile)
-	fileExists := err == nil
-
-	// Create bundler.
-	b, err := bundler.NewBundler(bundleFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to create bundler: %v\n", err)
-		os.Exit(1)
-	}
-	defer b.Close()
-
-	// Check if migration is needed.
-	upToDate, err := b.CheckMigration()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to check migration status: %v\n", err)
-		os.Exit(1)
-	}
-
-	if !upToDate {
-		// If the file didn't exist before, auto-migrate.
-		if !fileExists {
-			// Fresh database - auto-migrate.
-			if err := b.Migrate(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error: failed to migrate database: %v\n", err)
-				os.Exit(1)
-			}
-			if debug {
-				fmt.Fprintf(os.Stderr, "Database initialized successfully.\n")
-			}
-		} else {
-			// Existing database needs migration - fail with error.
-			fmt.Fprintf(os.Stderr, "Error: database schema is not up to date. Please run migration separately.\n")
-			os.Exit(1)
+	result := p.Compile(sources)
+	pipeline.WriteDiagnostics(os.Stderr, result.Diagnostics)
+	if !result.OK() {
+		if failed := result.Failed(); len(sources) > 1 && failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d files failed to compile\n", failed, len(sources))
 		}
-	}
-
-	// Process the unit node.
-	if err := b.ProcessUnit(tree); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to process unit: %v\n", err)
 		os.Exit(1)
 	}
 
 	if debug {
+		if result.Skipped() {
+			fmt.Fprintf(os.Stderr, "Nothing has changed since the bundle was last compiled.\n")
+		}
 		fmt.Fprintf(os.Stderr, "Compilation completed successfully.\n")
 	}
 }
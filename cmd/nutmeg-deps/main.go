@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	pflag "github.com/spf13/pflag"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/depgraph"
+)
+
+// Version is injected at build time via ldflags.
+var Version = "dev"
+
+const usage = `nutmeg-deps - analyses the dependencies between the bindings of a bundle
+
+This command reads the dependencies recorded in a bundle and does one of the
+following:
+
+  --order    lists the lazy bindings in an order they can be initialised in
+  --cycles   reports cycles among lazy bindings, which would fail at runtime
+  --prune    deletes every binding that no entry point can reach, which
+             is refused if the bundle has no entry points
+  --graph    draws the dependency graph in DOT or MERMAID format
+
+A lazy binding depends on another if its initialiser refers to it, either
+directly or through functions, which are assumed to be called.
+
+Usage:
+  nutmeg-deps --bundle FILE (--order | --cycles | --prune | --graph FORMAT)
+
+Options:
+`
+
+func main() {
+	var showHelp, showVersion, order, cycles, prune bool
+	var bundleFile, graphFormat string
+
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n", usage)
+		pflag.PrintDefaults()
+	}
+
+	pflag.BoolVarP(&showHelp, "help", "h", false, "Show help")
+	pflag.BoolVar(&showVersion, "version", false, "Show version")
+	pflag.StringVarP(&bundleFile, "bundle", "b", "", "Bundle file path (required)")
+	pflag.BoolVar(&order, "order", false, "List the lazy bindings in initialisation order")
+	pflag.BoolVar(&cycles, "cycles", false, "Report cycles among lazy bindings")
+	pflag.BoolVar(&prune, "prune", false, "Delete the bindings that no entry point can reach (needs an entry point)")
+	pflag.StringVar(&graphFormat, "graph", "", "Draw the dependency graph (DOT or MERMAID)")
+
+	pflag.Parse()
+
+	if showHelp {
+		pflag.Usage()
+		os.Exit(0)
+	}
+
+	if showVersion {
+		fmt.Printf("nutmeg-deps version %s\n", Version)
+		os.Exit(0)
+	}
+
+	// Bundle file is mandatory.
+	if bundleFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: --bundle flag is required\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	if len(pflag.Args()) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: Unexpected positional arguments.\n\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	modes := 0
+	for _, selected := range []bool{order, cycles, prune, graphFormat != ""} {
+		if selected {
+			modes++
+		}
+	}
+	if modes != 1 {
+		fmt.Fprintf(os.Stderr, "Error: exactly one of --order, --cycles, --prune or --graph is required\n\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	var printGraph func(*common.Graph, io.Writer)
+	if graphFormat != "" {
+		var err error
+		printGraph, err = common.PickGraphPrintFunc(graphFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Opening a missing file would silently create an empty bundle.
+	if _, err := os.Stat(bundleFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot open bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to open bundle: %v\n", err)
+		os.Exit(1)
+	}
+	defer b.Close()
+
+	upToDate, err := b.CheckMigration()
+	if err != nil || !upToDate {
+		fmt.Fprintf(os.Stderr, "Error: %s is not an up-to-date bundle\n", bundleFile)
+		os.Exit(1)
+	}
+
+	graph, err := depgraph.Load(b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case order:
+		names, err := graph.InitOrder()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	case cycles:
+		found := graph.Cycles()
+		for _, cycle := range found {
+			fmt.Fprintf(os.Stderr, "Error: lazy bindings depend on themselves: %s\n", cycle)
+		}
+		if len(found) > 0 {
+			fmt.Fprintf(os.Stderr, "Found %d cycle(s).\n", len(found))
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "No cycles found.\n")
+	case prune:
+		unreachable, err := graph.Prunable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := b.DeleteBindings(unreachable); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, name := range unreachable {
+			fmt.Println(name)
+		}
+		fmt.Fprintf(os.Stderr, "Pruned %d binding(s).\n", len(unreachable))
+	default:
+		printGraph(graph.ToGraph(), os.Stdout)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	pflag "github.com/spf13/pflag"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/disasm"
+)
+
+// Version is injected at build time via ldflags.
+var Version = "dev"
+
+const usage = `nutmeg-disasm - lists the contents of a Nutmeg bundle
+
+This command prints each binding of a bundle with its details, dependencies,
+annotations and a listing of its instructions, in which jumps are shown as
+arrows to labels. The bindings can be selected by name (glob patterns are
+allowed), by annotation or by being an entry point. Several --name options
+select any of the names, several --annotation options require all of them.
+
+Usage:
+  nutmeg-disasm --bundle FILE [--name NAME]... [--annotation KEY[=VALUE]]...
+                [--entry-points] [--json]
+
+Options:
+`
+
+func main() {
+	var showHelp, showVersion, entryPoints, asJSON bool
+	var bundleFile string
+	var names, annotations []string
+
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n", usage)
+		pflag.PrintDefaults()
+	}
+
+	pflag.BoolVarP(&showHelp, "help", "h", false, "Show help")
+	pflag.BoolVar(&showVersion, "version", false, "Show version")
+	pflag.StringVarP(&bundleFile, "bundle", "b", "", "Bundle file path (required)")
+	pflag.StringArrayVarP(&names, "name", "n", nil, "Only show bindings whose name matches this pattern")
+	pflag.StringArrayVarP(&annotations, "annotation", "a", nil, "Only show bindings with this annotation")
+	pflag.BoolVarP(&entryPoints, "entry-points", "e", false, "Only show entry points")
+	pflag.BoolVar(&asJSON, "json", false, "Output JSON instead of a listing")
+
+	pflag.Parse()
+
+	if showHelp {
+		pflag.Usage()
+		os.Exit(0)
+	}
+
+	if showVersion {
+		fmt.Printf("nutmeg-disasm version %s\n", Version)
+		os.Exit(0)
+	}
+
+	// Bundle file is mandatory.
+	if bundleFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: --bundle flag is required\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	if len(pflag.Args()) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: Unexpected positional arguments. Use --name flag instead.\n\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	filter := disasm.Filter{Names: names, Annotations: annotations, EntryPoints: entryPoints}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Opening a missing file would silently create an empty bundle.
+	if _, err := os.Stat(bundleFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot open bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to open bundle: %v\n", err)
+		os.Exit(1)
+	}
+	defer b.Close()
+
+	upToDate, err := b.CheckMigration()
+	if err != nil || !upToDate {
+		fmt.Fprintf(os.Stderr, "Error: %s is not an up-to-date bundle\n", bundleFile)
+		os.Exit(1)
+	}
+
+	bundle, err := disasm.Load(b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	bundle = bundle.Select(filter)
+
+	if asJSON {
+		err = bundle.WriteJSON(os.Stdout)
+	} else {
+		err = bundle.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	pflag "github.com/spf13/pflag"
+
+	"github.com/spicery/nutmeg-compiler/pkg/formatter"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
+)
+
+// Version is injected at build time via ldflags.
+var Version = "dev"
+
+const usage = `nutmeg-fmt - formats Nutmeg source code
+
+This tool parses each file and prints it back out in the canonical layout:
+one statement to a line, form bodies indented by four spaces, spaces around
+operators and each form closed by the closer that names it (endif rather
+than end). Comments and single blank lines between statements are kept.
+Formatting never changes the parse tree and formatting twice changes
+nothing.
+
+With no files, the standard input is formatted to the standard output.
+
+Usage:
+  nutmeg-fmt [options] [FILE...]
+
+Options:
+`
+
+func main() {
+	var showHelp, showVersion, check, write bool
+	var tokenRulesFile string
+
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n", usage)
+		pflag.PrintDefaults()
+	}
+
+	pflag.BoolVarP(&showHelp, "help", "h", false, "Show help")
+	pflag.BoolVar(&showVersion, "version", false, "Show version")
+	pflag.BoolVar(&check, "check", false, "List the files that are not formatted and exit with status 1 if there are any")
+	pflag.BoolVarP(&write, "write", "w", false, "Write the formatted source back to each file")
+	pflag.StringVar(&tokenRulesFile, "token-rules", "", "YAML file containing tokenizer rules (optional)")
+
+	pflag.Parse()
+
+	if showHelp {
+		pflag.Usage()
+		os.Exit(0)
+	}
+
+	if showVersion {
+		fmt.Printf("nutmeg-fmt version %s\n", Version)
+		os.Exit(0)
+	}
+
+	if check && write {
+		fmt.Fprintf(os.Stderr, "Error: --check and --write cannot be used together\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	files := pflag.Args()
+	if write && len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: --write needs files to write to\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	var opts pipeline.Options
+	if err := opts.LoadFiles(tokenRulesFile, "", ""); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	p, err := pipeline.New(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	ok := true
+	for _, file := range files {
+		text, err := read(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			ok = false
+			continue
+		}
+		formatted, diagnostics, err := format(p, file, text)
+		if pipeline.HasErrors(diagnostics) {
+			pipeline.WriteDiagnostics(os.Stderr, diagnostics)
+			ok = false
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", file, err)
+			ok = false
+			continue
+		}
+		switch {
+		case check:
+			if formatted != text {
+				fmt.Println(file)
+				ok = false
+			}
+		case write:
+			if formatted == text {
+				continue
+			}
+			if err := rewrite(file, formatted); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				ok = false
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// read returns the text of a file, or of the standard input if the file is
+// "-".
+func read(file string) (string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", file, err)
+	}
+	return string(data), nil
+}
+
+// rewrite replaces the contents of a file, keeping its permissions.
+func rewrite(file string, text string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(text), info.Mode().Perm())
+}
+
+// format tokenizes, parses and formats the text of a file. A file that
+// cannot be tokenized or parsed cannot be formatted, so the diagnostics are
+// returned instead.
+func format(p *pipeline.Pipeline, file string, text string) (string, []pipeline.Diagnostic, error) {
+	tokens, diagnostics := p.Tokenize(file, text)
+	if pipeline.HasErrors(diagnostics) {
+		return "", diagnostics, nil
+	}
+	tree, diagnostics := p.Parse(&pipeline.SourceFile{SrcPath: file, Contents: text}, tokens)
+	if pipeline.HasErrors(diagnostics) {
+		return "", diagnostics, nil
+	}
+	formatted, err := formatter.Format(tree, tokens, text)
+	return formatted, diagnostics, err
+}
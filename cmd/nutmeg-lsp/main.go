@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	pflag "github.com/spf13/pflag"
+
+	"github.com/spicery/nutmeg-compiler/pkg/lsp"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
+)
+
+// Version is injected at build time via ldflags.
+var Version = "dev"
+
+const usage = `nutmeg-lsp - language server for the Nutmeg programming language
+
+This tool speaks the Language Server Protocol over stdin and stdout. Each
+open document is tokenized, parsed, checked, rewritten and resolved as it
+changes, which provides:
+  - Diagnostics for the document
+  - Go to definition and find references, by resolved identifier
+  - Hover with the scope of an identifier and whether it is var or const
+  - Document symbols for the top-level def's
+  - Semantic tokens from the token types
+
+Documents are analysed one at a time, so references into other modules are
+not followed.
+
+Usage:
+  nutmeg-lsp [options]
+
+Options:
+`
+
+func main() {
+	var showHelp, showVersion bool
+	var tokenRulesFile, rewriteRulesFile, builtinsFile string
+
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n", usage)
+		pflag.PrintDefaults()
+	}
+
+	pflag.BoolVarP(&showHelp, "help", "h", false, "Show help")
+	pflag.BoolVar(&showVersion, "version", false, "Show version")
+	pflag.StringVar(&tokenRulesFile, "token-rules", "", "YAML file containing tokenizer rules (optional)")
+	pflag.StringVar(&rewriteRulesFile, "rewrite-rules", "", "YAML file containing rewrite rules (optional)")
+	pflag.StringVar(&builtinsFile, "builtins", "", "YAML file containing the registry of builtins (optional)")
+
+	pflag.Parse()
+
+	if showHelp {
+		pflag.Usage()
+		os.Exit(0)
+	}
+
+	if showVersion {
+		fmt.Printf("nutmeg-lsp version %s\n", Version)
+		os.Exit(0)
+	}
+
+	// Reject any positional arguments.
+	if len(pflag.Args()) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: Unexpected positional arguments.\n\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	var opts pipeline.Options
+	if err := opts.LoadFiles(tokenRulesFile, rewriteRulesFile, builtinsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	p, err := pipeline.New(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The protocol asks for exit code 1 if the client exits without first
+	// asking the server to shut down.
+	server := lsp.NewServer(p, os.Stdin, os.Stdout)
+	server.Version = Version
+	if err := server.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
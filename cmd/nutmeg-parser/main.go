@@ -8,6 +8,7 @@ import (
 
 	"github.com/spicery/nutmeg-compiler/pkg/common"
 	"github.com/spicery/nutmeg-compiler/pkg/parser"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 )
 
 // Version is injected at build time via ldflags.
@@ -31,55 +32,38 @@ func main() {
 		os.Exit(0)
 	}
 
-	p := parser.NewParser(os.Stdin, true)
-
-	// Select the appropriate print function based on format
-	printFunc := common.PickPrintFunc(*format)
-
-	tree := &common.Node{
-		Name:     "unit",
-		Options:  map[string]string{},
-		Children: []*common.Node{},
-	}
-	if srcPath != nil && *srcPath != "" {
-		tree.Options["src"] = *srcPath
-	}
-
-	var node *common.Node
-	var err error
-	for node, err = p.TryReadExpr(); node != nil; node, err = p.TryReadExpr() {
-		if len(tree.Children) == 0 {
-			tree.Span = node.Span
-		} else {
-			tree.Span = *tree.Span.ToSpan(&node.Span)
+	// Read the tokens, one JSON object per line.
+	tokens := make([]*common.Token, 0)
+	it := parser.NewScannerTokenIterator(os.Stdin)
+	for {
+		token, err := it.GetToken()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			os.Exit(1)
 		}
-		tree.Children = append(tree.Children, node)
-		// Check for semicolon separator
-		is_semicolon := p.TryReadSemiColon()
-		if !is_semicolon {
-			if p.PeekToken() != nil {
-				fmt.Fprintf(os.Stderr, "Unexpected token at end of expression: `%s`\n", p.PeekToken().Text)
-				os.Exit(1)
-			}
+		if token == nil {
 			break
 		}
+		tokens = append(tokens, token)
+	}
+
+	p, err := pipeline.New(pipeline.Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
+	tree, diagnostics := p.Parse(&pipeline.SourceFile{SrcPath: *srcPath}, tokens)
+
+	// Select the appropriate print function based on format. The tree is
+	// printed even if parsing failed part way.
+	printFunc := common.PickPrintFunc(*format)
 	printFunc(tree, "  ", os.Stdout, &common.PrintOptions{
 		TrimTokenOnOutput: *trim,
 		IncludeSpans:      !*noSpans,
 	})
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-		os.Exit(1)
-	}
-	token, err := p.GetToken()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-		os.Exit(1)
-	}
-	if token != nil {
-		fmt.Fprintf(os.Stderr, "parsing incomplete, next token: '%s' of type %s at line %d, char %d\n", token.Text, token.Type, token.Span.StartLine, token.Span.StartColumn)
+	pipeline.WriteDiagnostics(os.Stderr, diagnostics)
+	if pipeline.HasErrors(diagnostics) {
 		os.Exit(1)
 	}
 }
@@ -9,7 +9,7 @@ import (
 	pflag "github.com/spf13/pflag"
 
 	"github.com/spicery/nutmeg-compiler/pkg/common"
-	"github.com/spicery/nutmeg-compiler/pkg/resolver"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 )
 
 // Version is injected at build time via ldflags.
@@ -33,7 +33,7 @@ const DEFAULT_FORMAT = "JSON"
 
 func main() {
 	var showHelp, showVersion, noSpans bool
-	var inputFile, outputFile, format string
+	var inputFile, outputFile, builtinsFile, format string
 	var trim int
 
 	pflag.Usage = func() {
@@ -46,6 +46,7 @@ func main() {
 	pflag.StringVarP(&inputFile, "input", "i", "", "Input file (defaults to stdin)")
 	pflag.StringVarP(&outputFile, "output", "o", "", "Output file (defaults to stdout)")
 	pflag.StringVarP(&format, "format", "f", DEFAULT_FORMAT, "Output format (JSON, XML, etc.)")
+	pflag.StringVar(&builtinsFile, "builtins", "", "YAML file containing the registry of builtins (optional)")
 	pflag.IntVar(&trim, "trim", 0, "Trim names for display purposes")
 	pflag.BoolVar(&noSpans, "no-spans", false, "Suppress span information in output")
 
@@ -94,10 +95,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	opts := pipeline.Options{}
+	if err := opts.LoadFiles("", "", builtinsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	p, err := pipeline.New(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Perform resolution.
-	r := resolver.NewResolver()
-	if err := r.Resolve(&tree); err != nil {
-		fmt.Fprintf(os.Stderr, "Error resolving identifiers: %v\n", err)
+	diagnostics := p.Resolve(&tree, "", nil)
+	pipeline.WriteDiagnostics(os.Stderr, diagnostics)
+	if pipeline.HasErrors(diagnostics) {
 		os.Exit(1)
 	}
 
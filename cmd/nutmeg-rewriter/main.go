@@ -9,6 +9,7 @@ import (
 	pflag "github.com/spf13/pflag"
 
 	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 	"github.com/spicery/nutmeg-compiler/pkg/rewriter"
 )
 
@@ -21,7 +22,7 @@ const DEFAULT_FORMAT = "JSON"
 
 func main() {
 	var showHelp, showVersion, noSpans, makeRules, debug, skipOptional bool
-	var inputFile, outputFile, configFile, format string
+	var inputFile, outputFile, configFile, builtinsFile, format string
 	var trim, maxRewrites int
 
 	// Set up custom usage function that includes the description and flags
@@ -38,6 +39,7 @@ func main() {
 	pflag.StringVarP(&inputFile, "input", "i", "", "Input file (defaults to stdin)")
 	pflag.StringVarP(&outputFile, "output", "o", "", "Output file (defaults to stdout)")
 	pflag.StringVar(&configFile, "rewrite-rules", "", "YAML file containing rewrite rules")
+	pflag.StringVar(&builtinsFile, "builtins", "", "YAML file containing the registry of builtins (optional)")
 	pflag.StringVarP(&format, "format", "f", DEFAULT_FORMAT, "Output format (JSON, XML, etc.)")
 	pflag.IntVar(&trim, "trim", 0, "Trim names for display purposes")
 	pflag.BoolVar(&noSpans, "no-spans", false, "Suppress span information in output")
@@ -69,28 +71,22 @@ func main() {
 
 	printFunc := common.PickPrintFunc(format)
 
-	var rewriteConfig *rewriter.RewriteConfig
-	var err error
-	if configFile != "" {
-		rewriteConfig, err = rewriter.LoadRewriteConfig(configFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading rewrite configuration file: %v\n", err)
-			os.Exit(1)
-		}
-	} else {
-		rewriteConfig, err = rewriter.LoadRewriteConfigFromString(rewriter.DefaultRewriteRules)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading default rewrite rules: %v\n", err)
-			os.Exit(1)
-		}
+	opts := pipeline.Options{Debug: debug, SkipOptional: skipOptional}
+	if err := opts.LoadFiles("", configFile, builtinsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-	var r *rewriter.Rewriter
-	if rewriteConfig != nil {
-		r, err = rewriter.NewRewriterWithOptions(rewriteConfig, debug, skipOptional)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating rewriter: %v\n", err)
-			os.Exit(1)
-		}
+	p, err := pipeline.New(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	// The same rewriter is used for every iteration, so that the single
+	// passes are only run once.
+	r, err := p.NewRewriter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating rewriter: %v\n", err)
+		os.Exit(1)
 	}
 
 	// Determine input source
@@ -125,48 +121,46 @@ func main() {
 		os.Exit(1)
 	}
 
-	if r != nil {
-		// Repeat rewriting until no changes occur (fixed point).
-		iteration := 0
-		reachedFixedPoint := false
+	// Repeat rewriting until no changes occur (fixed point).
+	iteration := 0
+	reachedFixedPoint := false
 
-		for {
-			iteration++
+	for {
+		iteration++
 
-			// Check if we've hit the iteration limit.
-			if maxRewrites > 0 && iteration > maxRewrites {
-				if debug {
-					fmt.Fprintf(os.Stderr, "=== Stopped: reached maximum iterations (%d) ===\n", maxRewrites)
-				}
-				break
+		// Check if we've hit the iteration limit.
+		if maxRewrites > 0 && iteration > maxRewrites {
+			if debug {
+				fmt.Fprintf(os.Stderr, "=== Stopped: reached maximum iterations (%d) ===\n", maxRewrites)
 			}
+			break
+		}
+
+		if debug {
+			fmt.Fprintf(os.Stderr, "=== Rewrite iteration %d ===\n", iteration)
+		}
 
+		var changed bool
+		node, changed = r.Rewrite(node)
+
+		if changed {
 			if debug {
-				fmt.Fprintf(os.Stderr, "=== Rewrite iteration %d ===\n", iteration)
+				fmt.Fprintln(os.Stderr, "=== Rewrite modified the tree - continuing ===")
 			}
-
-			var changed bool
-			node, changed = r.Rewrite(node)
-
-			if changed {
-				if debug {
-					fmt.Fprintln(os.Stderr, "=== Rewrite modified the tree - continuing ===")
-				}
-			} else {
-				if debug {
-					fmt.Fprintln(os.Stderr, "=== Rewrite made no changes - fixed point reached ===")
-				}
-				reachedFixedPoint = true
-				break
+		} else {
+			if debug {
+				fmt.Fprintln(os.Stderr, "=== Rewrite made no changes - fixed point reached ===")
 			}
+			reachedFixedPoint = true
+			break
 		}
+	}
 
-		if debug {
-			if reachedFixedPoint {
-				fmt.Fprintf(os.Stderr, "=== Completed after %d iteration(s) ===\n", iteration)
-			} else {
-				fmt.Fprintf(os.Stderr, "=== Warning: Did not reach fixed point after %d iteration(s) ===\n", iteration-1)
-			}
+	if debug {
+		if reachedFixedPoint {
+			fmt.Fprintf(os.Stderr, "=== Completed after %d iteration(s) ===\n", iteration)
+		} else {
+			fmt.Fprintf(os.Stderr, "=== Warning: Did not reach fixed point after %d iteration(s) ===\n", iteration-1)
 		}
 	}
 
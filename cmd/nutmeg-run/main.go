@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	pflag "github.com/spf13/pflag"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/interpreter"
+)
+
+// Version is injected at build time via ldflags.
+var Version = "dev"
+
+const usage = `nutmeg-run - reference interpreter for Nutmeg bundles
+
+This command loads the bindings of a bundle and runs one of its entry points
+by interpreting the instructions generated by nutmeg-codegen. If the bundle
+has exactly one entry point then --entrypoint may be omitted.
+
+Usage:
+  nutmeg-run --bundle FILE [--entrypoint NAME]
+
+Options:
+`
+
+func main() {
+	var showHelp, showVersion bool
+	var bundleFile, entryPoint string
+
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n", usage)
+		pflag.PrintDefaults()
+	}
+
+	pflag.BoolVarP(&showHelp, "help", "h", false, "Show help")
+	pflag.BoolVar(&showVersion, "version", false, "Show version")
+	pflag.StringVarP(&bundleFile, "bundle", "b", "", "Bundle file path (required)")
+	pflag.StringVarP(&entryPoint, "entrypoint", "e", "", "Name of the entry point to run")
+
+	pflag.Parse()
+
+	if showHelp {
+		pflag.Usage()
+		os.Exit(0)
+	}
+
+	if showVersion {
+		fmt.Printf("nutmeg-run version %s\n", Version)
+		os.Exit(0)
+	}
+
+	// Bundle file is mandatory.
+	if bundleFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: --bundle flag is required\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	// Reject any positional arguments.
+	if len(pflag.Args()) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: Unexpected positional arguments. Use --entrypoint flag instead.\n\n")
+		pflag.Usage()
+		os.Exit(1)
+	}
+
+	// Opening a missing file would silently create an empty bundle.
+	if _, err := os.Stat(bundleFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot open bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to open bundle: %v\n", err)
+		os.Exit(1)
+	}
+	defer b.Close()
+
+	upToDate, err := b.CheckMigration()
+	if err != nil || !upToDate {
+		fmt.Fprintf(os.Stderr, "Error: %s is not an up-to-date bundle\n", bundleFile)
+		os.Exit(1)
+	}
+
+	entryPoints, err := b.LoadEntryPoints()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	entryPoint, err = chooseEntryPoint(entryPoint, entryPoints)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	bindings, err := b.LoadBindings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	it, err := interpreter.NewInterpreter(bindings, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Refuse to start a program that would fail on a missing system function.
+	uses, err := b.LoadSyscallUses()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if unsupported := it.Unsupported(uses); len(unsupported) > 0 {
+		for _, use := range unsupported {
+			fmt.Fprintf(os.Stderr, "Error: %s calls unsupported system function %s\n", use.IdName, use.SysFn)
+		}
+		os.Exit(1)
+	}
+
+	if err := it.Run(entryPoint); err != nil {
+		fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// chooseEntryPoint checks that the requested entry point is listed in the
+// bundle, or picks the only entry point if none was requested.
+func chooseEntryPoint(requested string, entryPoints []bundler.EntryPoint) (string, error) {
+	names := make([]string, len(entryPoints))
+	for i, ep := range entryPoints {
+		names[i] = ep.IdName
+	}
+	if requested == "" {
+		switch len(names) {
+		case 0:
+			return "", fmt.Errorf("bundle has no entry points")
+		case 1:
+			return names[0], nil
+		default:
+			return "", fmt.Errorf("bundle has several entry points, use --entrypoint to pick one of: %s", strings.Join(names, ", "))
+		}
+	}
+	for _, name := range names {
+		if name == requested {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not an entry point of the bundle", requested)
+}
@@ -8,6 +8,7 @@ import (
 
 	pflag "github.com/spf13/pflag"
 
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
 	"github.com/spicery/nutmeg-compiler/pkg/tokenizer"
 	"gopkg.in/yaml.v3"
 )
@@ -28,6 +29,7 @@ Options:
   --token-rules <file>  YAML rules file for custom tokenisation rules (optional)
   --make-token-rules    Generate default rules YAML to stdout
   --exit0               Exit with code 0 even on tokenisation errors (suppress stderr)
+  --trivia              Include the whitespace and comments around each token
 
 Examples:
   nutmeg-tokenizer                                   # Read from stdin, write to stdout
@@ -37,19 +39,24 @@ Examples:
   nutmeg-tokenizer --token-rules custom.yaml --input source.nutmeg   # Use custom rules
   nutmeg-tokenizer --make-token-rules                # Generate default rules configuration
   echo "def foo end" | nutmeg-tokenizer              # Read from stdin, write to stdout
+  nutmeg-tokenizer --trivia --input source.nutmeg    # Keep whitespace and comments
 
-The tokenizer outputs one JSON token object per line.
+The tokenizer outputs one JSON token object per line. With --trivia each token
+also has the whitespace and comments before it on its line and after it up to
+the end of its line, and the tokens end with an end-of-input token that holds
+the rest, so that the input can be reproduced exactly.
 See docs/rules_file.md for information about custom rules files.
 `
 
 func main() {
-	var showHelp, showVersion, exit0, makeRules bool
+	var showHelp, showVersion, exit0, makeRules, trivia bool
 	var inputFile, outputFile, rulesFile string
 
 	pflag.BoolVarP(&showHelp, "help", "h", false, "Show help")
 	pflag.BoolVar(&showVersion, "version", false, "Show version")
 	pflag.BoolVar(&exit0, "exit0", false, "Exit with code 0 even on errors")
 	pflag.BoolVar(&makeRules, "make-token-rules", false, "Generate default rules YAML")
+	pflag.BoolVar(&trivia, "trivia", false, "Include the whitespace and comments around each token")
 	pflag.StringVarP(&inputFile, "input", "i", "", "Input file (defaults to stdin)")
 	pflag.StringVarP(&outputFile, "output", "o", "", "Output file (defaults to stdout)")
 	pflag.StringVar(&rulesFile, "token-rules", "", "YAML rules file (optional)")
@@ -107,26 +114,19 @@ func main() {
 	}
 
 	// Load rules if specified
-	var t *tokenizer.Tokenizer
-	if rulesFile != "" {
-		rules, err := tokenizer.LoadRulesFile(rulesFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading rules file '%s': %v\n", rulesFile, err)
-			os.Exit(1)
-		}
-
-		tokenizerRules, err := tokenizer.ApplyRulesToDefaults(rules)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error applying rules: %v\n", err)
-			os.Exit(1)
-		}
-		t = tokenizer.NewTokenizerWithRules(input, tokenizerRules)
-	} else {
-		t = tokenizer.NewTokenizer(input)
+	opts := pipeline.Options{Trivia: trivia}
+	if err := opts.LoadFiles(rulesFile, "", ""); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	p, err := pipeline.New(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 
 	// Process input
-	tokens, tokenizeErr := t.Tokenize()
+	tokens, diagnostics := p.Tokenize(inputFile, input)
 
 	// Prepare output destination
 	var output io.Writer
@@ -165,13 +165,13 @@ func main() {
 	}
 
 	// Handle tokenisation error after outputting tokens
-	if tokenizeErr != nil {
+	if pipeline.HasErrors(diagnostics) {
 		if exit0 {
 			// With --exit0, exit normally despite error
 			os.Exit(0)
 		} else {
 			// Without --exit0, print error to stderr and exit with error code
-			fmt.Fprintf(os.Stderr, "Tokenization error: %v\n", tokenizeErr)
+			pipeline.WriteDiagnostics(os.Stderr, diagnostics)
 			os.Exit(1)
 		}
 	}
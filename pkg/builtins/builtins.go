@@ -0,0 +1,150 @@
+// Package builtins is the registry of the system functions that the compiler
+// knows about. The rewriter uses it to decide which operators become
+// syscalls, the resolver uses it to decide which names the standard library
+// provides and codegen uses it to check that system functions exist and are
+// given the right number of arguments.
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kind says how a builtin is reached from source code.
+type Kind string
+
+const (
+	FunctionKind Kind = "function" // A name provided by the standard library.
+	OperatorKind Kind = "operator" // An infix operator.
+	InternalKind Kind = "internal" // Only planted by the compiler itself.
+)
+
+// Builtin describes a single system function.
+type Builtin struct {
+	Name     string `yaml:"name"`               // What the programmer writes.
+	SysFn    string `yaml:"sysfn,omitempty"`    // The system function, defaults to Name.
+	Kind     Kind   `yaml:"kind"`               // How the builtin is reached.
+	Arity    int    `yaml:"arity"`              // The number of arguments, the minimum if variadic.
+	Variadic bool   `yaml:"variadic,omitempty"` // Whether extra arguments are allowed.
+	Results  int    `yaml:"results"`            // The number of results.
+	Pure     bool   `yaml:"pure,omitempty"`     // Whether it is free of side-effects.
+}
+
+// Registry is a collection of builtins indexed by name and by sysfn.
+type Registry struct {
+	Builtins []*Builtin `yaml:"builtins"`
+	byName   map[Kind]map[string]*Builtin
+	bySysFn  map[string]*Builtin
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRegistry *Registry
+)
+
+// Default returns the registry built from the embedded DefaultBuiltins.
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		registry, err := LoadFromString(DefaultBuiltins)
+		if err != nil {
+			// The embedded registry is part of the compiler, so this is a bug.
+			panic(fmt.Sprintf("invalid default builtins: %v", err))
+		}
+		defaultRegistry = registry
+	})
+	return defaultRegistry
+}
+
+// Load reads a registry from a YAML file.
+func Load(filename string) (*Registry, error) {
+	data, err := os.ReadFile(filename) // #nosec G304 - CLI tool reads user-specified config files
+	if err != nil {
+		return nil, fmt.Errorf("failed to read builtins file: %w", err)
+	}
+	return LoadFromString(string(data))
+}
+
+// LoadFromString reads a registry from YAML text and checks that it is
+// consistent.
+func LoadFromString(yamlContent string) (*Registry, error) {
+	var registry Registry
+	if err := yaml.Unmarshal([]byte(yamlContent), &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse builtins: %w", err)
+	}
+	if err := registry.index(); err != nil {
+		return nil, err
+	}
+	return &registry, nil
+}
+
+func (r *Registry) index() error {
+	r.byName = map[Kind]map[string]*Builtin{
+		FunctionKind: {},
+		OperatorKind: {},
+		InternalKind: {},
+	}
+	r.bySysFn = make(map[string]*Builtin)
+	for _, b := range r.Builtins {
+		if b.Name == "" {
+			return fmt.Errorf("builtin without a name")
+		}
+		if b.SysFn == "" {
+			b.SysFn = b.Name
+		}
+		names, ok := r.byName[b.Kind]
+		if !ok {
+			return fmt.Errorf("builtin %s has unknown kind: %q", b.Name, b.Kind)
+		}
+		if b.Arity < 0 || b.Results < 0 {
+			return fmt.Errorf("builtin %s has a negative arity or result count", b.Name)
+		}
+		if _, found := names[b.Name]; found {
+			return fmt.Errorf("builtin %s %s is defined more than once", b.Kind, b.Name)
+		}
+		names[b.Name] = b
+		// Several names may share a sysfn, but they must agree about it.
+		if other, found := r.bySysFn[b.SysFn]; found {
+			if other.Arity != b.Arity || other.Variadic != b.Variadic || other.Results != b.Results || other.Pure != b.Pure {
+				return fmt.Errorf("builtins %s and %s disagree about sysfn %s", other.Name, b.Name, b.SysFn)
+			}
+		} else {
+			r.bySysFn[b.SysFn] = b
+		}
+	}
+	return nil
+}
+
+// Lookup finds a builtin of the given kind by the name the programmer writes.
+func (r *Registry) Lookup(kind Kind, name string) (*Builtin, bool) {
+	b, ok := r.byName[kind][name]
+	return b, ok
+}
+
+// LookupSysFn finds a builtin by the name of its system function.
+func (r *Registry) LookupSysFn(sysfn string) (*Builtin, bool) {
+	b, ok := r.bySysFn[sysfn]
+	return b, ok
+}
+
+// Names returns the set of names of the builtins of the given kind.
+func (r *Registry) Names(kind Kind) map[string]bool {
+	names := make(map[string]bool, len(r.byName[kind]))
+	for name := range r.byName[kind] {
+		names[name] = true
+	}
+	return names
+}
+
+// CheckArgs reports an error if the builtin cannot be given nargs arguments.
+func (b *Builtin) CheckArgs(nargs int) error {
+	switch {
+	case b.Variadic && nargs < b.Arity:
+		return fmt.Errorf("%s expects at least %d arguments but was given %d", b.Name, b.Arity, nargs)
+	case !b.Variadic && nargs != b.Arity:
+		return fmt.Errorf("%s expects %d arguments but was given %d", b.Name, b.Arity, nargs)
+	}
+	return nil
+}
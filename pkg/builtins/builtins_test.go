@@ -0,0 +1,76 @@
+package builtins
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultRegistry(t *testing.T) {
+	registry := Default()
+	pl, ok := registry.Lookup(FunctionKind, "println")
+	if !ok {
+		t.Fatalf("Expected println to be a builtin function")
+	}
+	if pl.SysFn != "println" || !pl.Variadic || pl.Results != 0 {
+		t.Errorf("Unexpected entry for println: %+v", pl)
+	}
+	if _, ok := registry.Lookup(FunctionKind, "+"); ok {
+		t.Errorf("Expected + to be an operator rather than a function")
+	}
+	plus, ok := registry.LookupSysFn("+")
+	if !ok || plus.Kind != OperatorKind || !plus.Pure {
+		t.Errorf("Unexpected entry for +: %+v", plus)
+	}
+	if _, ok := registry.LookupSysFn("partapply"); !ok {
+		t.Errorf("Expected partapply to be an internal builtin")
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	fixed := &Builtin{Name: "f", Arity: 2}
+	variadic := &Builtin{Name: "g", Arity: 1, Variadic: true}
+	if err := fixed.CheckArgs(2); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := fixed.CheckArgs(3); err == nil || err.Error() != "f expects 2 arguments but was given 3" {
+		t.Errorf("Expected a fixed arity error, got %v", err)
+	}
+	if err := variadic.CheckArgs(5); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := variadic.CheckArgs(0); err == nil || err.Error() != "g expects at least 1 arguments but was given 0" {
+		t.Errorf("Expected a variadic arity error, got %v", err)
+	}
+}
+
+func TestSysFnDefaultsToName(t *testing.T) {
+	registry, err := LoadFromString("builtins:\n  - name: show\n    kind: function\n    arity: 1\n    results: 1\n")
+	if err != nil {
+		t.Fatalf("LoadFromString failed: %v", err)
+	}
+	if b, ok := registry.LookupSysFn("show"); !ok || b.Name != "show" {
+		t.Errorf("Expected sysfn to default to the name, got %+v", b)
+	}
+}
+
+func TestInvalidRegistries(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Missing name", "builtins:\n  - kind: function\n", "builtin without a name"},
+		{"Unknown kind", "builtins:\n  - name: f\n    kind: macro\n", "unknown kind"},
+		{"Negative arity", "builtins:\n  - name: f\n    kind: function\n    arity: -1\n", "negative arity"},
+		{"Duplicate", "builtins:\n  - name: f\n    kind: function\n  - name: f\n    kind: function\n", "defined more than once"},
+		{"Disagreement", "builtins:\n  - name: f\n    kind: function\n    arity: 1\n  - name: \"+\"\n    sysfn: f\n    kind: operator\n    arity: 2\n", "disagree about sysfn f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFromString(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
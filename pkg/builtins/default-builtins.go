@@ -0,0 +1,132 @@
+package builtins
+
+const DefaultBuiltins = `
+# The builtins of Nutmeg. Each entry describes one system function.
+#   name:     what the programmer writes, an identifier or an operator
+#   sysfn:    the system function that implements it (defaults to name)
+#   kind:     function (provided by std), operator or internal (planted
+#             by the compiler itself)
+#   arity:    the number of arguments, or the minimum if variadic
+#   variadic: true if extra arguments are allowed
+#   results:  the number of results
+#   pure:     true if it has no side-effects
+
+builtins:
+
+  - name: println
+    kind: function
+    arity: 0
+    variadic: true
+    results: 0
+
+  - name: "+"
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "-"
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "*"
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "/"
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "<"
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "<="
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: ">"
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: ">="
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "=="
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "..<"
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "..="
+    kind: operator
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: "=>"
+    kind: internal
+    arity: 2
+    results: 1
+    pure: true
+
+  - name: partapply
+    kind: internal
+    arity: 1
+    variadic: true
+    results: 1
+    pure: true
+
+  # The iterator protocol used by for loops over anything other than an
+  # integer range.
+  - name: iterator
+    kind: internal
+    arity: 1
+    results: 1
+
+  - name: iterator.hasnext
+    kind: internal
+    arity: 1
+    results: 1
+
+  - name: iterator.next
+    kind: internal
+    arity: 1
+    results: 1
+
+  # Interpolated strings convert each embedded expression with show and then
+  # glue the pieces together with concat.
+  - name: show
+    kind: internal
+    arity: 1
+    results: 1
+    pure: true
+
+  - name: concat
+    kind: internal
+    arity: 0
+    variadic: true
+    results: 1
+    pure: true
+`
@@ -0,0 +1,183 @@
+package bundler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// The versions of the function object format.
+const (
+	// SymbolicVersion is the format produced by codegen, where jumps name
+	// their destination and label pseudo-instructions mark where it is.
+	SymbolicVersion = 0
+	// AssembledVersion is the format stored in bundles, where jumps hold the
+	// index of their destination and there are no labels.
+	AssembledVersion = 1
+)
+
+// IsAssembled returns true if the jumps of the function object have been
+// resolved to instruction indices.
+func (f *FunctionObject) IsAssembled() bool {
+	return f.Version == AssembledVersion
+}
+
+// Assemble resolves the labels of a symbolic function object, returning a
+// new function object in which every jump holds the index of the instruction
+// it goes to and the label pseudo-instructions have been removed. A jump to
+// a label at the very end goes to the index just past the last instruction,
+// which returns. Undefined and duplicate labels are rejected.
+func Assemble(funcObj *FunctionObject) (*FunctionObject, error) {
+	if funcObj.IsAssembled() {
+		return funcObj, nil
+	}
+	if funcObj.Version != SymbolicVersion {
+		return nil, fmt.Errorf("unsupported function object version %d", funcObj.Version)
+	}
+
+	// Work out where each label will be once the labels are removed.
+	positions := make(map[string]int)
+	n := 0
+	for i, inst := range funcObj.Instructions {
+		if inst.Type != "label" {
+			n++
+			continue
+		}
+		if inst.StrValue == nil {
+			return nil, fmt.Errorf("label without a name at instruction %d", i)
+		}
+		if _, exists := positions[*inst.StrValue]; exists {
+			return nil, fmt.Errorf("duplicate label %s at instruction %d", *inst.StrValue, i)
+		}
+		positions[*inst.StrValue] = n
+	}
+
+	resolve := func(i int, label *string) (*int, error) {
+		if label == nil {
+			return nil, fmt.Errorf("missing label at instruction %d", i)
+		}
+		target, ok := positions[*label]
+		if !ok {
+			return nil, fmt.Errorf("undefined label %s at instruction %d", *label, i)
+		}
+		return &target, nil
+	}
+
+	oldSpans := funcObj.instructionSpans()
+	spans := make([]common.Span, 0, n)
+	instructions := make([]Instruction, 0, n)
+	for i, inst := range funcObj.Instructions {
+		var err error
+		switch inst.Type {
+		case "label":
+			continue
+		case "goto", "if.not", "if.so":
+			inst.Target, err = resolve(i, inst.StrValue)
+			inst.StrValue = nil
+		case "if.then.else":
+			if inst.Target, err = resolve(i, inst.Name); err == nil {
+				inst.ElseTarget, err = resolve(i, inst.StrValue)
+			}
+			inst.Name = nil
+			inst.StrValue = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+		spans = append(spans, oldSpans[i])
+	}
+	return &FunctionObject{
+		Version:      AssembledVersion,
+		NLocals:      funcObj.NLocals,
+		NParams:      funcObj.NParams,
+		File:         funcObj.File,
+		Lines:        NewLineTable(spans),
+		Instructions: instructions,
+	}, nil
+}
+
+// Disassemble turns an assembled function object back into the symbolic form
+// for debugging. The labels are named L0, L1, ... in order of position.
+func Disassemble(funcObj *FunctionObject) (*FunctionObject, error) {
+	if !funcObj.IsAssembled() {
+		return funcObj, nil
+	}
+	n := len(funcObj.Instructions)
+
+	// Collect the jump targets and name them in order.
+	targets := make([]int, 0)
+	seen := make(map[int]bool)
+	addTarget := func(i int, target *int) error {
+		if target == nil {
+			return fmt.Errorf("missing target at instruction %d", i)
+		}
+		if *target < 0 || *target > n {
+			return fmt.Errorf("target %d out of range at instruction %d", *target, i)
+		}
+		if !seen[*target] {
+			seen[*target] = true
+			targets = append(targets, *target)
+		}
+		return nil
+	}
+	for i, inst := range funcObj.Instructions {
+		var err error
+		switch inst.Type {
+		case "goto", "if.not", "if.so":
+			err = addTarget(i, inst.Target)
+		case "if.then.else":
+			if err = addTarget(i, inst.Target); err == nil {
+				err = addTarget(i, inst.ElseTarget)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Ints(targets)
+	labels := make(map[int]string)
+	for k, target := range targets {
+		labels[target] = fmt.Sprintf("L%d", k)
+	}
+
+	oldSpans := funcObj.instructionSpans()
+	spans := make([]common.Span, 0, n+len(targets))
+	instructions := make([]Instruction, 0, n+len(targets))
+	for i := 0; i <= n; i++ {
+		if label, ok := labels[i]; ok {
+			instructions = append(instructions, NewLabel(label))
+			// A label goes with the instruction that follows it.
+			if i < n {
+				spans = append(spans, oldSpans[i])
+			} else {
+				spans = append(spans, common.Span{})
+			}
+		}
+		if i == n {
+			break
+		}
+		inst := funcObj.Instructions[i]
+		switch inst.Type {
+		case "goto", "if.not", "if.so":
+			label := labels[*inst.Target]
+			inst.StrValue = &label
+			inst.Target = nil
+		case "if.then.else":
+			thenLabel, elseLabel := labels[*inst.Target], labels[*inst.ElseTarget]
+			inst.Name, inst.StrValue = &thenLabel, &elseLabel
+			inst.Target, inst.ElseTarget = nil, nil
+		}
+		instructions = append(instructions, inst)
+		spans = append(spans, oldSpans[i])
+	}
+	return &FunctionObject{
+		Version:      SymbolicVersion,
+		NLocals:      funcObj.NLocals,
+		NParams:      funcObj.NParams,
+		File:         funcObj.File,
+		Lines:        NewLineTable(spans),
+		Instructions: instructions,
+	}, nil
+}
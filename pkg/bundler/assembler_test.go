@@ -0,0 +1,98 @@
+package bundler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func types(instructions []Instruction) []string {
+	result := make([]string, len(instructions))
+	for i, inst := range instructions {
+		result[i] = inst.Type
+	}
+	return result
+}
+
+func TestAssembleResolvesLabels(t *testing.T) {
+	// if x then 1 else 2 endif
+	symbolic := &FunctionObject{
+		NLocals: 1,
+		NParams: 1,
+		Instructions: []Instruction{
+			NewPushLocal(0),
+			NewIfNot("L0"),
+			NewPushInt(1),
+			NewGoto("L1"),
+			NewLabel("L0"),
+			NewPushInt(2),
+			NewLabel("L1"),
+		},
+	}
+	assembled, err := Assemble(symbolic)
+	if err != nil {
+		t.Fatalf("Assemble failed: %v", err)
+	}
+	if !assembled.IsAssembled() {
+		t.Errorf("Expected version %d, got %d", AssembledVersion, assembled.Version)
+	}
+	expected := []string{"push.local", "if.not", "push.int", "goto", "push.int"}
+	if got := types(assembled.Instructions); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	if target := assembled.Instructions[1].Target; target == nil || *target != 4 {
+		t.Errorf("Expected if.not to jump to 4, got %v", target)
+	}
+	// A label at the end resolves to just past the last instruction.
+	if target := assembled.Instructions[3].Target; target == nil || *target != 5 {
+		t.Errorf("Expected goto to jump to 5, got %v", target)
+	}
+	if assembled.Instructions[1].StrValue != nil {
+		t.Errorf("Expected the label name to be removed")
+	}
+}
+
+func TestAssembleRejectsBadLabels(t *testing.T) {
+	tests := []struct {
+		name         string
+		instructions []Instruction
+		expected     string
+	}{
+		{"Undefined label", []Instruction{NewGoto("L9")}, "undefined label L9 at instruction 0"},
+		{"Duplicate label", []Instruction{NewLabel("L0"), NewLabel("L0")}, "duplicate label L0 at instruction 1"},
+		{"Undefined else label", []Instruction{NewLabel("L0"), NewIfThenElse("L0", "L1")}, "undefined label L1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(&FunctionObject{Instructions: tt.instructions})
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	symbolic := &FunctionObject{
+		NLocals: 1,
+		Instructions: []Instruction{
+			NewLabel("L0"),
+			NewPushLocal(0),
+			NewIfThenElse("L1", "L2"),
+			NewLabel("L1"),
+			NewGoto("L0"),
+			NewLabel("L2"),
+		},
+	}
+	assembled, err := Assemble(symbolic)
+	if err != nil {
+		t.Fatalf("Assemble failed: %v", err)
+	}
+	disassembled, err := Disassemble(assembled)
+	if err != nil {
+		t.Fatalf("Disassemble failed: %v", err)
+	}
+	if !reflect.DeepEqual(disassembled, symbolic) {
+		t.Errorf("Expected %+v, got %+v", symbolic, disassembled)
+	}
+}
@@ -17,18 +17,29 @@ type DependsOn struct {
 	Needs  string `gorm:"primaryKey;index"`
 }
 
+// SyscallUse records that a binding calls a system function, so that a
+// runtime can check that it supports every system function a bundle needs.
+type SyscallUse struct {
+	IdName string `gorm:"primaryKey;index"`
+	SysFn  string `gorm:"primaryKey;index"`
+}
+
 // Binding represents a value binding in the bundle.
 type Binding struct {
-	IdName   string `gorm:"primaryKey"`
-	Lazy     bool
-	Value    string
-	FileName string
+	IdName     string `gorm:"primaryKey"`
+	Lazy       bool
+	Value      string
+	FileName   string
+	ModuleName string
 }
 
-// SourceFile stores the original source file contents.
+// SourceFile stores the original source file contents. ContentHash
+// identifies what the file was last compiled from, so that compiling it
+// again can be skipped if nothing has changed.
 type SourceFile struct {
-	FileName string `gorm:"primaryKey"`
-	Contents string
+	FileName    string `gorm:"primaryKey"`
+	Contents    string
+	ContentHash string
 }
 
 // Annotation stores metadata about bindings.
@@ -64,6 +75,36 @@ func getMigrations() []*gormigrate.Migration {
 				)
 			},
 		},
+		{
+			ID: "202610160001",
+			Migrate: func(tx *gorm.DB) error {
+				// Record the module that each binding came from.
+				return tx.AutoMigrate(&Binding{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&Binding{}, "ModuleName")
+			},
+		},
+		{
+			ID: "202610170001",
+			Migrate: func(tx *gorm.DB) error {
+				// Record a hash of what each file was last compiled from.
+				return tx.AutoMigrate(&SourceFile{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropColumn(&SourceFile{}, "ContentHash")
+			},
+		},
+		{
+			ID: "202610170002",
+			Migrate: func(tx *gorm.DB) error {
+				// Record the system functions that each binding calls.
+				return tx.AutoMigrate(&SyscallUse{})
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&SyscallUse{})
+			},
+		},
 	}
 }
 
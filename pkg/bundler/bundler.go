@@ -7,11 +7,14 @@ import (
 	"github.com/spicery/nutmeg-compiler/pkg/common"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Bundler handles the bundling process.
 type Bundler struct {
-	db          *gorm.DB
+	conn        *gorm.DB // The database connection.
+	db          *gorm.DB // Either conn or the transaction in progress.
+	symbolic    bool     // Store function objects without assembling them.
 	annotations []struct {
 		key   string
 		value string
@@ -26,6 +29,7 @@ func NewBundler(dbPath string) (*Bundler, error) {
 	}
 
 	return &Bundler{
+		conn:        db,
 		db:          db,
 		annotations: make([]struct{ key, value string }, 0),
 	}, nil
@@ -41,13 +45,102 @@ func (b *Bundler) CheckMigration() (bool, error) {
 	return CheckMigration(b.db)
 }
 
+// SetSymbolic chooses whether function objects are stored in the symbolic
+// form that codegen produces, which is useful for debugging, rather than
+// being assembled.
+func (b *Bundler) SetSymbolic(symbolic bool) {
+	b.symbolic = symbolic
+}
+
+// Begin starts a transaction that the following changes to the bundle are
+// made in, until Commit or Rollback is called. This lets a build that
+// bundles several units commit them all at once, or leave the bundle as it
+// was if any of them fails.
+func (b *Bundler) Begin() error {
+	if b.db != b.conn {
+		return fmt.Errorf("a transaction is already in progress")
+	}
+	tx := b.conn.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+	b.db = tx
+	return nil
+}
+
+// Commit makes the changes since Begin permanent.
+func (b *Bundler) Commit() error {
+	if b.db == b.conn {
+		return fmt.Errorf("no transaction is in progress")
+	}
+	err := b.db.Commit().Error
+	b.db = b.conn
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rollback discards the changes since Begin, returning the bundle to the
+// state it was in before.
+func (b *Bundler) Rollback() error {
+	if b.db == b.conn {
+		return fmt.Errorf("no transaction is in progress")
+	}
+	err := b.db.Rollback().Error
+	b.db = b.conn
+	// Annotations waiting for a binding belong to the discarded changes.
+	b.annotations = b.annotations[:0]
+	if err != nil {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
+	}
+	return nil
+}
+
+// inTransaction runs fn in a transaction of its own, unless one is already
+// in progress, in which case it is left to the caller to commit or roll back.
+func (b *Bundler) inTransaction(fn func() error) error {
+	if b.db != b.conn {
+		return fn()
+	}
+	if err := b.Begin(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if rbErr := b.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (and %v)", err, rbErr)
+		}
+		return err
+	}
+	return b.Commit()
+}
+
 // ProcessUnit processes a unit node and adds its contents to the bundle.
+// A unit that came from a source file replaces everything that was
+// previously bundled from that file: bindings the file no longer defines are
+// removed along with their dependencies, annotations and entry points. The
+// unit is bundled in a single transaction, so if it fails the bundle is left
+// as it was.
 func (b *Bundler) ProcessUnit(unit *common.Node) error {
 	if unit.Name != common.NameUnit {
 		return fmt.Errorf("expected unit node, got %s", unit.Name)
 	}
+	return b.inTransaction(func() error {
+		return b.processUnit(unit)
+	})
+}
+
+// processUnit does the work of ProcessUnit.
+func (b *Bundler) processUnit(unit *common.Node) error {
 
 	srcPath := unit.Options[common.OptionSrc]
+	moduleName := unit.Options[common.OptionModule]
+
+	if srcPath != "" {
+		if err := b.removeStaleBindings(srcPath, boundNames(unit)); err != nil {
+			return err
+		}
+	}
 
 	// Iterate through children of the unit.
 	for _, child := range unit.Children {
@@ -60,7 +153,7 @@ func (b *Bundler) ProcessUnit(unit *common.Node) error {
 
 		case common.NameBind:
 			// Process bind node.
-			if err := b.processBind(child, srcPath); err != nil {
+			if err := b.processBind(child, srcPath, moduleName); err != nil {
 				return fmt.Errorf("failed to process bind: %w", err)
 			}
 
@@ -70,17 +163,156 @@ func (b *Bundler) ProcessUnit(unit *common.Node) error {
 		}
 	}
 
+	// The unit may not have been compiled from the source file as it is now,
+	// so the hash of the source is forgotten until AddSourceFile records it.
+	if srcPath != "" {
+		if err := b.updateSourceFile(srcPath, "content_hash", ""); err != nil {
+			return fmt.Errorf("failed to clear source file hash: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AddSourceFile records the text of a source file in the bundle, so that the
+// spans in the line tables of its function objects can be shown to the user.
+// The hash identifies what the units of the file were compiled from, such as
+// the text and the compiler options, so that compiling the file again can be
+// skipped if none of them has changed. An empty hash means it is unknown.
+func (b *Bundler) AddSourceFile(fileName, contents, hash string) error {
+	return b.inTransaction(func() error {
+		if err := b.updateSourceFile(fileName, "contents", contents); err != nil {
+			return fmt.Errorf("failed to record source file: %w", err)
+		}
+		if err := b.updateSourceFile(fileName, "content_hash", hash); err != nil {
+			return fmt.Errorf("failed to record source file hash: %w", err)
+		}
+		return nil
+	})
+}
+
+// SourceHash returns the hash that AddSourceFile last recorded for a source
+// file, or the empty string if there is none.
+func (b *Bundler) SourceHash(fileName string) (string, error) {
+	var sourceFile SourceFile
+	result := b.db.Where("file_name = ?", fileName).Limit(1).Find(&sourceFile)
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to look up source file: %w", result.Error)
+	}
+	return sourceFile.ContentHash, nil
+}
+
+// updateSourceFile sets a column of the source_files row for fileName,
+// creating the row if there is not one yet.
+func (b *Bundler) updateSourceFile(fileName, column, value string) error {
+	result := b.db.Model(&SourceFile{}).Where("file_name = ?", fileName).Update(column, value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return b.db.Model(&SourceFile{}).Create(map[string]interface{}{"file_name": fileName, column: value}).Error
+	}
+	return nil
+}
+
+// boundNames returns the names of the bindings that a unit defines.
+func boundNames(unit *common.Node) map[string]bool {
+	names := make(map[string]bool)
+	for _, child := range unit.Children {
+		if child.Name == common.NameBind && len(child.Children) > 0 {
+			names[child.Children[0].Options[common.OptionName]] = true
+		}
+	}
+	return names
+}
+
+// removeStaleBindings deletes the bindings that were bundled from a source
+// file but that it no longer defines, together with the rows that refer to
+// them.
+func (b *Bundler) removeStaleBindings(srcPath string, current map[string]bool) error {
+	var previous []Binding
+	if result := b.db.Where("file_name = ?", srcPath).Find(&previous); result.Error != nil {
+		return fmt.Errorf("failed to load bindings of %s: %w", srcPath, result.Error)
+	}
+	for _, binding := range previous {
+		if current[binding.IdName] {
+			continue
+		}
+		if err := b.removeBindingRows(binding.IdName); err != nil {
+			return err
+		}
+		if result := b.db.Delete(&Binding{}, "id_name = ?", binding.IdName); result.Error != nil {
+			return fmt.Errorf("failed to delete binding %s: %w", binding.IdName, result.Error)
+		}
+	}
+	return nil
+}
+
+// RemoveSourceFile removes a source file from the bundle, together with the
+// bindings that were bundled from it and the rows that refer to them. It is
+// for files that have been deleted from a project.
+func (b *Bundler) RemoveSourceFile(fileName string) error {
+	return b.inTransaction(func() error {
+		if err := b.removeStaleBindings(fileName, nil); err != nil {
+			return err
+		}
+		if result := b.db.Delete(&SourceFile{}, "file_name = ?", fileName); result.Error != nil {
+			return fmt.Errorf("failed to delete source file %s: %w", fileName, result.Error)
+		}
+		return nil
+	})
+}
+
+// DeleteBindings removes bindings from the bundle, together with the rows
+// that refer to them. The source files they came from are marked as changed,
+// so that compiling one of those files again restores what was removed.
+func (b *Bundler) DeleteBindings(names []string) error {
+	return b.inTransaction(func() error {
+		for _, name := range names {
+			var bindings []Binding
+			if result := b.db.Where("id_name = ?", name).Find(&bindings); result.Error != nil {
+				return fmt.Errorf("failed to load binding %s: %w", name, result.Error)
+			}
+			for _, binding := range bindings {
+				if binding.FileName == "" {
+					continue
+				}
+				result := b.db.Model(&SourceFile{}).Where("file_name = ?", binding.FileName).Update("content_hash", "")
+				if result.Error != nil {
+					return fmt.Errorf("failed to update source file %s: %w", binding.FileName, result.Error)
+				}
+			}
+			if err := b.removeBindingRows(name); err != nil {
+				return err
+			}
+			if result := b.db.Delete(&Binding{}, "id_name = ?", name); result.Error != nil {
+				return fmt.Errorf("failed to delete binding %s: %w", name, result.Error)
+			}
+		}
+		return nil
+	})
+}
+
+// removeBindingRows deletes the dependencies, system function uses,
+// annotations and entry point of a binding, so that they can be replaced or the binding removed.
+func (b *Bundler) removeBindingRows(idName string) error {
+	for _, model := range []any{&DependsOn{}, &SyscallUse{}, &Annotation{}, &EntryPoint{}} {
+		if result := b.db.Delete(model, "id_name = ?", idName); result.Error != nil {
+			return fmt.Errorf("failed to delete rows of %s: %w", idName, result.Error)
+		}
+	}
 	return nil
 }
 
 // processAnnotations extracts annotations and adds them to the accumulating list.
 func (b *Bundler) processAnnotations(annotationsNode *common.Node) error {
-	// Each child of the annotations node represents an annotation.
-	// The child is typically an <id> node with a name attribute.
+	// Each child of the annotations node represents an annotation. The
+	// child is an <id> node with a name attribute and, if the annotation has
+	// arguments, a value attribute that codegen has set to their JSON.
 	for _, child := range annotationsNode.Children {
 		if child.Name == common.NameIdentifier {
 			key := child.Options[common.OptionName]
-			value := "" // Value is not used at present, as per task description.
+			value := child.Options[common.OptionValue]
 			b.annotations = append(b.annotations, struct{ key, value string }{key, value})
 		} else {
 			fmt.Println("Skipping annotation:", child.Name)
@@ -91,7 +323,7 @@ func (b *Bundler) processAnnotations(annotationsNode *common.Node) error {
 }
 
 // processBind processes a bind node and inserts/updates the database.
-func (b *Bundler) processBind(bindNode *common.Node, srcPath string) error {
+func (b *Bundler) processBind(bindNode *common.Node, srcPath string, moduleName string) error {
 	if len(bindNode.Children) != 2 {
 		return fmt.Errorf("bind node must have exactly 2 children")
 	}
@@ -109,8 +341,10 @@ func (b *Bundler) processBind(bindNode *common.Node, srcPath string) error {
 	idName := idNode.Options[common.OptionName]
 	lazy := bindNode.Options[common.OptionLazy] == "true"
 
-	// Convert the value node to JSON.
+	// Convert the value node to JSON. Only function objects refer to other
+	// bindings or to system functions.
 	var valueJSON []byte
+	var globals, sysfns []string
 
 	if valueNode.Name == common.NameFn {
 		// Convert <fn> node to FunctionObject.
@@ -118,6 +352,14 @@ func (b *Bundler) processBind(bindNode *common.Node, srcPath string) error {
 		if err != nil {
 			return fmt.Errorf("failed to convert function: %w", err)
 		}
+		funcObj.File = srcPath
+		globals, sysfns = FindReferences(funcObj)
+		if !b.symbolic {
+			funcObj, err = Assemble(funcObj)
+			if err != nil {
+				return fmt.Errorf("failed to assemble %s: %w", idName, err)
+			}
+		}
 		valueJSON, err = json.Marshal(funcObj)
 		if err != nil {
 			return fmt.Errorf("failed to serialize function object: %w", err)
@@ -132,9 +374,6 @@ func (b *Bundler) processBind(bindNode *common.Node, srcPath string) error {
 
 	}
 
-	// Find all references to variables in the function body.
-	idrefs := findIdentifierReferences(valueNode)
-
 	// Prepare filename (use srcPath or NULL).
 	fileName := ""
 	if srcPath != "" {
@@ -143,22 +382,41 @@ func (b *Bundler) processBind(bindNode *common.Node, srcPath string) error {
 
 	// Upsert the binding.
 	binding := Binding{
-		IdName:   idName,
-		Lazy:     lazy,
-		Value:    string(valueJSON),
-		FileName: fileName,
+		IdName:     idName,
+		Lazy:       lazy,
+		Value:      string(valueJSON),
+		FileName:   fileName,
+		ModuleName: moduleName,
+	}
+
+	// Rows left over from a previous definition are replaced rather than
+	// added to.
+	if err := b.removeBindingRows(idName); err != nil {
+		return err
 	}
 
 	// Upsert the depends-on relationships.
-	for _, refName := range idrefs {
-		dependency := DependsOn{
+	dependencies := make([]DependsOn, 0, len(globals))
+	for _, refName := range globals {
+		dependencies = append(dependencies, DependsOn{
 			IdName: idName,
 			Needs:  refName,
-		}
-		result := b.db.Save(&dependency)
-		if result.Error != nil {
-			return fmt.Errorf("failed to save dependency relationship: %w", result.Error)
-		}
+		})
+	}
+	if err := b.upsert(&dependencies, len(dependencies)); err != nil {
+		return fmt.Errorf("failed to save dependency relationship: %w", err)
+	}
+
+	// Upsert the system functions used.
+	syscallUses := make([]SyscallUse, 0, len(sysfns))
+	for _, sysfn := range sysfns {
+		syscallUses = append(syscallUses, SyscallUse{
+			IdName: idName,
+			SysFn:  sysfn,
+		})
+	}
+	if err := b.upsert(&syscallUses, len(syscallUses)); err != nil {
+		return fmt.Errorf("failed to save system function use: %w", err)
 	}
 
 	result := b.db.Save(&binding)
@@ -167,28 +425,28 @@ func (b *Bundler) processBind(bindNode *common.Node, srcPath string) error {
 	}
 
 	// Process accumulated annotations.
+	annotations := make([]Annotation, 0, len(b.annotations))
+	entryPoints := make([]EntryPoint, 0, 1)
 	for _, ann := range b.annotations {
-		annotation := Annotation{
+		annotations = append(annotations, Annotation{
 			IdName:          idName,
 			AnnotationKey:   ann.key,
 			AnnotationValue: ann.value,
-		}
-		result := b.db.Save(&annotation)
-		if result.Error != nil {
-			return fmt.Errorf("failed to save annotation: %w", result.Error)
-		}
+		})
 
 		// If annotation key is "main", create an entry point.
 		if ann.key == "main" {
-			entryPoint := EntryPoint{
+			entryPoints = append(entryPoints, EntryPoint{
 				IdName: idName,
-			}
-			result := b.db.Save(&entryPoint)
-			if result.Error != nil {
-				return fmt.Errorf("failed to save entry point: %w", result.Error)
-			}
+			})
 		}
 	}
+	if err := b.upsert(&annotations, len(annotations)); err != nil {
+		return fmt.Errorf("failed to save annotation: %w", err)
+	}
+	if err := b.upsert(&entryPoints, len(entryPoints)); err != nil {
+		return fmt.Errorf("failed to save entry point: %w", err)
+	}
 
 	// Clear annotations after processing.
 	b.annotations = b.annotations[:0]
@@ -196,40 +454,25 @@ func (b *Bundler) processBind(bindNode *common.Node, srcPath string) error {
 	return nil
 }
 
-// Close closes the database connection.
-func (b *Bundler) Close() error {
-	sqlDB, err := b.db.DB()
-	if err != nil {
-		return err
+// upsert inserts a slice of n rows in a single statement, replacing any rows
+// with the same primary key.
+func (b *Bundler) upsert(rows any, n int) error {
+	// GORM refuses to insert an empty slice.
+	if n == 0 {
+		return nil
 	}
-	return sqlDB.Close()
-}
-
-// findIdentifierReferences traverses a code tree and collects all unique identifier
-// references. It returns a slice of identifier names that are referenced
-// in the given node and its descendants, with no duplicates.
-func findIdentifierReferences(node *common.Node) []string {
-	seen := make(map[string]bool)
-	var references []string
-	findIdentifierReferencesRecursive(node, &references, seen)
-	return references
+	return b.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(rows).Error
 }
 
-// findIdentifierReferencesRecursive is a helper function that recursively
-// traverses the node tree and collects identifier names, avoiding duplicates.
-func findIdentifierReferencesRecursive(node *common.Node, references *[]string, seen map[string]bool) {
-	if node == nil {
-		return
-	}
-
-	// If this is an instruction that references an identifier, record it.
-	if name, ok := node.Options[common.OptionName]; ok {
-		seen[name] = true
-		*references = append(*references, name)
+// Close closes the database connection, discarding any transaction that is
+// still in progress.
+func (b *Bundler) Close() error {
+	if b.db != b.conn {
+		_ = b.Rollback()
 	}
-
-	// Recursively process all children.
-	for _, child := range node.Children {
-		findIdentifierReferencesRecursive(child, references, seen)
+	sqlDB, err := b.conn.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.Close()
 }
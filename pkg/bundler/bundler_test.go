@@ -0,0 +1,303 @@
+package bundler
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func newTestBundler(t *testing.T) *Bundler {
+	t.Helper()
+	b, err := NewBundler(filepath.Join(t.TempDir(), "test.bundle"))
+	if err != nil {
+		t.Fatalf("NewBundler failed: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	if err := b.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	return b
+}
+
+// bindNode binds name to a function that calls each of the needed globals.
+func bindNode(name string, needs ...string) *common.Node {
+	fn := &common.Node{Name: common.NameFn, Options: map[string]string{common.OptionNParams: "0", common.OptionNLocals: "0"}}
+	for _, need := range needs {
+		fn.Children = append(fn.Children, &common.Node{
+			Name:    common.NameCallGlobalFixed,
+			Options: map[string]string{common.OptionName: need, common.OptionNArgs: "0"},
+		})
+	}
+	fn.Children = append(fn.Children, &common.Node{Name: common.NameReturn})
+	return &common.Node{
+		Name:     common.NameBind,
+		Children: []*common.Node{{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: name}}, fn},
+	}
+}
+
+func annotationsNode(keys ...string) *common.Node {
+	node := &common.Node{Name: "annotations"}
+	for _, key := range keys {
+		node.Children = append(node.Children, &common.Node{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: key}})
+	}
+	return node
+}
+
+func unitNode(src string, children ...*common.Node) *common.Node {
+	return &common.Node{Name: common.NameUnit, Options: map[string]string{common.OptionSrc: src}, Children: children}
+}
+
+func bindingNames(t *testing.T, b *Bundler) []string {
+	t.Helper()
+	bindings, err := b.LoadBindings()
+	if err != nil {
+		t.Fatalf("LoadBindings failed: %v", err)
+	}
+	names := make([]string, 0)
+	for _, binding := range bindings {
+		names = append(names, binding.IdName)
+	}
+	return names
+}
+
+func checkRows(t *testing.T, b *Bundler, bindings []string, entryPoints []string, dependencies []DependsOn, annotations []Annotation) {
+	t.Helper()
+	if got := bindingNames(t, b); !reflect.DeepEqual(got, bindings) {
+		t.Errorf("Expected bindings %v, got %v", bindings, got)
+	}
+	eps, err := b.LoadEntryPoints()
+	if err != nil {
+		t.Fatalf("LoadEntryPoints failed: %v", err)
+	}
+	epNames := make([]string, 0)
+	for _, ep := range eps {
+		epNames = append(epNames, ep.IdName)
+	}
+	if !reflect.DeepEqual(epNames, entryPoints) {
+		t.Errorf("Expected entry points %v, got %v", entryPoints, epNames)
+	}
+	deps, err := b.LoadDependencies()
+	if err != nil {
+		t.Fatalf("LoadDependencies failed: %v", err)
+	}
+	if deps = append([]DependsOn{}, deps...); !reflect.DeepEqual(deps, dependencies) {
+		t.Errorf("Expected dependencies %v, got %v", dependencies, deps)
+	}
+	anns, err := b.LoadAnnotations()
+	if err != nil {
+		t.Fatalf("LoadAnnotations failed: %v", err)
+	}
+	if anns = append([]Annotation{}, anns...); !reflect.DeepEqual(anns, annotations) {
+		t.Errorf("Expected annotations %v, got %v", annotations, anns)
+	}
+}
+
+func TestRecompilingRemovesStaleBindings(t *testing.T) {
+	b := newTestBundler(t)
+	if err := b.ProcessUnit(unitNode("a.nutmeg", bindNode("f"), annotationsNode("main"), bindNode("g", "f"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	if err := b.ProcessUnit(unitNode("b.nutmeg", bindNode("h"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	checkRows(t, b,
+		[]string{"f", "g", "h"},
+		[]string{"g"},
+		[]DependsOn{{IdName: "g", Needs: "f"}},
+		[]Annotation{{IdName: "g", AnnotationKey: "main"}},
+	)
+
+	// g is deleted from a.nutmeg, which leaves b.nutmeg alone.
+	if err := b.ProcessUnit(unitNode("a.nutmeg", bindNode("f"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	checkRows(t, b, []string{"f", "h"}, []string{}, []DependsOn{}, []Annotation{})
+}
+
+func TestRedefinitionReplacesRows(t *testing.T) {
+	b := newTestBundler(t)
+	if err := b.ProcessUnit(unitNode("a.nutmeg", annotationsNode("main"), bindNode("f", "g"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	// f loses its annotation and its dependency on g.
+	if err := b.ProcessUnit(unitNode("a.nutmeg", bindNode("f", "h"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	checkRows(t, b, []string{"f"}, []string{}, []DependsOn{{IdName: "f", Needs: "h"}}, []Annotation{})
+}
+
+func TestAddSourceFile(t *testing.T) {
+	b := newTestBundler(t)
+	if err := b.AddSourceFile("a.nutmeg", "f := 1\n", "1234"); err != nil {
+		t.Fatalf("AddSourceFile failed: %v", err)
+	}
+	if hash, err := b.SourceHash("a.nutmeg"); err != nil || hash != "1234" {
+		t.Errorf("Expected the hash 1234, got %q (%v)", hash, err)
+	}
+	// Bundling a unit forgets the hash, because it is not known what the
+	// unit was compiled from, but keeps the contents.
+	if err := b.ProcessUnit(unitNode("a.nutmeg", bindNode("f"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	var sourceFiles []SourceFile
+	if err := b.db.Find(&sourceFiles).Error; err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(sourceFiles) != 1 || sourceFiles[0].Contents != "f := 1\n" || sourceFiles[0].ContentHash != "" {
+		t.Errorf("Expected one source file with contents and no hash, got %+v", sourceFiles)
+	}
+	if hash, err := b.SourceHash("b.nutmeg"); err != nil || hash != "" {
+		t.Errorf("Expected no hash for an unknown file, got %q (%v)", hash, err)
+	}
+}
+
+// badBindNode is a bind node that processBind rejects.
+func badBindNode() *common.Node {
+	return &common.Node{Name: common.NameBind}
+}
+
+func TestFailedUnitLeavesBundleUnchanged(t *testing.T) {
+	b := newTestBundler(t)
+	if err := b.ProcessUnit(unitNode("a.nutmeg", annotationsNode("main"), bindNode("f", "g"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	// The unit removes f and adds h before it fails.
+	if err := b.ProcessUnit(unitNode("a.nutmeg", bindNode("h"), badBindNode())); err == nil {
+		t.Fatalf("Expected ProcessUnit to fail")
+	}
+	checkRows(t, b,
+		[]string{"f"},
+		[]string{"f"},
+		[]DependsOn{{IdName: "f", Needs: "g"}},
+		[]Annotation{{IdName: "f", AnnotationKey: "main"}},
+	)
+}
+
+func TestTransactionSpansUnits(t *testing.T) {
+	b := newTestBundler(t)
+	if err := b.ProcessUnit(unitNode("a.nutmeg", bindNode("f"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+
+	if err := b.Begin(); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if err := b.Begin(); err == nil {
+		t.Errorf("Expected nested Begin to fail")
+	}
+	if err := b.ProcessUnit(unitNode("b.nutmeg", bindNode("g"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	if err := b.AddSourceFile("b.nutmeg", "g() := 1\n", ""); err != nil {
+		t.Fatalf("AddSourceFile failed: %v", err)
+	}
+	if err := b.ProcessUnit(unitNode("c.nutmeg", badBindNode())); err == nil {
+		t.Fatalf("Expected ProcessUnit to fail")
+	}
+	// The units so far are still pending until the caller decides.
+	if got := bindingNames(t, b); !reflect.DeepEqual(got, []string{"f", "g"}) {
+		t.Errorf("Expected bindings [f g] inside the transaction, got %v", got)
+	}
+	if err := b.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	checkRows(t, b, []string{"f"}, []string{}, []DependsOn{}, []Annotation{})
+	var count int64
+	if err := b.db.Model(&SourceFile{}).Where("file_name = ?", "b.nutmeg").Count(&count).Error; err != nil || count != 0 {
+		t.Errorf("Expected b.nutmeg to be rolled back, got %d rows (%v)", count, err)
+	}
+
+	if err := b.Begin(); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if err := b.ProcessUnit(unitNode("b.nutmeg", bindNode("g"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := b.Commit(); err == nil {
+		t.Errorf("Expected Commit without a transaction to fail")
+	}
+	checkRows(t, b, []string{"f", "g"}, []string{}, []DependsOn{}, []Annotation{})
+}
+
+func TestDeleteBindings(t *testing.T) {
+	b := newTestBundler(t)
+	unit := unitNode("a.nutmeg", annotationsNode("main"), bindNode("f", "g"), bindNode("g"))
+	if err := b.ProcessUnit(unit); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	if err := b.DeleteBindings([]string{"f"}); err != nil {
+		t.Fatalf("DeleteBindings failed: %v", err)
+	}
+	checkRows(t, b, []string{"g"}, []string{}, []DependsOn{}, []Annotation{})
+
+	// Compiling the file again brings f back.
+	if err := b.ProcessUnit(unit); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	checkRows(t, b,
+		[]string{"f", "g"},
+		[]string{"f"},
+		[]DependsOn{{IdName: "f", Needs: "g"}},
+		[]Annotation{{IdName: "f", AnnotationKey: "main"}},
+	)
+}
+
+func TestOnlyGlobalsAndSysFnsAreReferences(t *testing.T) {
+	inst := func(name string, options ...string) *common.Node {
+		node := &common.Node{Name: name, Options: map[string]string{}}
+		for i := 0; i+1 < len(options); i += 2 {
+			node.Options[options[i]] = options[i+1]
+		}
+		return node
+	}
+	fn := &common.Node{
+		Name:    common.NameFn,
+		Options: map[string]string{common.OptionNParams: "0", common.OptionNLocals: "1"},
+		Children: []*common.Node{
+			inst(common.NameInProgress, common.OptionName, "x"),
+			inst(common.NameStackLength, common.OptionOffset, "0"),
+			inst(common.NamePushGlobal, common.OptionName, "g"),
+			inst(common.NameIfThenElse, common.OptionName, "L0", common.OptionValue, "L1"),
+			inst(common.NameLabel, common.OptionValue, "L0"),
+			inst(common.NameCallGlobalCounted, common.OptionName, "h", common.OptionOffset, "0"),
+			inst(common.NameLabel, common.OptionValue, "L1"),
+			inst(common.NameSysCallFixed, common.OptionSysFn, "println", common.OptionNArgs, "1"),
+			inst(common.NameCallGlobalFixed, common.OptionName, "g", common.OptionNArgs, "0"),
+			inst(common.NameDone, common.OptionName, "x", common.OptionOffset, "0"),
+			inst(common.NameReturn),
+		},
+	}
+	bind := &common.Node{
+		Name:     common.NameBind,
+		Options:  map[string]string{common.OptionLazy: "true"},
+		Children: []*common.Node{{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: "x"}}, fn},
+	}
+	b := newTestBundler(t)
+	if err := b.ProcessUnit(unitNode("a.nutmeg", bind)); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	checkRows(t, b, []string{"x"}, []string{}, []DependsOn{{IdName: "x", Needs: "g"}, {IdName: "x", Needs: "h"}}, []Annotation{})
+	uses, err := b.LoadSyscallUses()
+	if err != nil {
+		t.Fatalf("LoadSyscallUses failed: %v", err)
+	}
+	if expected := []SyscallUse{{IdName: "x", SysFn: "println"}}; !reflect.DeepEqual(uses, expected) {
+		t.Errorf("Expected %v, got %v", expected, uses)
+	}
+}
+
+func TestAnnotationValuesAreStored(t *testing.T) {
+	b := newTestBundler(t)
+	annotations := annotationsNode("main", "timeout")
+	annotations.Children[1].Options[common.OptionValue] = `[5]`
+	if err := b.ProcessUnit(unitNode("a.nutmeg", annotations, bindNode("f"))); err != nil {
+		t.Fatalf("ProcessUnit failed: %v", err)
+	}
+	checkRows(t, b, []string{"f"}, []string{"f"}, []DependsOn{},
+		[]Annotation{{IdName: "f", AnnotationKey: "main"}, {IdName: "f", AnnotationKey: "timeout", AnnotationValue: `[5]`}})
+}
@@ -34,7 +34,7 @@ func ConvertFnToFunctionObject(fnNode *common.Node) (*FunctionObject, error) {
 	}
 
 	// Convert the function body (children) to instructions.
-	instructions, err := convertNodesToInstructions(fnNode.Children)
+	instructions, spans, err := convertNodesToInstructions(fnNode.Children)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert function body: %w", err)
 	}
@@ -42,27 +42,43 @@ func ConvertFnToFunctionObject(fnNode *common.Node) (*FunctionObject, error) {
 	return &FunctionObject{
 		NLocals:      nlocals,
 		NParams:      nparams,
+		Lines:        NewLineTable(spans),
 		Instructions: instructions,
 	}, nil
 }
 
-// convertNodesToInstructions converts a list of nodes to instructions.
+// convertNodesToInstructions converts a list of nodes to instructions, along
+// with the source span of each instruction.
 // This function flattens container nodes (like <seq>) and extracts instruction nodes.
-func convertNodesToInstructions(nodes []*common.Node) ([]Instruction, error) {
+func convertNodesToInstructions(nodes []*common.Node) ([]Instruction, []common.Span, error) {
 	instructions := make([]Instruction, 0)
+	spans := make([]common.Span, 0)
 
 	for _, node := range nodes {
+		if node.Name == common.NameSeq || node.Name == common.NameArguments {
+			// For container nodes (like <seq>, <arguments>, etc.), recursively collect instructions from children.
+			childInstructions, childSpans, err := convertNodesToInstructions(node.Children)
+			if err != nil {
+				return nil, nil, err
+			}
+			instructions = append(instructions, childInstructions...)
+			spans = append(spans, childSpans...)
+			continue
+		}
 		nodeInstructions, err := collectInstructions(node)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		instructions = append(instructions, nodeInstructions...)
+		for range nodeInstructions {
+			spans = append(spans, node.Span)
+		}
 	}
 
-	return instructions, nil
+	return instructions, spans, nil
 }
 
-// collectInstructions recursively collects instructions from a node and its children.
+// collectInstructions converts a single instruction node.
 func collectInstructions(node *common.Node) ([]Instruction, error) {
 	// Check if this is an instruction node.
 	switch node.Name {
@@ -73,6 +89,27 @@ func collectInstructions(node *common.Node) ([]Instruction, error) {
 		}
 		return []Instruction{NewPushInt(value)}, nil
 
+	case common.NamePushBigInt:
+		value, err := getStringOption(node, common.OptionDecimal)
+		if err != nil {
+			return nil, fmt.Errorf("push.bigint missing value: %w", err)
+		}
+		return []Instruction{NewPushBigInt(value)}, nil
+
+	case common.NamePushRational:
+		value, err := getStringOption(node, common.OptionValue)
+		if err != nil {
+			return nil, fmt.Errorf("push.rational missing value: %w", err)
+		}
+		return []Instruction{NewPushRational(value)}, nil
+
+	case common.NamePushFloat:
+		value, err := getStringOption(node, common.OptionValue)
+		if err != nil {
+			return nil, fmt.Errorf("push.float missing value: %w", err)
+		}
+		return []Instruction{NewPushFloat(value)}, nil
+
 	case common.NamePushBool:
 		valueStr, err := getStringOption(node, common.OptionValue)
 		if err != nil {
@@ -151,6 +188,35 @@ func collectInstructions(node *common.Node) ([]Instruction, error) {
 		}
 		return []Instruction{NewCallGlobalCounted(name, offset)}, nil
 
+	case common.NameCallCounted:
+		offset, err := getIntOption(node, common.OptionOffset)
+		if err != nil {
+			return nil, fmt.Errorf("call.counted missing offset: %w", err)
+		}
+		return []Instruction{NewCallCounted(offset)}, nil
+
+	case common.NameSysCallFixed:
+		name, err := getStringOption(node, common.OptionSysFn)
+		if err != nil {
+			return nil, fmt.Errorf("syscall.fixed missing name: %w", err)
+		}
+		nargs, err := getIntOption(node, common.OptionNArgs)
+		if err != nil {
+			return nil, fmt.Errorf("syscall.fixed missing nargs: %w", err)
+		}
+		return []Instruction{NewSyscallFixed(name, nargs)}, nil
+
+	case common.NameCallGlobalFixed:
+		name, err := getStringOption(node, common.OptionName)
+		if err != nil {
+			return nil, fmt.Errorf("call.global.fixed missing name: %w", err)
+		}
+		nargs, err := getIntOption(node, common.OptionNArgs)
+		if err != nil {
+			return nil, fmt.Errorf("call.global.fixed missing nargs: %w", err)
+		}
+		return []Instruction{NewCallGlobalFixed(name, nargs)}, nil
+
 	case common.NameErase:
 		return []Instruction{NewErase()}, nil
 
@@ -161,6 +227,13 @@ func collectInstructions(node *common.Node) ([]Instruction, error) {
 		}
 		return []Instruction{NewCheckBool(offset)}, nil
 
+	case common.NameCheckOne:
+		offset, err := getIntOption(node, common.OptionOffset)
+		if err != nil {
+			return nil, fmt.Errorf("check.one missing offset: %w", err)
+		}
+		return []Instruction{NewCheckOne(offset)}, nil
+
 	case common.NameLabel:
 		label, err := getStringOption(node, common.OptionValue)
 		if err != nil {
@@ -213,13 +286,6 @@ func collectInstructions(node *common.Node) ([]Instruction, error) {
 		}
 		return []Instruction{NewInProgress(name)}, nil
 
-	case common.NameSeq, common.NameArguments:
-		// For container nodes (like <seq>, <arguments>, etc.), recursively collect instructions from children.
-		if len(node.Children) > 0 {
-			return convertNodesToInstructions(node.Children)
-		}
-		return []Instruction{}, nil
-
 	default:
 		return nil, fmt.Errorf("unrecognized instruction node: %s", node.Name)
 	}
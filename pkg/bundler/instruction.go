@@ -11,19 +11,32 @@ type Instruction struct {
 	// PushInt, PopLocal, PushLocal.
 	Index *int `json:"index,omitempty"`
 
+	// PushInt; SyscallFixed, CallGlobalFixed: the number of arguments.
 	IntValue *int `json:"ivalue,omitempty"`
 
 	// PushString, PushGlobal.
 	StrValue *string `json:"value,omitempty"`
 
-	// SyscallCounted, CallGlobalCounted.
+	// SyscallCounted, CallGlobalCounted, SyscallFixed, CallGlobalFixed.
 	Name *string `json:"name,omitempty"`
+
+	// Goto, IfNot, IfSo, IfThenElse once assembled: the index of the
+	// instruction to jump to.
+	Target *int `json:"target,omitempty"`
+
+	// IfThenElse once assembled: the index to jump to when false.
+	ElseTarget *int `json:"else,omitempty"`
 }
 
 // FunctionObject represents a compiled function with its metadata and instructions.
+// File and Lines say where in the source each instruction came from, see
+// LineEntry.
 type FunctionObject struct {
+	Version      int           `json:"version,omitempty"`
 	NLocals      int           `json:"nlocals"`
 	NParams      int           `json:"nparams"`
+	File         string        `json:"file,omitempty"`
+	Lines        []LineEntry   `json:"lines,omitempty"`
 	Instructions []Instruction `json:"instructions"`
 }
 
@@ -34,6 +47,24 @@ func NewPushInt(value int) Instruction {
 	return Instruction{Type: "push.int", IntValue: &value}
 }
 
+// NewPushBigInt creates a push.bigint instruction for an integer that is too
+// large for push.int, given in decimal.
+func NewPushBigInt(value string) Instruction {
+	return Instruction{Type: "push.bigint", StrValue: &value}
+}
+
+// NewPushRational creates a push.rational instruction for an exact fraction,
+// given as numerator/denominator in lowest terms.
+func NewPushRational(value string) Instruction {
+	return Instruction{Type: "push.rational", StrValue: &value}
+}
+
+// NewPushFloat creates a push.float instruction for a float, given as the
+// shortest decimal that reads back as the same float64.
+func NewPushFloat(value string) Instruction {
+	return Instruction{Type: "push.float", StrValue: &value}
+}
+
 func NewPushBool(value string) Instruction {
 	return Instruction{Type: "push.bool", StrValue: &value}
 }
@@ -82,6 +113,23 @@ func NewCallGlobalCounted(name string, nargs int) Instruction {
 	return Instruction{Type: "call.global.counted", Name: &name, Index: &nargs}
 }
 
+// NewCallCounted creates a call.counted instruction.
+func NewCallCounted(nargs int) Instruction {
+	return Instruction{Type: "call.counted", Index: &nargs}
+}
+
+// NewSyscallFixed creates a syscall.fixed instruction, which takes nargs
+// arguments off the stack.
+func NewSyscallFixed(name string, nargs int) Instruction {
+	return Instruction{Type: "syscall.fixed", Name: &name, IntValue: &nargs}
+}
+
+// NewCallGlobalFixed creates a call.global.fixed instruction, which takes
+// nargs arguments off the stack.
+func NewCallGlobalFixed(name string, nargs int) Instruction {
+	return Instruction{Type: "call.global.fixed", Name: &name, IntValue: &nargs}
+}
+
 // NewErase creates an erase instruction.
 func NewErase() Instruction {
 	return Instruction{Type: "erase"}
@@ -92,6 +140,11 @@ func NewCheckBool(offset int) Instruction {
 	return Instruction{Type: "check.bool", Index: &offset}
 }
 
+// NewCheckOne creates a check.one instruction.
+func NewCheckOne(offset int) Instruction {
+	return Instruction{Type: "check.one", Index: &offset}
+}
+
 // NewLabel creates a label instruction.
 func NewLabel(label string) Instruction {
 	return Instruction{Type: "label", StrValue: &label}
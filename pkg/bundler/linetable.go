@@ -0,0 +1,71 @@
+package bundler
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// LineEntry records that the instructions from Index up to the next entry
+// were compiled from the source at Span. To keep the line table compact it
+// is stored as a JSON array of five numbers: the index followed by the start
+// line, start column, end line and end column.
+type LineEntry struct {
+	Index int
+	Span  common.Span
+}
+
+// MarshalJSON implements custom JSON marshaling for LineEntry.
+func (e LineEntry) MarshalJSON() ([]byte, error) {
+	arr := [5]int{e.Index, e.Span.StartLine, e.Span.StartColumn, e.Span.EndLine, e.Span.EndColumn}
+	return json.Marshal(arr)
+}
+
+// UnmarshalJSON implements custom JSON unmarshaling for LineEntry.
+func (e *LineEntry) UnmarshalJSON(data []byte) error {
+	var arr [5]int
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return err
+	}
+	e.Index = arr[0]
+	e.Span = common.Span{StartLine: arr[1], StartColumn: arr[2], EndLine: arr[3], EndColumn: arr[4]}
+	return nil
+}
+
+// NewLineTable compresses the spans of a list of instructions into a line
+// table, with an entry wherever the span changes. An instruction with an
+// unknown (zero) span is taken to belong with the one before it.
+func NewLineTable(spans []common.Span) []LineEntry {
+	var lines []LineEntry
+	for i, span := range spans {
+		if span == (common.Span{}) {
+			continue
+		}
+		if len(lines) > 0 && lines[len(lines)-1].Span == span {
+			continue
+		}
+		lines = append(lines, LineEntry{Index: i, Span: span})
+	}
+	return lines
+}
+
+// SpanAt returns the span of the source that the instruction at index was
+// compiled from. The second result is false if that is not known.
+func (f *FunctionObject) SpanAt(index int) (common.Span, bool) {
+	// Find the last entry that starts at or before index.
+	k := sort.Search(len(f.Lines), func(k int) bool { return f.Lines[k].Index > index }) - 1
+	if k < 0 || index < 0 || index >= len(f.Instructions) {
+		return common.Span{}, false
+	}
+	return f.Lines[k].Span, true
+}
+
+// instructionSpans expands the line table into the span of each instruction.
+func (f *FunctionObject) instructionSpans() []common.Span {
+	spans := make([]common.Span, len(f.Instructions))
+	for i := range spans {
+		spans[i], _ = f.SpanAt(i)
+	}
+	return spans
+}
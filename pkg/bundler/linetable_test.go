@@ -0,0 +1,97 @@
+package bundler
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func span(line, column int) common.Span {
+	return common.Span{StartLine: line, StartColumn: column, EndLine: line, EndColumn: column + 1}
+}
+
+func TestNewLineTableCompressesSpans(t *testing.T) {
+	lines := NewLineTable([]common.Span{span(1, 1), span(1, 1), {}, span(2, 3), span(1, 1)})
+	expected := []LineEntry{{0, span(1, 1)}, {3, span(2, 3)}, {4, span(1, 1)}}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, lines)
+	}
+
+	data, err := json.Marshal(lines[1])
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != "[3,2,3,2,4]" {
+		t.Errorf("Expected [3,2,3,2,4], got %s", data)
+	}
+	var entry LineEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if entry != lines[1] {
+		t.Errorf("Expected %v, got %v", lines[1], entry)
+	}
+}
+
+func TestSpanAt(t *testing.T) {
+	funcObj := &FunctionObject{
+		Lines:        []LineEntry{{1, span(1, 1)}, {3, span(2, 3)}},
+		Instructions: []Instruction{NewPushInt(1), NewPushInt(2), NewPushInt(3), NewPushInt(4), NewReturn()},
+	}
+	tests := []struct {
+		index    int
+		expected common.Span
+		ok       bool
+	}{
+		{-1, common.Span{}, false},
+		{0, common.Span{}, false},
+		{1, span(1, 1), true},
+		{2, span(1, 1), true},
+		{4, span(2, 3), true},
+		{5, common.Span{}, false},
+	}
+	for _, test := range tests {
+		got, ok := funcObj.SpanAt(test.index)
+		if got != test.expected || ok != test.ok {
+			t.Errorf("SpanAt(%d): expected %v %v, got %v %v", test.index, test.expected, test.ok, got, ok)
+		}
+	}
+}
+
+func TestAssembleKeepsSpans(t *testing.T) {
+	symbolic := &FunctionObject{
+		File:  "a.nutmeg",
+		Lines: []LineEntry{{0, span(1, 1)}, {2, span(2, 1)}, {4, span(3, 1)}},
+		Instructions: []Instruction{
+			NewPushLocal(0),
+			NewIfNot("L0"),
+			NewLabel("L1"),
+			NewPushInt(1),
+			NewLabel("L0"),
+			NewReturn(),
+		},
+	}
+	assembled, err := Assemble(symbolic)
+	if err != nil {
+		t.Fatalf("Assemble failed: %v", err)
+	}
+	if assembled.File != "a.nutmeg" {
+		t.Errorf("Expected the file name to be kept, got %q", assembled.File)
+	}
+	expected := []LineEntry{{0, span(1, 1)}, {2, span(2, 1)}, {3, span(3, 1)}}
+	if !reflect.DeepEqual(assembled.Lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, assembled.Lines)
+	}
+
+	// Disassembling puts each label with the instruction after it.
+	disassembled, err := Disassemble(assembled)
+	if err != nil {
+		t.Fatalf("Disassemble failed: %v", err)
+	}
+	expected = []LineEntry{{0, span(1, 1)}, {2, span(2, 1)}, {3, span(3, 1)}}
+	if !reflect.DeepEqual(disassembled.Lines, expected) {
+		t.Errorf("Expected %v, got %v", expected, disassembled.Lines)
+	}
+}
@@ -0,0 +1,81 @@
+package bundler
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LoadBindings returns every binding stored in the bundle, ordered by name.
+func (b *Bundler) LoadBindings() ([]Binding, error) {
+	var bindings []Binding
+	result := b.db.Order("id_name").Find(&bindings)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load bindings: %w", result.Error)
+	}
+	return bindings, nil
+}
+
+// LoadEntryPoints returns every entry point stored in the bundle, ordered by
+// name.
+func (b *Bundler) LoadEntryPoints() ([]EntryPoint, error) {
+	var entryPoints []EntryPoint
+	result := b.db.Order("id_name").Find(&entryPoints)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load entry points: %w", result.Error)
+	}
+	return entryPoints, nil
+}
+
+// LoadDependencies returns every dependency stored in the bundle, ordered by
+// the name of the binding and then by what it needs.
+func (b *Bundler) LoadDependencies() ([]DependsOn, error) {
+	var dependencies []DependsOn
+	result := b.db.Order("id_name").Order("needs").Find(&dependencies)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load dependencies: %w", result.Error)
+	}
+	return dependencies, nil
+}
+
+// LoadSyscallUses returns every system function use in the bundle, ordered
+// by binding name and then system function.
+func (b *Bundler) LoadSyscallUses() ([]SyscallUse, error) {
+	var uses []SyscallUse
+	result := b.db.Order("id_name").Order("sys_fn").Find(&uses)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load system function uses: %w", result.Error)
+	}
+	return uses, nil
+}
+
+// LoadAnnotations returns every annotation stored in the bundle, ordered by
+// the name of the binding and then by key.
+func (b *Bundler) LoadAnnotations() ([]Annotation, error) {
+	var annotations []Annotation
+	result := b.db.Order("id_name").Order("annotation_key").Find(&annotations)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load annotations: %w", result.Error)
+	}
+	return annotations, nil
+}
+
+// LoadSourceFileNames returns the names of the source files stored in the
+// bundle, in order.
+func (b *Bundler) LoadSourceFileNames() ([]string, error) {
+	var names []string
+	result := b.db.Model(&SourceFile{}).Order("file_name").Pluck("file_name", &names)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load source file names: %w", result.Error)
+	}
+	return names, nil
+}
+
+// DecodeFunctionObject decodes the JSON stored in the value column of a
+// binding back into a FunctionObject.
+func DecodeFunctionObject(value string) (*FunctionObject, error) {
+	var funcObj FunctionObject
+	if err := json.Unmarshal([]byte(value), &funcObj); err != nil {
+		return nil, fmt.Errorf("failed to decode function object: %w", err)
+	}
+	return &funcObj, nil
+}
@@ -0,0 +1,34 @@
+package bundler
+
+import "sort"
+
+// FindReferences returns the globals and the system functions that a
+// function object refers to, each sorted and without duplicates. Only the
+// operands of the instructions that push or call a global, or call a system
+// function, count: labels and the names given to in.progress and done are
+// not references.
+func FindReferences(funcObj *FunctionObject) (globals []string, sysfns []string) {
+	seenGlobals := make(map[string]bool)
+	seenSysFns := make(map[string]bool)
+	for _, inst := range funcObj.Instructions {
+		if inst.Name == nil {
+			continue
+		}
+		switch inst.Type {
+		case "push.global", "call.global.counted", "call.global.fixed":
+			seenGlobals[*inst.Name] = true
+		case "syscall.counted", "syscall.fixed":
+			seenSysFns[*inst.Name] = true
+		}
+	}
+	return sortedKeys(seenGlobals), sortedKeys(seenSysFns)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
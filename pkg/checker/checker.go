@@ -2,7 +2,6 @@ package checker
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/spicery/nutmeg-compiler/pkg/common"
 )
@@ -23,26 +22,6 @@ type Checker struct {
 	Issues []Issue // Accumulated validation errors.
 }
 
-func (c *Checker) ReportErrors() {
-	// First report any bugs and then move onto issues.
-	if len(c.Bugs) > 0 {
-		fmt.Fprintln(os.Stderr, "Bug in parser detected; the output of the parser is faulty:")
-		count := 0
-		for _, bug := range c.Bugs {
-			count++
-			fmt.Fprintf(os.Stderr, "  [%d]. %s, at line %d, column %d\n", count, bug.Message, bug.Node.Span.StartLine, bug.Node.Span.StartColumn)
-		}
-	}
-	if len(c.Issues) > 0 {
-		fmt.Fprintln(os.Stderr, "Errors found in the source code:")
-		count := 0
-		for _, issue := range c.Issues {
-			count++
-			fmt.Fprintf(os.Stderr, "  [%d]. %s, at line %d, column %d\n", count, issue.Message, issue.Node.Span.StartLine, issue.Node.Span.StartColumn)
-		}
-	}
-}
-
 // NewChecker creates a new checker instance.
 func NewChecker() *Checker {
 	return &Checker{
@@ -67,7 +46,13 @@ func (c *Checker) Check(node *common.Node) bool {
 	// TODO: The top-level children must either be:
 	//  bind or annotations.
 
-	c.validateChildren(node)
+	for _, child := range node.Children {
+		if isAnnotations(child) {
+			c.validateAnnotations(child)
+		} else {
+			c.validate(child)
+		}
+	}
 
 	return len(c.Issues) == 0 && len(c.Bugs) == 0
 }
@@ -91,10 +76,16 @@ func (c *Checker) validate(node *common.Node) {
 		c.validateApply(node)
 	case common.NameDelimited:
 		c.validateDelimited(node)
+	case common.NameError:
+		// The parser has already reported the error that it recovered from.
 	case common.NameForm:
 		c.validateForm(node)
 	case common.NameIdentifier:
 		c.validateIdentifier(node)
+	case common.NameJoin:
+		c.validateChildren(node)
+	case common.NameJoinLines:
+		c.validateJoinLines(node)
 	case common.NameNumber:
 		c.validateNumber(node)
 	case common.NameOperator:
@@ -112,6 +103,19 @@ func (c *Checker) validateString(node *common.Node) {
 	c.factArity(0, node)
 }
 
+// validateJoinLines checks a multi-line string, whose children are its lines.
+// Each line is either a plain string or an interpolated string.
+func (c *Checker) validateJoinLines(node *common.Node) {
+	for _, line := range node.Children {
+		switch line.Name {
+		case common.NameString, common.NameJoin:
+			c.validate(line)
+		default:
+			c.addBug(fmt.Sprintf("unexpected line in multi-line string: %s", line.Name), line)
+		}
+	}
+}
+
 func (c *Checker) validateNumber(node *common.Node) {
 	c.factArity(0, node)
 }
@@ -120,9 +124,93 @@ func (c *Checker) validateOperator(node *common.Node) {
 	if _, ok := node.Options[common.OptionName]; !ok {
 		c.addBug("operator node missing name option", node)
 	}
+	if node.Options[common.OptionName] == "=" {
+		c.addIssue("'=' can only name an argument of an annotation, use := to bind or <- to assign", node)
+	}
 	c.validateChildren(node)
 }
 
+// isAnnotations returns true for a top-level bracketed list, such as
+// `[main]`, which the rewriter turns into annotations.
+func isAnnotations(node *common.Node) bool {
+	return node.Name == common.NameDelimited && node.Options[common.OptionKind] == common.ValueBrackets
+}
+
+// validateAnnotations checks a list of annotations. Each one is a name,
+// such as `main`, or a name applied to literal arguments, which are either
+// all positional, as in `timeout(5)`, or all named, as in
+// `entrypoint(name="cli")`.
+func (c *Checker) validateAnnotations(node *common.Node) {
+	for _, annotation := range node.Children {
+		switch annotation.Name {
+		case common.NameIdentifier:
+			c.validateIdentifier(annotation)
+		case common.NameApply:
+			c.validateAnnotationApply(annotation)
+		default:
+			c.addIssue("an annotation must be a name, optionally with arguments", annotation)
+		}
+	}
+}
+
+func (c *Checker) validateAnnotationApply(node *common.Node) {
+	if len(node.Children) != 2 || node.Children[1].Name != common.NameArguments {
+		c.addBug("invalid apply node in annotation", node)
+		return
+	}
+	if node.Children[0].Name != common.NameIdentifier || node.Options[common.OptionKind] != common.ValueParentheses {
+		c.addIssue("an annotation must be a name, optionally with arguments", node)
+		return
+	}
+	c.validateIdentifier(node.Children[0])
+	named := make(map[string]bool)
+	positional := 0
+	for _, arg := range node.Children[1].Children {
+		if arg.Name == common.NameOperator && arg.Options[common.OptionName] == "=" && len(arg.Children) == 2 {
+			name := arg.Children[0]
+			if name.Name != common.NameIdentifier {
+				c.addIssue("the name of an annotation argument must be an identifier", name)
+				continue
+			}
+			if named[name.Options[common.OptionName]] {
+				c.addIssue(fmt.Sprintf("annotation argument %s is given more than once", name.Options[common.OptionName]), name)
+			}
+			named[name.Options[common.OptionName]] = true
+			c.validateAnnotationLiteral(arg.Children[1])
+		} else {
+			positional++
+			c.validateAnnotationLiteral(arg)
+		}
+	}
+	if positional > 0 && len(named) > 0 {
+		c.addIssue("annotation arguments must be either all named or all positional", node)
+	}
+}
+
+// validateAnnotationLiteral checks that an annotation argument is a string,
+// a number, possibly negated, or a boolean.
+func (c *Checker) validateAnnotationLiteral(node *common.Node) {
+	switch node.Name {
+	case common.NameString, common.NameNumber:
+		c.validate(node)
+		return
+	case common.NameOperator:
+		if node.Options[common.OptionName] == "-" && len(node.Children) == 1 && node.Children[0].Name == common.NameNumber {
+			c.validate(node)
+			return
+		}
+	case common.NameForm:
+		if len(node.Children) == 1 {
+			switch node.Children[0].Options[common.OptionKeyword] {
+			case common.ValueTrue, common.ValueFalse:
+				c.validate(node)
+				return
+			}
+		}
+	}
+	c.addIssue("annotation arguments must be strings, numbers or booleans", node)
+}
+
 func (c *Checker) validateApply(node *common.Node) {
 	if len(node.Children) != 2 {
 		c.addBug("apply node must have exactly two children", node)
@@ -190,11 +278,30 @@ func (c *Checker) validateForm(node *common.Node) {
 		c.validateFormLet(node)
 	case common.ValueTrue, common.ValueFalse:
 		c.validateFormBoolean(node)
+	case common.ValueVal, common.ValueVar, common.ValueConst:
+		c.validateFormQualifier(node)
 	default:
 		c.addIssue(fmt.Sprintf("unexpected form keyword: %s", keyword), first)
 	}
 }
 
+// validateFormQualifier validates a qualified identifier such as "var x",
+// which must consist of the qualifier followed by a single identifier.
+func (c *Checker) validateFormQualifier(qualifier_node *common.Node) {
+	if !c.factArity(1, qualifier_node) {
+		return
+	}
+	part := qualifier_node.Children[0]
+	if !c.expectArity(1, part) {
+		return
+	}
+	if part.Children[0].Name != common.NameIdentifier {
+		c.addIssue(fmt.Sprintf("%s must be followed by an identifier", part.Options[common.OptionKeyword]), part)
+		return
+	}
+	c.validateIdentifier(part.Children[0])
+}
+
 func (c *Checker) validateFormBoolean(bool_node *common.Node) {
 	if !c.factArity(1, bool_node) {
 		return
@@ -360,8 +467,12 @@ func (c *Checker) validateFormFor(for_node *common.Node) {
 	if !c.factArity(2, for_node) {
 		return
 	}
-	c.validateQuery(for_node.Children[0])
-	c.validate(for_node.Children[1])
+	query_part := for_node.Children[0]
+	if !c.expectArity(1, query_part) {
+		return
+	}
+	c.validateQuery(query_part.Children[0])
+	c.validateChildren(for_node.Children[1])
 }
 
 func (c *Checker) validateQuery(query *common.Node) {
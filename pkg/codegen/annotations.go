@@ -0,0 +1,99 @@
+package codegen
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// generateAnnotations works out the values of the arguments of annotations,
+// which the checker has restricted to literals. An annotation with arguments,
+// such as `[timeout(5)]`, is replaced by the identifier of its name with the
+// arguments stored as JSON in its value option: an array for positional
+// arguments, `[5]`, or an object for named ones, `{"name":"cli"}`. A bare
+// annotation such as `[main]` is left without a value.
+func (cg *CodeGenerator) generateAnnotations(node *common.Node) error {
+	for i, child := range node.Children {
+		switch child.Name {
+		case common.NameIdentifier:
+		case common.NameApply:
+			if len(child.Children) != 2 || child.Children[0].Name != common.NameIdentifier {
+				return common.SpanErrorf(child.Span, "invalid annotation")
+			}
+			value, err := annotationArguments(child.Children[1])
+			if err != nil {
+				return err
+			}
+			node.Children[i] = &common.Node{
+				Name: common.NameIdentifier,
+				Span: child.Span,
+				Options: map[string]string{
+					common.OptionName:  child.Children[0].Options[common.OptionName],
+					common.OptionValue: value,
+				},
+			}
+		default:
+			return common.SpanErrorf(child.Span, "invalid annotation")
+		}
+	}
+	return nil
+}
+
+// annotationArguments encodes the arguments of an annotation as JSON.
+func annotationArguments(args *common.Node) (string, error) {
+	positional := make([]json.RawMessage, 0)
+	named := make(map[string]json.RawMessage)
+	for _, arg := range args.Children {
+		if arg.Name == common.NameOperator && arg.Options[common.OptionName] == "=" && len(arg.Children) == 2 {
+			value, err := literalJSON(arg.Children[1])
+			if err != nil {
+				return "", err
+			}
+			named[arg.Children[0].Options[common.OptionName]] = value
+			continue
+		}
+		value, err := literalJSON(arg)
+		if err != nil {
+			return "", err
+		}
+		positional = append(positional, value)
+	}
+	var result []byte
+	var err error
+	if len(named) > 0 {
+		result, err = json.Marshal(named)
+	} else {
+		result, err = json.Marshal(positional)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// literalJSON encodes a literal as JSON. Exact numbers that are not integers
+// are given as the nearest float, since JSON has nothing better.
+func literalJSON(node *common.Node) (json.RawMessage, error) {
+	switch node.Name {
+	case common.NameString:
+		return json.Marshal(node.Options[common.OptionValue])
+	case common.NameBoolean:
+		return json.Marshal(node.Options[common.OptionValue] == common.ValueTrue)
+	case common.NameNumber:
+		value, isFloat, err := numberValue(node)
+		if err != nil {
+			return nil, common.SpanErrorf(node.Span, "%w", err)
+		}
+		if !isFloat && value.IsInt() {
+			return json.RawMessage(value.Num().String()), nil
+		}
+		f, _ := value.Float64()
+		if math.IsInf(f, 0) {
+			return nil, common.SpanErrorf(node.Span, "number is too large to be a float")
+		}
+		return json.RawMessage(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	}
+	return nil, common.SpanErrorf(node.Span, "annotation arguments must be literals")
+}
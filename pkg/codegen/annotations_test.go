@@ -0,0 +1,62 @@
+package codegen
+
+import (
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func identifierNode(name string) *common.Node {
+	return &common.Node{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: name}}
+}
+
+func namedArgNode(name string, value *common.Node) *common.Node {
+	return &common.Node{
+		Name:     common.NameOperator,
+		Options:  map[string]string{common.OptionName: "="},
+		Children: []*common.Node{identifierNode(name), value},
+	}
+}
+
+func TestGenerateAnnotations(t *testing.T) {
+	// [main, deprecated("use bar"), timeout(-5), entrypoint(name="cli", ratio=2.5, debug=true)]
+	annotations := &common.Node{
+		Name: common.NameAnnotations,
+		Children: []*common.Node{
+			identifierNode("main"),
+			applyNode(identifierNode("deprecated"), stringNode("use bar")),
+			applyNode(identifierNode("timeout"), numberNode("10", "5", "", "0", "-")),
+			applyNode(identifierNode("entrypoint"),
+				namedArgNode("name", stringNode("cli")),
+				namedArgNode("ratio", numberNode("10", "2", "5", "0", "+")),
+				namedArgNode("debug", &common.Node{Name: common.NameBoolean, Options: map[string]string{common.OptionValue: common.ValueTrue}}),
+			),
+		},
+	}
+	if err := NewCodeGenerator().generateAnnotations(annotations); err != nil {
+		t.Fatalf("generateAnnotations failed: %v", err)
+	}
+	expected := []struct{ name, value string }{
+		{"main", ""},
+		{"deprecated", `["use bar"]`},
+		{"timeout", `[-5]`},
+		{"entrypoint", `{"debug":true,"name":"cli","ratio":2.5}`},
+	}
+	for i, want := range expected {
+		got := annotations.Children[i]
+		if got.Name != common.NameIdentifier || got.Options[common.OptionName] != want.name || got.Options[common.OptionValue] != want.value {
+			t.Errorf("Expected annotation %s with value %q, got %s %s with value %q", want.name, want.value, got.Name, got.Options[common.OptionName], got.Options[common.OptionValue])
+		}
+	}
+}
+
+func TestGenerateAnnotationsRejectsExpressions(t *testing.T) {
+	// [timeout(x)]
+	annotations := &common.Node{
+		Name:     common.NameAnnotations,
+		Children: []*common.Node{applyNode(identifierNode("timeout"), identifierNode("x"))},
+	}
+	if err := NewCodeGenerator().generateAnnotations(annotations); err == nil {
+		t.Errorf("Expected an error for an argument that is not a literal")
+	}
+}
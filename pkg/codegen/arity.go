@@ -0,0 +1,106 @@
+package codegen
+
+import (
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// checkSysCall looks up a system function in the registry of builtins and,
+// if the number of arguments can be worked out at compile time, checks that
+// the system function accepts that many.
+func (fcg *FnCodeGenState) checkSysCall(sysfn string, args []*common.Node, node *common.Node) error {
+	b, ok := fcg.CodeGenerator.builtins.LookupSysFn(sysfn)
+	if !ok {
+		return common.SpanErrorf(node.Span, "unknown system function: %s", sysfn)
+	}
+	if nargs, known := fcg.countArguments(args); known {
+		if err := b.CheckArgs(nargs); err != nil {
+			return common.SpanErrorf(node.Span, "%w", err)
+		}
+	}
+	return nil
+}
+
+// argumentCount says how a call finds out how many arguments it was given.
+// Either the number is known at compile time, or tmpvar holds the length of
+// the stack from before the arguments were pushed.
+type argumentCount struct {
+	nargs  int
+	tmpvar *TemporaryVariable
+}
+
+// plantArguments plants the arguments of a call. If fixed is true and the
+// number of values they push is known then that is all it does, otherwise it
+// first records the stack length in a temporary variable. The call
+// instruction planted with the result frees the temporary variable.
+func (fcg *FnCodeGenState) plantArguments(args []*common.Node, fixed bool) (argumentCount, error) {
+	var count argumentCount
+	if nargs, known := fcg.countArguments(args); fixed && known {
+		count.nargs = nargs
+	} else {
+		count.tmpvar = fcg.plantStackLength()
+	}
+	for _, arg := range args {
+		if err := fcg.plantInstructions(arg); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// countArguments adds up the number of values that a list of expressions
+// push. The second result is false if that cannot be known at compile time.
+func (fcg *FnCodeGenState) countArguments(args []*common.Node) (int, bool) {
+	total := 0
+	for _, arg := range args {
+		n, known := fcg.countValues(arg)
+		if !known {
+			return 0, false
+		}
+		total += n
+	}
+	return total, true
+}
+
+// countValues returns how many values an expression pushes, if that is known
+// at compile time.
+func (fcg *FnCodeGenState) countValues(node *common.Node) (int, bool) {
+	switch node.Name {
+	case common.NameNumber, common.NameString, common.NameBoolean, common.NameIdentifier,
+		common.NameSysFn, common.NamePartApply, common.NameFn, common.NameJoin, common.NameJoinLines:
+		return 1, true
+	case common.NameSysCall:
+		return fcg.countResults(node.Options[common.OptionSysFn])
+	case common.NameApply:
+		if len(node.Children) == 2 && node.Children[0].Name == common.NameSysFn {
+			return fcg.countResults(node.Children[0].Options[common.OptionSysFn])
+		}
+	case common.NameBind, common.NameAssign:
+		return 0, true
+	case common.NameSeq:
+		return fcg.countArguments(node.Children)
+	case common.NameFor:
+		// A loop delivers its body's values once per iteration, which is
+		// only known when the body delivers none.
+		if len(node.Children) == 2 {
+			if n, known := fcg.countValues(node.Children[1]); known && n == 0 {
+				return 0, true
+			}
+		}
+	case common.NameIf:
+		if len(node.Children) == 3 {
+			thenCount, thenKnown := fcg.countValues(node.Children[1])
+			elseCount, elseKnown := fcg.countValues(node.Children[2])
+			if thenKnown && elseKnown && thenCount == elseCount {
+				return thenCount, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (fcg *FnCodeGenState) countResults(sysfn string) (int, bool) {
+	if b, ok := fcg.CodeGenerator.builtins.LookupSysFn(sysfn); ok {
+		return b.Results, true
+	}
+	return 0, false
+}
@@ -0,0 +1,72 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func intNode(value string) *common.Node {
+	return &common.Node{
+		Name: common.NameNumber,
+		Options: map[string]string{
+			common.OptionMantissa: value,
+			common.OptionFraction: "",
+			common.OptionExponent: "0",
+			common.OptionBase:     "10",
+		},
+	}
+}
+
+func sysCallNode(sysfn string, args ...*common.Node) *common.Node {
+	return &common.Node{
+		Name:     common.NameSysCall,
+		Options:  map[string]string{common.OptionSysFn: sysfn},
+		Children: args,
+	}
+}
+
+func TestSysCallArgumentCounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     *common.Node
+		expected string // Empty if the node should compile.
+	}{
+		{"Binary operator", sysCallNode("+", intNode("1"), intNode("2")), ""},
+		{"Too few arguments", sysCallNode("+", intNode("1")), "+ expects 2 arguments but was given 1"},
+		{"Too many arguments", sysCallNode("<", intNode("1"), intNode("2"), intNode("3")), "< expects 2 arguments but was given 3"},
+		{"Nested results are counted", sysCallNode("*", sysCallNode("-", intNode("3"), intNode("1")), intNode("2")), ""},
+		{"Zero results are counted", sysCallNode("+", sysCallNode("println"), intNode("2")), "+ expects 2 arguments but was given 1"},
+		{"Variadic", sysCallNode("println", intNode("1"), intNode("2"), intNode("3")), ""},
+		{"Unknown system function", sysCallNode("frobnicate", intNode("1")), "unknown system function: frobnicate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fcg := NewCodeGenerator().NewFnCodeGenState()
+			err := fcg.plantInstructions(tt.node)
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestUnknownArgumentCountIsAllowed(t *testing.T) {
+	// The result count of a global function is not known at compile time.
+	call := &common.Node{
+		Name: common.NameApply,
+		Children: []*common.Node{
+			{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: "f", common.OptionScope: common.ValueGlobal}},
+			{Name: common.NameArguments},
+		},
+	}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(sysCallNode("+", call)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
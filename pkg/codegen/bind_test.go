@@ -0,0 +1,114 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func localNode(name string, serialNo string) *common.Node {
+	return &common.Node{
+		Name: common.NameIdentifier,
+		Options: map[string]string{
+			common.OptionName:     name,
+			common.OptionSerialNo: serialNo,
+			common.OptionScope:    common.ValueInner,
+		},
+	}
+}
+
+func TestPlantSeqBindAndAssign(t *testing.T) {
+	// var x := 1; x <- 2; x
+	seq := &common.Node{
+		Name: common.NameSeq,
+		Children: []*common.Node{
+			{Name: common.NameBind, Children: []*common.Node{localNode("x", "7"), intNode("1")}},
+			{Name: common.NameAssign, Children: []*common.Node{localNode("x", "7"), intNode("2")}},
+			localNode("x", "7"),
+		},
+	}
+
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(seq); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+
+	expected := []struct {
+		name   string
+		option string
+	}{
+		{common.NamePushInt, "1"},
+		{common.NamePopLocal, "0"},
+		{common.NamePushInt, "2"},
+		{common.NamePopLocal, "0"},
+		{common.NamePushLocal, "0"},
+	}
+	instructions := fcg.instructions.Items()
+	if len(instructions) != len(expected) {
+		t.Fatalf("Expected %d instructions, got %d", len(expected), len(instructions))
+	}
+	for i, exp := range expected {
+		inst := instructions[i]
+		if inst.Name != exp.name {
+			t.Errorf("Instruction %d: expected %s, got %s", i, exp.name, inst.Name)
+			continue
+		}
+		option := inst.Options[common.OptionOffset]
+		if inst.Name == common.NamePushInt {
+			option = inst.Options[common.OptionDecimal]
+		}
+		if option != exp.option {
+			t.Errorf("Instruction %d: expected %s %s, got %s", i, exp.name, exp.option, option)
+		}
+	}
+}
+
+func TestBindMustDeliverOneValue(t *testing.T) {
+	bind := &common.Node{
+		Name:     common.NameBind,
+		Children: []*common.Node{localNode("x", "1"), sysCallNode("println")},
+	}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	err := fcg.plantInstructions(bind)
+	if err == nil || !strings.Contains(err.Error(), "cannot bind x to 0 values") {
+		t.Errorf("Expected a value count error, got %v", err)
+	}
+}
+
+func TestBindOfUnknownCountIsCheckedAtRunTime(t *testing.T) {
+	// val x := g() where the number of values g returns is unknown.
+	g := &common.Node{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: "g", common.OptionScope: common.ValueGlobal}}
+	bind := &common.Node{
+		Name:     common.NameBind,
+		Children: []*common.Node{localNode("x", "1"), applyNode(g)},
+	}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(bind); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NameStackLength, common.NameCallGlobalFixed, common.NameCheckOne, common.NamePopLocal,
+	})
+	stackLength := fcg.instructions.Items()[0]
+	check := fcg.instructions.Items()[2]
+	if check.Options[common.OptionOffset] != stackLength.Options[common.OptionOffset] {
+		t.Errorf("Expected check.one to use offset %s, got %s", stackLength.Options[common.OptionOffset], check.Options[common.OptionOffset])
+	}
+}
+
+func TestAssignToGlobalIsRejected(t *testing.T) {
+	global := &common.Node{
+		Name:    common.NameIdentifier,
+		Options: map[string]string{common.OptionName: "g", common.OptionScope: common.ValueGlobal},
+	}
+	assign := &common.Node{
+		Name:     common.NameAssign,
+		Children: []*common.Node{global, intNode("1")},
+	}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	err := fcg.plantInstructions(assign)
+	if err == nil || !strings.Contains(err.Error(), "cannot assign non-local identifier g") {
+		t.Errorf("Expected a non-local error, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func applyNode(fn *common.Node, args ...*common.Node) *common.Node {
+	return &common.Node{
+		Name:     common.NameApply,
+		Children: []*common.Node{fn, {Name: common.NameArguments, Children: args}},
+	}
+}
+
+func TestCallLocalFunction(t *testing.T) {
+	// f(1) where f is a parameter.
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(applyNode(localNode("f", "1"), intNode("1"))); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	// The function is pushed after its arguments.
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NameStackLength, common.NamePushInt, common.NamePushLocal, common.NameCallCounted,
+	})
+	stackLength := fcg.instructions.Items()[0]
+	call := fcg.instructions.Items()[3]
+	if call.Options[common.OptionOffset] != stackLength.Options[common.OptionOffset] {
+		t.Errorf("Expected call.counted to use offset %s, got %s", stackLength.Options[common.OptionOffset], call.Options[common.OptionOffset])
+	}
+}
+
+func TestCallLiftedLambdaByName(t *testing.T) {
+	lifted := &common.Node{
+		Name:    common.NameIdentifier,
+		Options: map[string]string{common.OptionName: "tmp-7", common.OptionScope: common.ValueUnit},
+	}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(applyNode(lifted, intNode("1"))); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NamePushInt, common.NameCallGlobalFixed,
+	})
+	if nargs := fcg.instructions.Items()[1].Options[common.OptionNArgs]; nargs != "1" {
+		t.Errorf("Expected 1 argument, got %s", nargs)
+	}
+}
+
+func TestCallResultOfPartApply(t *testing.T) {
+	// (fn x =>> k + x)(1), where k is captured.
+	lifted := &common.Node{
+		Name:    common.NameIdentifier,
+		Options: map[string]string{common.OptionName: "tmp-7", common.OptionScope: common.ValueUnit},
+	}
+	closure := &common.Node{
+		Name:     common.NamePartApply,
+		Children: []*common.Node{lifted, {Name: common.NameArguments, Children: []*common.Node{localNode("k", "2")}}},
+	}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(applyNode(closure, intNode("1"))); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	// The closure's own arguments are counted statically, including the
+	// lifted function, but the closure is called by value.
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NameStackLength, common.NamePushInt,
+		common.NamePushLocal, common.NamePushGlobal, common.NameSysCallFixed,
+		common.NameCallCounted,
+	})
+	if nargs := fcg.instructions.Items()[4].Options[common.OptionNArgs]; nargs != "2" {
+		t.Errorf("Expected partapply to take 2 arguments, got %s", nargs)
+	}
+}
+
+func TestCallGlobalWithUnknownArgumentCount(t *testing.T) {
+	// f(g()) where the number of values g returns is unknown.
+	f := &common.Node{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: "f", common.OptionScope: common.ValueGlobal}}
+	g := &common.Node{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: "g", common.OptionScope: common.ValueGlobal}}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(applyNode(f, applyNode(g))); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NameStackLength, common.NameCallGlobalFixed, common.NameCallGlobalCounted,
+	})
+	if nargs := fcg.instructions.Items()[1].Options[common.OptionNArgs]; nargs != "0" {
+		t.Errorf("Expected g to be called with 0 arguments, got %s", nargs)
+	}
+}
+
+func TestCallOfSeveralValuesIsRejected(t *testing.T) {
+	callee := &common.Node{Name: common.NameSeq, Children: []*common.Node{intNode("1"), intNode("2")}}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	err := fcg.plantInstructions(applyNode(callee))
+	if err == nil || !strings.Contains(err.Error(), "delivers 2 values") {
+		t.Errorf("Expected a value count error, got %v", err)
+	}
+}
+
+func TestInstructionsKeepTheSpanOfTheirNode(t *testing.T) {
+	// f(1) where f is a parameter, with the 1 at column 3.
+	arg := intNode("1")
+	arg.Span = common.Span{StartLine: 1, StartColumn: 3, EndLine: 1, EndColumn: 4}
+	call := applyNode(localNode("f", "1"), arg)
+	call.Span = common.Span{StartLine: 1, StartColumn: 1, EndLine: 1, EndColumn: 5}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(call); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	for i, inst := range fcg.instructions.Items() {
+		expected := call.Span
+		if inst.Name == common.NamePushInt {
+			expected = arg.Span
+		}
+		if inst.Span != expected {
+			t.Errorf("Expected instruction %d (%s) to have span %v, got %v", i, inst.Name, expected, inst.Span)
+		}
+	}
+}
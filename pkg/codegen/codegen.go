@@ -3,6 +3,7 @@ package codegen
 import (
 	"fmt"
 
+	"github.com/spicery/nutmeg-compiler/pkg/builtins"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
 )
 
@@ -13,6 +14,8 @@ type CodeGenerator struct {
 	// Future: Add fields for tracking generated code, labels, etc.
 	// Maps local variable serial-numbers to their stack offsets.
 
+	builtins   *builtins.Registry // The system functions that may be called.
+	noOptimize bool               // Skip the peephole optimizer, for debugging.
 }
 
 type FnCodeGenState struct {
@@ -45,7 +48,19 @@ func (fcg *FnCodeGenState) offset(serialNo string) int {
 
 // NewCodeGenerator creates a new code generator instance.
 func NewCodeGenerator() *CodeGenerator {
-	return &CodeGenerator{}
+	return NewCodeGeneratorWithBuiltins(builtins.Default())
+}
+
+// NewCodeGeneratorWithBuiltins creates a code generator that checks system
+// function calls against the given registry of builtins.
+func NewCodeGeneratorWithBuiltins(registry *builtins.Registry) *CodeGenerator {
+	return &CodeGenerator{builtins: registry}
+}
+
+// SetNoOptimize turns the peephole optimizer off, so that the instructions
+// are exactly as planted, which makes debugging codegen easier.
+func (cg *CodeGenerator) SetNoOptimize(noOptimize bool) {
+	cg.noOptimize = noOptimize
 }
 
 func (cg *CodeGenerator) NewFnCodeGenState() *FnCodeGenState {
@@ -96,7 +111,9 @@ func (cg *CodeGenerator) Generate(root *common.Node) error {
 					return err
 				}
 			case common.NameAnnotations:
-				// Annotations are ignored for now.
+				if err := cg.generateAnnotations(child); err != nil {
+					return err
+				}
 			default:
 				return fmt.Errorf("unimplemented top-level node: %s", child.Name)
 			}
@@ -124,8 +141,11 @@ func (cg *CodeGenerator) generateBind(bindNode *common.Node) error {
 			return fmt.Errorf("expected id node, got %s", idNode.Name)
 		}
 		bindNode.Options[common.OptionLazy] = common.ValueTrue
+		// The initialiser is compiled as if it were written in place of
+		// the binding, so its bookkeeping is located there.
 		in_progress := &common.Node{
 			Name: common.NameSetInProgress,
+			Span: bindNode.Span,
 			Options: map[string]string{
 				common.OptionName: idNode.Options[common.OptionName],
 			},
@@ -133,6 +153,7 @@ func (cg *CodeGenerator) generateBind(bindNode *common.Node) error {
 		}
 		fn_node := &common.Node{
 			Name:    common.NameFn,
+			Span:    bindNode.Span,
 			Options: map[string]string{},
 			Children: []*common.Node{
 				{
@@ -198,13 +219,28 @@ func (fcg *FnCodeGenState) rewriteFnNode(node *common.Node) error {
 	if err != nil {
 		return err
 	}
-	fcg.instructions.Add(&common.Node{Name: common.NameReturn})
+	fcg.plantReturn()
+	fcg.stampSpan(0, node.Span)
 	node.ClearChildren()
 	node.Children = fcg.instructions.Items()
+	if !fcg.CodeGenerator.noOptimize {
+		node.Children = Optimize(node.Children)
+	}
 	node.Options[common.OptionNLocals] = fmt.Sprintf("%d", fcg.maxOffsetSoFar)
 	return nil
 }
 
+// stampSpan gives the instructions planted since position start the span of
+// the node they were compiled from. Instructions that already have a span
+// came from a more deeply nested node and keep it.
+func (fcg *FnCodeGenState) stampSpan(start int, span common.Span) {
+	for _, inst := range fcg.instructions.Items()[start:] {
+		if inst.Span == (common.Span{}) {
+			inst.Span = span
+		}
+	}
+}
+
 // func (fcg *FnCodeGenState) plantPopArguments(argumentsNode *common.Node) {
 // 	pdnargs := len(argumentsNode.Children)
 // 	for i := pdnargs - 1; i >= 0; i-- {
@@ -216,38 +252,39 @@ func (fcg *FnCodeGenState) rewriteFnNode(node *common.Node) error {
 // }
 
 func (fcg *FnCodeGenState) plantInstructions(node *common.Node) error {
+	defer fcg.stampSpan(len(fcg.instructions.Items()), node.Span)
 	switch node.Name {
 	case common.NameSysCall:
-		tmpvar := fcg.plantStackLength()
-		err := fcg.plantChildren(node)
+		name := node.Options[common.OptionSysFn]
+		if err := fcg.checkSysCall(name, node.Children, node); err != nil {
+			return err
+		}
+		count, err := fcg.plantArguments(node.Children, true)
 		if err != nil {
 			return err
 		}
-		name := node.Options[common.OptionSysFn]
-		fcg.plantSysCall(name, tmpvar)
+		fcg.plantSysCall(name, count)
 	case common.NameIdentifier:
 		scope := node.Options[common.OptionScope]
 		switch scope {
 		case common.ValueInner, common.ValueOuter:
 			fcg.plantPushLocal(node.Options[common.OptionSerialNo])
-		case common.ValueGlobal:
+		case common.ValueGlobal, common.ValueUnit:
 			id_name := node.Options[common.OptionName]
 			fcg.plantPushGlobal(id_name)
 		default:
 			return fmt.Errorf("unknown identifier scope: %s", scope)
 		}
 	case common.NameNumber:
-		mantissa_str := node.ToInteger()
-		if mantissa_str == nil {
-			return fmt.Errorf("non-integer numbers not implemented")
-		}
-		fcg.plantPushInt(*mantissa_str)
+		return fcg.plantNumber(node)
 	case common.NameString:
 		str_value, ok := node.Options[common.OptionValue]
 		if !ok {
 			return fmt.Errorf("string node missing string value option")
 		}
 		fcg.plantPushString(str_value)
+	case common.NameJoin, common.NameJoinLines:
+		return fcg.plantJoin(node)
 	case common.NameBoolean:
 		bool_value, ok := node.Options[common.OptionValue]
 		if !ok {
@@ -262,16 +299,19 @@ func (fcg *FnCodeGenState) plantInstructions(node *common.Node) error {
 		if len(node.Children) == 2 {
 			fn := node.Children[0]
 			args := node.Children[1]
-			tmpvar := fcg.plantStackLength()
-			err := fcg.plantChildren(args)
+			if fn.Name == common.NameSysFn {
+				if err := fcg.checkSysCall(fn.Options[common.OptionSysFn], args.Children, node); err != nil {
+					return err
+				}
+			}
+			count, err := fcg.plantArguments(args.Children, callsByName(fn))
 			if err != nil {
 				return err
 			}
-			err = fcg.plantCall(fn, tmpvar)
+			err = fcg.plantCall(fn, count)
 			if err != nil {
 				return err
 			}
-			fcg.FreeTemporaryVariable(tmpvar)
 		} else {
 			return fmt.Errorf("apply with != 2 children not implemented")
 		}
@@ -279,16 +319,14 @@ func (fcg *FnCodeGenState) plantInstructions(node *common.Node) error {
 		if len(node.Children) == 2 {
 			fn := node.Children[0]
 			args := node.Children[1]
-			tmpvar := fcg.plantStackLength()
-			err := fcg.plantChildren(args)
-			if err != nil {
-				return err
-			}
-			err = fcg.plantInstructions(fn)
+			// The function is pushed after its arguments, so it is counted
+			// as the last of them.
+			operands := append(append([]*common.Node{}, args.Children...), fn)
+			count, err := fcg.plantArguments(operands, true)
 			if err != nil {
 				return err
 			}
-			fcg.plantSysCall(common.NamePartApply, tmpvar)
+			fcg.plantSysCall(common.NamePartApply, count)
 		} else {
 			return fmt.Errorf("apply with != 2 children not implemented")
 		}
@@ -340,12 +378,62 @@ func (fcg *FnCodeGenState) plantInstructions(node *common.Node) error {
 
 		// Plant end label
 		fcg.plantLabel(endLabel)
+	case common.NameSeq:
+		// The values of a sequence are the values of each statement in turn.
+		return fcg.plantChildren(node)
+	case common.NameBind:
+		if len(node.Children) != 2 {
+			return fmt.Errorf("bind node must have exactly 2 children")
+		}
+		return fcg.plantPopToLocal(node.Children[0], node.Children[1], "bind")
+	case common.NameAssign:
+		if len(node.Children) != 2 {
+			return fmt.Errorf("assign node must have exactly 2 children")
+		}
+		return fcg.plantPopToLocal(node.Children[0], node.Children[1], "assign")
+	case common.NameFor:
+		return fcg.plantFor(node)
+	case common.NameUpdate:
+		return common.SpanErrorf(node.Span, "update (<--) not implemented")
 	default:
 		return fmt.Errorf("unimplemented node type: %s", node.Name)
 	}
 	return nil
 }
 
+// plantPopToLocal compiles the value of a bind or assign node and stores it
+// in the local variable that idNode refers to. The value must deliver exactly
+// one result, which is checked here whenever it is known at compile time and
+// by check.one at run time otherwise.
+func (fcg *FnCodeGenState) plantPopToLocal(idNode *common.Node, valueNode *common.Node, what string) error {
+	if idNode.Name != common.NameIdentifier {
+		return common.SpanErrorf(idNode.Span, "cannot %s to %s node", what, idNode.Name)
+	}
+	name := idNode.Options[common.OptionName]
+	scope := idNode.Options[common.OptionScope]
+	if scope != common.ValueInner && scope != common.ValueOuter {
+		return common.SpanErrorf(idNode.Span, "cannot %s non-local identifier %s", what, name)
+	}
+	n, known := fcg.countValues(valueNode)
+	if known && n != 1 {
+		return common.SpanErrorf(idNode.Span, "cannot %s %s to %d values", what, name, n)
+	}
+	if known {
+		if err := fcg.plantInstructions(valueNode); err != nil {
+			return err
+		}
+	} else {
+		tmpvar := fcg.plantStackLength()
+		defer fcg.FreeTemporaryVariable(tmpvar)
+		if err := fcg.plantInstructions(valueNode); err != nil {
+			return err
+		}
+		fcg.plantCheckOne(tmpvar)
+	}
+	fcg.plantPopLocal(idNode.Options[common.OptionSerialNo])
+	return nil
+}
+
 func (fcg *FnCodeGenState) plantChildren(node *common.Node) error {
 	for _, child := range node.Children {
 		err := fcg.plantInstructions(child)
@@ -377,7 +465,21 @@ func (fcg *FnCodeGenState) plantDone(name string, stackLengthTmpVar *TemporaryVa
 	})
 }
 
-func (fcg *FnCodeGenState) plantCall(node *common.Node, stackLengthTmpVar *TemporaryVariable) error {
+// callsByName returns true if a call of fn names its target, as a global or
+// a system function, rather than calling a function value on the stack.
+// Only these calls can take a fixed number of arguments.
+func callsByName(fn *common.Node) bool {
+	switch fn.Name {
+	case common.NameSysFn:
+		return true
+	case common.NameIdentifier:
+		scope := fn.Options[common.OptionScope]
+		return scope == common.ValueGlobal || scope == common.ValueUnit
+	}
+	return false
+}
+
+func (fcg *FnCodeGenState) plantCall(node *common.Node, count argumentCount) error {
 	if node == nil {
 		return fmt.Errorf("nil node in plantCall")
 	}
@@ -386,32 +488,78 @@ func (fcg *FnCodeGenState) plantCall(node *common.Node, stackLengthTmpVar *Tempo
 		scope := node.Options[common.OptionScope]
 		switch scope {
 		case common.ValueInner, common.ValueOuter:
-			return fmt.Errorf("cannot call local function: %s", node.Options[common.OptionName])
-		case common.ValueGlobal:
+			return fcg.plantCallValue(node, count)
+		case common.ValueGlobal, common.ValueUnit:
+			// Lifted lambdas are unit-level bindings, which are called by
+			// name just like globals.
 			id_name := node.Options[common.OptionName]
-			fcg.plantCallGlobal(id_name, stackLengthTmpVar)
+			fcg.plantCallGlobal(id_name, count)
 			return nil
 		default:
 			return fmt.Errorf("unknown identifier scope in call: %s", scope)
 		}
 	case common.NameSysFn:
 		sysfn_name := node.Options[common.OptionSysFn]
-		fcg.plantSysCall(sysfn_name, stackLengthTmpVar)
+		fcg.plantSysCall(sysfn_name, count)
 		return nil
 	default:
-		return fmt.Errorf("unimplemented call target node: %s", node.Name)
+		return fcg.plantCallValue(node, count)
 	}
 }
 
-func (fcg *FnCodeGenState) plantCallGlobal(id_name string, stackLengthTmpVar *TemporaryVariable) {
+// plantCallValue calls the function value that an arbitrary expression
+// evaluates to. The function is pushed after its arguments, just as for
+// partapply, and call.counted takes it off the top of the stack.
+func (fcg *FnCodeGenState) plantCallValue(node *common.Node, count argumentCount) error {
+	if count.tmpvar == nil {
+		// There is no fixed form of call.counted, so plantArguments must
+		// have been asked to record the stack length.
+		return fmt.Errorf("call of a function value without a recorded stack length")
+	}
+	if n, known := fcg.countValues(node); known && n != 1 {
+		return common.SpanErrorf(node.Span, "cannot call an expression that delivers %d values", n)
+	}
+	if err := fcg.plantInstructions(node); err != nil {
+		return err
+	}
+	fcg.instructions.Add(&common.Node{
+		Name: common.NameCallCounted,
+		Options: map[string]string{
+			common.OptionOffset: count.tmpvar.OffsetString(),
+		},
+		Children: []*common.Node{},
+	})
+	fcg.FreeTemporaryVariable(count.tmpvar)
+	return nil
+}
+
+// plantCallGlobal calls a global with call.global.fixed when the number of
+// arguments is known and call.global.counted otherwise.
+func (fcg *FnCodeGenState) plantCallGlobal(id_name string, count argumentCount) {
+	if count.tmpvar == nil {
+		fcg.plantCallGlobalFixed(id_name, count.nargs)
+		return
+	}
 	fcg.instructions.Add(&common.Node{
 		Name: common.NameCallGlobalCounted,
 		Options: map[string]string{
-			common.OptionOffset: stackLengthTmpVar.OffsetString(),
+			common.OptionOffset: count.tmpvar.OffsetString(),
 			common.OptionName:   id_name,
 		},
 		Children: []*common.Node{},
 	})
+	fcg.FreeTemporaryVariable(count.tmpvar)
+}
+
+func (fcg *FnCodeGenState) plantCallGlobalFixed(id_name string, nargs int) {
+	fcg.instructions.Add(&common.Node{
+		Name: common.NameCallGlobalFixed,
+		Options: map[string]string{
+			common.OptionNArgs: fmt.Sprintf("%d", nargs),
+			common.OptionName:  id_name,
+		},
+		Children: []*common.Node{},
+	})
 }
 
 func (fcg *FnCodeGenState) plantPushBool(value string) {
@@ -436,17 +584,69 @@ func (fcg *FnCodeGenState) plantPushInt(value string) {
 	fcg.instructions.Add(pushNumber)
 }
 
+func (fcg *FnCodeGenState) plantPushBigInt(value string) {
+	pushBigInt := &common.Node{
+		Name: common.NamePushBigInt,
+		Options: map[string]string{
+			common.OptionDecimal: value,
+		},
+		Children: []*common.Node{},
+	}
+	fcg.instructions.Add(pushBigInt)
+}
+
+func (fcg *FnCodeGenState) plantPushRational(value string) {
+	pushRational := &common.Node{
+		Name: common.NamePushRational,
+		Options: map[string]string{
+			common.OptionValue: value,
+		},
+		Children: []*common.Node{},
+	}
+	fcg.instructions.Add(pushRational)
+}
+
+func (fcg *FnCodeGenState) plantPushFloat(value string) {
+	pushFloat := &common.Node{
+		Name: common.NamePushFloat,
+		Options: map[string]string{
+			common.OptionValue: value,
+		},
+		Children: []*common.Node{},
+	}
+	fcg.instructions.Add(pushFloat)
+}
+
 func (fcg *FnCodeGenState) plantPushString(value string) {
 	pushString := &common.Node{Name: common.NamePushString, Options: map[string]string{common.OptionValue: value}, Children: []*common.Node{}}
 	fcg.instructions.Add(pushString)
 }
 
-func (fcg *FnCodeGenState) plantSysCall(syscallName string, stackLengthTmpVar *TemporaryVariable) {
+// plantSysCall calls a system function with syscall.fixed when the number
+// of arguments is known and syscall.counted otherwise.
+func (fcg *FnCodeGenState) plantSysCall(syscallName string, count argumentCount) {
+	if count.tmpvar == nil {
+		fcg.plantSysCallFixed(syscallName, count.nargs)
+		return
+	}
 	syscallNode := &common.Node{
 		Name: common.NameSysCallCounted,
 		Options: map[string]string{
 			common.OptionSysFn:  syscallName,
-			common.OptionOffset: stackLengthTmpVar.OffsetString(),
+			common.OptionOffset: count.tmpvar.OffsetString(),
+		},
+		Children: []*common.Node{},
+	}
+	fcg.instructions.Add(syscallNode)
+	fcg.FreeTemporaryVariable(count.tmpvar)
+}
+
+func (fcg *FnCodeGenState) plantSysCallFixed(syscallName string, nargs int) {
+	syscallNode := &common.Node{
+		Name: common.NameSysCallFixed,
+		Options: map[string]string{
+			common.OptionSysFn: syscallName,
+			common.OptionNArgs: fmt.Sprintf("%d", nargs),
 		},
 		Children: []*common.Node{},
 	}
@@ -454,11 +654,23 @@ func (fcg *FnCodeGenState) plantSysCall(syscallName string, stackLengthTmpVar *T
 }
 
 func (fcg *FnCodeGenState) plantPushLocal(serialNo string) {
-	offset := fcg.offset(serialNo)
+	fcg.plantPushLocalOffset(fcg.offset(serialNo))
+}
+
+func (fcg *FnCodeGenState) plantPushLocalOffset(offset int) {
 	pushLocalNode := &common.Node{Name: common.NamePushLocal, Options: map[string]string{common.OptionOffset: fmt.Sprintf("%d", offset)}, Children: []*common.Node{}}
 	fcg.instructions.Add(pushLocalNode)
 }
 
+func (fcg *FnCodeGenState) plantPopLocal(serialNo string) {
+	fcg.plantPopLocalOffset(fcg.offset(serialNo))
+}
+
+func (fcg *FnCodeGenState) plantPopLocalOffset(offset int) {
+	popLocalNode := &common.Node{Name: common.NamePopLocal, Options: map[string]string{common.OptionOffset: fmt.Sprintf("%d", offset)}, Children: []*common.Node{}}
+	fcg.instructions.Add(popLocalNode)
+}
+
 func (fcg *FnCodeGenState) plantPushGlobal(id_name string) {
 	pushGlobalNode := &common.Node{Name: common.NamePushGlobal, Options: map[string]string{common.OptionName: id_name}, Children: []*common.Node{}}
 	fcg.instructions.Add(pushGlobalNode)
@@ -506,6 +718,18 @@ func (fcg *FnCodeGenState) plantCheckBool(tmpvar *TemporaryVariable) {
 	fcg.instructions.Add(checkBoolNode)
 }
 
+// plantCheckOne plants an instruction to check that exactly one value has
+// been pushed since the stack length was recorded in tmpvar.
+func (fcg *FnCodeGenState) plantCheckOne(tmpvar *TemporaryVariable) {
+	fcg.instructions.Add(&common.Node{
+		Name: common.NameCheckOne,
+		Options: map[string]string{
+			common.OptionOffset: tmpvar.OffsetString(),
+		},
+		Children: []*common.Node{},
+	})
+}
+
 // plantConditionalJump plants the appropriate conditional jump instruction
 // based on the types of the success and failure labels.
 func (fcg *FnCodeGenState) plantConditionalJump(successLabel Label, failureLabel Label) {
@@ -0,0 +1,165 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// The system functions that for loops are compiled into.
+const (
+	sysFnHalfOpenRange = "..<"
+	sysFnClosedRange   = "..="
+	sysFnIterator      = "iterator"
+	sysFnHasNext       = "iterator.hasnext"
+	sysFnNext          = "iterator.next"
+)
+
+// plantFor compiles for(in(id, E), body). When E is a range between integer
+// literals the loop counts through the range without creating it, otherwise E
+// is walked using the iterator protocol, which leaves the range operator to
+// check its bounds at run time. The values of the loop are the values of each
+// iteration of the body in turn.
+func (fcg *FnCodeGenState) plantFor(node *common.Node) error {
+	if len(node.Children) != 2 {
+		return fmt.Errorf("for node must have exactly 2 children (query, body)")
+	}
+	query := node.Children[0]
+	if query.Name != common.NameIn || len(query.Children) != 2 {
+		return fmt.Errorf("unimplemented for query node: %s", query.Name)
+	}
+	loopVar := query.Children[0]
+	if loopVar.Name != common.NameIdentifier {
+		return common.SpanErrorf(loopVar.Span, "for loop variable must be an identifier")
+	}
+	body := node.Children[1]
+	if fcg.isIntegerRange(query.Children[1]) {
+		return fcg.plantRangeLoop(loopVar, query.Children[1], body)
+	}
+	return fcg.plantIteratorLoop(loopVar, query.Children[1], body)
+}
+
+// isIntegerRange returns true if node is a range whose bounds are literal
+// integers that fit in a machine word, which is what plantRangeLoop needs.
+// Any other bounds might not be integers at all.
+func (fcg *FnCodeGenState) isIntegerRange(node *common.Node) bool {
+	if node.Name != common.NameSysCall || len(node.Children) != 2 {
+		return false
+	}
+	switch node.Options[common.OptionSysFn] {
+	case sysFnHalfOpenRange, sysFnClosedRange:
+	default:
+		return false
+	}
+	for _, bound := range node.Children {
+		if !isSmallIntegerLiteral(bound) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSmallIntegerLiteral returns true if node is a numeric literal that
+// plantNumber compiles into push.int.
+func isSmallIntegerLiteral(node *common.Node) bool {
+	if node.Name != common.NameNumber {
+		return false
+	}
+	value, isFloat, err := numberValue(node)
+	return err == nil && !isFloat && value.IsInt() && value.Num().BitLen() < strconv.IntSize
+}
+
+// plantRangeLoop compiles a loop over lo ..< hi or lo ..= hi. The loop
+// variable doubles as the counter and the upper bound is kept in a temporary
+// variable for the duration of the loop.
+//
+//	[lo] pop.local I
+//	[hi] pop.local HI
+//	TEST: I < HI (or I <= HI) if.not END
+//	[body]
+//	I + 1 pop.local I
+//	goto TEST
+//	END:
+func (fcg *FnCodeGenState) plantRangeLoop(loopVar *common.Node, rangeNode *common.Node, body *common.Node) error {
+	serialNo := loopVar.Options[common.OptionSerialNo]
+	hiVar := fcg.AllocateTemporaryVariable()
+	defer fcg.FreeTemporaryVariable(hiVar)
+
+	if err := fcg.plantInstructions(rangeNode.Children[0]); err != nil {
+		return err
+	}
+	fcg.plantPopLocal(serialNo)
+	if err := fcg.plantInstructions(rangeNode.Children[1]); err != nil {
+		return err
+	}
+	fcg.plantPopLocalOffset(hiVar.Offset)
+
+	testLabel := fcg.AllocateLabel()
+	endLabel := fcg.AllocateLabel()
+	comparison := "<"
+	if rangeNode.Options[common.OptionSysFn] == sysFnClosedRange {
+		comparison = "<="
+	}
+
+	fcg.plantLabel(testLabel)
+	fcg.plantPushLocal(serialNo)
+	fcg.plantPushLocalOffset(hiVar.Offset)
+	fcg.plantSysCallFixed(comparison, 2)
+	fcg.plantIfNot(endLabel)
+
+	if err := fcg.plantInstructions(body); err != nil {
+		return err
+	}
+
+	fcg.plantPushLocal(serialNo)
+	fcg.plantPushInt("1")
+	fcg.plantSysCallFixed("+", 2)
+	fcg.plantPopLocal(serialNo)
+	fcg.plantGoto(testLabel)
+	fcg.plantLabel(endLabel)
+	return nil
+}
+
+// plantIteratorLoop compiles a loop over an arbitrary collection, which is
+// turned into an iterator that is kept in a temporary variable.
+//
+//	[E] iterator pop.local IT
+//	TEST: iterator.hasnext(IT) if.not END
+//	iterator.next(IT) pop.local I
+//	[body]
+//	goto TEST
+//	END:
+func (fcg *FnCodeGenState) plantIteratorLoop(loopVar *common.Node, collection *common.Node, body *common.Node) error {
+	if err := fcg.checkSysCall(sysFnIterator, []*common.Node{collection}, collection); err != nil {
+		return err
+	}
+	iterVar := fcg.AllocateTemporaryVariable()
+	defer fcg.FreeTemporaryVariable(iterVar)
+
+	count, err := fcg.plantArguments([]*common.Node{collection}, true)
+	if err != nil {
+		return err
+	}
+	fcg.plantSysCall(sysFnIterator, count)
+	fcg.plantPopLocalOffset(iterVar.Offset)
+
+	testLabel := fcg.AllocateLabel()
+	endLabel := fcg.AllocateLabel()
+
+	fcg.plantLabel(testLabel)
+	fcg.plantPushLocalOffset(iterVar.Offset)
+	fcg.plantSysCallFixed(sysFnHasNext, 1)
+	fcg.plantIfNot(endLabel)
+
+	fcg.plantPushLocalOffset(iterVar.Offset)
+	fcg.plantSysCallFixed(sysFnNext, 1)
+	fcg.plantPopLocal(loopVar.Options[common.OptionSerialNo])
+
+	if err := fcg.plantInstructions(body); err != nil {
+		return err
+	}
+	fcg.plantGoto(testLabel)
+	fcg.plantLabel(endLabel)
+	return nil
+}
@@ -0,0 +1,143 @@
+package codegen
+
+import (
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func forNode(loopVar *common.Node, collection *common.Node, body *common.Node) *common.Node {
+	return &common.Node{
+		Name: common.NameFor,
+		Children: []*common.Node{
+			{Name: common.NameIn, Children: []*common.Node{loopVar, collection}},
+			body,
+		},
+	}
+}
+
+func instructionNames(fcg *FnCodeGenState) []string {
+	names := make([]string, 0)
+	for _, inst := range fcg.instructions.Items() {
+		names = append(names, inst.Name)
+	}
+	return names
+}
+
+func checkInstructionNames(t *testing.T, actual []string, expected []string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d instructions %v, got %d %v", len(expected), expected, len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Instruction %d: expected %s, got %s", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestPlantForOverIntegerRange(t *testing.T) {
+	// for i in 0 ..< 10 do i endfor
+	loop := forNode(localNode("i", "1"), sysCallNode("..<", intNode("0"), intNode("10")), localNode("i", "1"))
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(loop); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+
+	// The range is never created, the bounds go straight into locals.
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NamePushInt, common.NamePopLocal,
+		common.NamePushInt, common.NamePopLocal,
+		common.NameLabel,
+		common.NamePushLocal, common.NamePushLocal, common.NameSysCallFixed,
+		common.NameIfNot,
+		common.NamePushLocal,
+		common.NamePushLocal, common.NamePushInt, common.NameSysCallFixed,
+		common.NamePopLocal,
+		common.NameGoto,
+		common.NameLabel,
+	})
+	compare := fcg.instructions.Items()[7]
+	if compare.Options[common.OptionSysFn] != "<" {
+		t.Errorf("Expected the loop test to use <, got %s", compare.Options[common.OptionSysFn])
+	}
+}
+
+func TestPlantForOverClosedRangeUsesLessOrEqual(t *testing.T) {
+	loop := forNode(localNode("i", "1"), sysCallNode("..=", intNode("1"), intNode("3")), localNode("i", "1"))
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(loop); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	compare := fcg.instructions.Items()[7]
+	if compare.Name != common.NameSysCallFixed || compare.Options[common.OptionSysFn] != "<=" {
+		t.Errorf("Expected the loop test to use <=, got %s %s", compare.Name, compare.Options[common.OptionSysFn])
+	}
+}
+
+func TestPlantForOverCollectionUsesIterator(t *testing.T) {
+	// for x in xs do x endfor
+	loop := forNode(localNode("x", "1"), localNode("xs", "2"), localNode("x", "1"))
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(loop); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NamePushLocal, common.NameSysCallFixed, common.NamePopLocal,
+		common.NameLabel,
+		common.NamePushLocal, common.NameSysCallFixed,
+		common.NameIfNot,
+		common.NamePushLocal, common.NameSysCallFixed, common.NamePopLocal,
+		common.NamePushLocal,
+		common.NameGoto,
+		common.NameLabel,
+	})
+	sysfns := []string{}
+	for _, inst := range fcg.instructions.Items() {
+		if inst.Name == common.NameSysCallFixed {
+			sysfns = append(sysfns, inst.Options[common.OptionSysFn])
+		}
+	}
+	checkInstructionNames(t, sysfns, []string{sysFnIterator, sysFnHasNext, sysFnNext})
+}
+
+func TestPlantForOverRangeWithOtherBoundsUsesIterator(t *testing.T) {
+	// The bounds must be integer literals for the range to be lowered, so
+	// that the range operator checks any others at run time.
+	call := &common.Node{
+		Name: common.NameApply,
+		Children: []*common.Node{
+			{Name: common.NameIdentifier, Options: map[string]string{common.OptionName: "f", common.OptionScope: common.ValueGlobal}},
+			{Name: common.NameArguments},
+		},
+	}
+	tests := []struct {
+		name   string
+		lo, hi *common.Node
+	}{
+		{"call", intNode("0"), call},
+		{"local", intNode("0"), localNode("n", "2")},
+		{"strings", stringNode("a"), stringNode("c")},
+		{"float", numberNode("10", "0", "5", "0", ""), intNode("3")},
+		{"big integer", intNode("0"), intNode("100000000000000000000")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop := forNode(localNode("i", "1"), sysCallNode("..<", tt.lo, tt.hi), localNode("i", "1"))
+			fcg := NewCodeGenerator().NewFnCodeGenState()
+			if err := fcg.plantInstructions(loop); err != nil {
+				t.Fatalf("plantInstructions failed: %v", err)
+			}
+			found := false
+			for _, inst := range fcg.instructions.Items() {
+				if inst.Options[common.OptionSysFn] == sysFnIterator {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected the loop to use the iterator protocol")
+			}
+		})
+	}
+}
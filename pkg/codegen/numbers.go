@@ -0,0 +1,112 @@
+package codegen
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// MaxLiteralExponent bounds the exponent of a numeric literal, so that the
+// exact value of a literal is always of a reasonable size.
+const MaxLiteralExponent = 4096
+
+// plantNumber compiles a numeric literal. A literal with a fractional part
+// is a float. Otherwise it is exact, an integer unless a negative exponent
+// makes it a rational, e.g. 25e-2 is exactly 1/4. Integers that do not fit
+// in a machine word are pushed as big integers.
+func (fcg *FnCodeGenState) plantNumber(node *common.Node) error {
+	value, isFloat, err := numberValue(node)
+	if err != nil {
+		return common.SpanErrorf(node.Span, "%w", err)
+	}
+	switch {
+	case isFloat:
+		f, _ := value.Float64()
+		if math.IsInf(f, 0) {
+			return common.SpanErrorf(node.Span, "number is too large to be a float")
+		}
+		if f == 0 && value.Sign() != 0 {
+			return common.SpanErrorf(node.Span, "number is too small to be a float")
+		}
+		fcg.plantPushFloat(strconv.FormatFloat(f, 'g', -1, 64))
+	case !value.IsInt():
+		fcg.plantPushRational(value.RatString())
+	case value.Num().BitLen() < strconv.IntSize:
+		fcg.plantPushInt(value.Num().String())
+	default:
+		fcg.plantPushBigInt(value.Num().String())
+	}
+	return nil
+}
+
+// numberValue works out the exact value of a number node, which is
+// sign * mantissa.fraction * base^exponent. The second result is true if the
+// literal denotes a float rather than an exact number.
+func numberValue(node *common.Node) (*big.Rat, bool, error) {
+	base, err := strconv.Atoi(node.Options[common.OptionBase])
+	if err != nil || base < 2 || base > 36 {
+		return nil, false, fmt.Errorf("invalid number base: %s", node.Options[common.OptionBase])
+	}
+	exponent, err := strconv.Atoi(node.Options[common.OptionExponent])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid number exponent: %s", node.Options[common.OptionExponent])
+	}
+	if exponent > MaxLiteralExponent || exponent < -MaxLiteralExponent {
+		return nil, false, fmt.Errorf("number exponent %d is out of range", exponent)
+	}
+	mantissa := node.Options[common.OptionMantissa]
+	fraction := node.Options[common.OptionFraction]
+	balanced := node.Options[common.OptionBalanced] == common.ValueTrue
+
+	// Read the digits as one integer and then scale by the fraction's length.
+	digits, err := parseDigits(mantissa+fraction, base, balanced)
+	if err != nil {
+		return nil, false, err
+	}
+	value := new(big.Rat).SetInt(digits)
+	bigBase := big.NewInt(int64(base))
+	scale := exponent - len(fraction)
+	power := new(big.Int).Exp(bigBase, big.NewInt(int64(max(scale, -scale))), nil)
+	if scale >= 0 {
+		value.Mul(value, new(big.Rat).SetInt(power))
+	} else {
+		value.Quo(value, new(big.Rat).SetInt(power))
+	}
+	if node.Options[common.OptionSign] == "-" {
+		value.Neg(value)
+	}
+	return value, fraction != "", nil
+}
+
+// parseDigits reads a string of digits in the given base. Balanced ternary
+// uses the digits 1, 0 and T, where T stands for -1.
+func parseDigits(digits string, base int, balanced bool) (*big.Int, error) {
+	if digits == "" {
+		return new(big.Int), nil
+	}
+	if !balanced {
+		n, ok := new(big.Int).SetString(digits, base)
+		if !ok {
+			return nil, fmt.Errorf("invalid digits for base %d: %s", base, digits)
+		}
+		return n, nil
+	}
+	n := new(big.Int)
+	three := big.NewInt(3)
+	for _, ch := range digits {
+		n.Mul(n, three)
+		switch ch {
+		case '1':
+			n.Add(n, big.NewInt(1))
+		case '0':
+		case 'T':
+			n.Sub(n, big.NewInt(1))
+		default:
+			return nil, fmt.Errorf("invalid balanced ternary digit: %c", ch)
+		}
+	}
+	return n, nil
+}
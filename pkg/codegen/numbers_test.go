@@ -0,0 +1,86 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func numberNode(base, mantissa, fraction, exponent, sign string) *common.Node {
+	return &common.Node{
+		Name: common.NameNumber,
+		Options: map[string]string{
+			common.OptionBase:     base,
+			common.OptionMantissa: mantissa,
+			common.OptionFraction: fraction,
+			common.OptionExponent: exponent,
+			common.OptionSign:     sign,
+		},
+	}
+}
+
+func TestPlantNumber(t *testing.T) {
+	balanced := numberNode("3", "1T", "", "0", "+")
+	balanced.Options[common.OptionBalanced] = common.ValueTrue
+
+	tests := []struct {
+		name        string
+		node        *common.Node
+		instruction string
+		option      string
+		value       string
+	}{
+		{"Decimal integer", numberNode("10", "42", "", "0", "+"), common.NamePushInt, common.OptionDecimal, "42"},
+		{"Negative integer", numberNode("10", "42", "", "0", "-"), common.NamePushInt, common.OptionDecimal, "-42"},
+		{"Hexadecimal integer", numberNode("16", "FF", "", "0", "+"), common.NamePushInt, common.OptionDecimal, "255"},
+		{"Radix 36 integer", numberNode("36", "Z", "", "0", "+"), common.NamePushInt, common.OptionDecimal, "35"},
+		{"Balanced ternary", balanced, common.NamePushInt, common.OptionDecimal, "2"},
+		{"Exponent makes an integer", numberNode("10", "1", "", "3", "+"), common.NamePushInt, common.OptionDecimal, "1000"},
+		{"Big integer", numberNode("10", "123456789012345678901234567890", "", "0", "+"), common.NamePushBigInt, common.OptionDecimal, "123456789012345678901234567890"},
+		{"Negative exponent makes a rational", numberNode("10", "25", "", "-2", "+"), common.NamePushRational, common.OptionValue, "1/4"},
+		{"Decimal float", numberNode("10", "3", "14", "0", "+"), common.NamePushFloat, common.OptionValue, "3.14"},
+		{"Scientific float", numberNode("10", "1", "5", "-10", "-"), common.NamePushFloat, common.OptionValue, "-1.5e-10"},
+		{"Hexadecimal float", numberNode("16", "1", "1", "2", "+"), common.NamePushFloat, common.OptionValue, "272"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fcg := NewCodeGenerator().NewFnCodeGenState()
+			if err := fcg.plantInstructions(tt.node); err != nil {
+				t.Fatalf("plantInstructions failed: %v", err)
+			}
+			instructions := fcg.instructions.Items()
+			if len(instructions) != 1 {
+				t.Fatalf("Expected 1 instruction, got %d", len(instructions))
+			}
+			if instructions[0].Name != tt.instruction {
+				t.Errorf("Expected %s, got %s", tt.instruction, instructions[0].Name)
+			}
+			if got := instructions[0].Options[tt.option]; got != tt.value {
+				t.Errorf("Expected value %s, got %s", tt.value, got)
+			}
+		})
+	}
+}
+
+func TestUnrepresentableNumbersAreRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     *common.Node
+		expected string
+	}{
+		{"Float overflow", numberNode("10", "1", "0", "400", "+"), "too large to be a float"},
+		{"Float underflow", numberNode("10", "1", "0", "-400", "+"), "too small to be a float"},
+		{"Huge exponent", numberNode("10", "1", "", "5000", "+"), "exponent 5000 is out of range"},
+		{"Bad digits", numberNode("2", "12", "", "0", "+"), "invalid digits for base 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fcg := NewCodeGenerator().NewFnCodeGenState()
+			err := fcg.plantInstructions(tt.node)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
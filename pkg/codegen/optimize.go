@@ -0,0 +1,307 @@
+package codegen
+
+import (
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// peepholePass rewrites a list of instructions, reporting whether it changed
+// anything.
+type peepholePass func(instructions []*common.Node) ([]*common.Node, bool)
+
+// peepholePasses are applied in turn by Optimize. Each one removes
+// instructions, replaces them with simpler ones or sends a jump straight to
+// the end of a chain of gotos, so repeating them until nothing changes is
+// guaranteed to stop.
+var peepholePasses = []peepholePass{
+	foldConstantBools,
+	mergeLabels,
+	threadJumps,
+	removeJumpsToNext,
+	removeUnreachable,
+	removeUnusedLabels,
+}
+
+// Optimize performs peephole optimizations on the instructions of a single
+// function until no more apply.
+func Optimize(instructions []*common.Node) []*common.Node {
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range peepholePasses {
+			var passChanged bool
+			instructions, passChanged = pass(instructions)
+			changed = changed || passChanged
+		}
+	}
+	return instructions
+}
+
+// jumpLabels returns the options that hold the labels of a jump instruction,
+// so that they can be both read and retargeted. Other instructions have none.
+func jumpLabels(inst *common.Node) []string {
+	switch inst.Name {
+	case common.NameGoto, common.NameIfNot, common.NameIfSo:
+		return []string{common.OptionValue}
+	case common.NameIfThenElse:
+		return []string{common.OptionName, common.OptionValue}
+	}
+	return nil
+}
+
+// labelsFollowing returns the names of the labels that come immediately
+// after position i, which are the labels that the next real instruction has.
+func labelsFollowing(instructions []*common.Node, i int) map[string]bool {
+	labels := make(map[string]bool)
+	for j := i + 1; j < len(instructions) && instructions[j].Name == common.NameLabel; j++ {
+		labels[instructions[j].Options[common.OptionValue]] = true
+	}
+	return labels
+}
+
+// labelPositions maps each label to its position in the instructions.
+func labelPositions(instructions []*common.Node) map[string]int {
+	positions := make(map[string]int)
+	for i, inst := range instructions {
+		if inst.Name == common.NameLabel {
+			positions[inst.Options[common.OptionValue]] = i
+		}
+	}
+	return positions
+}
+
+// nextInstruction returns the first instruction after position i that is not
+// a label, or nil if there is none.
+func nextInstruction(instructions []*common.Node, i int) *common.Node {
+	for j := i + 1; j < len(instructions); j++ {
+		if instructions[j].Name != common.NameLabel {
+			return instructions[j]
+		}
+	}
+	return nil
+}
+
+func newInstruction(name string, options map[string]string) *common.Node {
+	return &common.Node{Name: name, Options: options, Children: []*common.Node{}}
+}
+
+// replaceInstruction makes an instruction that replaces old, keeping the
+// source span of old.
+func replaceInstruction(old *common.Node, name string, options map[string]string) *common.Node {
+	inst := newInstruction(name, options)
+	inst.Span = old.Span
+	return inst
+}
+
+// foldConstantBools simplifies tests of a boolean constant, which arise from
+// conditions such as `if true then ...`.
+//
+//	stack.length T push.bool B check.bool T  =>  push.bool B
+//	push.bool B if.not/if.so/if.then.else    =>  goto or nothing
+//	push.bool B if.not.return/if.so.return   =>  return or nothing
+//	push.bool B erase                        =>  nothing
+func foldConstantBools(instructions []*common.Node) ([]*common.Node, bool) {
+	result := make([]*common.Node, 0, len(instructions))
+	changed := false
+	for i := 0; i < len(instructions); i++ {
+		inst := instructions[i]
+		if inst.Name == common.NameStackLength && i+2 < len(instructions) &&
+			instructions[i+1].Name == common.NamePushBool &&
+			instructions[i+2].Name == common.NameCheckBool &&
+			instructions[i+2].Options[common.OptionOffset] == inst.Options[common.OptionOffset] {
+			// The check cannot fail, and the stack length was only recorded
+			// for it.
+			result = append(result, instructions[i+1])
+			i += 2
+			changed = true
+			continue
+		}
+		if inst.Name != common.NamePushBool || i+1 == len(instructions) {
+			result = append(result, inst)
+			continue
+		}
+		b := inst.Options[common.OptionValue] == common.ValueTrue
+		next := instructions[i+1]
+		var replacement *common.Node
+		switch next.Name {
+		case common.NameIfNot, common.NameIfSo:
+			if b == (next.Name == common.NameIfSo) {
+				replacement = replaceInstruction(next, common.NameGoto, map[string]string{common.OptionValue: next.Options[common.OptionValue]})
+			}
+		case common.NameIfThenElse:
+			target := next.Options[common.OptionValue]
+			if b {
+				target = next.Options[common.OptionName]
+			}
+			replacement = replaceInstruction(next, common.NameGoto, map[string]string{common.OptionValue: target})
+		case common.NameIfNotReturn, common.NameIfSoReturn:
+			if b == (next.Name == common.NameIfSoReturn) {
+				replacement = replaceInstruction(next, common.NameReturn, map[string]string{})
+			}
+		case common.NameErase:
+		default:
+			result = append(result, inst)
+			continue
+		}
+		if replacement != nil {
+			result = append(result, replacement)
+		}
+		i++
+		changed = true
+	}
+	return result, changed
+}
+
+// mergeLabels replaces runs of adjacent labels with the first of them.
+func mergeLabels(instructions []*common.Node) ([]*common.Node, bool) {
+	alias := make(map[string]string)
+	result := make([]*common.Node, 0, len(instructions))
+	for i, inst := range instructions {
+		if inst.Name == common.NameLabel && i > 0 && instructions[i-1].Name == common.NameLabel {
+			first := instructions[i-1].Options[common.OptionValue]
+			if a, ok := alias[first]; ok {
+				first = a
+			}
+			alias[inst.Options[common.OptionValue]] = first
+			continue
+		}
+		result = append(result, inst)
+	}
+	if len(alias) == 0 {
+		return instructions, false
+	}
+	for _, inst := range result {
+		for _, key := range jumpLabels(inst) {
+			if a, ok := alias[inst.Options[key]]; ok {
+				inst.Options[key] = a
+			}
+		}
+	}
+	return result, true
+}
+
+// threadJumps retargets jumps to a label that is followed by a goto so that
+// they go straight to the final destination, and turns a goto of a label
+// that is followed by a return into a return.
+func threadJumps(instructions []*common.Node) ([]*common.Node, bool) {
+	positions := labelPositions(instructions)
+
+	// destination follows a chain of gotos from a label. A chain that loops
+	// back on itself is left alone.
+	destination := func(label string) string {
+		visited := map[string]bool{label: true}
+		current := label
+		for {
+			position, ok := positions[current]
+			if !ok {
+				return label
+			}
+			next := nextInstruction(instructions, position)
+			if next == nil || next.Name != common.NameGoto {
+				return current
+			}
+			current = next.Options[common.OptionValue]
+			if visited[current] {
+				return label
+			}
+			visited[current] = true
+		}
+	}
+
+	changed := false
+	for i, inst := range instructions {
+		if inst.Name == common.NameGoto {
+			if position, ok := positions[inst.Options[common.OptionValue]]; ok {
+				if next := nextInstruction(instructions, position); next != nil && next.Name == common.NameReturn {
+					instructions[i] = replaceInstruction(inst, common.NameReturn, map[string]string{})
+					changed = true
+					continue
+				}
+			}
+		}
+		for _, key := range jumpLabels(inst) {
+			if target := destination(inst.Options[key]); target != inst.Options[key] {
+				inst.Options[key] = target
+				changed = true
+			}
+		}
+	}
+	return instructions, changed
+}
+
+// removeJumpsToNext removes jumps to the instruction that follows anyway.
+// A conditional jump still has to discard its boolean, so it becomes an
+// erase, or a simpler conditional jump for if.then.else.
+func removeJumpsToNext(instructions []*common.Node) ([]*common.Node, bool) {
+	result := make([]*common.Node, 0, len(instructions))
+	changed := false
+	for i, inst := range instructions {
+		following := labelsFollowing(instructions, i)
+		switch inst.Name {
+		case common.NameGoto:
+			if following[inst.Options[common.OptionValue]] {
+				changed = true
+				continue
+			}
+		case common.NameIfNot, common.NameIfSo:
+			if following[inst.Options[common.OptionValue]] {
+				inst = replaceInstruction(inst, common.NameErase, map[string]string{})
+				changed = true
+			}
+		case common.NameIfThenElse:
+			thenNext := following[inst.Options[common.OptionName]]
+			elseNext := following[inst.Options[common.OptionValue]]
+			switch {
+			case thenNext && elseNext:
+				inst = replaceInstruction(inst, common.NameErase, map[string]string{})
+				changed = true
+			case thenNext:
+				inst = replaceInstruction(inst, common.NameIfNot, map[string]string{common.OptionValue: inst.Options[common.OptionValue]})
+				changed = true
+			case elseNext:
+				inst = replaceInstruction(inst, common.NameIfSo, map[string]string{common.OptionValue: inst.Options[common.OptionName]})
+				changed = true
+			}
+		}
+		result = append(result, inst)
+	}
+	return result, changed
+}
+
+// removeUnreachable deletes the instructions between a goto or return and
+// the next label, which can never be executed.
+func removeUnreachable(instructions []*common.Node) ([]*common.Node, bool) {
+	result := make([]*common.Node, 0, len(instructions))
+	changed := false
+	reachable := true
+	for _, inst := range instructions {
+		if inst.Name == common.NameLabel {
+			reachable = true
+		}
+		if !reachable {
+			changed = true
+			continue
+		}
+		result = append(result, inst)
+		if inst.Name == common.NameGoto || inst.Name == common.NameReturn {
+			reachable = false
+		}
+	}
+	return result, changed
+}
+
+// removeUnusedLabels deletes labels that no jump refers to.
+func removeUnusedLabels(instructions []*common.Node) ([]*common.Node, bool) {
+	used := make(map[string]bool)
+	for _, inst := range instructions {
+		for _, key := range jumpLabels(inst) {
+			used[inst.Options[key]] = true
+		}
+	}
+	result := make([]*common.Node, 0, len(instructions))
+	for _, inst := range instructions {
+		if inst.Name == common.NameLabel && !used[inst.Options[common.OptionValue]] {
+			continue
+		}
+		result = append(result, inst)
+	}
+	return result, len(result) != len(instructions)
+}
@@ -0,0 +1,130 @@
+package codegen
+
+import (
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func labelInst(name string, label string) *common.Node {
+	return newInstruction(name, map[string]string{common.OptionValue: label})
+}
+
+func simpleInst(name string) *common.Node {
+	return newInstruction(name, map[string]string{})
+}
+
+func pushLocal(offset string) *common.Node {
+	return newInstruction(common.NamePushLocal, map[string]string{common.OptionOffset: offset})
+}
+
+func pushInt(value string) *common.Node {
+	return newInstruction(common.NamePushInt, map[string]string{common.OptionDecimal: value})
+}
+
+// describe renders instructions as names, with the label of labels and jumps.
+func describe(instructions []*common.Node) []string {
+	result := make([]string, len(instructions))
+	for i, inst := range instructions {
+		result[i] = inst.Name
+		if label, ok := inst.Options[common.OptionValue]; ok && (inst.Name == common.NameLabel || jumpLabels(inst) != nil) {
+			result[i] += " " + label
+		}
+	}
+	return result
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name         string
+		instructions []*common.Node
+		expected     []string
+	}{
+		{
+			"If with an empty else",
+			[]*common.Node{
+				pushLocal("0"), labelInst(common.NameIfNot, "L0"),
+				pushInt("1"), labelInst(common.NameGoto, "L1"),
+				labelInst(common.NameLabel, "L0"), labelInst(common.NameLabel, "L1"),
+				pushInt("2"), simpleInst(common.NameReturn),
+			},
+			[]string{"push.local", "if.not L0", "push.int", "label L0", "push.int", "return"},
+		},
+		{
+			"Jump chains are threaded",
+			[]*common.Node{
+				labelInst(common.NameLabel, "L0"), simpleInst(common.NameErase), labelInst(common.NameIfSo, "L1"),
+				pushInt("1"), labelInst(common.NameGoto, "L0"),
+				labelInst(common.NameLabel, "L1"), labelInst(common.NameGoto, "L2"),
+				labelInst(common.NameLabel, "L2"), labelInst(common.NameGoto, "L0"),
+			},
+			[]string{"label L0", "erase", "if.so L0", "push.int", "goto L0"},
+		},
+		{
+			"Goto of a return is a return",
+			[]*common.Node{
+				pushLocal("0"), labelInst(common.NameIfNot, "L0"),
+				pushInt("1"), labelInst(common.NameGoto, "L1"),
+				labelInst(common.NameLabel, "L0"), pushInt("2"),
+				labelInst(common.NameLabel, "L1"), simpleInst(common.NameReturn),
+			},
+			[]string{"push.local", "if.not L0", "push.int", "return", "label L0", "push.int", "return"},
+		},
+		{
+			"Unreachable code is removed",
+			[]*common.Node{simpleInst(common.NameReturn), pushInt("1"), simpleInst(common.NameReturn)},
+			[]string{"return"},
+		},
+		{
+			"A loop that goes nowhere is kept",
+			[]*common.Node{
+				labelInst(common.NameLabel, "L0"), labelInst(common.NameGoto, "L1"),
+				labelInst(common.NameLabel, "L1"), labelInst(common.NameGoto, "L0"),
+			},
+			[]string{"label L0", "goto L0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkInstructionNames(t, describe(Optimize(tt.instructions)), tt.expected)
+		})
+	}
+}
+
+func TestOptimizeFoldsConstantConditions(t *testing.T) {
+	// if true then 1 else 2 endif
+	cond := &common.Node{Name: common.NameBoolean, Options: map[string]string{common.OptionValue: common.ValueTrue}}
+	node := &common.Node{Name: common.NameIf, Children: []*common.Node{cond, intNode("1"), intNode("2")}}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(node); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	fcg.plantReturn()
+	checkInstructionNames(t, describe(Optimize(fcg.instructions.Items())), []string{"push.int", "return"})
+}
+
+func TestNoOptimize(t *testing.T) {
+	cg := NewCodeGenerator()
+	cg.SetNoOptimize(true)
+	fcg := cg.NewFnCodeGenState()
+	fn := &common.Node{
+		Name:    common.NameFn,
+		Options: map[string]string{},
+		Children: []*common.Node{
+			{Name: common.NameArguments},
+			{Name: common.NameIf, Children: []*common.Node{localNode("x", "1"), intNode("1"), intNode("2")}},
+		},
+	}
+	if err := fcg.rewriteFnNode(fn); err != nil {
+		t.Fatalf("rewriteFnNode failed: %v", err)
+	}
+	found := false
+	for _, inst := range fn.Children {
+		if inst.Name == common.NameGoto {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the goto planted by if to be kept, got %v", describe(fn.Children))
+	}
+}
@@ -0,0 +1,128 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// The system functions that interpolated strings are compiled into.
+const (
+	sysFnShow   = "show"
+	sysFnConcat = "concat"
+)
+
+// stringPart is one piece of an interpolated string. It is either literal
+// text or, when expr is not nil, an expression whose value is shown.
+type stringPart struct {
+	text string
+	expr *common.Node
+}
+
+// plantJoin compiles an interpolated string (join) or a multi-line string
+// (joinlines). Literal text is folded together at compile time, each embedded
+// expression is converted with show and the pieces are glued together with
+// concat, which is skipped when there is only one piece.
+//
+//	push.string "Hello, "
+//	[expr] syscall.fixed show 1
+//	push.string "!"
+//	syscall.fixed concat 3
+//
+// Every piece is a single string, so concat always has a fixed number of
+// arguments. show does too unless the expression's count is unknown.
+func (fcg *FnCodeGenState) plantJoin(node *common.Node) error {
+	parts, err := stringParts(node)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(parts) == 0:
+		fcg.plantPushString("")
+		return nil
+	case len(parts) == 1:
+		return fcg.plantStringPart(parts[0])
+	}
+	for _, part := range parts {
+		if err := fcg.plantStringPart(part); err != nil {
+			return err
+		}
+	}
+	fcg.plantSysCallFixed(sysFnConcat, len(parts))
+	return nil
+}
+
+func (fcg *FnCodeGenState) plantStringPart(part stringPart) error {
+	if part.expr == nil {
+		fcg.plantPushString(part.text)
+		return nil
+	}
+	if err := fcg.checkSysCall(sysFnShow, []*common.Node{part.expr}, part.expr); err != nil {
+		return err
+	}
+	count, err := fcg.plantArguments([]*common.Node{part.expr}, true)
+	if err != nil {
+		return err
+	}
+	fcg.plantSysCall(sysFnShow, count)
+	return nil
+}
+
+// stringParts flattens a join or joinlines node into its pieces, with the
+// lines of a multi-line string separated by newlines. Adjacent literal text
+// is merged and empty text is dropped.
+func stringParts(node *common.Node) ([]stringPart, error) {
+	parts := make([]stringPart, 0)
+	var text strings.Builder
+	addExpr := func(expr *common.Node) {
+		if text.Len() > 0 {
+			parts = append(parts, stringPart{text: text.String()})
+			text.Reset()
+		}
+		parts = append(parts, stringPart{expr: expr})
+	}
+	var walk func(n *common.Node) error
+	walk = func(n *common.Node) error {
+		switch n.Name {
+		case common.NameString:
+			value, ok := n.Options[common.OptionValue]
+			if !ok {
+				return fmt.Errorf("string node missing string value option")
+			}
+			text.WriteString(value)
+		case common.NameJoin:
+			for _, child := range n.Children {
+				if child.Name == common.NameString {
+					if err := walk(child); err != nil {
+						return err
+					}
+				} else {
+					addExpr(child)
+				}
+			}
+		case common.NameJoinLines:
+			for i, line := range n.Children {
+				if i > 0 {
+					text.WriteString("\n")
+				}
+				if line.Name != common.NameString && line.Name != common.NameJoin {
+					return common.SpanErrorf(line.Span, "unexpected line in multi-line string: %s", line.Name)
+				}
+				if err := walk(line); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unexpected string node: %s", n.Name)
+		}
+		return nil
+	}
+	if err := walk(node); err != nil {
+		return nil, err
+	}
+	if text.Len() > 0 {
+		parts = append(parts, stringPart{text: text.String()})
+	}
+	return parts, nil
+}
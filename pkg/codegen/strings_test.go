@@ -0,0 +1,73 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func stringNode(value string) *common.Node {
+	return &common.Node{Name: common.NameString, Options: map[string]string{common.OptionValue: value}}
+}
+
+func joinNode(children ...*common.Node) *common.Node {
+	return &common.Node{Name: common.NameJoin, Children: children}
+}
+
+func TestPlantInterpolatedString(t *testing.T) {
+	// "Hello, \(name)!"
+	join := joinNode(stringNode("Hello, "), localNode("name", "1"), stringNode("!"))
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(join); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NamePushString,
+		common.NamePushLocal, common.NameSysCallFixed,
+		common.NamePushString,
+		common.NameSysCallFixed,
+	})
+	instructions := fcg.instructions.Items()
+	if instructions[2].Options[common.OptionSysFn] != sysFnShow {
+		t.Errorf("Expected the expression to be shown, got %s", instructions[2].Options[common.OptionSysFn])
+	}
+	if instructions[4].Options[common.OptionSysFn] != sysFnConcat || instructions[4].Options[common.OptionNArgs] != "3" {
+		t.Errorf("Expected the 3 parts to be concatenated, got %s of %s", instructions[4].Options[common.OptionSysFn], instructions[4].Options[common.OptionNArgs])
+	}
+}
+
+func TestPlantJoinFoldsLiterals(t *testing.T) {
+	// The lines of a multi-line string without interpolation become one string.
+	lines := &common.Node{
+		Name:     common.NameJoinLines,
+		Children: []*common.Node{joinNode(stringNode("a"), stringNode("b")), stringNode(""), stringNode("c")},
+	}
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(lines); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	checkInstructionNames(t, instructionNames(fcg), []string{common.NamePushString})
+	if got := fcg.instructions.Items()[0].Options[common.OptionValue]; got != "ab\n\nc" {
+		t.Errorf("Expected %q, got %q", "ab\n\nc", got)
+	}
+}
+
+func TestPlantJoinOfOneExpressionSkipsConcat(t *testing.T) {
+	// "\(x)"
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	if err := fcg.plantInstructions(joinNode(localNode("x", "1"))); err != nil {
+		t.Fatalf("plantInstructions failed: %v", err)
+	}
+	checkInstructionNames(t, instructionNames(fcg), []string{
+		common.NamePushLocal, common.NameSysCallFixed,
+	})
+}
+
+func TestInterpolatedExpressionMustDeliverOneValue(t *testing.T) {
+	fcg := NewCodeGenerator().NewFnCodeGenState()
+	err := fcg.plantInstructions(joinNode(stringNode("x = "), sysCallNode("println")))
+	if err == nil || !strings.Contains(err.Error(), "show expects 1 arguments but was given 0") {
+		t.Errorf("Expected an argument count error for an expression with no value, got %v", err)
+	}
+}
@@ -72,3 +72,21 @@ var tagColors = map[string]string{
 	"operator":  "#C0FFC0",
 	"number":    "lightgoldenrodyellow",
 }
+
+func PrintGraphDOT(graph *Graph, output io.Writer) {
+	// Initialize the DOT graph
+	fmt.Fprintln(output, `digraph G {`)
+	fmt.Fprintln(output, `  bgcolor="transparent";`)
+	fmt.Fprintln(output, `  node [shape="box", style="filled", fontname="Ubuntu Mono"];`)
+
+	for _, vertex := range graph.Vertices {
+		name := escapeDOTValue(vertex.Name)
+		fmt.Fprintf(output, "  \"%s\" [label=\"%s\", fillcolor=\"%s\"];\n", name, name, graphColor(vertex.Kind))
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(output, "  \"%s\" -> \"%s\";\n", escapeDOTValue(edge.From), escapeDOTValue(edge.To))
+	}
+
+	// Close the graph
+	fmt.Fprintln(output, `}`)
+}
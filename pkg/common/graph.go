@@ -0,0 +1,57 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Graph is a directed graph to be drawn by one of the graph writers, such as
+// the dependency graph of a bundle. Each vertex has a kind, which picks the
+// colour it is drawn in.
+type Graph struct {
+	Vertices []GraphVertex
+	Edges    []GraphEdge
+}
+
+// GraphVertex is a vertex of a Graph, identified by its name.
+type GraphVertex struct {
+	Name string
+	Kind string
+}
+
+// GraphEdge is an edge of a Graph, between two vertex names.
+type GraphEdge struct {
+	From string
+	To   string
+}
+
+// graphKinds are the kinds of vertex that have a colour of their own.
+var graphKinds = []string{"function", "lazy", "entry"}
+
+// graphColors are the fill colours of the vertex kinds.
+var graphColors = map[string]string{
+	"function": "Honeydew",
+	"lazy":     "lightgoldenrodyellow",
+	"entry":    "lightgreen",
+}
+
+func graphColor(kind string) string {
+	if color, ok := graphColors[kind]; ok {
+		return color
+	}
+	return "lightgray"
+}
+
+// PickGraphPrintFunc returns the writer for a graph format, which is DOT or
+// MERMAID in any case.
+func PickGraphPrintFunc(format string) (func(*Graph, io.Writer), error) {
+	switch strings.ToUpper(format) {
+	case "DOT":
+		return PrintGraphDOT, nil
+	case "MERMAID":
+		return PrintGraphMermaid, nil
+	default:
+		return nil, fmt.Errorf("unknown graph format: %s", format)
+	}
+}
@@ -78,3 +78,23 @@ func escapeMermaidValue(value string) string {
 	// Escape special characters for Mermaid (minimal requirements)
 	return strings.ReplaceAll(value, "\"", "\\\"")
 }
+
+func PrintGraphMermaid(graph *Graph, output io.Writer) {
+	// Initialize the Mermaid graph
+	fmt.Fprintln(output, "graph LR")
+
+	// Names may contain characters that Mermaid does not allow in an ID, so
+	// the vertices are numbered instead.
+	ids := make(map[string]string, len(graph.Vertices))
+	for i, vertex := range graph.Vertices {
+		ids[vertex.Name] = fmt.Sprintf("v%d", i)
+		fmt.Fprintf(output, "  %s[\"%s\"]:::graph_%s;\n", ids[vertex.Name], escapeMermaidValue(vertex.Name), vertex.Kind)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(output, "  %s --> %s;\n", ids[edge.From], ids[edge.To])
+	}
+
+	for _, kind := range graphKinds {
+		fmt.Fprintf(output, "classDef graph_%s fill:%s,stroke:#333,stroke-width:2px;\n", kind, graphColor(kind))
+	}
+}
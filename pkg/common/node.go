@@ -43,19 +43,26 @@ const NameFn = "fn"
 const NameLet = "let"
 const NameIf = "if"
 const NameFor = "for"
+const NameIn = "in"
 const NameSeq = "seq"
 const NameSysCall = "syscall"
 const NameSysCallCounted = "syscall.counted"
+const NameSysCallFixed = "syscall.fixed"
 const NameSysFn = "sysfn"
 const NamePopLocal = "pop.local"
 const NamePushLocal = "push.local"
 const NamePushGlobal = "push.global"
 const NamePushInt = "push.int"
+const NamePushBigInt = "push.bigint"
+const NamePushRational = "push.rational"
+const NamePushFloat = "push.float"
 const NamePushBool = "push.bool"
 const NamePushString = "push.string"
 const NameReturn = "return"
 const NameStackLength = "stack.length"
 const NameCallGlobalCounted = "call.global.counted"
+const NameCallCounted = "call.counted"
+const NameCallGlobalFixed = "call.global.fixed"
 const NameAnnotations = "annotations"
 const NameSetInProgress = "setinprogress"
 const NameInProgress = "in.progress"
@@ -68,7 +75,9 @@ const NameIfSoReturn = "if.so.return"
 const NameIfNotReturn = "if.not.return"
 const NameIfThenElse = "if.then.else"
 const NameCheckBool = "check.bool"
+const NameCheckOne = "check.one"
 const NameErase = "erase"
+const NameError = "error" // Left by the parser in place of tokens that it skipped.
 
 const OptionConst = "const"
 const OptionKeyword = "keyword"
@@ -87,14 +96,19 @@ const OptionSyntax = "syntax"
 const OptionValue = "value"
 const OptionVar = "var"
 const OptionOffset = "offset"
+const OptionNArgs = "nargs"
 const OptionBase = "base"
 const OptionFraction = "fraction"
 const OptionExponent = "exponent"
 const OptionMantissa = "mantissa"
+const OptionSign = "sign"
+const OptionBalanced = "balanced"
 const OptionLazy = "lazy"
 const OptionNParams = "nparams"
 const OptionNLocals = "nlocals"
 const OptionOrigin = "origin"
+const OptionModule = "module"
+const OptionMessage = "message"
 
 const ValueParentheses = "parentheses"
 const ValueBrackets = "brackets"
@@ -102,6 +116,9 @@ const ValueBraces = "braces"
 const ValueDef = "def"
 const ValueFn = "fn"
 const ValueLet = "let"
+const ValueVal = "val"
+const ValueVar = "var"
+const ValueConst = "const"
 const ValueIf = "if"
 const ValueFor = "for"
 const ValueInfix = "infix"
@@ -117,6 +134,7 @@ const ValueRegex = "regex"
 const ValueInner = "inner"
 const ValueOuter = "outer"
 const ValueGlobal = "global"
+const ValueUnit = "unit"
 const ValueBlank = ""
 const ValueTrue = "true"
 const ValueFalse = "false"
@@ -170,6 +188,28 @@ func (n *Node) ClearChildren() {
 	n.Children = n.Children[:0]
 }
 
+// Clone returns a deep copy of the tree, so that the copy is unaffected by
+// later phases that rewrite the original in place.
+func (n *Node) Clone() *Node {
+	if n == nil {
+		return nil
+	}
+	clone := &Node{Name: n.Name, Span: n.Span}
+	if n.Options != nil {
+		clone.Options = make(map[string]string, len(n.Options))
+		for k, v := range n.Options {
+			clone.Options[k] = v
+		}
+	}
+	if n.Children != nil {
+		clone.Children = make([]*Node, len(n.Children))
+		for i, child := range n.Children {
+			clone.Children[i] = child.Clone()
+		}
+	}
+	return clone
+}
+
 func (n *Node) ToInteger() *string {
 	if n == nil {
 		return nil
@@ -83,3 +83,24 @@ func (s *Span) UnmarshalJSON(data []byte) error {
 	s.EndColumn = arr[3]
 	return nil
 }
+
+// SpanError is an error about the source code at a span. The message does
+// not say where that is, because whatever reports the error shows the span,
+// as a pipeline diagnostic does, and saying it twice is just noise.
+type SpanError struct {
+	Err  error
+	Span Span
+}
+
+// SpanErrorf formats an error as fmt.Errorf does and attaches a span to it.
+func SpanErrorf(span Span, format string, args ...any) error {
+	return &SpanError{Err: fmt.Errorf(format, args...), Span: span}
+}
+
+func (e *SpanError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SpanError) Unwrap() error {
+	return e.Err
+}
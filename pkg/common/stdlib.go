@@ -2,4 +2,5 @@ package common
 
 const (
 	BuiltinModule = "builtin"
+	StdModule     = "std" // The standard library, imported by default.
 )
@@ -29,6 +29,7 @@ const (
 	MarkTokenType           TokenType = "M" // Marks (commas, semicolons)
 	UnclassifiedTokenType   TokenType = "U" // Unclassified tokens
 	ExceptionTokenType      TokenType = "X" // Exception tokens for invalid constructs
+	EndOfInputTokenType     TokenType = "$" // End of input, which holds the trivia after the last token
 )
 
 type Arity int
@@ -79,6 +80,26 @@ type Token struct {
 	// Newline tracking fields
 	LnBefore *bool `json:"ln_before,omitempty"` // True if token was preceded by a newline
 	LnAfter  *bool `json:"ln_after,omitempty"`  // True if token was followed by a newline
+
+	// Trivia fields, which are only filled in if the tokenizer is asked to
+	LeadingTrivia  []Trivia `json:"leading_trivia,omitempty"`  // The trivia from the end of the previous line up to the token
+	TrailingTrivia []Trivia `json:"trailing_trivia,omitempty"` // The trivia after the token up to the end of its line
+}
+
+// TriviaKind says what a piece of trivia is.
+type TriviaKind string
+
+const (
+	WhitespaceTrivia TriviaKind = "whitespace" // A run of whitespace other than line breaks
+	NewlineTrivia    TriviaKind = "newline"    // A line break, which is LF, CR LF or CR
+	CommentTrivia    TriviaKind = "comment"    // A comment, up to but not including the line break
+)
+
+// Trivia is a piece of the source between tokens that does not change its
+// meaning, kept so that tools can reproduce the source exactly.
+type Trivia struct {
+	Kind TriviaKind `json:"kind"`
+	Text string     `json:"text"`
 }
 
 // SetQuote sets the quote type for a string token.
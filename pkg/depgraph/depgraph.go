@@ -0,0 +1,331 @@
+// Package depgraph analyses the dependencies between the bindings of a
+// bundle, as recorded in its depends_ons table. It finds the order in which
+// lazy bindings can be initialised, the cycles among lazy bindings that
+// would fail at runtime, and the bindings that no entry point can reach.
+package depgraph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// Graph is the dependency graph of the bindings of a bundle. Dependencies on
+// names that are not bound in the bundle are left out.
+type Graph struct {
+	Names       []string            // The binding names, in order.
+	Lazy        map[string]bool     // Whether each binding is lazy.
+	EntryPoints map[string]bool     // Whether each binding is an entry point.
+	Needs       map[string][]string // The bindings that each binding needs, in order.
+}
+
+// Cycle is a path of bindings that leads back to where it started, so the
+// first and last names are the same.
+type Cycle []string
+
+func (c Cycle) String() string {
+	return strings.Join(c, " -> ")
+}
+
+// New builds the dependency graph from the rows of a bundle.
+func New(bindings []bundler.Binding, dependencies []bundler.DependsOn, entryPoints []bundler.EntryPoint) *Graph {
+	g := &Graph{
+		Names:       make([]string, 0, len(bindings)),
+		Lazy:        make(map[string]bool),
+		EntryPoints: make(map[string]bool),
+		Needs:       make(map[string][]string),
+	}
+	for _, binding := range bindings {
+		g.Names = append(g.Names, binding.IdName)
+		g.Lazy[binding.IdName] = binding.Lazy
+	}
+	sort.Strings(g.Names)
+	bound := func(name string) bool {
+		_, ok := g.Lazy[name]
+		return ok
+	}
+	for _, dep := range dependencies {
+		if bound(dep.IdName) && bound(dep.Needs) {
+			g.Needs[dep.IdName] = append(g.Needs[dep.IdName], dep.Needs)
+		}
+	}
+	for _, needs := range g.Needs {
+		sort.Strings(needs)
+	}
+	for _, ep := range entryPoints {
+		if bound(ep.IdName) {
+			g.EntryPoints[ep.IdName] = true
+		}
+	}
+	return g
+}
+
+// Load builds the dependency graph of a bundle.
+func Load(b *bundler.Bundler) (*Graph, error) {
+	bindings, err := b.LoadBindings()
+	if err != nil {
+		return nil, err
+	}
+	dependencies, err := b.LoadDependencies()
+	if err != nil {
+		return nil, err
+	}
+	entryPoints, err := b.LoadEntryPoints()
+	if err != nil {
+		return nil, err
+	}
+	return New(bindings, dependencies, entryPoints), nil
+}
+
+// Reachable returns the bindings that can be reached from an entry point,
+// including the entry points themselves.
+func (g *Graph) Reachable() map[string]bool {
+	reached := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if reached[name] {
+			return
+		}
+		reached[name] = true
+		for _, need := range g.Needs[name] {
+			visit(need)
+		}
+	}
+	for _, name := range g.Names {
+		if g.EntryPoints[name] {
+			visit(name)
+		}
+	}
+	return reached
+}
+
+// Unreachable returns the bindings, in order, that no entry point can
+// reach. Without any entry points that is all of them.
+func (g *Graph) Unreachable() []string {
+	reached := g.Reachable()
+	unreachable := make([]string, 0)
+	for _, name := range g.Names {
+		if !reached[name] {
+			unreachable = append(unreachable, name)
+		}
+	}
+	return unreachable
+}
+
+// Prunable returns the bindings that pruning removes, which are the
+// Unreachable ones. A bundle without entry points is refused, because it is
+// most likely a library and pruning would empty it.
+func (g *Graph) Prunable() ([]string, error) {
+	if len(g.EntryPoints) == 0 && len(g.Names) > 0 {
+		return nil, fmt.Errorf("the bundle has no entry points, so pruning would delete all %d binding(s)", len(g.Names))
+	}
+	return g.Unreachable(), nil
+}
+
+// lazyNeeds returns, for each lazy binding, the lazy bindings that its
+// initialisation may force, either directly or by way of functions that it
+// refers to. Each one comes with the path that leads to it, which starts
+// with the binding and ends with the one it forces. The paths are as short
+// as possible and the forced bindings are in order.
+//
+// This is conservative: a function that an initialiser refers to is assumed
+// to be called while it runs.
+func (g *Graph) lazyNeeds() map[string][]Cycle {
+	result := make(map[string][]Cycle)
+	for _, start := range g.Names {
+		if !g.Lazy[start] {
+			continue
+		}
+		// A breadth first search that stops at lazy bindings.
+		parent := map[string]string{}
+		visited := map[string]bool{}
+		queue := []string{start}
+		forced := make([]string, 0)
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			for _, need := range g.Needs[name] {
+				if visited[need] {
+					continue
+				}
+				visited[need] = true
+				parent[need] = name
+				if g.Lazy[need] {
+					forced = append(forced, need)
+				} else {
+					queue = append(queue, need)
+				}
+			}
+		}
+		sort.Strings(forced)
+		paths := make([]Cycle, 0, len(forced))
+		for _, name := range forced {
+			path := Cycle{name}
+			for at := parent[name]; at != start; at = parent[at] {
+				path = append(path, at)
+			}
+			path = append(path, start)
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			paths = append(paths, path)
+		}
+		result[start] = paths
+	}
+	return result
+}
+
+// Cycles returns the cycles among lazy bindings, which would fail at runtime
+// because a binding would be needed while it is being initialised. There is
+// one cycle for each group of bindings that need each other, starting from
+// the first of them in order. The paths include the functions that lead
+// from one lazy binding to the next.
+func (g *Graph) Cycles() []Cycle {
+	needs := g.lazyNeeds()
+	cycles := make([]Cycle, 0)
+	for _, component := range g.components(needs) {
+		members := make(map[string]bool)
+		for _, name := range component {
+			members[name] = true
+		}
+		if cycle := shortestCycle(component[0], needs, members); cycle != nil {
+			cycles = append(cycles, cycle)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// components returns the strongly connected components of the lazy
+// bindings, using Tarjan's algorithm. Each component is in order.
+func (g *Graph) components(needs map[string][]Cycle) [][]string {
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := make([]string, 0)
+	components := make([][]string, 0)
+
+	var connect func(name string)
+	connect = func(name string) {
+		index[name] = len(index)
+		lowlink[name] = index[name]
+		stack = append(stack, name)
+		onStack[name] = true
+		for _, path := range needs[name] {
+			need := path[len(path)-1]
+			if _, seen := index[need]; !seen {
+				connect(need)
+				lowlink[name] = min(lowlink[name], lowlink[need])
+			} else if onStack[need] {
+				lowlink[name] = min(lowlink[name], index[need])
+			}
+		}
+		if lowlink[name] == index[name] {
+			component := make([]string, 0)
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == name {
+					break
+				}
+			}
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+	for _, name := range g.Names {
+		if _, seen := index[name]; !seen && g.Lazy[name] {
+			connect(name)
+		}
+	}
+	return components
+}
+
+// shortestCycle finds the shortest cycle from start back to itself that
+// stays among the members, or nil if there is none. This is only nil for a
+// component of one binding that does not need itself.
+func shortestCycle(start string, needs map[string][]Cycle, members map[string]bool) Cycle {
+	// Each step of the search is a path from one lazy binding to the next.
+	via := map[string]Cycle{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, path := range needs[name] {
+			need := path[len(path)-1]
+			if !members[need] {
+				continue
+			}
+			if need == start {
+				// Join up the steps that led here, starting from the end.
+				cycle := append(Cycle{}, path...)
+				for at := name; at != start; {
+					step := via[at]
+					cycle = append(append(Cycle{}, step[:len(step)-1]...), cycle...)
+					at = step[0]
+				}
+				return cycle
+			}
+			if _, seen := via[need]; !seen {
+				via[need] = path
+				queue = append(queue, need)
+			}
+		}
+	}
+	return nil
+}
+
+// InitOrder returns the lazy bindings in an order in which they can be
+// initialised, with every binding after the ones its initialisation may
+// force. Bindings that do not depend on each other are in name order. It is
+// an error if the lazy bindings have cycles.
+func (g *Graph) InitOrder() ([]string, error) {
+	if cycles := g.Cycles(); len(cycles) > 0 {
+		return nil, fmt.Errorf("lazy bindings depend on themselves: %s", cycles[0])
+	}
+	needs := g.lazyNeeds()
+	done := make(map[string]bool)
+	order := make([]string, 0)
+	var visit func(name string)
+	visit = func(name string) {
+		if done[name] {
+			return
+		}
+		done[name] = true
+		for _, path := range needs[name] {
+			visit(path[len(path)-1])
+		}
+		order = append(order, name)
+	}
+	for _, name := range g.Names {
+		if g.Lazy[name] {
+			visit(name)
+		}
+	}
+	return order, nil
+}
+
+// ToGraph converts the dependency graph into a form that the graph writers
+// of pkg/common can draw, with an edge from each binding to the ones it
+// needs.
+func (g *Graph) ToGraph() *common.Graph {
+	graph := &common.Graph{}
+	for _, name := range g.Names {
+		kind := "function"
+		if g.EntryPoints[name] {
+			kind = "entry"
+		} else if g.Lazy[name] {
+			kind = "lazy"
+		}
+		graph.Vertices = append(graph.Vertices, common.GraphVertex{Name: name, Kind: kind})
+		for _, need := range g.Needs[name] {
+			graph.Edges = append(graph.Edges, common.GraphEdge{From: name, To: need})
+		}
+	}
+	return graph
+}
@@ -0,0 +1,102 @@
+package depgraph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+)
+
+// newGraph builds a graph from bindings written as "name" for a function or
+// "name*" for a lazy binding, and edges written as "from>to".
+func newGraph(bindings string, edges string, entryPoints ...string) *Graph {
+	rows := make([]bundler.Binding, 0)
+	for _, name := range strings.Fields(bindings) {
+		lazy := strings.HasSuffix(name, "*")
+		rows = append(rows, bundler.Binding{IdName: strings.TrimSuffix(name, "*"), Lazy: lazy})
+	}
+	deps := make([]bundler.DependsOn, 0)
+	for _, edge := range strings.Fields(edges) {
+		from, to, _ := strings.Cut(edge, ">")
+		deps = append(deps, bundler.DependsOn{IdName: from, Needs: to})
+	}
+	eps := make([]bundler.EntryPoint, 0)
+	for _, name := range entryPoints {
+		eps = append(eps, bundler.EntryPoint{IdName: name})
+	}
+	return New(rows, deps, eps)
+}
+
+func cycleStrings(cycles []Cycle) []string {
+	result := make([]string, 0, len(cycles))
+	for _, cycle := range cycles {
+		result = append(result, cycle.String())
+	}
+	return result
+}
+
+func TestInitOrder(t *testing.T) {
+	// c forces a by calling f, b forces c directly and d stands alone.
+	g := newGraph("a* b* c* d* f", "b>c c>f f>a f>println")
+	order, err := g.InitOrder()
+	if err != nil {
+		t.Fatalf("InitOrder failed: %v", err)
+	}
+	expected := []string{"a", "c", "b", "d"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %v, got %v", expected, order)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		graph    *Graph
+		expected []string
+	}{
+		{"none", newGraph("a* b* f", "a>b b>f f>f"), []string{}},
+		{"self", newGraph("a*", "a>a"), []string{"a -> a"}},
+		{"direct", newGraph("a* b*", "a>b b>a"), []string{"a -> b -> a"}},
+		{"through functions", newGraph("a* b* f g", "a>f f>g g>b b>a"), []string{"a -> f -> g -> b -> a"}},
+		{"shortest", newGraph("a* b* c*", "a>b b>c c>a b>a"), []string{"a -> b -> a"}},
+		{"separate", newGraph("a* b* c* d*", "a>b b>a c>d d>c"), []string{"a -> b -> a", "c -> d -> c"}},
+	}
+	for _, test := range tests {
+		if got := cycleStrings(test.graph.Cycles()); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+
+	_, err := newGraph("a* b*", "a>b b>a").InitOrder()
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("Expected InitOrder to report the cycle, got %v", err)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	g := newGraph("main f g h x*", "main>f f>x g>h", "main")
+	expected := []string{"g", "h"}
+	if got := g.Unreachable(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestPruningNeedsAnEntryPoint(t *testing.T) {
+	g := newGraph("f g", "f>g")
+	if got := g.Unreachable(); !reflect.DeepEqual(got, []string{"f", "g"}) {
+		t.Errorf("Expected every binding to be unreachable, got %v", got)
+	}
+	if _, err := g.Prunable(); err == nil || !strings.Contains(err.Error(), "no entry points") {
+		t.Errorf("Expected pruning to be refused, got %v", err)
+	}
+
+	g = newGraph("main f g", "main>f", "main")
+	if got, err := g.Prunable(); err != nil || !reflect.DeepEqual(got, []string{"g"}) {
+		t.Errorf("Expected [g], got %v (%v)", got, err)
+	}
+	// An empty bundle has nothing to prune.
+	if got, err := newGraph("", "").Prunable(); err != nil || len(got) != 0 {
+		t.Errorf("Expected nothing to prune, got %v (%v)", got, err)
+	}
+}
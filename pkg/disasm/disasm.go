@@ -0,0 +1,253 @@
+// Package disasm gathers the contents of a bundle and prints them in a form
+// that people can read, or as JSON for other tools.
+package disasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+)
+
+// Bundle is everything that is stored in a bundle about its bindings.
+type Bundle struct {
+	Bindings []*Binding `json:"bindings"`
+}
+
+// Binding brings together a binding with the rows of the other tables that
+// refer to it. Error is set instead of Function if the value of the binding
+// could not be decoded.
+type Binding struct {
+	Name        string                  `json:"name"`
+	Lazy        bool                    `json:"lazy"`
+	EntryPoint  bool                    `json:"entry_point"`
+	FileName    string                  `json:"file_name"`
+	ModuleName  string                  `json:"module_name,omitempty"`
+	Needs       []string                `json:"needs"`
+	SysFns      []string                `json:"sysfns"`
+	Annotations []Annotation            `json:"annotations"`
+	Function    *bundler.FunctionObject `json:"function,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+}
+
+// Annotation is a single annotation of a binding.
+type Annotation struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (a Annotation) String() string {
+	if a.Value == "" {
+		return a.Key
+	}
+	return fmt.Sprintf("%s=%s", a.Key, a.Value)
+}
+
+// Load reads every binding from the bundle along with its dependencies, the
+// system functions it calls, its annotations and whether it is an entry point.
+func Load(b *bundler.Bundler) (*Bundle, error) {
+	bindings, err := b.LoadBindings()
+	if err != nil {
+		return nil, err
+	}
+	entryPoints, err := b.LoadEntryPoints()
+	if err != nil {
+		return nil, err
+	}
+	dependencies, err := b.LoadDependencies()
+	if err != nil {
+		return nil, err
+	}
+	syscallUses, err := b.LoadSyscallUses()
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := b.LoadAnnotations()
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{Bindings: make([]*Binding, 0, len(bindings))}
+	byName := make(map[string]*Binding)
+	for _, binding := range bindings {
+		entry := &Binding{
+			Name:        binding.IdName,
+			Lazy:        binding.Lazy,
+			FileName:    binding.FileName,
+			ModuleName:  binding.ModuleName,
+			Needs:       make([]string, 0),
+			SysFns:      make([]string, 0),
+			Annotations: make([]Annotation, 0),
+		}
+		if funcObj, err := bundler.DecodeFunctionObject(binding.Value); err == nil {
+			entry.Function = funcObj
+		} else {
+			entry.Error = err.Error()
+		}
+		bundle.Bindings = append(bundle.Bindings, entry)
+		byName[binding.IdName] = entry
+	}
+	// Rows that refer to a missing binding are left out, as there is no
+	// binding to list them under. The bundler deletes the rows of a binding
+	// along with it, so they only arise in a bundle edited by other means.
+	for _, ep := range entryPoints {
+		if entry, ok := byName[ep.IdName]; ok {
+			entry.EntryPoint = true
+		}
+	}
+	for _, dep := range dependencies {
+		if entry, ok := byName[dep.IdName]; ok {
+			entry.Needs = append(entry.Needs, dep.Needs)
+		}
+	}
+	for _, use := range syscallUses {
+		if entry, ok := byName[use.IdName]; ok {
+			entry.SysFns = append(entry.SysFns, use.SysFn)
+		}
+	}
+	for _, ann := range annotations {
+		if entry, ok := byName[ann.IdName]; ok {
+			entry.Annotations = append(entry.Annotations, Annotation{Key: ann.AnnotationKey, Value: ann.AnnotationValue})
+		}
+	}
+	return bundle, nil
+}
+
+// Filter selects bindings. A binding is selected if its name matches any of
+// Names, it has all of Annotations and, when EntryPoints is set, it is an
+// entry point. An empty filter selects everything.
+type Filter struct {
+	Names       []string // Glob patterns, as understood by path.Match.
+	Annotations []string // Either KEY or KEY=VALUE.
+	EntryPoints bool
+}
+
+// Validate checks that the name patterns are well formed.
+func (f Filter) Validate() error {
+	for _, pattern := range f.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid name pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the filter selects the binding.
+func (f Filter) Matches(binding *Binding) bool {
+	if f.EntryPoints && !binding.EntryPoint {
+		return false
+	}
+	if len(f.Names) > 0 {
+		found := false
+		for _, pattern := range f.Names {
+			// Validate has already rejected malformed patterns.
+			if ok, _ := path.Match(pattern, binding.Name); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, wanted := range f.Annotations {
+		key, value, hasValue := strings.Cut(wanted, "=")
+		found := false
+		for _, ann := range binding.Annotations {
+			if ann.Key == key && (!hasValue || ann.Value == value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns a bundle with just the bindings that the filter selects.
+func (bundle *Bundle) Select(f Filter) *Bundle {
+	selected := &Bundle{Bindings: make([]*Binding, 0)}
+	for _, binding := range bundle.Bindings {
+		if f.Matches(binding) {
+			selected.Bindings = append(selected.Bindings, binding)
+		}
+	}
+	return selected
+}
+
+// WriteJSON writes the bundle as a single indented JSON document.
+func (bundle *Bundle) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bundle)
+}
+
+// WriteText writes a listing of each binding, separated by blank lines.
+func (bundle *Bundle) WriteText(w io.Writer) error {
+	for i, binding := range bundle.Bindings {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if err := binding.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteText writes the details of a single binding followed by a listing of
+// its instructions.
+func (binding *Binding) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString(binding.Name)
+	if binding.EntryPoint {
+		sb.WriteString("  [entry point]")
+	}
+	sb.WriteString("\n")
+	field := func(name string, value any) {
+		fmt.Fprintf(&sb, "  %-12s %v\n", name+":", value)
+	}
+	field("lazy", binding.Lazy)
+	field("file", binding.FileName)
+	if binding.ModuleName != "" {
+		field("module", binding.ModuleName)
+	}
+	if binding.Function != nil {
+		field("nparams", binding.Function.NParams)
+		field("nlocals", binding.Function.NLocals)
+	}
+	if len(binding.Needs) > 0 {
+		field("needs", strings.Join(binding.Needs, ", "))
+	}
+	if len(binding.SysFns) > 0 {
+		field("sysfns", strings.Join(binding.SysFns, ", "))
+	}
+	if len(binding.Annotations) > 0 {
+		names := make([]string, len(binding.Annotations))
+		for i, ann := range binding.Annotations {
+			names[i] = ann.String()
+		}
+		field("annotations", strings.Join(names, ", "))
+	}
+	if binding.Function == nil {
+		field("error", binding.Error)
+	} else if lines, err := Listing(binding.Function); err != nil {
+		field("error", err)
+	} else {
+		sb.WriteString("\n")
+		for _, line := range lines {
+			sb.WriteString("  ")
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,186 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// ifThenElse is if x then 1 else 2 endif, as codegen produces it.
+func ifThenElse() *bundler.FunctionObject {
+	return &bundler.FunctionObject{
+		NLocals: 1,
+		NParams: 1,
+		Instructions: []bundler.Instruction{
+			bundler.NewPushLocal(0),
+			bundler.NewIfNot("L0"),
+			bundler.NewPushInt(1),
+			bundler.NewGoto("L1"),
+			bundler.NewLabel("L0"),
+			bundler.NewPushInt(2),
+			bundler.NewLabel("L1"),
+			bundler.NewReturn(),
+		},
+	}
+}
+
+func checkLines(t *testing.T, actual []string, expected []string) {
+	t.Helper()
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestListingDrawsJumps(t *testing.T) {
+	expected := []string{
+		"           0  push.local 0",
+		"  +--      1  if.not     L0",
+		"  |        2  push.int   1",
+		"+-|--      3  goto       L1",
+		"| +->         L0:",
+		"|          4  push.int   2",
+		"+--->         L1:",
+		"           5  return",
+	}
+	symbolic, err := Listing(ifThenElse())
+	if err != nil {
+		t.Fatalf("Listing failed: %v", err)
+	}
+	checkLines(t, symbolic, expected)
+
+	// The assembled form is listed the same way.
+	assembled, err := bundler.Assemble(ifThenElse())
+	if err != nil {
+		t.Fatalf("Assemble failed: %v", err)
+	}
+	lines, err := Listing(assembled)
+	if err != nil {
+		t.Fatalf("Listing failed: %v", err)
+	}
+	checkLines(t, lines, expected)
+}
+
+func TestListingWithoutJumpsHasNoMargin(t *testing.T) {
+	lines, err := Listing(&bundler.FunctionObject{
+		Instructions: []bundler.Instruction{
+			bundler.NewPushString("hi\n"),
+			bundler.NewSyscallFixed("println", 1),
+			bundler.NewReturn(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Listing failed: %v", err)
+	}
+	checkLines(t, lines, []string{
+		`    0  push.string   "hi\n"`,
+		"    1  syscall.fixed println 1",
+		"    2  return",
+	})
+}
+
+func TestListingRejectsUndefinedLabels(t *testing.T) {
+	_, err := Listing(&bundler.FunctionObject{Instructions: []bundler.Instruction{bundler.NewGoto("L9")}})
+	if err == nil || !strings.Contains(err.Error(), "undefined label L9") {
+		t.Errorf("Expected an undefined label error, got %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	main := &Binding{Name: "main", EntryPoint: true, Annotations: []Annotation{{Key: "command", Value: "go"}}}
+	helper := &Binding{Name: "tmp-1"}
+	bundle := &Bundle{Bindings: []*Binding{main, helper}}
+
+	tests := []struct {
+		filter   Filter
+		expected []string
+	}{
+		{Filter{}, []string{"main", "tmp-1"}},
+		{Filter{Names: []string{"tmp-*"}}, []string{"tmp-1"}},
+		{Filter{Names: []string{"nope", "main"}}, []string{"main"}},
+		{Filter{Annotations: []string{"command"}}, []string{"main"}},
+		{Filter{Annotations: []string{"command=go"}}, []string{"main"}},
+		{Filter{Annotations: []string{"command=stop"}}, []string{}},
+		{Filter{EntryPoints: true, Names: []string{"tmp-*"}}, []string{}},
+	}
+	for _, test := range tests {
+		names := make([]string, 0)
+		for _, binding := range bundle.Select(test.filter).Bindings {
+			names = append(names, binding.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Filter %+v: expected %v, got %v", test.filter, test.expected, names)
+		}
+	}
+}
+
+func TestFilterRejectsBadPatterns(t *testing.T) {
+	if err := (Filter{Names: []string{"["}}).Validate(); err == nil {
+		t.Errorf("Expected an invalid pattern error")
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	binding := &Binding{
+		Name:        "main",
+		EntryPoint:  true,
+		FileName:    "main.nutmeg",
+		Needs:       []string{"f", "g"},
+		SysFns:      []string{"println"},
+		Annotations: []Annotation{{Key: "main"}, {Key: "doc", Value: "hi"}},
+		Function: &bundler.FunctionObject{
+			Version:      bundler.AssembledVersion,
+			Instructions: []bundler.Instruction{bundler.NewReturn()},
+		},
+	}
+	var sb strings.Builder
+	if err := binding.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	expected := strings.Join([]string{
+		"main  [entry point]",
+		"  lazy:        false",
+		"  file:        main.nutmeg",
+		"  nparams:     0",
+		"  nlocals:     0",
+		"  needs:       f, g",
+		"  sysfns:      println",
+		"  annotations: main, doc=hi",
+		"",
+		"      0  return",
+		"",
+	}, "\n")
+	if sb.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, sb.String())
+	}
+}
+
+func TestListingShowsSourceLocations(t *testing.T) {
+	funcObj := ifThenElse()
+	funcObj.Lines = []bundler.LineEntry{
+		{Index: 0, Span: common.Span{StartLine: 1, StartColumn: 4, EndLine: 1, EndColumn: 5}},
+		{Index: 2, Span: common.Span{StartLine: 1, StartColumn: 11, EndLine: 1, EndColumn: 12}},
+		{Index: 3, Span: common.Span{StartLine: 1, StartColumn: 1, EndLine: 1, EndColumn: 25}},
+		{Index: 5, Span: common.Span{StartLine: 1, StartColumn: 18, EndLine: 1, EndColumn: 19}},
+		{Index: 7, Span: common.Span{StartLine: 1, StartColumn: 1, EndLine: 1, EndColumn: 25}},
+	}
+	assembled, err := bundler.Assemble(funcObj)
+	if err != nil {
+		t.Fatalf("Assemble failed: %v", err)
+	}
+	lines, err := Listing(assembled)
+	if err != nil {
+		t.Fatalf("Listing failed: %v", err)
+	}
+	checkLines(t, lines, []string{
+		"           0  1:4   push.local 0",
+		"  +--      1        if.not     L0",
+		"  |        2  1:11  push.int   1",
+		"+-|--      3  1:1   goto       L1",
+		"| +->               L0:",
+		"|          4  1:18  push.int   2",
+		"+--->               L1:",
+		"           5  1:1   return",
+	})
+}
@@ -0,0 +1,229 @@
+package disasm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// jump is an arrow in the margin of a listing, from the row of a jump
+// instruction to the row of the label it goes to.
+type jump struct {
+	from, to int
+	column   int // 0 is the column nearest the instructions.
+}
+
+func (j jump) span() (int, int) {
+	return min(j.from, j.to), max(j.from, j.to)
+}
+
+// Listing returns the lines of a listing of the instructions of a function
+// object. Assembled function objects are disassembled first, so that jumps
+// are shown going to labels. Each instruction is numbered with its index in
+// the assembled form and the margin has an arrow for each jump.
+//
+//	           0  push.local 0
+//	  +--      1  if.not     L0
+//	  |        2  push.int   1
+//	+-|--      3  goto       L1
+//	| +->         L0:
+//	|          4  push.int   2
+//	+--->         L1:
+//	           5  return
+//
+// If the function object has a line table then each instruction that starts
+// a new source span is also given its line and column.
+func Listing(funcObj *bundler.FunctionObject) ([]string, error) {
+	symbolic, err := bundler.Disassemble(funcObj)
+	if err != nil {
+		return nil, err
+	}
+	rows := symbolic.Instructions
+
+	labels := make(map[string]int)
+	opWidth := 0
+	for i, inst := range rows {
+		if inst.Type == "label" && inst.StrValue != nil {
+			labels[*inst.StrValue] = i
+		}
+		opWidth = max(opWidth, len(inst.Type))
+	}
+	jumps, err := findJumps(rows, labels)
+	if err != nil {
+		return nil, err
+	}
+	margin := drawMargin(len(rows), jumps)
+	locations := sourceLocations(symbolic)
+	locWidth := 0
+	for _, loc := range locations {
+		locWidth = max(locWidth, len(loc))
+	}
+	if locWidth > 0 {
+		// Leave a gap between the locations and the instructions.
+		locWidth += 2
+	}
+
+	lines := make([]string, len(rows))
+	index := 0
+	for i, inst := range rows {
+		var line string
+		if inst.Type == "label" {
+			line = fmt.Sprintf("%5s  %*s%s:", "", locWidth, "", operands(inst))
+		} else {
+			line = strings.TrimRight(fmt.Sprintf("%5d  %-*s%-*s %s", index, locWidth, locations[i], opWidth, inst.Type, operands(inst)), " ")
+			index++
+		}
+		lines[i] = margin[i] + line
+	}
+	return lines, nil
+}
+
+// sourceLocations returns the line:column of the source of each row, leaving
+// it blank for labels and for rows whose span is the same as the previous
+// instruction's.
+func sourceLocations(symbolic *bundler.FunctionObject) []string {
+	locations := make([]string, len(symbolic.Instructions))
+	var previous *common.Span
+	for i, inst := range symbolic.Instructions {
+		if inst.Type == "label" {
+			continue
+		}
+		span, ok := symbolic.SpanAt(i)
+		if !ok || (previous != nil && *previous == span) {
+			continue
+		}
+		previous = &span
+		locations[i] = fmt.Sprintf("%d:%d", span.StartLine, span.StartColumn)
+	}
+	return locations
+}
+
+// findJumps finds the jumps between rows and gives each one a column in the
+// margin, so that arrows whose spans overlap are in different columns. The
+// shortest arrows are placed nearest the instructions.
+func findJumps(rows []bundler.Instruction, labels map[string]int) ([]jump, error) {
+	jumps := make([]jump, 0)
+	add := func(i int, label *string) error {
+		if label == nil {
+			return fmt.Errorf("missing label at instruction %d", i)
+		}
+		to, ok := labels[*label]
+		if !ok {
+			return fmt.Errorf("undefined label %s at instruction %d", *label, i)
+		}
+		jumps = append(jumps, jump{from: i, to: to})
+		return nil
+	}
+	for i, inst := range rows {
+		var err error
+		switch inst.Type {
+		case "goto", "if.not", "if.so":
+			err = add(i, inst.StrValue)
+		case "if.then.else":
+			if err = add(i, inst.Name); err == nil {
+				err = add(i, inst.StrValue)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(jumps, func(a, b int) bool {
+		loA, hiA := jumps[a].span()
+		loB, hiB := jumps[b].span()
+		return hiA-loA < hiB-loB
+	})
+	for k := range jumps {
+		lo, hi := jumps[k].span()
+		for column := 0; ; column++ {
+			free := true
+			for _, other := range jumps[:k] {
+				otherLo, otherHi := other.span()
+				if other.column == column && lo <= otherHi && otherLo <= hi {
+					free = false
+					break
+				}
+			}
+			if free {
+				jumps[k].column = column
+				break
+			}
+		}
+	}
+	return jumps, nil
+}
+
+// drawMargin draws the arrows for the jumps, returning the margin for each
+// row. Each column takes two characters and there is a final character for
+// the arrow heads.
+func drawMargin(nrows int, jumps []jump) []string {
+	margins := make([]string, nrows)
+	if len(jumps) == 0 {
+		return margins
+	}
+	ncolumns := 0
+	for _, j := range jumps {
+		ncolumns = max(ncolumns, j.column+1)
+	}
+	width := 2*ncolumns + 1
+	grid := make([][]rune, nrows)
+	for r := range grid {
+		grid[r] = []rune(strings.Repeat(" ", width+2))
+	}
+	x := func(j jump) int {
+		return 2 * (ncolumns - 1 - j.column)
+	}
+	// The vertical lines go first so that the horizontal lines can cross
+	// them.
+	for _, j := range jumps {
+		lo, hi := j.span()
+		for r := lo + 1; r < hi; r++ {
+			grid[r][x(j)] = '|'
+		}
+	}
+	for _, j := range jumps {
+		for _, r := range []int{j.from, j.to} {
+			grid[r][x(j)] = '+'
+			for k := x(j) + 1; k < width; k++ {
+				if grid[r][k] == ' ' {
+					grid[r][k] = '-'
+				}
+			}
+		}
+	}
+	for _, j := range jumps {
+		grid[j.to][width-1] = '>'
+	}
+	for r := range grid {
+		margins[r] = string(grid[r])
+	}
+	return margins
+}
+
+// operands formats the operands of an instruction, in a fixed order, leaving
+// out the ones it does not have.
+func operands(inst bundler.Instruction) string {
+	parts := make([]string, 0, 4)
+	if inst.Name != nil {
+		parts = append(parts, *inst.Name)
+	}
+	if inst.StrValue != nil {
+		if inst.Type == "push.string" {
+			parts = append(parts, strconv.Quote(*inst.StrValue))
+		} else {
+			parts = append(parts, *inst.StrValue)
+		}
+	}
+	if inst.Index != nil {
+		parts = append(parts, strconv.Itoa(*inst.Index))
+	}
+	if inst.IntValue != nil {
+		parts = append(parts, strconv.Itoa(*inst.IntValue))
+	}
+	return strings.Join(parts, " ")
+}
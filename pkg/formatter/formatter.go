@@ -0,0 +1,584 @@
+// Package formatter prints the parse tree of a Nutmeg unit back out as
+// canonical source code.
+package formatter
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// Indent is the text that indents each level of a block.
+const Indent = "    "
+
+// comment is a comment of the source, which the parse tree does not keep.
+type comment struct {
+	line int
+	text string
+}
+
+// position is the start of a token, by which the keywords of the parts of a
+// form are found.
+type position struct {
+	line, column int
+}
+
+// printer writes out a parse tree one line at a time. It keeps track of the
+// last line of the source that it has printed, so that comments and blank
+// lines can be placed among the statements that they came between.
+type printer struct {
+	source   string
+	offsets  []int // The offset in the source at which each line starts.
+	tokens   map[position]*common.Token
+	comments []comment
+	next     int // The index of the next comment to print.
+	out      strings.Builder
+	line     strings.Builder
+	indent   int
+	last     int // The last line of the source printed so far.
+	previous int // The last line of the source printed on a finished line.
+}
+
+// Format returns the canonical source code of a unit, given the parse tree
+// that the parser made from the tokens of the source. The tokens supply the
+// keywords of forms, which the tree lacks, and the source supplies the
+// literals, comments and blank lines.
+//
+// Statements go one to a line, with the bodies of forms indented. A form
+// that is on a single line stays on one line, and its parts are otherwise
+// put on the lines that they started on. Keywords are printed as the parser
+// understood them, so that aliases are replaced, and each form is closed
+// with the closer that names it, such as endif rather than end.
+func Format(tree *common.Node, tokens []*common.Token, source string) (string, error) {
+	if tree.Name != common.NameUnit {
+		return "", fmt.Errorf("expected a %s but got a %s", common.NameUnit, tree.Name)
+	}
+	if bad := findError(tree); bad != nil {
+		return "", fmt.Errorf("cannot format a unit with a parse error at line %d, column %d: %s", bad.Span.StartLine, bad.Span.StartColumn, bad.Options[common.OptionMessage])
+	}
+	p := newPrinter(tokens, source)
+	first := true
+	for _, statement := range tree.Children {
+		p.item(startLine(statement, p.last), first)
+		if err := p.expr(statement); err != nil {
+			return "", err
+		}
+		first = false
+	}
+	p.leading(math.MaxInt, first, true)
+	p.newline()
+	return p.out.String(), nil
+}
+
+// findError returns the first error node of a tree, or nil if it has none.
+func findError(node *common.Node) *common.Node {
+	if node.Name == common.NameError {
+		return node
+	}
+	for _, child := range node.Children {
+		if bad := findError(child); bad != nil {
+			return bad
+		}
+	}
+	return nil
+}
+
+func newPrinter(tokens []*common.Token, source string) *printer {
+	p := &printer{source: source, offsets: []int{0}, tokens: make(map[position]*common.Token)}
+	for i, c := range source {
+		if c == '\n' {
+			p.offsets = append(p.offsets, i+1)
+		}
+	}
+	// Comments are skipped by the tokenizer, so they are found in the gaps
+	// between the tokens.
+	gap := 0
+	for _, token := range tokens {
+		p.tokens[position{token.Span.StartLine, token.Span.StartColumn}] = token
+		start := p.offset(token.Span.StartLine, token.Span.StartColumn)
+		p.findComments(gap, start)
+		gap = max(gap, p.offset(token.Span.EndLine, token.Span.EndColumn))
+	}
+	p.findComments(gap, len(source))
+	return p
+}
+
+// offset returns the offset in the source of a line and column.
+func (p *printer) offset(line, column int) int {
+	if line < 1 {
+		return 0
+	}
+	if line > len(p.offsets) {
+		return len(p.source)
+	}
+	return min(p.offsets[line-1]+max(column-1, 0), len(p.source))
+}
+
+// findComments records the comments between two offsets of the source, which
+// hold nothing but whitespace and comments.
+func (p *printer) findComments(start, end int) {
+	for start < end {
+		i := strings.Index(p.source[start:end], "###")
+		if i < 0 {
+			return
+		}
+		start += i
+		stop := strings.IndexByte(p.source[start:end], '\n')
+		if stop < 0 {
+			stop = end - start
+		}
+		line, _ := slices.BinarySearch(p.offsets, start+1)
+		p.comments = append(p.comments, comment{line: line, text: strings.TrimRightFunc(p.source[start:start+stop], unicode.IsSpace)})
+		start += stop
+	}
+}
+
+// text returns the source code that a span covers.
+func (p *printer) text(span common.Span) string {
+	return p.source[p.offset(span.StartLine, span.StartColumn):p.offset(span.EndLine, span.EndColumn)]
+}
+
+// isBlank returns true if a line of the source has nothing on it.
+func (p *printer) isBlank(line int) bool {
+	return strings.TrimSpace(p.text(common.Span{StartLine: line, StartColumn: 1, EndLine: line + 1, EndColumn: 1})) == ""
+}
+
+// write adds text to the current line, indenting the line first if the text
+// starts it.
+func (p *printer) write(text string) {
+	if p.line.Len() == 0 {
+		p.line.WriteString(strings.Repeat(Indent, p.indent))
+	}
+	p.line.WriteString(text)
+}
+
+// touch records that the source up to the end of a span has been printed.
+func (p *printer) touch(span common.Span) {
+	p.last = max(p.last, span.EndLine)
+}
+
+// newline finishes the current line, if one has been started. The comments
+// on the lines of the source that it printed follow it, the first on the
+// same line and any others on lines of their own.
+func (p *printer) newline() {
+	if p.line.Len() == 0 {
+		return
+	}
+	var after []comment
+	for p.next < len(p.comments) && p.comments[p.next].line <= p.last {
+		after = append(after, p.comments[p.next])
+		p.next++
+	}
+	if len(after) > 0 {
+		p.line.WriteString(" " + after[0].text)
+		after = after[1:]
+	}
+	p.out.WriteString(p.line.String() + "\n")
+	p.line.Reset()
+	for _, c := range after {
+		p.out.WriteString(strings.Repeat(Indent, p.indent) + c.text + "\n")
+	}
+	p.previous = p.last
+}
+
+// leading finishes the current line and prints the comments that come
+// before a line of the source on lines of their own. If blanks is true then
+// a blank line is kept before any comment that follows blank lines, except
+// for the first item of a block. It returns whether the next item is still
+// the first.
+func (p *printer) leading(line int, first, blanks bool) bool {
+	p.newline()
+	for p.next < len(p.comments) && p.comments[p.next].line < line {
+		c := p.comments[p.next]
+		p.next++
+		if blanks {
+			p.separate(c.line, first)
+		}
+		p.out.WriteString(strings.Repeat(Indent, p.indent) + c.text + "\n")
+		p.previous = c.line
+		first = false
+	}
+	return first
+}
+
+// separate prints a blank line if the source has blank lines between the
+// last line printed and a line, unless the line starts a block.
+func (p *printer) separate(line int, first bool) {
+	if first {
+		return
+	}
+	for l := p.previous + 1; l < line; l++ {
+		if p.isBlank(l) {
+			p.out.WriteString("\n")
+			return
+		}
+	}
+}
+
+// item starts a new line for a statement of a block, which starts on a line
+// of the source, after the comments that precede it.
+func (p *printer) item(line int, first bool) {
+	first = p.leading(line, first, true)
+	p.separate(line, first)
+}
+
+// expr prints an expression on the current line. Only the surround forms
+// that span several lines of the source print several lines.
+func (p *printer) expr(node *common.Node) error {
+	switch node.Name {
+	case common.NameIdentifier:
+		p.write(node.Options[common.OptionName])
+		p.touch(node.Span)
+	case common.NameNumber, common.NameString, common.NameJoin, common.NameJoinLines:
+		// The tree does not record how a literal was written, such as the
+		// radix of a number or the escapes of a string, so it is copied. The
+		// spans of the expressions interpolated into a string are relative to
+		// the expression, which is why the string is copied as a whole.
+		p.write(p.text(node.Span))
+		p.touch(node.Span)
+	case common.NameOperator:
+		return p.operator(node)
+	case common.NameApply:
+		if len(node.Children) != 2 {
+			return fmt.Errorf("expected an %s to have 2 children but got %d", node.Name, len(node.Children))
+		}
+		if err := p.expr(node.Children[0]); err != nil {
+			return err
+		}
+		return p.delimited(node.Children[1])
+	case common.NameDelimited, common.NameArguments:
+		return p.delimited(node)
+	case common.NameForm:
+		if len(node.Children) == 0 {
+			return fmt.Errorf("expected a %s to have parts", node.Name)
+		}
+		if node.Options[common.OptionSyntax] == common.ValuePrefix {
+			return p.prefixForm(node)
+		}
+		return p.surroundForm(node)
+	default:
+		if !isSane(node.Span) {
+			return fmt.Errorf("cannot format a %s node", node.Name)
+		}
+		p.write(p.text(node.Span))
+		p.touch(node.Span)
+	}
+	return nil
+}
+
+func (p *printer) operator(node *common.Node) error {
+	name := node.Options[common.OptionName]
+	switch node.Options[common.OptionSyntax] {
+	case common.ValueInfix:
+		if len(node.Children) != 2 {
+			return fmt.Errorf("expected the infix operator %s to have 2 children but got %d", name, len(node.Children))
+		}
+		if err := p.expr(node.Children[0]); err != nil {
+			return err
+		}
+		// Member access is written tightly, unless the dot would be taken
+		// for the decimal point of a number.
+		if name == "." && node.Children[0].Name != common.NameNumber {
+			p.write(name)
+		} else {
+			p.write(" " + name + " ")
+		}
+		return p.expr(node.Children[1])
+	case common.ValuePrefix:
+		if len(node.Children) != 1 {
+			return fmt.Errorf("expected the prefix operator %s to have 1 child but got %d", name, len(node.Children))
+		}
+		p.write(name)
+		if needsSpace(name, p.edge(node.Children[0], true)) {
+			p.write(" ")
+		}
+		return p.expr(node.Children[0])
+	case common.ValuePostfix:
+		if len(node.Children) != 1 {
+			return fmt.Errorf("expected the postfix operator %s to have 1 child but got %d", name, len(node.Children))
+		}
+		if err := p.expr(node.Children[0]); err != nil {
+			return err
+		}
+		if needsSpace(p.edge(node.Children[0], false), name) {
+			p.write(" ")
+		}
+		p.write(name)
+	default:
+		return fmt.Errorf("unknown syntax for the operator %s: %q", name, node.Options[common.OptionSyntax])
+	}
+	return nil
+}
+
+// brackets gives the opening and closing brackets of each kind of delimited
+// expression.
+var brackets = map[string][2]string{
+	common.ValueParentheses: {"(", ")"},
+	common.ValueBrackets:    {"[", "]"},
+	common.ValueBraces:      {"{", "}"},
+}
+
+// delimited prints a bracketed list of expressions, such as the arguments
+// of an apply.
+func (p *printer) delimited(node *common.Node) error {
+	pair, ok := brackets[node.Options[common.OptionKind]]
+	if !ok {
+		return fmt.Errorf("unknown kind of %s: %q", node.Name, node.Options[common.OptionKind])
+	}
+	separator := ", "
+	if node.Options[common.OptionSeparator] == common.ValueSemicolon {
+		separator = "; "
+	}
+	p.write(pair[0])
+	for i, child := range node.Children {
+		if i > 0 {
+			p.write(separator)
+		}
+		if err := p.expr(child); err != nil {
+			return err
+		}
+	}
+	p.write(pair[1])
+	p.touch(node.Span)
+	return nil
+}
+
+func (p *printer) prefixForm(form *common.Node) error {
+	part := form.Children[0]
+	p.write(part.Options[common.OptionKeyword])
+	p.touch(form.Span)
+	for _, child := range part.Children {
+		p.write(" ")
+		if err := p.expr(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// surroundForm prints a form such as an if or a def. Each part starts on the
+// same line as the part before it if it did so in the source, except after a
+// block. The expressions of a part that takes many of them are printed on
+// the keyword's line if the part was on a single line with the next keyword,
+// and otherwise as a block of statements, one to a line.
+func (p *printer) surroundForm(form *common.Node) error {
+	start, err := p.keyword(form.Children[0])
+	if err != nil {
+		return err
+	}
+	indent := p.indent
+	block := false
+	for i, part := range form.Children {
+		token, err := p.keyword(part)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			p.startPart(part.Span.StartLine, indent, block)
+		}
+		p.write(part.Options[common.OptionKeyword])
+		p.touch(token.Span)
+		block = false
+		var arity common.Arity
+		if token.Arity != nil {
+			arity = *token.Arity
+		}
+		switch arity {
+		case common.Zero:
+		case common.One:
+			for _, child := range part.Children {
+				p.write(" ")
+				if err := p.expr(child); err != nil {
+					return err
+				}
+			}
+		default:
+			// The last part of a form that spans several lines is always a
+			// block, so that the closer is on a line of its own.
+			last := i+1 == len(form.Children)
+			next := form.Span.EndLine
+			if !last {
+				next = form.Children[i+1].Span.StartLine
+			}
+			if p.isOneLine(part, next) && (!last || form.Span.StartLine == form.Span.EndLine) {
+				for j, child := range part.Children {
+					if j > 0 {
+						p.write(";")
+					}
+					p.write(" ")
+					if err := p.expr(child); err != nil {
+						return err
+					}
+				}
+			} else {
+				block = true
+				p.indent = indent + 1
+				for j, child := range part.Children {
+					p.item(startLine(child, p.last), j == 0)
+					if err := p.expr(child); err != nil {
+						return err
+					}
+				}
+				p.indent = indent
+			}
+		}
+	}
+	p.startPart(form.Span.EndLine, indent, block)
+	p.write(closer(start))
+	p.touch(form.Span)
+	return nil
+}
+
+// startPart separates a keyword that is on a line of the source from what
+// was printed before it, with a space if the keyword followed it on the same
+// line and otherwise by starting a new line. The comments before it stay in
+// the block before it, if there is one.
+func (p *printer) startPart(line, indent int, block bool) {
+	if !block && line <= p.last {
+		p.write(" ")
+		return
+	}
+	if block {
+		p.indent = indent + 1
+	}
+	p.leading(line, true, false)
+	p.indent = indent
+}
+
+// isOneLine returns true if a part and its expressions are on a single line
+// of the source, along with the keyword that follows them.
+func (p *printer) isOneLine(part *common.Node, next int) bool {
+	line := part.Span.StartLine
+	if next != line {
+		return false
+	}
+	for _, child := range part.Children {
+		first, last := lines(child)
+		if first != line || last != line {
+			return false
+		}
+	}
+	return true
+}
+
+// keyword returns the token of the keyword that starts a part.
+func (p *printer) keyword(part *common.Node) (*common.Token, error) {
+	token, ok := p.tokens[position{part.Span.StartLine, part.Span.StartColumn}]
+	if !ok || part.Name != common.NamePart {
+		return nil, fmt.Errorf("cannot find the keyword %q at line %d, column %d", part.Options[common.OptionKeyword], part.Span.StartLine, part.Span.StartColumn)
+	}
+	return token, nil
+}
+
+// closer returns the canonical closer of a form, which is the one that names
+// the start of the form if there is one, such as endif for if.
+func closer(start *common.Token) string {
+	named := "end" + start.Text
+	if slices.Contains(start.ClosedBy, named) {
+		return named
+	}
+	for _, text := range start.ClosedBy {
+		if text != "end" {
+			return text
+		}
+	}
+	return "end"
+}
+
+// edge returns the text of the first or last token of an expression, which
+// decides whether an operator next to it needs a space.
+func (p *printer) edge(node *common.Node, first bool) string {
+	switch node.Name {
+	case common.NameIdentifier:
+		return node.Options[common.OptionName]
+	case common.NameOperator:
+		syntax := node.Options[common.OptionSyntax]
+		if len(node.Children) == 0 || (first && syntax == common.ValuePrefix) || (!first && syntax == common.ValuePostfix) {
+			return node.Options[common.OptionName]
+		}
+		if first {
+			return p.edge(node.Children[0], first)
+		}
+		return p.edge(node.Children[len(node.Children)-1], first)
+	case common.NameApply:
+		if first && len(node.Children) > 0 {
+			return p.edge(node.Children[0], first)
+		}
+		return brackets[node.Options[common.OptionKind]][1]
+	case common.NameDelimited, common.NameArguments:
+		pair := brackets[node.Options[common.OptionKind]]
+		if first {
+			return pair[0]
+		}
+		return pair[1]
+	case common.NameForm:
+		// Forms start and end with keywords.
+		return "a"
+	default:
+		if isSane(node.Span) {
+			return p.text(node.Span)
+		}
+		return ""
+	}
+}
+
+// needsSpace returns true if two tokens would run together into one if they
+// were written without a space between them.
+func needsSpace(left, right string) bool {
+	if left == "" || right == "" {
+		return false
+	}
+	a, _ := utf8.DecodeLastRuneInString(left)
+	b, _ := utf8.DecodeRuneInString(right)
+	return (isWord(a) && isWord(b)) || (isSymbol(a) && isSymbol(b))
+}
+
+func isWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isSymbol(r rune) bool {
+	return !isWord(r) && !unicode.IsSpace(r) && !strings.ContainsRune("()[]{},;\"'`", r)
+}
+
+// isSane returns true if a span covers part of the source. The parser leaves
+// the spans of some nodes, such as prefix operators, empty.
+func isSane(span common.Span) bool {
+	return span.StartLine > 0 && span.EndLine >= span.StartLine
+}
+
+// lines returns the first and last lines of the source that an expression
+// covers, or zeros if it is not known. The spans of nodes are incomplete in
+// places, so those of their children are taken into account too.
+func lines(node *common.Node) (int, int) {
+	first, last := 0, 0
+	if isSane(node.Span) {
+		first, last = node.Span.StartLine, node.Span.EndLine
+	}
+	switch node.Name {
+	case common.NameNumber, common.NameString, common.NameJoin, common.NameJoinLines:
+		return first, last
+	}
+	for _, child := range node.Children {
+		f, l := lines(child)
+		if f > 0 && (first == 0 || f < first) {
+			first = f
+		}
+		last = max(last, l)
+	}
+	return first, last
+}
+
+// startLine returns the line of the source that an expression starts on, or
+// a fallback if that is not known.
+func startLine(node *common.Node, fallback int) int {
+	if first, _ := lines(node); first > 0 {
+		return first
+	}
+	return fallback
+}
@@ -0,0 +1,167 @@
+package formatter
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
+)
+
+// parse tokenizes and parses a text, returning false if it has errors.
+func parse(t *testing.T, text string) (*common.Node, []*common.Token, bool) {
+	t.Helper()
+	p, err := pipeline.New(pipeline.Options{})
+	if err != nil {
+		t.Fatalf("pipeline.New failed: %v", err)
+	}
+	tokens, diagnostics := p.Tokenize("a.nutmeg", text)
+	if pipeline.HasErrors(diagnostics) {
+		return nil, nil, false
+	}
+	tree, diagnostics := p.Parse(&pipeline.SourceFile{SrcPath: "a.nutmeg"}, tokens)
+	return tree, tokens, !pipeline.HasErrors(diagnostics)
+}
+
+// format formats a text that is expected to parse.
+func format(t *testing.T, text string) (*common.Node, string) {
+	t.Helper()
+	tree, tokens, ok := parse(t, text)
+	if !ok {
+		t.Fatalf("Expected %q to parse", text)
+	}
+	formatted, err := Format(tree, tokens, text)
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	return tree, formatted
+}
+
+// sameTree compares two trees, ignoring their spans.
+func sameTree(a, b *common.Node) bool {
+	if a.Name != b.Name || !maps.Equal(a.Options, b.Options) || len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i := range a.Children {
+		if !sameTree(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSnippetsKeepTheirTrees(t *testing.T) {
+	files, err := filepath.Glob("../../snippets/*.nutmeg")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Expected to find the snippets, got %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("ReadFile failed: %v", err)
+			}
+			// Some snippets are examples of errors.
+			if _, _, ok := parse(t, string(data)); !ok {
+				t.Skip("does not parse")
+			}
+			tree, formatted := format(t, string(data))
+			reformatted, _, _ := parse(t, formatted)
+			if reformatted == nil || !sameTree(tree, reformatted) {
+				t.Fatalf("Expected formatting to keep the parse tree, got:\n%s", formatted)
+			}
+			if _, again := format(t, formatted); again != formatted {
+				t.Errorf("Expected formatting to be idempotent, got:\n%s\nthen:\n%s", formatted, again)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"canonical closers and keywords",
+			"def f(x):\n  if x: 1 else 2 end\nend\n",
+			"def f(x) =>>\n    if x then 1 else 2 endif\nenddef\n",
+		},
+		{
+			"spacing",
+			"x:=a.b( 1,2 )+[ 3 ] * -y\n",
+			"x := a.b(1, 2) + [3] * -y\n",
+		},
+		{
+			"prefix operators that would run together",
+			"x := - -1\n",
+			"x := - -1\n",
+		},
+		{
+			"single-line forms",
+			"f := fn x =>> a; b endfn\n",
+			"f := fn x =>> a; b endfn\n",
+		},
+		{
+			"blocks",
+			"if a then b\nelse c; d end\n",
+			"if a then\n    b\nelse\n    c\n    d\nendif\n",
+		},
+		{
+			"blank lines",
+			"x := 1\n\n\n\ny := 2\nz := 3\n",
+			"x := 1\n\ny := 2\nz := 3\n",
+		},
+		{
+			"comments",
+			"### about f\n\ndef f() =>> ### f\n\n    ### body\n    1 ### one\n    ### last\nend\n### tail",
+			"### about f\n\ndef f() =>> ### f\n    ### body\n    1 ### one\n    ### last\nenddef\n### tail\n",
+		},
+		{
+			"comments inside an expression",
+			"x := [\n    1, ### one\n    2  ### two\n]\n",
+			"x := [1, 2] ### one\n### two\n",
+		},
+		{
+			"literals are copied",
+			"x := 0xFF + 'it\\'s'\n",
+			"x := 0xFF + 'it\\'s'\n",
+		},
+		{
+			"empty",
+			"",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, formatted := format(t, tt.input)
+			if formatted != tt.expected {
+				t.Fatalf("Expected:\n%s\ngot:\n%s", tt.expected, formatted)
+			}
+			reformatted, _, _ := parse(t, formatted)
+			if reformatted == nil || !sameTree(tree, reformatted) {
+				t.Errorf("Expected formatting to keep the parse tree")
+			}
+		})
+	}
+}
+
+func TestFormatRefusesParseErrors(t *testing.T) {
+	text := "def f()\n    1\nenddef\n"
+	p, err := pipeline.New(pipeline.Options{})
+	if err != nil {
+		t.Fatalf("pipeline.New failed: %v", err)
+	}
+	tokens, _ := p.Tokenize("a.nutmeg", text)
+	tree, diagnostics := p.Parse(&pipeline.SourceFile{SrcPath: "a.nutmeg"}, tokens)
+	if !pipeline.HasErrors(diagnostics) {
+		t.Fatalf("Expected a parse error")
+	}
+	if _, err := Format(tree, tokens, text); err == nil {
+		t.Errorf("Expected Format to refuse a tree with an error node")
+	}
+}
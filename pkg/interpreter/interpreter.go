@@ -0,0 +1,534 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+)
+
+// MaxCallDepth limits the depth of nested calls so that runaway recursion is
+// reported as a Nutmeg error rather than exhausting the Go stack.
+const MaxCallDepth = 100000
+
+type globalState int
+
+const (
+	uninitialised globalState = iota
+	inProgress
+	initialised
+)
+
+// Global is the run-time state of a single binding from the bundle. A lazy
+// global is initialised by running its Function the first time it is needed;
+// a non-lazy global simply denotes its Function.
+type Global struct {
+	Name     string
+	Lazy     bool
+	Function *Function
+	state    globalState
+	value    Value
+}
+
+// Interpreter is a reference interpreter for the FunctionObjects stored in a
+// bundle. It is deliberately simple, favouring clarity over speed, so that it
+// can be used to check the output of codegen end to end.
+type Interpreter struct {
+	globals map[string]*Global
+	sysfns  map[string]SysFn
+	stack   []Value
+	output  io.Writer
+	depth   int
+}
+
+// NewInterpreter prepares the given bindings for execution. Output from
+// println and friends is written to output, which defaults to stdout.
+func NewInterpreter(bindings []bundler.Binding, output io.Writer) (*Interpreter, error) {
+	if output == nil {
+		output = os.Stdout
+	}
+	it := &Interpreter{
+		globals: make(map[string]*Global),
+		sysfns:  defaultSysFns(),
+		stack:   make([]Value, 0, 256),
+		output:  output,
+	}
+	for _, binding := range bindings {
+		funcObj, err := bundler.DecodeFunctionObject(binding.Value)
+		if err != nil {
+			return nil, fmt.Errorf("binding %s: %w", binding.IdName, err)
+		}
+		fn, err := NewFunction(binding.IdName, funcObj)
+		if err != nil {
+			return nil, fmt.Errorf("binding %s: %w", binding.IdName, err)
+		}
+		it.globals[binding.IdName] = &Global{
+			Name:     binding.IdName,
+			Lazy:     binding.Lazy,
+			Function: fn,
+		}
+	}
+	return it, nil
+}
+
+// Unsupported returns the uses of system functions that this interpreter
+// does not provide, so that a bundle can be rejected before it runs rather
+// than failing part way through.
+func (it *Interpreter) Unsupported(uses []bundler.SyscallUse) []bundler.SyscallUse {
+	unsupported := make([]bundler.SyscallUse, 0)
+	for _, use := range uses {
+		if _, ok := it.sysfns[use.SysFn]; !ok {
+			unsupported = append(unsupported, use)
+		}
+	}
+	return unsupported
+}
+
+// NewFunction prepares a FunctionObject for execution. Symbolic function
+// objects are assembled first. It then checks that every instruction has the
+// operands it needs, so that the main loop can rely on them being present.
+func NewFunction(name string, funcObj *bundler.FunctionObject) (*Function, error) {
+	funcObj, err := bundler.Assemble(funcObj)
+	if err != nil {
+		return nil, err
+	}
+	for i, inst := range funcObj.Instructions {
+		if err := checkOperands(inst, len(funcObj.Instructions), funcObj.NLocals); err != nil {
+			return nil, fmt.Errorf("instruction %d (%s): %w", i, inst.Type, err)
+		}
+	}
+	return &Function{Name: name, Object: funcObj}, nil
+}
+
+func checkOperands(inst bundler.Instruction, ninstructions int, nlocals int) error {
+	needIndex := func() error {
+		if inst.Index == nil {
+			return fmt.Errorf("missing index")
+		}
+		if *inst.Index < 0 || *inst.Index >= nlocals {
+			return fmt.Errorf("index %d out of range for %d locals", *inst.Index, nlocals)
+		}
+		return nil
+	}
+	needName := func() error {
+		if inst.Name == nil {
+			return fmt.Errorf("missing name")
+		}
+		return nil
+	}
+	needCount := func() error {
+		if inst.IntValue == nil {
+			return fmt.Errorf("missing ivalue")
+		}
+		if *inst.IntValue < 0 {
+			return fmt.Errorf("negative argument count %d", *inst.IntValue)
+		}
+		return nil
+	}
+	needTarget := func(target *int) error {
+		if target == nil {
+			return fmt.Errorf("missing target")
+		}
+		// A jump just past the last instruction returns.
+		if *target < 0 || *target > ninstructions {
+			return fmt.Errorf("target %d out of range for %d instructions", *target, ninstructions)
+		}
+		return nil
+	}
+	switch inst.Type {
+	case "push.int":
+		if inst.IntValue == nil {
+			return fmt.Errorf("missing ivalue")
+		}
+	case "push.bool":
+		if inst.StrValue == nil || (*inst.StrValue != "true" && *inst.StrValue != "false") {
+			return fmt.Errorf("invalid boolean value")
+		}
+	case "push.string":
+		if inst.StrValue == nil {
+			return fmt.Errorf("missing value")
+		}
+	case "push.bigint", "push.rational", "push.float":
+		if inst.StrValue == nil {
+			return fmt.Errorf("missing value")
+		}
+		if _, err := parseNumber(inst); err != nil {
+			return err
+		}
+	case "push.local", "pop.local", "stack.length", "check.bool", "check.one", "call.counted":
+		return needIndex()
+	case "push.global", "in.progress":
+		return needName()
+	case "syscall.counted", "call.global.counted", "done":
+		if err := needName(); err != nil {
+			return err
+		}
+		return needIndex()
+	case "syscall.fixed", "call.global.fixed":
+		if err := needName(); err != nil {
+			return err
+		}
+		return needCount()
+	case "goto", "if.not", "if.so":
+		return needTarget(inst.Target)
+	case "if.then.else":
+		if err := needTarget(inst.Target); err != nil {
+			return err
+		}
+		return needTarget(inst.ElseTarget)
+	case "return", "erase", "if.so.return", "if.not.return":
+	default:
+		return fmt.Errorf("unknown instruction")
+	}
+	return nil
+}
+
+// Run calls the named global with no arguments and discards any results.
+func (it *Interpreter) Run(name string) error {
+	fn, err := it.valueOfGlobal(name)
+	if err != nil {
+		return err
+	}
+	base := len(it.stack)
+	if err := it.call(fn, 0); err != nil {
+		return err
+	}
+	it.stack = it.stack[:base]
+	return nil
+}
+
+// Stack returns the current contents of the value stack. It is intended for
+// tests and debugging.
+func (it *Interpreter) Stack() []Value {
+	return it.stack
+}
+
+func (it *Interpreter) push(v Value) {
+	it.stack = append(it.stack, v)
+}
+
+func (it *Interpreter) pop() (Value, error) {
+	n := len(it.stack)
+	if n == 0 {
+		return nil, fmt.Errorf("value stack underflow")
+	}
+	v := it.stack[n-1]
+	it.stack = it.stack[:n-1]
+	return v, nil
+}
+
+// popN removes the top n values from the stack and returns them in the order
+// in which they were pushed.
+func (it *Interpreter) popN(n int) ([]Value, error) {
+	if n < 0 || n > len(it.stack) {
+		return nil, fmt.Errorf("value stack underflow")
+	}
+	base := len(it.stack) - n
+	values := make([]Value, n)
+	copy(values, it.stack[base:])
+	it.stack = it.stack[:base]
+	return values, nil
+}
+
+func (it *Interpreter) popBool() (bool, error) {
+	v, err := it.pop()
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+// valueOfGlobal returns the value of a global, running its initialiser first
+// if it is lazy and has not been initialised yet.
+func (it *Interpreter) valueOfGlobal(name string) (Value, error) {
+	global, ok := it.globals[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined global variable: %s", name)
+	}
+	if !global.Lazy {
+		return global.Function, nil
+	}
+	switch global.state {
+	case initialised:
+		return global.value, nil
+	case inProgress:
+		return nil, fmt.Errorf("global variable %s is needed during its own initialisation", name)
+	}
+	base := len(it.stack)
+	if err := it.call(global.Function, 0); err != nil {
+		return nil, err
+	}
+	if global.state != initialised {
+		return nil, fmt.Errorf("initialiser for %s did not complete", name)
+	}
+	it.stack = it.stack[:base]
+	return global.value, nil
+}
+
+func isCallable(v Value) bool {
+	switch v.(type) {
+	case *Function, *PartApply:
+		return true
+	}
+	return false
+}
+
+// call invokes a function value whose nargs arguments are on top of the stack.
+func (it *Interpreter) call(fn Value, nargs int) error {
+	switch f := fn.(type) {
+	case *Function:
+		return it.run(f, nargs)
+	case *PartApply:
+		for _, arg := range f.Args {
+			it.push(arg)
+		}
+		return it.call(f.Fn, nargs+len(f.Args))
+	default:
+		return fmt.Errorf("cannot call a non-function value (%s)", typeName(fn))
+	}
+}
+
+// run executes a prepared function whose nargs arguments are on top of the
+// stack. Arguments are popped into the first nparams local slots, the last
+// argument going into slot 0, which matches the offsets allocated by codegen.
+func (it *Interpreter) run(fn *Function, nargs int) error {
+	obj := fn.Object
+	if nargs != obj.NParams {
+		return fmt.Errorf("%s expects %d arguments but was given %d", fn.Name, obj.NParams, nargs)
+	}
+	if it.depth >= MaxCallDepth {
+		return fmt.Errorf("call depth exceeded %d in %s", MaxCallDepth, fn.Name)
+	}
+	it.depth++
+	defer func() { it.depth-- }()
+
+	locals := make([]Value, max(obj.NLocals, nargs))
+	for i := 0; i < nargs; i++ {
+		v, err := it.pop()
+		if err != nil {
+			return err
+		}
+		locals[i] = v
+	}
+
+	counted := func(offset int) (int, error) {
+		start, ok := locals[offset].(int)
+		if !ok {
+			return 0, fmt.Errorf("local %d does not hold a stack length", offset)
+		}
+		n := len(it.stack) - start
+		if n < 0 {
+			return 0, fmt.Errorf("value stack shrank below a recorded stack length")
+		}
+		return n, nil
+	}
+
+	instructions := obj.Instructions
+	for pc := 0; pc < len(instructions); {
+		inst := instructions[pc]
+		pc++
+		err := func() error {
+			switch inst.Type {
+			case "push.int":
+				it.push(*inst.IntValue)
+			case "push.bool":
+				it.push(*inst.StrValue == "true")
+			case "push.string":
+				it.push(*inst.StrValue)
+			case "push.bigint", "push.rational", "push.float":
+				v, err := parseNumber(inst)
+				if err != nil {
+					return err
+				}
+				it.push(v)
+			case "push.local":
+				it.push(locals[*inst.Index])
+			case "pop.local":
+				v, err := it.pop()
+				if err != nil {
+					return err
+				}
+				locals[*inst.Index] = v
+			case "push.global":
+				v, err := it.valueOfGlobal(*inst.Name)
+				if err != nil {
+					return err
+				}
+				it.push(v)
+			case "stack.length":
+				locals[*inst.Index] = len(it.stack)
+			case "erase":
+				_, err := it.pop()
+				return err
+			case "syscall.counted":
+				n, err := counted(*inst.Index)
+				if err != nil {
+					return err
+				}
+				return it.sysCall(*inst.Name, n)
+			case "call.global.counted":
+				n, err := counted(*inst.Index)
+				if err != nil {
+					return err
+				}
+				callee, err := it.valueOfGlobal(*inst.Name)
+				if err != nil {
+					return err
+				}
+				return it.call(callee, n)
+			case "syscall.fixed":
+				return it.sysCall(*inst.Name, *inst.IntValue)
+			case "call.global.fixed":
+				callee, err := it.valueOfGlobal(*inst.Name)
+				if err != nil {
+					return err
+				}
+				return it.call(callee, *inst.IntValue)
+			case "call.counted":
+				n, err := counted(*inst.Index)
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					return fmt.Errorf("call.counted found no function to call")
+				}
+				callee, err := it.pop()
+				if err != nil {
+					return err
+				}
+				return it.call(callee, n-1)
+			case "check.bool":
+				n, err := counted(*inst.Index)
+				if err != nil {
+					return err
+				}
+				if n != 1 {
+					return fmt.Errorf("condition produced %d values, expected exactly 1", n)
+				}
+				if _, ok := it.stack[len(it.stack)-1].(bool); !ok {
+					return fmt.Errorf("condition is not a boolean, got %s", typeName(it.stack[len(it.stack)-1]))
+				}
+			case "check.one":
+				n, err := counted(*inst.Index)
+				if err != nil {
+					return err
+				}
+				if n != 1 {
+					return fmt.Errorf("expression produced %d values, expected exactly 1", n)
+				}
+			case "goto":
+				pc = *inst.Target
+			case "if.not", "if.so":
+				b, err := it.popBool()
+				if err != nil {
+					return err
+				}
+				if b == (inst.Type == "if.so") {
+					pc = *inst.Target
+				}
+			case "if.then.else":
+				b, err := it.popBool()
+				if err != nil {
+					return err
+				}
+				if b {
+					pc = *inst.Target
+				} else {
+					pc = *inst.ElseTarget
+				}
+			case "if.so.return", "if.not.return":
+				b, err := it.popBool()
+				if err != nil {
+					return err
+				}
+				if b == (inst.Type == "if.so.return") {
+					pc = len(instructions)
+				}
+			case "return":
+				pc = len(instructions)
+			case "in.progress":
+				return it.inProgress(*inst.Name)
+			case "done":
+				n, err := counted(*inst.Index)
+				if err != nil {
+					return err
+				}
+				return it.done(*inst.Name, n)
+			default:
+				return fmt.Errorf("unknown instruction")
+			}
+			return nil
+		}()
+		if err != nil {
+			return fmt.Errorf("%s, instruction %d (%s)%s: %w", fn.Name, pc-1, inst.Type, location(fn.Object, pc-1), err)
+		}
+	}
+	return nil
+}
+
+// location describes where in the source the instruction at index came from,
+// for error messages, or is empty if the function object does not say.
+func location(funcObj *bundler.FunctionObject, index int) string {
+	span, ok := funcObj.SpanAt(index)
+	if !ok {
+		return ""
+	}
+	if funcObj.File == "" {
+		return fmt.Sprintf(" at line %d, column %d", span.StartLine, span.StartColumn)
+	}
+	return fmt.Sprintf(" at %s, line %d, column %d", funcObj.File, span.StartLine, span.StartColumn)
+}
+
+func (it *Interpreter) sysCall(name string, nargs int) error {
+	sysfn, ok := it.sysfns[name]
+	if !ok {
+		return fmt.Errorf("unknown system function: %s", name)
+	}
+	args, err := it.popN(nargs)
+	if err != nil {
+		return err
+	}
+	results, err := sysfn(it, args)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		it.push(result)
+	}
+	return nil
+}
+
+// inProgress marks a lazy global as being initialised. Reaching this for a
+// global that is already in progress means it depends on itself.
+func (it *Interpreter) inProgress(name string) error {
+	global, ok := it.globals[name]
+	if !ok {
+		return fmt.Errorf("undefined global variable: %s", name)
+	}
+	if global.state == inProgress {
+		return fmt.Errorf("global variable %s is needed during its own initialisation", name)
+	}
+	global.state = inProgress
+	return nil
+}
+
+// done records the single value on top of the stack as the value of a lazy
+// global. The value is left on the stack so that it is also the result of the
+// initialiser.
+func (it *Interpreter) done(name string, nvalues int) error {
+	global, ok := it.globals[name]
+	if !ok {
+		return fmt.Errorf("undefined global variable: %s", name)
+	}
+	if nvalues != 1 {
+		return fmt.Errorf("initialiser for %s produced %d values, expected exactly 1", name, nvalues)
+	}
+	global.value = it.stack[len(it.stack)-1]
+	global.state = initialised
+	return nil
+}
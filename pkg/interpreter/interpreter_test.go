@@ -0,0 +1,433 @@
+package interpreter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+func makeBinding(t *testing.T, name string, lazy bool, nparams, nlocals int, insts ...bundler.Instruction) bundler.Binding {
+	t.Helper()
+	value, err := json.Marshal(&bundler.FunctionObject{
+		NParams:      nparams,
+		NLocals:      nlocals,
+		Instructions: insts,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal function object: %v", err)
+	}
+	return bundler.Binding{IdName: name, Lazy: lazy, Value: string(value)}
+}
+
+func runProgram(t *testing.T, entry string, bindings ...bundler.Binding) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	it, err := NewInterpreter(bindings, &out)
+	if err != nil {
+		t.Fatalf("NewInterpreter failed: %v", err)
+	}
+	err = it.Run(entry)
+	return out.String(), err
+}
+
+func TestHelloWorld(t *testing.T) {
+	main := makeBinding(t, "main", false, 0, 1,
+		bundler.NewStackLength(0),
+		bundler.NewPushString("Hello, world!"),
+		bundler.NewSyscallCounted("println", 0),
+		bundler.NewReturn(),
+	)
+	out, err := runProgram(t, "main", main)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "Hello, world!\n" {
+		t.Errorf("Expected 'Hello, world!', got %q", out)
+	}
+}
+
+func TestRecursionAndConditionals(t *testing.T) {
+	// def triangle(n): if n <= 0 then 0 else n + triangle(n-1) endif enddef
+	triangle := makeBinding(t, "triangle", false, 1, 5,
+		bundler.NewStackLength(1),
+		bundler.NewStackLength(2),
+		bundler.NewPushLocal(0),
+		bundler.NewPushInt(0),
+		bundler.NewSyscallCounted("<=", 2),
+		bundler.NewCheckBool(1),
+		bundler.NewIfNot("L0"),
+		bundler.NewPushInt(0),
+		bundler.NewGoto("L1"),
+		bundler.NewLabel("L0"),
+		bundler.NewStackLength(1),
+		bundler.NewPushLocal(0),
+		bundler.NewStackLength(3),
+		bundler.NewStackLength(4),
+		bundler.NewPushLocal(0),
+		bundler.NewPushInt(1),
+		bundler.NewSyscallCounted("-", 4),
+		bundler.NewCallGlobalCounted("triangle", 3),
+		bundler.NewSyscallCounted("+", 1),
+		bundler.NewLabel("L1"),
+		bundler.NewReturn(),
+	)
+	main := makeBinding(t, "main", false, 0, 2,
+		bundler.NewStackLength(0),
+		bundler.NewStackLength(1),
+		bundler.NewPushInt(10),
+		bundler.NewCallGlobalCounted("triangle", 1),
+		bundler.NewSyscallCounted("println", 0),
+		bundler.NewReturn(),
+	)
+	out, err := runProgram(t, "main", triangle, main)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "55\n" {
+		t.Errorf("Expected 55, got %q", out)
+	}
+}
+
+func TestLazyGlobals(t *testing.T) {
+	x := makeBinding(t, "x", true, 0, 1,
+		bundler.NewInProgress("x"),
+		bundler.NewStackLength(0),
+		bundler.NewPushInt(99),
+		bundler.NewDone("x", 0),
+		bundler.NewReturn(),
+	)
+	main := makeBinding(t, "main", false, 0, 1,
+		bundler.NewStackLength(0),
+		bundler.NewPushGlobal("x"),
+		bundler.NewPushGlobal("x"),
+		bundler.NewSyscallCounted("println", 0),
+		bundler.NewReturn(),
+	)
+	out, err := runProgram(t, "main", x, main)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "99 99\n" {
+		t.Errorf("Expected '99 99', got %q", out)
+	}
+}
+
+func TestLazyCycleIsReported(t *testing.T) {
+	x := makeBinding(t, "x", true, 0, 1,
+		bundler.NewInProgress("x"),
+		bundler.NewStackLength(0),
+		bundler.NewPushGlobal("x"),
+		bundler.NewDone("x", 0),
+		bundler.NewReturn(),
+	)
+	_, err := runProgram(t, "x", x)
+	if err == nil || !strings.Contains(err.Error(), "its own initialisation") {
+		t.Errorf("Expected a cyclic initialisation error, got %v", err)
+	}
+}
+
+func TestPartApply(t *testing.T) {
+	// The lifted lambda fn y =>> x, where x is captured as the last parameter.
+	lambda := makeBinding(t, "tmp-1", false, 2, 2,
+		bundler.NewPushLocal(0),
+		bundler.NewReturn(),
+	)
+	main := makeBinding(t, "main", false, 0, 1,
+		bundler.NewStackLength(0),
+		bundler.NewPushString("captured"),
+		bundler.NewPushGlobal("tmp-1"),
+		bundler.NewSyscallCounted("partapply", 0),
+		bundler.NewReturn(),
+	)
+	it, err := NewInterpreter([]bundler.Binding{lambda, main}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("NewInterpreter failed: %v", err)
+	}
+	if err := it.call(it.globals["main"].Function, 0); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	closure, ok := it.stack[0].(*PartApply)
+	if !ok || len(it.stack) != 1 {
+		t.Fatalf("Expected main to return a single partapply, got %v", it.Stack())
+	}
+
+	// Now call the closure with its one remaining argument.
+	it.stack = it.stack[:0]
+	it.push(0)
+	if err := it.call(closure, 1); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(it.Stack()) != 1 || it.Stack()[0] != "captured" {
+		t.Errorf("Expected closure to return its captured value, got %v", it.Stack())
+	}
+}
+
+func TestArityMismatch(t *testing.T) {
+	f := makeBinding(t, "f", false, 1, 1, bundler.NewReturn())
+	main := makeBinding(t, "main", false, 0, 1,
+		bundler.NewStackLength(0),
+		bundler.NewCallGlobalCounted("f", 0),
+		bundler.NewReturn(),
+	)
+	_, err := runProgram(t, "main", f, main)
+	if err == nil || !strings.Contains(err.Error(), "expects 1 arguments but was given 0") {
+		t.Errorf("Expected an arity error, got %v", err)
+	}
+}
+
+func TestUndefinedLabelIsRejected(t *testing.T) {
+	bad := makeBinding(t, "bad", false, 0, 0, bundler.NewGoto("L9"))
+	_, err := NewInterpreter([]bundler.Binding{bad}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "undefined label L9") {
+		t.Errorf("Expected an undefined label error, got %v", err)
+	}
+}
+
+func TestIteratorOverRange(t *testing.T) {
+	// for i in 1 ..= 3 do println(i) endfor, using the iterator protocol.
+	main := makeBinding(t, "main", false, 0, 3,
+		bundler.NewStackLength(0),
+		bundler.NewPushInt(1),
+		bundler.NewPushInt(3),
+		bundler.NewSyscallCounted("..=", 0),
+		bundler.NewSyscallCounted("iterator", 0),
+		bundler.NewPopLocal(1),
+		bundler.NewLabel("L0"),
+		bundler.NewStackLength(0),
+		bundler.NewPushLocal(1),
+		bundler.NewSyscallCounted("iterator.hasnext", 0),
+		bundler.NewIfNot("L1"),
+		bundler.NewStackLength(0),
+		bundler.NewPushLocal(1),
+		bundler.NewSyscallCounted("iterator.next", 0),
+		bundler.NewPopLocal(2),
+		bundler.NewStackLength(0),
+		bundler.NewPushLocal(2),
+		bundler.NewSyscallCounted("println", 0),
+		bundler.NewGoto("L0"),
+		bundler.NewLabel("L1"),
+		bundler.NewReturn(),
+	)
+	out, err := runProgram(t, "main", main)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "1\n2\n3\n" {
+		t.Errorf("Expected 1 to 3 on separate lines, got %q", out)
+	}
+}
+
+func TestIteratorRejectsNonCollections(t *testing.T) {
+	main := makeBinding(t, "main", false, 0, 1,
+		bundler.NewStackLength(0),
+		bundler.NewPushBool("true"),
+		bundler.NewSyscallCounted("iterator", 0),
+		bundler.NewReturn(),
+	)
+	_, err := runProgram(t, "main", main)
+	if err == nil || !strings.Contains(err.Error(), "cannot iterate over boolean") {
+		t.Errorf("Expected an iteration error, got %v", err)
+	}
+}
+
+func TestNumericTower(t *testing.T) {
+	tests := []struct {
+		name     string
+		insts    []bundler.Instruction
+		expected string
+	}{
+		{"Exact division", []bundler.Instruction{bundler.NewPushInt(7), bundler.NewPushInt(2), bundler.NewSyscallCounted("/", 0)}, "7/2"},
+		{"Whole rationals are integers", []bundler.Instruction{bundler.NewPushRational("1/2"), bundler.NewPushRational("3/2"), bundler.NewSyscallCounted("+", 0)}, "2"},
+		{"Overflow promotes to big integers", []bundler.Instruction{bundler.NewPushInt(9223372036854775807), bundler.NewPushInt(1), bundler.NewSyscallCounted("+", 0)}, "9223372036854775808"},
+		{"Big integers shrink back", []bundler.Instruction{bundler.NewPushBigInt("9223372036854775808"), bundler.NewPushInt(1), bundler.NewSyscallCounted("-", 0)}, "9223372036854775807"},
+		{"Floats are contagious", []bundler.Instruction{bundler.NewPushFloat("0.5"), bundler.NewPushRational("1/4"), bundler.NewSyscallCounted("*", 0)}, "0.125"},
+		{"Whole floats show a point", []bundler.Instruction{bundler.NewPushFloat("1.5"), bundler.NewPushInt(2), bundler.NewSyscallCounted("*", 0)}, "3.0"},
+		{"Mixed comparison", []bundler.Instruction{bundler.NewPushRational("1/3"), bundler.NewPushFloat("0.3"), bundler.NewSyscallCounted(">", 0)}, "true"},
+		{"Numeric equality", []bundler.Instruction{bundler.NewPushInt(1), bundler.NewPushFloat("1"), bundler.NewSyscallCounted("==", 0)}, "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insts := []bundler.Instruction{bundler.NewStackLength(0)}
+			insts = append(insts, tt.insts...)
+			insts = append(insts, bundler.NewSyscallCounted("println", 0), bundler.NewReturn())
+			main := makeBinding(t, "main", false, 0, 1, insts...)
+			out, err := runProgram(t, "main", main)
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if out != tt.expected+"\n" {
+				t.Errorf("Expected %q, got %q", tt.expected, out)
+			}
+		})
+	}
+}
+
+func TestExactDivisionByZero(t *testing.T) {
+	main := makeBinding(t, "main", false, 0, 1,
+		bundler.NewStackLength(0),
+		bundler.NewPushInt(1),
+		bundler.NewPushInt(0),
+		bundler.NewSyscallCounted("/", 0),
+		bundler.NewReturn(),
+	)
+	_, err := runProgram(t, "main", main)
+	if err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("Expected a division by zero error, got %v", err)
+	}
+}
+
+func TestShowAndConcat(t *testing.T) {
+	main := makeBinding(t, "main", false, 0, 3,
+		bundler.NewStackLength(0),
+		bundler.NewStackLength(1),
+		bundler.NewPushString("x = "),
+		bundler.NewStackLength(2),
+		bundler.NewPushRational("3/4"),
+		bundler.NewSyscallCounted("show", 2),
+		bundler.NewPushString("!"),
+		bundler.NewSyscallCounted("concat", 1),
+		bundler.NewSyscallCounted("println", 0),
+		bundler.NewReturn(),
+	)
+	out, err := runProgram(t, "main", main)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "x = 3/4!\n" {
+		t.Errorf("Expected %q, got %q", "x = 3/4!\n", out)
+	}
+}
+
+func TestCallCounted(t *testing.T) {
+	// The lifted lambda fn y =>> x - y, where x is captured as the last parameter.
+	lambda := makeBinding(t, "tmp-1", false, 2, 3,
+		bundler.NewStackLength(2),
+		bundler.NewPushLocal(0),
+		bundler.NewPushLocal(1),
+		bundler.NewSyscallCounted("-", 2),
+		bundler.NewReturn(),
+	)
+	main := makeBinding(t, "main", false, 0, 3,
+		bundler.NewStackLength(0),
+		bundler.NewStackLength(1),
+		bundler.NewPushInt(3),
+		bundler.NewStackLength(2),
+		bundler.NewPushInt(10),
+		bundler.NewPushGlobal("tmp-1"),
+		bundler.NewSyscallCounted("partapply", 2),
+		bundler.NewCallCounted(1),
+		bundler.NewSyscallCounted("println", 0),
+		bundler.NewReturn(),
+	)
+	out, err := runProgram(t, "main", lambda, main)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "7\n" {
+		t.Errorf("Expected %q, got %q", "7\n", out)
+	}
+}
+
+func TestFixedCalls(t *testing.T) {
+	// The global sub(x, y) = x - y.
+	sub := makeBinding(t, "sub", false, 2, 2,
+		bundler.NewPushLocal(1),
+		bundler.NewPushLocal(0),
+		bundler.NewSyscallFixed("-", 2),
+		bundler.NewReturn(),
+	)
+	main := makeBinding(t, "main", false, 0, 0,
+		bundler.NewPushInt(10),
+		bundler.NewPushInt(3),
+		bundler.NewCallGlobalFixed("sub", 2),
+		bundler.NewSyscallFixed("println", 1),
+		bundler.NewReturn(),
+	)
+	out, err := runProgram(t, "main", sub, main)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if out != "7\n" {
+		t.Errorf("Expected %q, got %q", "7\n", out)
+	}
+}
+
+func TestCallCountedRejectsNonFunctions(t *testing.T) {
+	main := makeBinding(t, "main", false, 0, 1,
+		bundler.NewStackLength(0),
+		bundler.NewPushInt(1),
+		bundler.NewCallCounted(0),
+		bundler.NewReturn(),
+	)
+	_, err := runProgram(t, "main", main)
+	if err == nil || !strings.Contains(err.Error(), "cannot call a non-function value") {
+		t.Errorf("Expected a non-function error, got %v", err)
+	}
+}
+
+func TestCheckOne(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []bundler.Instruction
+		err    string
+	}{
+		{"one", []bundler.Instruction{bundler.NewPushInt(1)}, ""},
+		{"too many", []bundler.Instruction{bundler.NewPushInt(1), bundler.NewPushInt(2)}, "expression produced 2 values, expected exactly 1"},
+		{"too few", []bundler.Instruction{}, "expression produced 0 values, expected exactly 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insts := []bundler.Instruction{bundler.NewStackLength(0)}
+			insts = append(insts, tt.values...)
+			insts = append(insts, bundler.NewCheckOne(0), bundler.NewPopLocal(1), bundler.NewReturn())
+			_, err := runProgram(t, "main", makeBinding(t, "main", false, 0, 2, insts...))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Run failed: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Expected the error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestErrorsReportSourceLocation(t *testing.T) {
+	value, err := json.Marshal(&bundler.FunctionObject{
+		NLocals: 1,
+		File:    "main.nutmeg",
+		Lines: []bundler.LineEntry{
+			{Index: 0, Span: common.Span{StartLine: 1, StartColumn: 1, EndLine: 1, EndColumn: 9}},
+			{Index: 3, Span: common.Span{StartLine: 2, StartColumn: 5, EndLine: 2, EndColumn: 10}},
+		},
+		Instructions: []bundler.Instruction{
+			bundler.NewStackLength(0),
+			bundler.NewPushInt(1),
+			bundler.NewPushInt(0),
+			bundler.NewSyscallCounted("/", 0),
+			bundler.NewReturn(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal function object: %v", err)
+	}
+	_, err = runProgram(t, "main", bundler.Binding{IdName: "main", Value: string(value)})
+	if err == nil || !strings.Contains(err.Error(), "instruction 3 (syscall.counted) at main.nutmeg, line 2, column 5: ") {
+		t.Errorf("Expected the error to give its source location, got %v", err)
+	}
+}
+
+func TestUnsupportedSysFns(t *testing.T) {
+	it, err := NewInterpreter(nil, nil)
+	if err != nil {
+		t.Fatalf("NewInterpreter failed: %v", err)
+	}
+	uses := []bundler.SyscallUse{{IdName: "main", SysFn: "println"}, {IdName: "main", SysFn: "launch.rockets"}}
+	unsupported := it.Unsupported(uses)
+	if len(unsupported) != 1 || unsupported[0].SysFn != "launch.rockets" {
+		t.Errorf("Expected only launch.rockets to be unsupported, got %v", unsupported)
+	}
+}
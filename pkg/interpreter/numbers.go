@@ -0,0 +1,237 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+)
+
+// Numbers form a tower. Integers are represented by int when they fit and
+// by *big.Int when they do not, exact fractions by *big.Rat and floats by
+// float64. Exact results are always normalised, so that an integer is never
+// held as a *big.Int or *big.Rat if a smaller representation will do.
+
+// smallInt is the largest magnitude for which +, - and * on two ints cannot
+// overflow.
+const smallInt = 1 << 31
+
+// parseNumber reads the operand of push.bigint, push.rational or push.float.
+func parseNumber(inst bundler.Instruction) (Value, error) {
+	switch inst.Type {
+	case "push.bigint":
+		return parseBigInt(*inst.StrValue)
+	case "push.rational":
+		return parseRational(*inst.StrValue)
+	default:
+		return parseFloat(*inst.StrValue)
+	}
+}
+
+// parseBigInt reads the operand of a push.bigint instruction.
+func parseBigInt(text string) (Value, error) {
+	n, ok := new(big.Int).SetString(text, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid big integer: %s", text)
+	}
+	return normaliseInt(n), nil
+}
+
+// parseRational reads the operand of a push.rational instruction.
+func parseRational(text string) (Value, error) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("invalid rational: %s", text)
+	}
+	return normaliseRat(r), nil
+}
+
+// parseFloat reads the operand of a push.float instruction.
+func parseFloat(text string) (Value, error) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid float: %s", text)
+	}
+	return f, nil
+}
+
+func normaliseInt(n *big.Int) Value {
+	if n.IsInt64() && n.Int64() >= math.MinInt && n.Int64() <= math.MaxInt {
+		return int(n.Int64())
+	}
+	return n
+}
+
+func normaliseRat(r *big.Rat) Value {
+	if r.IsInt() {
+		return normaliseInt(new(big.Int).Set(r.Num()))
+	}
+	return r
+}
+
+func isNumber(v Value) bool {
+	switch v.(type) {
+	case int, *big.Int, *big.Rat, float64:
+		return true
+	}
+	return false
+}
+
+// toRat converts an exact number to a *big.Rat.
+func toRat(v Value) (*big.Rat, bool) {
+	switch x := v.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(x)), true
+	case *big.Int:
+		return new(big.Rat).SetInt(x), true
+	case *big.Rat:
+		return x, true
+	}
+	return nil, false
+}
+
+// toFloat converts any number to a float64.
+func toFloat(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	}
+	if r, ok := toRat(v); ok {
+		f, _ := r.Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+// arithmetic describes a binary operator on the tower. The ints function is
+// only used when neither argument can overflow it; it returns false if it
+// cannot give an exact int result, e.g. for division.
+type arithmetic struct {
+	name   string
+	ints   func(x, y int) (int, bool)
+	exact  func(x, y *big.Rat) (*big.Rat, error)
+	floats func(x, y float64) float64
+}
+
+// apply computes the operator on two numbers. If either is a float then so is
+// the result, otherwise the result is exact.
+func (a arithmetic) apply(x, y Value) (Value, error) {
+	if xi, ok := x.(int); ok {
+		if yi, ok := y.(int); ok && -smallInt < xi && xi < smallInt && -smallInt < yi && yi < smallInt {
+			if n, ok := a.ints(xi, yi); ok {
+				return n, nil
+			}
+		}
+	}
+	_, xfloat := x.(float64)
+	_, yfloat := y.(float64)
+	if xfloat || yfloat {
+		xf, xok := toFloat(x)
+		yf, yok := toFloat(y)
+		if xok && yok {
+			return a.floats(xf, yf), nil
+		}
+	} else {
+		xr, xok := toRat(x)
+		yr, yok := toRat(y)
+		if xok && yok {
+			r, err := a.exact(xr, yr)
+			if err != nil {
+				return nil, err
+			}
+			return normaliseRat(r), nil
+		}
+	}
+	return nil, fmt.Errorf("%s cannot be applied to %s and %s", a.name, typeName(x), typeName(y))
+}
+
+var (
+	addition = arithmetic{
+		name:   "+",
+		ints:   func(x, y int) (int, bool) { return x + y, true },
+		exact:  func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Add(x, y), nil },
+		floats: func(x, y float64) float64 { return x + y },
+	}
+	subtraction = arithmetic{
+		name:   "-",
+		ints:   func(x, y int) (int, bool) { return x - y, true },
+		exact:  func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Sub(x, y), nil },
+		floats: func(x, y float64) float64 { return x - y },
+	}
+	multiplication = arithmetic{
+		name:   "*",
+		ints:   func(x, y int) (int, bool) { return x * y, true },
+		exact:  func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Mul(x, y), nil },
+		floats: func(x, y float64) float64 { return x * y },
+	}
+	division = arithmetic{
+		name: "/",
+		ints: func(x, y int) (int, bool) {
+			if y == 0 || x%y != 0 {
+				return 0, false
+			}
+			return x / y, true
+		},
+		exact: func(x, y *big.Rat) (*big.Rat, error) {
+			if y.Sign() == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return new(big.Rat).Quo(x, y), nil
+		},
+		floats: func(x, y float64) float64 { return x / y },
+	}
+)
+
+// compareNumbers returns -1, 0 or +1 as x is less than, equal to or greater
+// than y. Exact numbers are compared exactly. The second result is false if
+// the numbers are unordered, which happens when either is a NaN.
+func compareNumbers(x, y Value) (int, bool) {
+	if xi, ok := x.(int); ok {
+		if yi, ok := y.(int); ok {
+			return cmpInt(xi, yi), true
+		}
+	}
+	_, xfloat := x.(float64)
+	_, yfloat := y.(float64)
+	if xfloat || yfloat {
+		xf, _ := toFloat(x)
+		yf, _ := toFloat(y)
+		if math.IsNaN(xf) || math.IsNaN(yf) {
+			return 0, false
+		}
+		switch {
+		case xf < yf:
+			return -1, true
+		case xf > yf:
+			return 1, true
+		}
+		return 0, true
+	}
+	xr, _ := toRat(x)
+	yr, _ := toRat(y)
+	return xr.Cmp(yr), true
+}
+
+func cmpInt(x, y int) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// showFloat prints a float so that it can be told apart from an integer.
+func showFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
@@ -0,0 +1,241 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SysFn is the implementation of a system function. It receives the arguments
+// in the order they were pushed and returns its results in the order they
+// should be pushed.
+type SysFn func(it *Interpreter, args []Value) ([]Value, error)
+
+// defaultSysFns returns the system functions that codegen currently emits.
+func defaultSysFns() map[string]SysFn {
+	return map[string]SysFn{
+		"println":          sysPrintln,
+		"partapply":        sysPartApply,
+		"+":                sysAdd,
+		"-":                numeric(subtraction),
+		"*":                numeric(multiplication),
+		"/":                numeric(division),
+		"<":                compare("<", func(c int) bool { return c < 0 }),
+		"<=":               compare("<=", func(c int) bool { return c <= 0 }),
+		">":                compare(">", func(c int) bool { return c > 0 }),
+		">=":               compare(">=", func(c int) bool { return c >= 0 }),
+		"==":               sysEquals,
+		"..<":              makeRange("..<", 0),
+		"..=":              makeRange("..=", 1),
+		"iterator":         sysIterator,
+		"iterator.hasnext": sysHasNext,
+		"iterator.next":    sysNext,
+		"show":             sysShow,
+		"concat":           sysConcat,
+	}
+}
+
+func sysPrintln(it *Interpreter, args []Value) ([]Value, error) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = Show(arg)
+	}
+	_, err := fmt.Fprintln(it.output, strings.Join(parts, " "))
+	return nil, err
+}
+
+// sysPartApply expects the captured arguments followed by the function being
+// partially applied, which is how codegen plants them.
+func sysPartApply(it *Interpreter, args []Value) ([]Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("partapply needs a function argument")
+	}
+	fn := args[len(args)-1]
+	if !isCallable(fn) {
+		return nil, fmt.Errorf("partapply expects a function, got %s", typeName(fn))
+	}
+	captured := make([]Value, len(args)-1)
+	copy(captured, args[:len(args)-1])
+	return []Value{&PartApply{Fn: fn, Args: captured}}, nil
+}
+
+func sysAdd(it *Interpreter, args []Value) ([]Value, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("+ expects 2 arguments, got %d", len(args))
+	}
+	if x, ok := args[0].(string); ok {
+		if y, ok := args[1].(string); ok {
+			return []Value{x + y}, nil
+		}
+	}
+	result, err := addition.apply(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return []Value{result}, nil
+}
+
+// numeric creates the system function for an arithmetic operator.
+func numeric(a arithmetic) SysFn {
+	return func(it *Interpreter, args []Value) ([]Value, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments, got %d", a.name, len(args))
+		}
+		result, err := a.apply(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return []Value{result}, nil
+	}
+}
+
+func compare(name string, test func(int) bool) SysFn {
+	return func(it *Interpreter, args []Value) ([]Value, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+		}
+		if isNumber(args[0]) && isNumber(args[1]) {
+			c, ordered := compareNumbers(args[0], args[1])
+			return []Value{ordered && test(c)}, nil
+		}
+		if x, ok := args[0].(string); ok {
+			if y, ok := args[1].(string); ok {
+				return []Value{test(strings.Compare(x, y))}, nil
+			}
+		}
+		return nil, fmt.Errorf("%s cannot be applied to %s and %s", name, typeName(args[0]), typeName(args[1]))
+	}
+}
+
+// sysEquals compares numbers by value, so that 1 == 1.0, and everything else
+// by identity.
+func sysEquals(it *Interpreter, args []Value) ([]Value, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("== expects 2 arguments, got %d", len(args))
+	}
+	if isNumber(args[0]) && isNumber(args[1]) {
+		c, ordered := compareNumbers(args[0], args[1])
+		return []Value{ordered && c == 0}, nil
+	}
+	return []Value{args[0] == args[1]}, nil
+}
+
+// sysShow converts a value to the string that println would print for it.
+func sysShow(it *Interpreter, args []Value) ([]Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("show expects 1 argument, got %d", len(args))
+	}
+	return []Value{Show(args[0])}, nil
+}
+
+// sysConcat joins any number of strings together.
+func sysConcat(it *Interpreter, args []Value) ([]Value, error) {
+	var result strings.Builder
+	for _, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("concat expects strings, got %s", typeName(arg))
+		}
+		result.WriteString(s)
+	}
+	return []Value{result.String()}, nil
+}
+
+// makeRange creates the system function for a range operator. The extra is
+// added to the upper bound to turn the range into a half-open one.
+func makeRange(name string, extra int) SysFn {
+	return func(it *Interpreter, args []Value) ([]Value, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+		}
+		lo, lok := args[0].(int)
+		hi, hok := args[1].(int)
+		if !lok || !hok {
+			return nil, fmt.Errorf("%s cannot be applied to %s and %s", name, typeName(args[0]), typeName(args[1]))
+		}
+		return []Value{&Range{Lo: lo, Hi: hi + extra}}, nil
+	}
+}
+
+// rangeIterator steps through the integers of a Range.
+type rangeIterator struct {
+	next int
+	hi   int
+}
+
+func (r *rangeIterator) HasNext() bool {
+	return r.next < r.hi
+}
+
+func (r *rangeIterator) Next() (Value, error) {
+	if r.next >= r.hi {
+		return nil, fmt.Errorf("iterator is exhausted")
+	}
+	v := r.next
+	r.next++
+	return v, nil
+}
+
+// stringIterator steps through the characters of a string, each of which is
+// delivered as a string of length one.
+type stringIterator struct {
+	chars []rune
+	next  int
+}
+
+func (s *stringIterator) HasNext() bool {
+	return s.next < len(s.chars)
+}
+
+func (s *stringIterator) Next() (Value, error) {
+	if s.next >= len(s.chars) {
+		return nil, fmt.Errorf("iterator is exhausted")
+	}
+	v := string(s.chars[s.next])
+	s.next++
+	return v, nil
+}
+
+// sysIterator starts the iteration of a collection for a for loop.
+func sysIterator(it *Interpreter, args []Value) ([]Value, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("a for loop needs exactly 1 collection, got %d values", len(args))
+	}
+	switch x := args[0].(type) {
+	case *Range:
+		return []Value{&rangeIterator{next: x.Lo, hi: x.Hi}}, nil
+	case string:
+		return []Value{&stringIterator{chars: []rune(x)}}, nil
+	}
+	return nil, fmt.Errorf("cannot iterate over %s", typeName(args[0]))
+}
+
+func sysHasNext(it *Interpreter, args []Value) ([]Value, error) {
+	iter, err := iteratorArg("iterator.hasnext", args)
+	if err != nil {
+		return nil, err
+	}
+	return []Value{iter.HasNext()}, nil
+}
+
+func sysNext(it *Interpreter, args []Value) ([]Value, error) {
+	iter, err := iteratorArg("iterator.next", args)
+	if err != nil {
+		return nil, err
+	}
+	v, err := iter.Next()
+	if err != nil {
+		return nil, err
+	}
+	return []Value{v}, nil
+}
+
+func iteratorArg(name string, args []Value) (Iterator, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+	}
+	iter, ok := args[0].(Iterator)
+	if !ok {
+		return nil, fmt.Errorf("%s expects an iterator, got %s", name, typeName(args[0]))
+	}
+	return iter, nil
+}
@@ -0,0 +1,93 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+)
+
+// Value is a run-time Nutmeg value. The interpreter uses ordinary Go values
+// where it can: int for integers, bool for booleans and string for strings.
+// Larger integers are *big.Int, fractions *big.Rat and floats float64, see
+// numbers.go. Functions are represented by *Function and closures by
+// *PartApply.
+type Value any
+
+// Function is a FunctionObject that has been prepared for execution, which
+// means it has been assembled so that jumps hold instruction indices.
+type Function struct {
+	Name   string
+	Object *bundler.FunctionObject
+}
+
+// PartApply is a function value with some of its trailing arguments already
+// supplied. The resolver uses these to implement closures.
+type PartApply struct {
+	Fn   Value
+	Args []Value
+}
+
+// Range is the half-open range of integers from Lo up to but excluding Hi.
+// Closed ranges are normalised into half-open ones when they are created.
+type Range struct {
+	Lo int
+	Hi int
+}
+
+// Iterator is the state of a for loop over a collection, as created by the
+// iterator system function.
+type Iterator interface {
+	HasNext() bool
+	Next() (Value, error)
+}
+
+// Show returns the printed representation of a value, as used by println.
+func Show(v Value) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case *big.Int:
+		return x.String()
+	case *big.Rat:
+		return x.RatString()
+	case float64:
+		return showFloat(x)
+	case *Function:
+		return fmt.Sprintf("<function %s>", x.Name)
+	case *PartApply:
+		return fmt.Sprintf("<partapply %s>", Show(x.Fn))
+	case *Range:
+		return fmt.Sprintf("%d ..< %d", x.Lo, x.Hi)
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
+
+// typeName returns a short description of the type of a value, for use in
+// error messages.
+func typeName(v Value) string {
+	switch v.(type) {
+	case int, *big.Int:
+		return "integer"
+	case *big.Rat:
+		return "rational"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case *Function, *PartApply:
+		return "function"
+	case *Range:
+		return "range"
+	case Iterator:
+		return "iterator"
+	case nil:
+		return "uninitialised"
+	default:
+		return strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+	}
+}
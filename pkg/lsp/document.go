@@ -0,0 +1,247 @@
+package lsp
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
+	"github.com/spicery/nutmeg-compiler/pkg/resolver"
+)
+
+// identifier is an occurrence of an identifier in a document, as annotated
+// by the resolver. Occurrences of the same binding share a serial number.
+type identifier struct {
+	Span     common.Span
+	Name     string
+	SerialNo string
+	Scope    string
+	Var      bool
+	Const    bool
+	Def      bool // Whether this occurrence is the one that defines the binding.
+}
+
+// document is an open text document together with the results of analysing
+// its current text.
+type document struct {
+	uri         string
+	version     int
+	lines       []string
+	tokens      []*common.Token
+	diagnostics []pipeline.Diagnostic
+	identifiers []identifier
+	symbols     []DocumentSymbol
+}
+
+// newDocument analyses the text of a document. The document is analysed as
+// a lone file, so references to other modules are not followed.
+func newDocument(p *pipeline.Pipeline, uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version, lines: strings.Split(text, "\n")}
+	source := &pipeline.SourceFile{SrcPath: pathOfURI(uri), Contents: text}
+
+	tokens, diagnostics := p.Tokenize(source.SrcPath, text)
+	d.tokens = tokens
+	if d.fails(diagnostics) {
+		return d
+	}
+	// The parser recovers from errors and the checker skips the error nodes
+	// that it leaves, so both report all the problems that they find.
+	tree, diagnostics := p.Parse(source, tokens)
+	diagnostics = append(diagnostics, p.Check(tree)...)
+	if d.fails(diagnostics) {
+		return d
+	}
+	tree, diagnostics = p.Rewrite(tree)
+	if d.fails(diagnostics) {
+		return d
+	}
+	if d.fails(p.Annotate(tree, "", nil)) {
+		return d
+	}
+	d.collectIdentifiers(tree, false)
+	d.collectSymbols(tree)
+	return d
+}
+
+// fails records the diagnostics of a phase and returns true if any of them
+// is an error, in which case the later phases cannot run.
+func (d *document) fails(diagnostics []pipeline.Diagnostic) bool {
+	d.diagnostics = append(d.diagnostics, diagnostics...)
+	return pipeline.HasErrors(diagnostics)
+}
+
+// pathOfURI returns the path of a file URI, or the URI itself if it is not
+// one.
+func pathOfURI(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+// collectIdentifiers records the identifiers of the tree that come from the
+// source. The ones that define bindings are marked, in the same places that
+// the resolver defines them: the name of a bind, the parameters of a fn and
+// the variable of a for loop.
+func (d *document) collectIdentifiers(node *common.Node, defines bool) {
+	if node.Name == common.NameIdentifier && node.Span.StartLine > 0 {
+		if serialNo, ok := node.Options[common.OptionSerialNo]; ok {
+			d.identifiers = append(d.identifiers, identifier{
+				Span:     node.Span,
+				Name:     node.Options[common.OptionName],
+				SerialNo: serialNo,
+				Scope:    node.Options[common.OptionScope],
+				Var:      node.Options[common.OptionVar] == common.ValueTrue,
+				Const:    node.Options[common.OptionConst] == common.ValueTrue,
+				Def:      defines,
+			})
+		}
+	}
+	for i, child := range node.Children {
+		switch node.Name {
+		case common.NameBind, common.NameIn, common.NameFn:
+			// The parameters of a fn are the children of its first child.
+			d.collectIdentifiers(child, i == 0)
+		case common.NameArguments:
+			d.collectIdentifiers(child, defines)
+		default:
+			d.collectIdentifiers(child, false)
+		}
+	}
+}
+
+// collectSymbols records the top-level functions that are defined with def,
+// which the rewriter marks as protected.
+func (d *document) collectSymbols(tree *common.Node) {
+	for _, child := range tree.Children {
+		if child.Name != common.NameBind || len(child.Children) != 2 {
+			continue
+		}
+		id, value := child.Children[0], child.Children[1]
+		if id.Name != common.NameIdentifier || id.Options[resolver.ProtectedOption] != common.ValueTrue || value.Name != common.NameFn {
+			continue
+		}
+		d.symbols = append(d.symbols, DocumentSymbol{
+			Name:           id.Options[common.OptionName],
+			Kind:           SymbolKindFunction,
+			Range:          d.spanRange(child.Span),
+			SelectionRange: d.spanRange(id.Span),
+		})
+	}
+}
+
+// identifierAt returns the identifier at a position, including one that
+// ends just before it, or nil if there is none.
+func (d *document) identifierAt(pos Position) *identifier {
+	line, column := d.lineColumn(pos)
+	var found *identifier
+	for i := range d.identifiers {
+		id := &d.identifiers[i]
+		if id.Span.StartLine != line || column < id.Span.StartColumn || column > id.Span.EndColumn {
+			continue
+		}
+		// Prefer the identifier that the position is inside.
+		if found == nil || column < id.Span.EndColumn {
+			found = id
+		}
+	}
+	return found
+}
+
+// occurrences returns the occurrences of the binding with a serial number,
+// in order and without the duplicates that rewriting can introduce.
+func (d *document) occurrences(serialNo string, includeDef bool) []identifier {
+	seen := make(map[common.Span]bool)
+	result := make([]identifier, 0)
+	for _, id := range d.identifiers {
+		if id.SerialNo != serialNo || seen[id.Span] || (id.Def && !includeDef) {
+			continue
+		}
+		seen[id.Span] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+// lspDiagnostics converts the diagnostics of the pipeline for the client.
+// Most phases only give the start of a problem, so the range is widened to
+// the token that starts there.
+func (d *document) lspDiagnostics() []Diagnostic {
+	result := make([]Diagnostic, 0, len(d.diagnostics))
+	for _, diag := range d.diagnostics {
+		span := diag.Span
+		if span.EndLine < span.StartLine || (span.EndLine == span.StartLine && span.EndColumn <= span.StartColumn) {
+			span.EndLine, span.EndColumn = span.StartLine, span.StartColumn
+			for _, token := range d.tokens {
+				if token.Span.StartLine == span.StartLine && token.Span.StartColumn == span.StartColumn {
+					span = token.Span
+					break
+				}
+			}
+		}
+		severity := SeverityError
+		if diag.Severity == pipeline.SeverityWarning {
+			severity = SeverityWarning
+		}
+		result = append(result, Diagnostic{
+			Range:    d.spanRange(span),
+			Severity: severity,
+			Code:     string(diag.Code),
+			Source:   "nutmeg",
+			Message:  diag.Message,
+		})
+	}
+	return result
+}
+
+// position converts a one-based line and byte column of the source into a
+// position for the client. A problem without a location is placed at the
+// start of the document.
+func (d *document) position(line, column int) Position {
+	if line < 1 {
+		return Position{}
+	}
+	if line > len(d.lines) {
+		return Position{Line: len(d.lines) - 1, Character: utf16Length(d.lines[len(d.lines)-1])}
+	}
+	text := d.lines[line-1]
+	offset := min(max(column-1, 0), len(text))
+	return Position{Line: line - 1, Character: utf16Length(text[:offset])}
+}
+
+// spanRange converts a span of the source into a range for the client.
+func (d *document) spanRange(span common.Span) Range {
+	start := d.position(span.StartLine, span.StartColumn)
+	if span.EndLine < 1 {
+		return Range{Start: start, End: start}
+	}
+	return Range{Start: start, End: d.position(span.EndLine, span.EndColumn)}
+}
+
+// lineColumn converts a position from the client into a one-based line and
+// byte column of the source.
+func (d *document) lineColumn(pos Position) (int, int) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos.Line + 1, 0
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for offset, r := range text {
+		if units >= pos.Character {
+			return pos.Line + 1, offset + 1
+		}
+		units += utf16.RuneLen(r)
+	}
+	return pos.Line + 1, len(text) + 1
+}
+
+// utf16Length returns the number of UTF-16 code units in a string, which is
+// how the client measures characters.
+func utf16Length(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
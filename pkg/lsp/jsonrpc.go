@@ -0,0 +1,114 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// The JSON-RPC error codes used by the server.
+const (
+	CodeParseError           = -32700
+	CodeInvalidRequest       = -32600
+	CodeMethodNotFound       = -32601
+	CodeInvalidParams        = -32602
+	CodeServerNotInitialized = -32002
+)
+
+// message is a JSON-RPC request, notification or response. Requests and
+// responses have an ID, notifications do not.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ResponseError  `json:"error,omitempty"`
+}
+
+// ResponseError is the error of a failed request.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Conn reads and writes JSON-RPC messages in the base protocol of LSP, where
+// each message is preceded by a Content-Length header and a blank line.
+type Conn struct {
+	in  *textproto.Reader
+	out io.Writer
+}
+
+// NewConn creates a connection that reads messages from in and writes them
+// to out.
+func NewConn(in io.Reader, out io.Writer) *Conn {
+	return &Conn{in: textproto.NewReader(bufio.NewReader(in)), out: out}
+}
+
+// read reads the next message, returning io.EOF when the input ends between
+// messages.
+func (c *Conn) read() (*message, error) {
+	header, err := c.in.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading message header: %w", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header: %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.in.R, body); err != nil {
+		return nil, fmt.Errorf("reading message body: %w", err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, &ResponseError{Code: CodeParseError, Message: err.Error()}
+	}
+	return &msg, nil
+}
+
+// write writes a message with its header.
+func (c *Conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.out.Write(body)
+	return err
+}
+
+// reply sends the response to a request. A successful response always has a
+// result, even if it is null.
+func (c *Conn) reply(id json.RawMessage, result any, rerr *ResponseError) error {
+	if rerr != nil {
+		return c.write(&message{ID: id, Error: rerr})
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{ID: id, Result: data})
+}
+
+// notify sends a notification.
+func (c *Conn) notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: data})
+}
@@ -0,0 +1,64 @@
+package lsp
+
+import (
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// semanticTokenTypes is the legend of semantic token types, in the order
+// that the client numbers them.
+var semanticTokenTypes = []string{"keyword", "variable", "string", "number", "operator"}
+
+// semanticTokenType gives the index into the legend for each type of token
+// that is highlighted. Marks, brackets and the like are left to the client.
+var semanticTokenType = map[common.TokenType]int{
+	common.StartTokenType:              0,
+	common.EndTokenType:                0,
+	common.BridgeTokenType:             0,
+	common.PrefixTokenType:             0,
+	common.VariableTokenType:           1,
+	common.StringLiteralTokenType:      2,
+	common.MultiLineStringTokenType:    2,
+	common.InterpolatedStringTokenType: 2,
+	common.NumericLiteralTokenType:     3,
+	common.OperatorTokenType:           4,
+}
+
+// semanticTokens encodes the tokens of the document in the relative form
+// that the protocol uses: five numbers for each token, which are the change
+// of line, the start character (relative to the previous token if it is on
+// the same line), the length, the type and the modifiers. A token that
+// spans several lines, such as a multi-line string, is split into one token
+// per line, since clients need not support tokens that span lines.
+func (d *document) semanticTokens() []int {
+	data := make([]int, 0, 5*len(d.tokens))
+	previous := Position{}
+	add := func(start, end Position, tokenType int) {
+		if end.Character <= start.Character {
+			return
+		}
+		character := start.Character
+		if start.Line == previous.Line {
+			character -= previous.Character
+		}
+		data = append(data, start.Line-previous.Line, character, end.Character-start.Character, tokenType, 0)
+		previous = start
+	}
+	for _, token := range d.tokens {
+		tokenType, ok := semanticTokenType[token.Type]
+		if !ok {
+			continue
+		}
+		r := d.spanRange(token.Span)
+		for line := r.Start.Line; line <= r.End.Line; line++ {
+			start, end := Position{Line: line}, Position{Line: line, Character: utf16Length(d.lines[line])}
+			if line == r.Start.Line {
+				start = r.Start
+			}
+			if line == r.End.Line {
+				end = r.End
+			}
+			add(start, end, tokenType)
+		}
+	}
+	return data
+}
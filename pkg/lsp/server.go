@@ -0,0 +1,251 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
+)
+
+// Server is a language server for Nutmeg. It analyses each open document
+// with the phases of the pipeline up to and including resolution.
+type Server struct {
+	Name        string
+	Version     string
+	pipeline    *pipeline.Pipeline
+	conn        *Conn
+	documents   map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer creates a server that uses a pipeline to analyse documents and
+// speaks LSP over in and out.
+func NewServer(p *pipeline.Pipeline, in io.Reader, out io.Writer) *Server {
+	return &Server{
+		Name:      "nutmeg-lsp",
+		Version:   "dev",
+		pipeline:  p,
+		conn:      NewConn(in, out),
+		documents: make(map[string]*document),
+	}
+}
+
+// Run handles messages until the client sends exit or the input ends. It
+// returns an error if the input ends or the client exits without first
+// asking the server to shut down.
+func (s *Server) Run() error {
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return fmt.Errorf("connection closed before exit")
+		}
+		if rerr, ok := err.(*ResponseError); ok {
+			// The message cannot be decoded, so there is no ID to reply to.
+			if err := s.conn.reply(json.RawMessage("null"), nil, rerr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a request or notification. Only an error in writing to
+// the client is returned; errors in requests are replied to instead.
+func (s *Server) handle(msg *message) error {
+	isRequest := len(msg.ID) > 0
+	if !s.initialized && msg.Method != "initialize" {
+		if isRequest {
+			return s.conn.reply(msg.ID, nil, &ResponseError{Code: CodeServerNotInitialized, Message: "server not initialized"})
+		}
+		return nil
+	}
+	if isRequest {
+		result, rerr := s.request(msg.Method, msg.Params)
+		return s.conn.reply(msg.ID, result, rerr)
+	}
+	return s.notification(msg.Method, msg.Params)
+}
+
+// request handles a request and returns its result.
+func (s *Server) request(method string, params json.RawMessage) (any, *ResponseError) {
+	switch method {
+	case "initialize":
+		s.initialized = true
+		return s.capabilities(), nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if rerr := decode(params, &p); rerr != nil {
+			return nil, rerr
+		}
+		return s.definition(p), nil
+	case "textDocument/references":
+		var p ReferenceParams
+		if rerr := decode(params, &p); rerr != nil {
+			return nil, rerr
+		}
+		return s.references(p), nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if rerr := decode(params, &p); rerr != nil {
+			return nil, rerr
+		}
+		return s.hover(p), nil
+	case "textDocument/documentSymbol":
+		var p DocumentSymbolParams
+		if rerr := decode(params, &p); rerr != nil {
+			return nil, rerr
+		}
+		symbols := make([]DocumentSymbol, 0)
+		if d := s.documents[p.TextDocument.URI]; d != nil {
+			symbols = append(symbols, d.symbols...)
+		}
+		return symbols, nil
+	case "textDocument/semanticTokens/full":
+		var p SemanticTokensParams
+		if rerr := decode(params, &p); rerr != nil {
+			return nil, rerr
+		}
+		tokens := &SemanticTokens{Data: []int{}}
+		if d := s.documents[p.TextDocument.URI]; d != nil {
+			tokens.Data = d.semanticTokens()
+		}
+		return tokens, nil
+	default:
+		return nil, &ResponseError{Code: CodeMethodNotFound, Message: fmt.Sprintf("method not found: %s", method)}
+	}
+}
+
+// notification handles a notification. Notifications that the server does
+// not know are ignored, as the protocol requires.
+func (s *Server) notification(method string, params json.RawMessage) error {
+	switch method {
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if decode(params, &p) != nil {
+			return nil
+		}
+		return s.update(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if decode(params, &p) != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		// With full synchronisation the last change holds the whole text.
+		text := p.ContentChanges[len(p.ContentChanges)-1].Text
+		return s.update(p.TextDocument.URI, p.TextDocument.Version, text)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if decode(params, &p) != nil {
+			return nil
+		}
+		delete(s.documents, p.TextDocument.URI)
+		return s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	}
+	return nil
+}
+
+// update analyses the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri string, version int, text string) error {
+	d := newDocument(s.pipeline, uri, version, text)
+	s.documents[uri] = d
+	return s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: d.lspDiagnostics(),
+	})
+}
+
+func (s *Server) capabilities() *InitializeResult {
+	result := &InitializeResult{ServerInfo: ServerInfo{Name: s.Name, Version: s.Version}}
+	c := &result.Capabilities
+	c.TextDocumentSync.OpenClose = true
+	c.TextDocumentSync.Change = TextDocumentSyncKindFull
+	c.DefinitionProvider = true
+	c.ReferencesProvider = true
+	c.HoverProvider = true
+	c.DocumentSymbolProvider = true
+	c.SemanticTokensProvider.Legend = SemanticTokensLegend{TokenTypes: semanticTokenTypes, TokenModifiers: []string{}}
+	c.SemanticTokensProvider.Full = true
+	return result
+}
+
+// lookup returns the document and the identifier at a position, or nils if
+// there is no identifier there.
+func (s *Server) lookup(p TextDocumentPositionParams) (*document, *identifier) {
+	d := s.documents[p.TextDocument.URI]
+	if d == nil {
+		return nil, nil
+	}
+	return d, d.identifierAt(p.Position)
+}
+
+// definition returns the location of the occurrence that defines the
+// identifier at a position. Identifiers bound outside the document, such as
+// those that are imported, have no definition in it.
+func (s *Server) definition(p TextDocumentPositionParams) *Location {
+	d, id := s.lookup(p)
+	if id == nil {
+		return nil
+	}
+	for _, occurrence := range d.occurrences(id.SerialNo, true) {
+		if occurrence.Def {
+			return &Location{URI: d.uri, Range: d.spanRange(occurrence.Span)}
+		}
+	}
+	return nil
+}
+
+// references returns the locations of all the occurrences of the binding of
+// the identifier at a position.
+func (s *Server) references(p ReferenceParams) []Location {
+	locations := make([]Location, 0)
+	d, id := s.lookup(p.TextDocumentPositionParams)
+	if id == nil {
+		return locations
+	}
+	for _, occurrence := range d.occurrences(id.SerialNo, p.Context.IncludeDeclaration) {
+		locations = append(locations, Location{URI: d.uri, Range: d.spanRange(occurrence.Span)})
+	}
+	return locations
+}
+
+// hover describes the scope of the identifier at a position and whether it
+// is a variable or a constant.
+func (s *Server) hover(p TextDocumentPositionParams) *Hover {
+	d, id := s.lookup(p)
+	if id == nil {
+		return nil
+	}
+	// The flags are spelled as the resolver annotates them, since a binding
+	// made with := is neither a var nor a const.
+	text := fmt.Sprintf("`%s` — %s scope, var=%t, const=%t", id.Name, id.Scope, id.Var, id.Const)
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: text},
+		Range:    d.spanRange(id.Span),
+	}
+}
+
+// decode unmarshals the parameters of a message.
+func decode(params json.RawMessage, v any) *ResponseError {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ResponseError{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/pipeline"
+)
+
+const testURI = "file:///test/a.nutmeg"
+
+const testSource = "def f(a) =>>\n    b := a\n    b + a\nenddef\n"
+
+// client is a scripted LSP client that talks to a server over pipes.
+type client struct {
+	t      *testing.T
+	conn   *Conn
+	nextID int
+	done   chan error
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	p, err := pipeline.New(pipeline.Options{})
+	if err != nil {
+		t.Fatalf("pipeline.New failed: %v", err)
+	}
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	server := NewServer(p, serverIn, serverOut)
+	c := &client{t: t, conn: NewConn(clientIn, clientOut), done: make(chan error, 1)}
+	go func() {
+		err := server.Run()
+		serverOut.Close()
+		c.done <- err
+	}()
+	return c
+}
+
+// receive reads the next message from the server.
+func (c *client) receive() *message {
+	c.t.Helper()
+	msg, err := c.conn.read()
+	if err != nil {
+		c.t.Fatalf("Reading from the server failed: %v", err)
+	}
+	return msg
+}
+
+// call sends a request and decodes the result of its response into result.
+func (c *client) call(method string, params any, result any) *ResponseError {
+	c.t.Helper()
+	c.nextID++
+	data, _ := json.Marshal(params)
+	id, _ := json.Marshal(c.nextID)
+	if err := c.conn.write(&message{ID: id, Method: method, Params: data}); err != nil {
+		c.t.Fatalf("Writing to the server failed: %v", err)
+	}
+	msg := c.receive()
+	if string(msg.ID) != string(id) {
+		c.t.Fatalf("Expected a response to %s, got %+v", id, msg)
+	}
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if err := json.Unmarshal(msg.Result, result); err != nil {
+		c.t.Fatalf("Decoding the result of %s failed: %v", method, err)
+	}
+	return nil
+}
+
+// notify sends a notification.
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	if err := c.conn.notify(method, params); err != nil {
+		c.t.Fatalf("Writing to the server failed: %v", err)
+	}
+}
+
+// diagnostics reads the diagnostics that the server publishes.
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+	msg := c.receive()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("Expected diagnostics, got %+v", msg)
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatalf("Decoding diagnostics failed: %v", err)
+	}
+	return params
+}
+
+// open initialises the server and opens a document with the given text.
+func (c *client) open(text string) PublishDiagnosticsParams {
+	c.t.Helper()
+	var result InitializeResult
+	if rerr := c.call("initialize", map[string]any{}, &result); rerr != nil {
+		c.t.Fatalf("initialize failed: %v", rerr)
+	}
+	c.notify("initialized", map[string]any{})
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: testURI, LanguageID: "nutmeg", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+// close shuts down the server and checks that it exits cleanly.
+func (c *client) close() {
+	c.t.Helper()
+	var result any
+	if rerr := c.call("shutdown", nil, &result); rerr != nil {
+		c.t.Fatalf("shutdown failed: %v", rerr)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Errorf("Expected the server to exit cleanly, got %v", err)
+	}
+}
+
+func at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func rangeOf(line, start, end int) Range {
+	return Range{Start: Position{Line: line, Character: start}, End: Position{Line: line, Character: end}}
+}
+
+func TestInitialize(t *testing.T) {
+	c := newClient(t)
+	var result InitializeResult
+	if rerr := c.call("initialize", map[string]any{}, &result); rerr != nil {
+		t.Fatalf("initialize failed: %v", rerr)
+	}
+	caps := result.Capabilities
+	if caps.TextDocumentSync.Change != TextDocumentSyncKindFull || !caps.DefinitionProvider || !caps.ReferencesProvider ||
+		!caps.HoverProvider || !caps.DocumentSymbolProvider || !caps.SemanticTokensProvider.Full {
+		t.Errorf("Expected all capabilities, got %+v", caps)
+	}
+	if result.ServerInfo.Name != "nutmeg-lsp" {
+		t.Errorf("Expected the server to be nutmeg-lsp, got %q", result.ServerInfo.Name)
+	}
+	c.close()
+}
+
+func TestRequestBeforeInitialize(t *testing.T) {
+	c := newClient(t)
+	var result any
+	rerr := c.call("textDocument/hover", at(0, 0), &result)
+	if rerr == nil || rerr.Code != CodeServerNotInitialized {
+		t.Errorf("Expected error %d, got %v", CodeServerNotInitialized, rerr)
+	}
+	c.open(testSource)
+	rerr = c.call("textDocument/unknown", at(0, 0), &result)
+	if rerr == nil || rerr.Code != CodeMethodNotFound {
+		t.Errorf("Expected error %d, got %v", CodeMethodNotFound, rerr)
+	}
+	c.close()
+}
+
+func TestDiagnosticsOnChange(t *testing.T) {
+	c := newClient(t)
+	published := c.open(testSource)
+	if published.URI != testURI || published.Version != 1 || len(published.Diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics for version 1, got %+v", published)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: testURI, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "def f()\n    \"hi\"\nenddef\n"}},
+	})
+	published = c.diagnostics()
+	if published.Version != 2 || len(published.Diagnostics) != 1 {
+		t.Fatalf("Expected one diagnostic for version 2, got %+v", published)
+	}
+	diagnostic := published.Diagnostics[0]
+	if diagnostic.Severity != SeverityError || diagnostic.Code != string(pipeline.CodeParseError) {
+		t.Errorf("Expected a parse error, got %+v", diagnostic)
+	}
+	if expected := rangeOf(1, 4, 8); diagnostic.Range != expected {
+		t.Errorf("Expected the range %+v, got %+v", expected, diagnostic.Range)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: testURI}})
+	if published = c.diagnostics(); len(published.Diagnostics) != 0 {
+		t.Errorf("Expected closing to clear the diagnostics, got %+v", published)
+	}
+	c.close()
+}
+
+func TestDefinitionAndReferences(t *testing.T) {
+	c := newClient(t)
+	c.open(testSource)
+
+	var location *Location
+	if rerr := c.call("textDocument/definition", at(2, 9), &location); rerr != nil {
+		t.Fatalf("definition failed: %v", rerr)
+	}
+	if location == nil || location.URI != testURI || location.Range != rangeOf(0, 6, 7) {
+		t.Errorf("Expected a to be defined as the parameter, got %+v", location)
+	}
+
+	tests := []struct {
+		name               string
+		includeDeclaration bool
+		expected           []Range
+	}{
+		{"with declaration", true, []Range{rangeOf(1, 4, 5), rangeOf(2, 4, 5)}},
+		{"without declaration", false, []Range{rangeOf(2, 4, 5)}},
+	}
+	for _, tt := range tests {
+		params := ReferenceParams{TextDocumentPositionParams: at(2, 4)}
+		params.Context.IncludeDeclaration = tt.includeDeclaration
+		var locations []Location
+		if rerr := c.call("textDocument/references", params, &locations); rerr != nil {
+			t.Fatalf("references failed: %v", rerr)
+		}
+		if len(locations) != len(tt.expected) {
+			t.Fatalf("Expected %d references %s, got %+v", len(tt.expected), tt.name, locations)
+		}
+		for i, location := range locations {
+			if location.Range != tt.expected[i] {
+				t.Errorf("Expected reference %d at %+v, got %+v", i, tt.expected[i], location.Range)
+			}
+		}
+	}
+
+	if rerr := c.call("textDocument/definition", at(3, 0), &location); rerr != nil || location != nil {
+		t.Errorf("Expected no definition outside an identifier, got %+v, %v", location, rerr)
+	}
+	c.close()
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.open(testSource + "var v := 1\nconst k := 2\n")
+	tests := []struct {
+		position TextDocumentPositionParams
+		expected Range
+		text     string
+	}{
+		{at(2, 5), rangeOf(2, 4, 5), "`b` — inner scope, var=false, const=false"},
+		{at(4, 4), rangeOf(4, 4, 5), "`v` — global scope, var=true, const=false"},
+		{at(5, 6), rangeOf(5, 6, 7), "`k` — global scope, var=false, const=true"},
+	}
+	for _, tt := range tests {
+		var hover *Hover
+		if rerr := c.call("textDocument/hover", tt.position, &hover); rerr != nil {
+			t.Fatalf("hover failed: %v", rerr)
+		}
+		if hover == nil || hover.Range != tt.expected {
+			t.Fatalf("Expected a hover at %+v, got %+v", tt.expected, hover)
+		}
+		if hover.Contents.Kind != "markdown" || hover.Contents.Value != tt.text {
+			t.Errorf("Expected %q, got %q", tt.text, hover.Contents.Value)
+		}
+	}
+	c.close()
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	c.open(testSource + "\nx := 1\n")
+	var symbols []DocumentSymbol
+	params := DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: testURI}}
+	if rerr := c.call("textDocument/documentSymbol", params, &symbols); rerr != nil {
+		t.Fatalf("documentSymbol failed: %v", rerr)
+	}
+	if len(symbols) != 1 || symbols[0].Name != "f" || symbols[0].Kind != SymbolKindFunction {
+		t.Fatalf("Expected the function f, got %+v", symbols)
+	}
+	if symbols[0].SelectionRange != rangeOf(0, 4, 5) {
+		t.Errorf("Expected f to be selected, got %+v", symbols[0].SelectionRange)
+	}
+	c.close()
+}
+
+func TestSemanticTokens(t *testing.T) {
+	c := newClient(t)
+	c.open("x := 1\ny := \"é\"\n")
+	var tokens SemanticTokens
+	params := SemanticTokensParams{TextDocument: TextDocumentIdentifier{URI: testURI}}
+	if rerr := c.call("textDocument/semanticTokens/full", params, &tokens); rerr != nil {
+		t.Fatalf("semanticTokens failed: %v", rerr)
+	}
+	expected := []int{
+		0, 0, 1, 1, 0, // x
+		0, 2, 2, 4, 0, // :=
+		0, 3, 1, 3, 0, // 1
+		1, 0, 1, 1, 0, // y
+		0, 2, 2, 4, 0, // :=
+		0, 3, 3, 2, 0, // "é"
+	}
+	if len(tokens.Data) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, tokens.Data)
+	}
+	for i := range expected {
+		if tokens.Data[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, tokens.Data)
+		}
+	}
+	c.close()
+}
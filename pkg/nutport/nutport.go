@@ -0,0 +1,208 @@
+// Package nutport reads the Nutmeg import format. Each module of a project may
+// have an imports.nutport file that lists what it imports from other modules,
+// see docs/nutmeg-project-structure.md. For example:
+//
+//	### Comments run to the end of the line.
+//	import #pub from parser as p
+//	import #pub, #exp from advanced_parser as adv
+//	import #pub from std optionally as std
+//	include #pub from json_parser
+package nutport
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// FileName is the name of the import file inside a module folder.
+const FileName = "imports.nutport"
+
+// Import is a single import or include declaration.
+type Import struct {
+	Include  bool     // True for `include`, which re-exports the module's names.
+	Tags     []string // The tags being imported, e.g. #pub.
+	Module   string   // The name of the module being imported.
+	Alias    string   // The qualifier used in references such as alias::name.
+	Optional bool     // True if the names may also be used without qualification.
+	Line     int      // The line of the declaration, for error reporting.
+}
+
+// DefaultImports is what every module imports unless it says otherwise,
+// namely the public part of the standard library with optional qualification.
+func DefaultImports() []Import {
+	return []Import{
+		{
+			Tags:     []string{"#pub"},
+			Module:   common.StdModule,
+			Alias:    common.StdModule,
+			Optional: true,
+		},
+	}
+}
+
+// ParseFile reads the imports of a module. A missing file is not an error and
+// gives the DefaultImports, which are otherwise added to the imports of the
+// file unless it imports std itself or uses std as an alias.
+func ParseFile(path string) ([]Import, error) {
+	data, err := os.ReadFile(path) // #nosec G304 - the path is the module's own imports file
+	if os.IsNotExist(err) {
+		return DefaultImports(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	imports, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return withDefaultImports(imports), nil
+}
+
+// withDefaultImports adds the DefaultImports to the imports of a module,
+// unless the module already takes charge of how std is imported.
+func withDefaultImports(imports []Import) []Import {
+	for _, imp := range imports {
+		if imp.Module == common.StdModule || (!imp.Include && imp.Alias == common.StdModule) {
+			return imports
+		}
+	}
+	return append(imports, DefaultImports()...)
+}
+
+// Parse reads the text of an imports.nutport file. There is one declaration
+// per line and the grammar of each declaration is:
+//
+//	(import | include) TAG (, TAG)* from MODULE [optionally] [as ALIAS]
+//
+// The alias defaults to the last component of the module name, so that
+// `import #pub from parser/advanced` is referenced as advanced::name. An
+// include may not be given an alias because its names become part of the
+// importing module. Errors are prefixed with the line number.
+func Parse(text string) ([]Import, error) {
+	imports := make([]Import, 0)
+	for i, line := range strings.Split(text, "\n") {
+		if n := strings.Index(line, "###"); n >= 0 {
+			line = line[:n]
+		}
+		words := splitWords(line)
+		if len(words) == 0 {
+			continue
+		}
+		imp, err := parseDeclaration(words)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %w", i+1, err)
+		}
+		imp.Line = i + 1
+		imports = append(imports, *imp)
+	}
+	if err := checkAliases(imports); err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+// splitWords breaks a line into words, treating commas as words of their own.
+func splitWords(line string) []string {
+	line = strings.ReplaceAll(line, ",", " , ")
+	return strings.Fields(line)
+}
+
+func parseDeclaration(words []string) (*Import, error) {
+	imp := &Import{}
+	switch words[0] {
+	case "import":
+	case "include":
+		imp.Include = true
+	default:
+		return nil, fmt.Errorf("expected `import` or `include` but found `%s`", words[0])
+	}
+	previous := words[0]
+	words = words[1:]
+
+	// The tags, separated by commas.
+	for {
+		if len(words) == 0 || !isTag(words[0]) {
+			return nil, fmt.Errorf("expected a tag such as #pub after `%s`", previous)
+		}
+		imp.Tags = append(imp.Tags, words[0])
+		words = words[1:]
+		if len(words) == 0 || words[0] != "," {
+			break
+		}
+		previous = words[0]
+		words = words[1:]
+	}
+
+	if len(words) == 0 || words[0] != "from" {
+		return nil, fmt.Errorf("expected `from` after the tags")
+	}
+	if len(words) < 2 || !isModuleName(words[1]) {
+		return nil, fmt.Errorf("expected a module name after `from`")
+	}
+	imp.Module = words[1]
+	words = words[2:]
+
+	if len(words) > 0 && words[0] == "optionally" {
+		imp.Optional = true
+		words = words[1:]
+	}
+	if len(words) > 0 && words[0] == "as" {
+		if imp.Include {
+			return nil, fmt.Errorf("an include cannot have an alias")
+		}
+		if len(words) < 2 || !isIdentifier(words[1]) {
+			return nil, fmt.Errorf("expected an alias after `as`")
+		}
+		imp.Alias = words[1]
+		words = words[2:]
+	} else {
+		imp.Alias = imp.Module[strings.LastIndex(imp.Module, "/")+1:]
+	}
+	if len(words) > 0 {
+		return nil, fmt.Errorf("unexpected `%s` at end of declaration", words[0])
+	}
+	return imp, nil
+}
+
+// checkAliases rejects two imports that would share the same qualifier.
+func checkAliases(imports []Import) error {
+	lines := make(map[string]int)
+	for _, imp := range imports {
+		if imp.Include {
+			continue
+		}
+		if line, found := lines[imp.Alias]; found {
+			return fmt.Errorf("%d: alias `%s` is already used on line %d", imp.Line, imp.Alias, line)
+		}
+		lines[imp.Alias] = imp.Line
+	}
+	return nil
+}
+
+func isTag(word string) bool {
+	return len(word) > 1 && word[0] == '#' && isIdentifier(word[1:])
+}
+
+// isModuleName accepts a module name, where nested modules are separated by
+// slashes, e.g. parser/advanced.
+func isModuleName(word string) bool {
+	for _, part := range strings.Split(word, "/") {
+		if !isIdentifier(part) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentifier(word string) bool {
+	for i, r := range word {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return word != ""
+}
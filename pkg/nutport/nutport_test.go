@@ -0,0 +1,111 @@
+package nutport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDeclarations(t *testing.T) {
+	text := `### Imports for the main module.
+import #pub from parser as p
+import #pub from stdlib   ### auto-named
+import #pub from common optionally as c
+import #pub, #exp from advanced_parser as adv
+import #pub from parser/advanced
+
+include #pub from json_parser
+`
+	imports, err := Parse(text)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expected := []Import{
+		{Tags: []string{"#pub"}, Module: "parser", Alias: "p", Line: 2},
+		{Tags: []string{"#pub"}, Module: "stdlib", Alias: "stdlib", Line: 3},
+		{Tags: []string{"#pub"}, Module: "common", Alias: "c", Optional: true, Line: 4},
+		{Tags: []string{"#pub", "#exp"}, Module: "advanced_parser", Alias: "adv", Line: 5},
+		{Tags: []string{"#pub"}, Module: "parser/advanced", Alias: "advanced", Line: 6},
+		{Include: true, Tags: []string{"#pub"}, Module: "json_parser", Alias: "json_parser", Line: 8},
+	}
+	if !reflect.DeepEqual(imports, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, imports)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Unknown keyword", "export #pub from parser", "1: expected `import` or `include`"},
+		{"Missing tag", "import from parser", "expected a tag"},
+		{"Trailing comma", "import #pub, from parser", "expected a tag such as #pub after `,`"},
+		{"Missing from", "import #pub parser", "expected `from`"},
+		{"Missing module", "import #pub from", "expected a module name"},
+		{"Missing alias", "import #pub from parser as", "expected an alias"},
+		{"Aliased include", "include #pub from parser as p", "an include cannot have an alias"},
+		{"Trailing words", "import #pub from parser as p q", "unexpected `q`"},
+		{"Duplicate alias", "import #pub from parser as p\nimport #pub from printer as p", "2: alias `p` is already used on line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestMissingFileGivesDefaultImports(t *testing.T) {
+	imports, err := ParseFile(filepath.Join(t.TempDir(), FileName))
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(imports, DefaultImports()) {
+		t.Errorf("Expected the default imports, got %+v", imports)
+	}
+}
+
+func TestFileImportsStdByDefault(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []Import
+	}{
+		{
+			"std is added",
+			"import #pub from util as u\n",
+			append([]Import{{Tags: []string{"#pub"}, Module: "util", Alias: "u", Line: 1}}, DefaultImports()...),
+		},
+		{
+			"std is imported explicitly",
+			"import #pub from std as s\n",
+			[]Import{{Tags: []string{"#pub"}, Module: "std", Alias: "s", Line: 1}},
+		},
+		{
+			"std is used as an alias",
+			"import #pub from util as std\n",
+			[]Import{{Tags: []string{"#pub"}, Module: "util", Alias: "std", Line: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			if err := os.WriteFile(path, []byte(tt.text), 0o600); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+			imports, err := ParseFile(path)
+			if err != nil {
+				t.Fatalf("ParseFile failed: %v", err)
+			}
+			if !reflect.DeepEqual(imports, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, imports)
+			}
+		})
+	}
+}
@@ -29,16 +29,19 @@ func NewScannerTokenIterator(input io.Reader) *ScannerTokenIterator {
 }
 
 func (it *ScannerTokenIterator) GetToken() (*Token, error) {
-	if !it.scanner.Scan() {
-		return nil, it.scanner.Err()
-	}
-	line := it.scanner.Bytes()
-	var token Token
-	if err := json.Unmarshal(line, &token); err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing token: %v\n", err)
-		return nil, err
+	for it.scanner.Scan() {
+		line := it.scanner.Bytes()
+		var token Token
+		if err := json.Unmarshal(line, &token); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing token: %v\n", err)
+			return nil, err
+		}
+		// The end-of-input token only carries trivia.
+		if token.Type != EndOfInputTokenType {
+			return &token, nil
+		}
 	}
-	return &token, nil
+	return nil, it.scanner.Err()
 }
 
 type SliceTokenIterator struct {
@@ -54,20 +57,23 @@ func NewSliceTokenIterator(tokens []*Token) *SliceTokenIterator {
 }
 
 func (it *SliceTokenIterator) GetToken() (*Token, error) {
-	if it.index >= len(it.tokens) {
-		return nil, nil // End of tokens.
+	for it.index < len(it.tokens) {
+		token := it.tokens[it.index]
+		it.index++
+		// The end-of-input token only carries trivia.
+		if token.Type != EndOfInputTokenType {
+			return token, nil
+		}
 	}
-	token := it.tokens[it.index]
-	it.index++
-	return token, nil
+	return nil, nil // End of tokens.
 }
 
 type TokenQueue struct {
 	tokens []*Token
 }
 
-func NewPeekQueue() TokenQueue {
-	return TokenQueue{
+func NewPeekQueue() *TokenQueue {
+	return &TokenQueue{
 		tokens: []*Token{},
 	}
 }
@@ -98,8 +104,51 @@ func (q *TokenQueue) Pop() *Token {
 
 type Parser struct {
 	tokenIterator ITokenIterator
-	peeked        TokenQueue
+	peeked        *TokenQueue
 	fragile       bool
+	recovery      *recovery // Nil unless the parser is in recovery mode.
+}
+
+// ParseError is an error that a parser in recovery mode has read past. The
+// span is that of the error node that took the place of the tokens that
+// were skipped.
+type ParseError struct {
+	Err  error
+	Span Span
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// TrailingTokenError is the error for a token that follows a complete
+// expression at the top level without a semicolon or newline between them.
+type TrailingTokenError struct {
+	Token *Token
+}
+
+func (e *TrailingTokenError) Error() string {
+	return fmt.Sprintf("unexpected token at end of expression: `%s`", e.Token.Text)
+}
+
+// recovery is the state of a parser in recovery mode, which it shares with
+// its clones.
+type recovery struct {
+	errors []*ParseError
+	last   *Token   // The last token that was consumed.
+	open   []*Token // The start and open delimiter tokens that are not yet closed.
+}
+
+// checkpoint is where an expression starts, so that a parser in recovery
+// mode can replace everything from there to the point of recovery with an
+// error node.
+type checkpoint struct {
+	start *Token
+	depth int // The number of tokens that were open.
 }
 
 func StringToParser(input string) *Parser {
@@ -132,16 +181,36 @@ func (p *Parser) Clone(fragile bool) *Parser {
 		tokenIterator: p.tokenIterator,
 		peeked:        p.peeked,
 		fragile:       fragile,
+		recovery:      p.recovery,
 	}
 }
 
+// EnableRecovery switches the parser to recovery mode. Instead of stopping
+// at the first error, the parser skips ahead to the next point where it can
+// carry on, leaves an error node in the tree in place of what it skipped and
+// collects the error, so that many errors can be reported at once.
+func (p *Parser) EnableRecovery() {
+	if p.recovery == nil {
+		p.recovery = &recovery{errors: []*ParseError{}}
+	}
+}
+
+// Errors returns the errors that the parser has recovered from, in the
+// order that they were found.
+func (p *Parser) Errors() []*ParseError {
+	if p.recovery == nil {
+		return nil
+	}
+	return p.recovery.errors
+}
+
 // PeekToken returns the next token without consuming it. If there are no more
 // tokens, it returns nil.
 func (p *Parser) PeekToken() *Token {
 	if !p.peeked.IsEmpty() {
 		return p.peeked.Peek()
 	}
-	token, _ := p.GetToken()
+	token, _ := p.tokenIterator.GetToken()
 
 	// May push nil if at end of input.
 	p.peeked.Push(token)
@@ -151,28 +220,145 @@ func (p *Parser) PeekToken() *Token {
 // DropPeekedToken removes the first token from the peeked list, effectively consuming it.
 // If there are no peeked tokens, it does nothing.
 func (p *Parser) DropPeekedToken() {
-	p.peeked.Pop()
+	p.consume(p.peeked.Pop())
 }
 
 func (p *Parser) GetToken() (*Token, error) {
 	if !p.peeked.IsEmpty() {
 		token := p.peeked.Pop()
+		p.consume(token)
 		return token, nil
 	}
-	return p.tokenIterator.GetToken()
+	token, err := p.tokenIterator.GetToken()
+	if err == nil {
+		p.consume(token)
+	}
+	return token, err
 }
 
-func (p *Parser) MustReadToken(expectedType TokenType, text string) (*Token, error) {
-	token, err := p.GetToken()
-	if err != nil {
-		return nil, err
+// consume keeps track of the tokens that a parser in recovery mode has
+// consumed, so that it knows what to skip when it meets an error.
+func (p *Parser) consume(token *Token) {
+	if p.recovery == nil || token == nil {
+		return
+	}
+	r := p.recovery
+	r.last = token
+	switch token.Type {
+	case StartTokenType, OpenDelimiterTokenType:
+		r.open = append(r.open, token)
+	case EndTokenType, CloseDelimiterTokenType:
+		if len(r.open) > 0 && closes(r.open[len(r.open)-1], token) {
+			r.open = r.open[:len(r.open)-1]
+		}
 	}
-	if token == nil || token.Type != expectedType || token.Text != text {
+}
+
+// closes returns true if a token is the closer of a start or open delimiter
+// token.
+func closes(opener *Token, token *Token) bool {
+	switch opener.Type {
+	case StartTokenType:
+		return token.Type == EndTokenType && slices.Contains(opener.ClosedBy, token.Text)
+	case OpenDelimiterTokenType:
+		return token.Type == CloseDelimiterTokenType && slices.Contains(opener.ClosedBy, token.Text)
+	}
+	return false
+}
+
+// checkpoint marks the start of the expression that the parser is about to
+// read.
+func (p *Parser) checkpoint() checkpoint {
+	cp := checkpoint{start: p.PeekToken()}
+	if p.recovery != nil {
+		cp.depth = len(p.recovery.open)
+	}
+	return cp
+}
+
+// recover records an error and skips ahead to where parsing can carry on,
+// returning an error node in place of the expression that started at the
+// checkpoint. The forms and brackets that were opened since the checkpoint
+// are skipped up to their closers, and after that the parser stops at a
+// semicolon, at the start of a new line, or at a bridge or end token that
+// belongs to the enclosing form, if there is one. The enclosing form is also
+// allowed to end inside a bracket that was never closed.
+func (p *Parser) recover(cp checkpoint, err error, form *Token) *Node {
+	r := p.recovery
+	cp.depth = min(cp.depth, len(r.open))
+	// An error node always covers at least one token, so that the parser
+	// cannot get stuck on a token that it cannot read.
+	progress := cp.start == nil || p.PeekToken() != cp.start
+	for {
+		token := p.PeekToken()
 		if token == nil {
-			return nil, fmt.Errorf("found end of input while expecting '%s'", text)
+			break
 		}
-		return nil, fmt.Errorf("found '%s' while expecting '%s' at line %d, column %d", token.Text, text, token.Span.StartLine, token.Span.StartColumn)
+		if open := r.open[cp.depth:]; len(open) == 0 {
+			if belongsTo(form, token) || (token.Type == MarkTokenType && token.Text == ";") {
+				break
+			}
+			// Nor does it skip the closer of a bracket that it is inside.
+			if cp.depth > 0 && closes(r.open[cp.depth-1], token) {
+				break
+			}
+			if progress && token.LnBefore != nil && *token.LnBefore {
+				break
+			}
+		} else if top := open[len(open)-1]; !closes(top, token) && belongsTo(form, token) && (top.Type == OpenDelimiterTokenType || token.Type == EndTokenType) {
+			break
+		}
+		p.DropPeekedToken()
+		progress = true
+	}
+	// Whatever is still open is part of the error node.
+	r.open = r.open[:cp.depth]
+
+	var span Span
+	if cp.start != nil {
+		span = cp.start.Span
+		if progress && p.recovery.last != nil {
+			span = *span.ToSpan(&p.recovery.last.Span)
+		}
+	}
+	p.recovery.errors = append(p.recovery.errors, &ParseError{Err: err, Span: span})
+	return &Node{
+		Name: NameError,
+		Options: map[string]string{
+			OptionMessage: err.Error(),
+		},
+		Span:     span,
+		Children: []*Node{},
 	}
+}
+
+// belongsTo returns true if a token is a bridge or end token of a form, in
+// which case recovery inside the form stops before it.
+func belongsTo(form *Token, token *Token) bool {
+	if form == nil {
+		return false
+	}
+	switch token.Type {
+	case BridgeTokenType:
+		return token.In == nil || slices.Contains(token.In, form.Text)
+	case EndTokenType:
+		return slices.Contains(form.ClosedBy, token.Text)
+	}
+	return false
+}
+
+// MustReadToken reads a token of the given type and text, which must come
+// next. A token that does not match is left unread, so that a parser in
+// recovery mode can carry on from it.
+func (p *Parser) MustReadToken(expectedType TokenType, text string) (*Token, error) {
+	token := p.PeekToken()
+	if token == nil {
+		return nil, fmt.Errorf("found end of input while expecting '%s'", text)
+	}
+	if token.Type != expectedType || token.Text != text {
+		return nil, SpanErrorf(token.Span, "found '%s' while expecting '%s'", token.Text, text)
+	}
+	p.DropPeekedToken()
 	return token, nil
 }
 
@@ -188,11 +374,11 @@ func (p *Parser) TryReadToken(expectedType TokenType, text string) *Token {
 	return nil
 }
 
+// MustReadOneOf reads a token of the given type whose text is one of
+// closedBy, which must come next. Like MustReadToken, it leaves a token that
+// does not match unread.
 func (p *Parser) MustReadOneOf(expectedType TokenType, closedBy []string) (*Token, error) {
-	token, err := p.GetToken()
-	if err != nil {
-		return nil, err
-	}
+	token := p.PeekToken()
 	if token == nil || token.Type != expectedType || !slices.Contains(closedBy, token.Text) {
 		expecting := ""
 		for _, ex := range closedBy {
@@ -204,8 +390,9 @@ func (p *Parser) MustReadOneOf(expectedType TokenType, closedBy []string) (*Toke
 		if token == nil {
 			return nil, fmt.Errorf("found end of input while expecting '%s'", expecting)
 		}
-		return nil, fmt.Errorf("found '%s' while expecting '%s' at line %d, column %d", token.Text, expecting, token.Span.StartLine, token.Span.StartColumn)
+		return nil, SpanErrorf(token.Span, "found '%s' while expecting '%s'", token.Text, expecting)
 	}
+	p.DropPeekedToken()
 	return token, nil
 }
 
@@ -252,7 +439,7 @@ func (p *Parser) DoReadPrimaryExpr(optional bool) (*Node, error) {
 			p.DropPeekedToken()
 			return p.ReadDelimited(token)
 		} else {
-			return nil, fmt.Errorf("unexpected start of expression, token '%s' at line %d, char %d", token.Text, token.Span.StartLine, token.Span.StartColumn)
+			return nil, SpanErrorf(token.Span, "unexpected start of expression, token '%s'", token.Text)
 		}
 	case PrefixTokenType:
 		p.DropPeekedToken()
@@ -264,7 +451,12 @@ func (p *Parser) DoReadPrimaryExpr(optional bool) (*Node, error) {
 		if optional {
 			return nil, nil
 		}
-		return nil, fmt.Errorf("unexpected end token '%s' at line %d, char %d", token.Text, token.Span.StartLine, token.Span.StartColumn)
+		return nil, SpanErrorf(token.Span, "unexpected end token '%s'", token.Text)
+	case BridgeTokenType:
+		if optional {
+			return nil, nil
+		}
+		return nil, SpanErrorf(token.Span, "unexpected token '%s'", token.Text)
 	case OperatorTokenType:
 		if token.PrefixPrecedence != nil && *token.PrefixPrecedence > 0 {
 			p.DropPeekedToken()
@@ -288,11 +480,11 @@ func (p *Parser) DoReadPrimaryExpr(optional bool) (*Node, error) {
 		if optional {
 			return nil, nil
 		}
-		return nil, fmt.Errorf("misplaced punctuation mark '%s' at line %d, char %d", token.Text, token.Span.StartLine, token.Span.StartColumn)
+		return nil, SpanErrorf(token.Span, "misplaced punctuation mark '%s'", token.Text)
 	case UnclassifiedTokenType:
-		return nil, fmt.Errorf("invalid token '%s' found at line %d, char %d", token.Text, token.Span.StartLine, token.Span.StartColumn)
+		return nil, SpanErrorf(token.Span, "invalid token '%s' found", token.Text)
 	case ExceptionTokenType:
-		return nil, fmt.Errorf("%s '%s' found at line %d, char %d", *token.Reason, token.Text, token.Span.StartLine, token.Span.StartColumn)
+		return nil, SpanErrorf(token.Span, "%s '%s' found", *token.Reason, token.Text)
 	}
 	return nil, fmt.Errorf("unimplemented, got token '%s' of type: %s", token.Text, token.Type)
 }
@@ -308,7 +500,7 @@ func ConvertStringTokenToNode(token *Token) (*Node, error) {
 	case "e":
 		return ConvertExpressionSubtoken(token)
 	}
-	return nil, fmt.Errorf("internal error, malformed string token for '%s' in string at line %d, char %d", token.Type, token.Span.StartLine, token.Span.StartColumn)
+	return nil, SpanErrorf(token.Span, "internal error, malformed string token for '%s' in string", token.Type)
 }
 
 func ConvertExpressionSubtoken(token *Token) (*Node, error) {
@@ -319,9 +511,35 @@ func ConvertExpressionSubtoken(token *Token) (*Node, error) {
 	// Now we pipe *subtoken.Value through nutmeg-tokenizer to generate the output as a string.
 	output, perr := PipeThroughNutmegTokenizer(valueString)
 	if perr != nil {
-		return nil, fmt.Errorf("error tokenizing interpolated string at line %d, char %d: %s", token.Span.StartLine, token.Span.StartColumn, perr.Error())
+		return nil, SpanErrorf(token.Span, "error tokenizing interpolated string: %s", perr.Error())
+	}
+	node, err := StringToParser(output).MustReadExpr()
+	if err != nil {
+		return nil, err
+	}
+	// The expression was parsed on its own, so its spans are relative to the
+	// start of the subtoken rather than to the start of the file.
+	shiftSpans(node, token.Span.StartLine, token.Span.StartColumn)
+	return node, nil
+}
+
+// shiftSpans moves the spans of a tree, which start at line 1, column 1, so
+// that they start at the given line and column instead. Only the first line
+// is shifted sideways.
+func shiftSpans(node *Node, line, column int) {
+	shift := func(l, c int) (int, int) {
+		if l == 1 {
+			return line, column + c - 1
+		}
+		return line + l - 1, c
+	}
+	if node.Span != (Span{}) {
+		node.Span.StartLine, node.Span.StartColumn = shift(node.Span.StartLine, node.Span.StartColumn)
+		node.Span.EndLine, node.Span.EndColumn = shift(node.Span.EndLine, node.Span.EndColumn)
+	}
+	for _, child := range node.Children {
+		shiftSpans(child, line, column)
 	}
-	return StringToParser(output).MustReadExpr()
 }
 
 func ConvertPlainStringToken(token *Token) (*Node, error) {
@@ -412,7 +630,7 @@ func (p *Parser) ReadSurroundForm(token *Token) (*Node, error) {
 	}
 
 	// Read expressions for the start token
-	if err := p.readPartExpressions(currentPart, token.Arity); err != nil {
+	if err := p.readPartExpressions(currentPart, token.Arity, token); err != nil {
 		return nil, err
 	}
 
@@ -422,7 +640,7 @@ func (p *Parser) ReadSurroundForm(token *Token) (*Node, error) {
 	for {
 		nextToken := p.PeekToken()
 		if nextToken == nil {
-			return nil, fmt.Errorf("unexpected end of input inside '%s' on line %d, char %d", token.Text, token.Span.StartLine, token.Span.StartColumn)
+			return nil, SpanErrorf(token.Span, "unexpected end of input inside '%s'", token.Text)
 		}
 
 		// Check if it's an end token
@@ -433,7 +651,7 @@ func (p *Parser) ReadSurroundForm(token *Token) (*Node, error) {
 				form.Span = *token.Span.ToSpan(&nextToken.Span)
 				return form, nil
 			}
-			return nil, fmt.Errorf("encountered '%s' unexpectedly early at line %d, column %d, while parsing '%s'", nextToken.Text, nextToken.Span.StartLine, nextToken.Span.StartColumn, token.Text)
+			return nil, SpanErrorf(nextToken.Span, "encountered '%s' unexpectedly early while parsing '%s'", nextToken.Text, token.Text)
 		}
 
 		// Check if it's a bridge token
@@ -447,12 +665,12 @@ func (p *Parser) ReadSurroundForm(token *Token) (*Node, error) {
 			}
 			if token.Expecting != nil && !slices.Contains(token.Expecting, text) {
 				expecting_text := token.ExpectingMessage(nextToken.Text)
-				return nil, fmt.Errorf("unexpected token '%s' at line %d, column %d, but expecting %s", nextToken.Text, nextToken.Span.StartLine, nextToken.Span.StartColumn, expecting_text)
+				return nil, SpanErrorf(nextToken.Span, "unexpected token '%s', but expecting %s", nextToken.Text, expecting_text)
 			}
 
 			// Verify the bridge token is valid for this form
 			if nextToken.In != nil && !slices.Contains(nextToken.In, token.Text) {
-				return nil, fmt.Errorf("misplaced token '%s' is not valid inside '%s' at line %d, column %d", nextToken.Text, token.Text, nextToken.Span.StartLine, nextToken.Span.StartColumn)
+				return nil, SpanErrorf(nextToken.Span, "misplaced token '%s' is not valid inside '%s'", nextToken.Text, token.Text)
 			}
 
 			p.DropPeekedToken()
@@ -468,7 +686,7 @@ func (p *Parser) ReadSurroundForm(token *Token) (*Node, error) {
 			}
 
 			// Read expressions for the bridge token
-			if err := p.readPartExpressions(bridgePart, nextToken.Arity); err != nil {
+			if err := p.readPartExpressions(bridgePart, nextToken.Arity, token); err != nil {
 				return nil, err
 			}
 
@@ -478,13 +696,14 @@ func (p *Parser) ReadSurroundForm(token *Token) (*Node, error) {
 			token.Expecting = nextToken.Expecting
 		} else {
 			expecting := token.ExpectingMessage(nextToken.Text)
-			return nil, fmt.Errorf("found '%s' at line %d, column %d but expecting %s", nextToken.Text, nextToken.Span.StartLine, nextToken.Span.StartColumn, expecting)
+			return nil, SpanErrorf(nextToken.Span, "found '%s' but expecting %s", nextToken.Text, expecting)
 		}
 	}
 }
 
 // Helper method to read expressions for a part (either single or multiple)
-func (p *Parser) readPartExpressions(part *Node, arity *Arity) error {
+// of a form.
+func (p *Parser) readPartExpressions(part *Node, arity *Arity, form *Token) error {
 	var a Arity
 	if arity != nil {
 		a = *arity
@@ -515,17 +734,32 @@ func (p *Parser) readPartExpressions(part *Node, arity *Arity) error {
 				break
 			}
 
-			// Stop if we hit a bridge or end token
+			// Stop if we hit a bridge or end token. In recovery mode only
+			// the ones that belong to this form stop it and the others are
+			// errors.
+			cp := p.checkpoint()
 			if nextToken.Type == BridgeTokenType || nextToken.Type == EndTokenType {
-				break
+				if p.recovery == nil || belongsTo(form, nextToken) {
+					break
+				}
+				err := SpanErrorf(nextToken.Span, "misplaced token '%s' is not valid inside '%s'", nextToken.Text, form.Text)
+				part.Children = append(part.Children, p.recover(cp, err, form))
+				continue
 			}
 
 			expr, err := p.TryReadExpr()
 			if err != nil {
-				return err
+				if p.recovery == nil {
+					return err
+				}
+				expr = p.recover(cp, err, form)
 			}
 			if expr == nil {
-				break
+				if p.recovery == nil {
+					break
+				}
+				err := SpanErrorf(nextToken.Span, "found '%s' but expected expression", nextToken.Text)
+				expr = p.recover(cp, err, form)
 			}
 			part.Children = append(part.Children, expr)
 
@@ -536,18 +770,29 @@ func (p *Parser) readPartExpressions(part *Node, arity *Arity) error {
 
 				// No semicolon found, check if next token is bridge/end token
 				if nextToken != nil && (nextToken.Type == BridgeTokenType || nextToken.Type == EndTokenType) {
-					// End of expressions, break out
-					break
+					// End of expressions, unless recovering from a misplaced token
+					if p.recovery == nil || belongsTo(form, nextToken) {
+						break
+					}
+					continue
 				}
 				// If there's another expression coming but no semicolon, that's an error
 				if nextToken != nil {
-					return fmt.Errorf("found '%s' but expected semicolon between expressions at line %d, column %d", nextToken.Text, nextToken.Span.StartLine, nextToken.Span.StartColumn)
+					err := SpanErrorf(nextToken.Span, "found '%s' but expected semicolon between expressions", nextToken.Text)
+					if p.recovery == nil {
+						return err
+					}
+					part.Children = append(part.Children, p.recover(p.checkpoint(), err, form))
+					if !p.TryReadSemiColon() {
+						continue
+					}
+				} else {
+					break
 				}
-				break
 			}
 			// Semicolon found, continue to next expression or check for termination
 			nextToken = p.PeekToken()
-			if nextToken != nil && (nextToken.Type == BridgeTokenType || nextToken.Type == EndTokenType) {
+			if nextToken != nil && (nextToken.Type == BridgeTokenType || nextToken.Type == EndTokenType) && (p.recovery == nil || belongsTo(form, nextToken)) {
 				// Optional trailing semicolon before bridge/end token
 				break
 			}
@@ -573,6 +818,43 @@ func (p *Parser) TryReadSemiColon() bool {
 	return is_semicolon
 }
 
+// ReadStatements reads the expressions at the top level of a unit, which are
+// separated by semicolons or newlines, up to the end of input. It returns the
+// expressions read before the first error, unless the parser is in recovery
+// mode, when it reads past errors and never returns one.
+func (p *Parser) ReadStatements() ([]*Node, error) {
+	nodes := []*Node{}
+	for {
+		cp := p.checkpoint()
+		if cp.start == nil {
+			return nodes, nil
+		}
+		node, err := p.TryReadExpr()
+		if err == nil && node == nil {
+			err = SpanErrorf(cp.start.Span, "unexpected token '%s'", cp.start.Text)
+		}
+		if err != nil {
+			if p.recovery == nil {
+				return nodes, err
+			}
+			node = p.recover(cp, err, nil)
+		}
+		nodes = append(nodes, node)
+		if !p.TryReadSemiColon() {
+			token := p.PeekToken()
+			if token == nil {
+				return nodes, nil
+			}
+			err := &TrailingTokenError{Token: token}
+			if p.recovery == nil {
+				return nodes, err
+			}
+			nodes = append(nodes, p.recover(p.checkpoint(), err, nil))
+			p.TryReadSemiColon()
+		}
+	}
+}
+
 func (p *Parser) ReadPrefixForm(token *Token) (*Node, error) {
 	form := &Node{
 		Name: NameForm,
@@ -599,7 +881,7 @@ func (p *Parser) ReadPrefixForm(token *Token) (*Node, error) {
 			return nil, err
 		}
 		if operand == nil {
-			return nil, fmt.Errorf("expected expression after prefix operator '%s' at line %d, char %d", token.Text, token.Span.StartLine, token.Span.StartColumn)
+			return nil, SpanErrorf(token.Span, "expected expression after prefix operator '%s'", token.Text)
 		}
 		part := &Node{
 			Name: NamePart,
@@ -611,7 +893,7 @@ func (p *Parser) ReadPrefixForm(token *Token) (*Node, error) {
 		}
 		form.Children = append(form.Children, part)
 	} else if token.Arity != nil && *token.Arity == Many {
-		return nil, fmt.Errorf("unimplemented: prefix form with many arity for token '%s' at line %d, char %d", token.Text, token.Span.StartLine, token.Span.StartColumn)
+		return nil, SpanErrorf(token.Span, "unimplemented: prefix form with many arity for token '%s'", token.Text)
 	}
 	return form, nil
 }
@@ -676,7 +958,7 @@ func (p *Parser) DoReadExprPrec(outerPrec int, optional bool) (*Node, error) {
 					var made_progress bool
 					lhs, made_progress = p.doReadExprPostfixPrec(op, outerPrec, lhs, true)
 					if !made_progress {
-						return nil, fmt.Errorf("expected expression after operator '%s' at line %d, char %d", op.Text, op.Span.StartLine, op.Span.StartColumn)
+						return nil, SpanErrorf(op.Span, "expected expression after operator '%s'", op.Text)
 					}
 				} else {
 					lhs = &Node{
@@ -690,7 +972,7 @@ func (p *Parser) DoReadExprPrec(outerPrec int, optional bool) (*Node, error) {
 					}
 				}
 			default:
-				return nil, fmt.Errorf("unexpected token at start of an expression'%s' at line %d, char %d", op.Text, op.Span.StartLine, op.Span.StartColumn)
+				return nil, SpanErrorf(op.Span, "unexpected token at start of an expression'%s'", op.Text)
 			}
 		} else {
 			var made_progress bool
@@ -746,15 +1028,19 @@ func (p *Parser) ReadNumber(token *Token) (*Node, error) {
 	if token.Exponent != nil {
 		exponent = *token.Exponent
 	}
+	options := map[string]string{
+		"base":     fmt.Sprintf("%d", base),
+		"mantissa": mantissa,
+		"fraction": fraction,
+		"exponent": fmt.Sprintf("%d", exponent),
+		"sign":     "+",
+	}
+	if token.Balanced != nil && *token.Balanced {
+		options[OptionBalanced] = ValueTrue
+	}
 	return &Node{
-		Name: NameNumber,
-		Options: map[string]string{
-			"base":     fmt.Sprintf("%d", base),
-			"mantissa": mantissa,
-			"fraction": fraction,
-			"exponent": fmt.Sprintf("%d", exponent),
-			"sign":     "+",
-		},
+		Name:     NameNumber,
+		Options:  options,
 		Span:     token.Span,
 		Children: []*Node{},
 	}, nil
@@ -802,7 +1088,7 @@ func (p *Parser) ReadBracketedExprs(token *Token, name string) (*Node, error) {
 			return nil, err
 		}
 		if node == nil {
-			return nil, fmt.Errorf("expected expression in delimited starting at line %d, char %d", token.Span.StartLine, token.Span.StartColumn)
+			return nil, SpanErrorf(token.Span, "expected expression after '%s'", token.Text)
 		}
 		result.Children = append(result.Children, node)
 	}
@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/nutport"
+)
+
+// OpenBundle opens a bundle, creating it with the current schema if it does
+// not exist. An existing bundle with an older schema is an error, because
+// migrating it is left to nutmeg-bundler --migrate.
+func OpenBundle(bundleFile string) (*bundler.Bundler, error) {
+	// Check if the bundle file exists.
+	_, err := os.Stat(bundleFile)
+	fileExists := err == nil
+
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundler: %w", err)
+	}
+	upToDate, err := b.CheckMigration()
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("failed to check migration status: %w", err)
+	}
+	if !upToDate {
+		if fileExists {
+			b.Close()
+			return nil, fmt.Errorf("database schema is not up to date. Please run migration separately")
+		}
+		// Fresh database - auto-migrate.
+		if err := b.Migrate(); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// Bundle adds the generated units to the bundle given in the options, along
+// with the text of their source files. When the units are a project, files
+// that were bundled from the project before but are no longer part of it are
+// removed. The units are committed all at once, so that a failure leaves the
+// bundle as it was.
+func (p *Pipeline) Bundle(units []*Unit) []Diagnostic {
+	fail := func(file string, err error) []Diagnostic {
+		return []Diagnostic{newError(file, PhaseBundle, CodeBundleError, err)}
+	}
+	b, err := OpenBundle(p.opts.Bundle)
+	if err != nil {
+		return fail(p.opts.Bundle, err)
+	}
+	defer b.Close()
+	b.SetSymbolic(p.opts.Symbolic)
+
+	removed, err := removedSources(b, units)
+	if err != nil {
+		return fail(p.opts.Bundle, err)
+	}
+	if err := b.Begin(); err != nil {
+		return fail(p.opts.Bundle, err)
+	}
+	for _, fileName := range removed {
+		if err := b.RemoveSourceFile(fileName); err != nil {
+			_ = b.Rollback()
+			return fail(fileName, err)
+		}
+	}
+	for _, unit := range units {
+		if err := b.ProcessUnit(unit.Generated); err != nil {
+			_ = b.Rollback()
+			unit.Failed = true
+			return fail(unit.Source.SrcPath, fmt.Errorf("failed to process unit %s: %w", unit.Generated.Options[common.OptionSrc], err))
+		}
+		if err := b.AddSourceFile(unit.Source.SrcPath, unit.Source.Contents, p.sourceHash(unit.Source)); err != nil {
+			_ = b.Rollback()
+			return fail(unit.Source.SrcPath, err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		return fail(p.opts.Bundle, err)
+	}
+	return nil
+}
+
+// removedSources returns the files of a project that are in the bundle but
+// are no longer among the units, because they have been deleted. A file
+// belongs to the project if it lies inside a module, just as DiscoverProject
+// decides. A lone file is not a project, so nothing is removed for one.
+func removedSources(b *bundler.Bundler, units []*Unit) ([]string, error) {
+	if len(units) == 0 || units[0].Source.Module == "" {
+		return nil, nil
+	}
+	current := make(map[string]bool)
+	for _, unit := range units {
+		current[unit.Source.SrcPath] = true
+	}
+	names, err := b.LoadSourceFileNames()
+	if err != nil {
+		return nil, err
+	}
+	removed := make([]string, 0)
+	for _, name := range names {
+		if !current[name] && ModuleNameForPath(filepath.FromSlash(name)) != "" {
+			removed = append(removed, name)
+		}
+	}
+	return removed, nil
+}
+
+// fingerprint returns a hash of the options that affect the code generated
+// for a source file, together with the format that it is bundled in.
+func fingerprint(opts Options) (string, error) {
+	data, err := json.Marshal(struct {
+		Versions      [2]int
+		TokenRules    any
+		RewriteConfig any
+		Builtins      any
+		SkipOptional  bool
+		NoOptimize    bool
+		Symbolic      bool
+	}{
+		[2]int{bundler.SymbolicVersion, bundler.AssembledVersion},
+		opts.TokenRules, opts.RewriteConfig, opts.Builtins,
+		opts.SkipOptional, opts.NoOptimize, opts.Symbolic,
+	})
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting the options: %w", err)
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// sourceHash returns a hash of everything that the code generated for a
+// source file depends on, apart from the other files of its project: the
+// options, the text of the file, its module and the imports of the module.
+// It returns the empty string if the imports cannot be read.
+func (p *Pipeline) sourceHash(source *SourceFile) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%q\n%q\n%q\n", p.fingerprint, source.SrcPath, source.Module, source.Contents)
+	if source.Module != "" {
+		imports, err := os.ReadFile(filepath.Join(source.ModuleDir, nutport.FileName)) // #nosec G304 - the module's own imports file
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return ""
+		}
+		fmt.Fprintf(h, "%q\n", imports)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// unchanged returns true if every unit has been bundled before from the
+// same source and options, and no file has been removed from the project
+// since. A bundle that does not exist yet, or cannot be opened, holds
+// nothing, and the problem is left for Bundle to report.
+func (p *Pipeline) unchanged(units []*Unit) bool {
+	if _, err := os.Stat(p.opts.Bundle); err != nil {
+		return false
+	}
+	b, err := OpenBundle(p.opts.Bundle)
+	if err != nil {
+		return false
+	}
+	defer b.Close()
+	if removed, err := removedSources(b, units); err != nil || len(removed) > 0 {
+		return false
+	}
+	for _, unit := range units {
+		previous, err := b.SourceHash(unit.Source.SrcPath)
+		if err != nil || previous == "" || previous != p.sourceHash(unit.Source) {
+			return false
+		}
+	}
+	return true
+}
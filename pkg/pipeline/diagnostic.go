@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/parser"
+)
+
+// Severity says how serious a diagnostic is. Only errors stop compilation.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Phase names the phase of the pipeline that reported a diagnostic. The
+// phases are listed in the order that they run.
+type Phase string
+
+const (
+	PhaseInput    Phase = "input"
+	PhaseTokenize Phase = "tokenization"
+	PhaseParse    Phase = "parse"
+	PhaseCheck    Phase = "syntax"
+	PhaseRewrite  Phase = "rewrite"
+	PhaseModules  Phase = "module"
+	PhaseResolve  Phase = "resolution"
+	PhaseGenerate Phase = "code generation"
+	PhaseBundle   Phase = "bundle"
+)
+
+var phaseOrder = []Phase{
+	PhaseInput, PhaseTokenize, PhaseParse, PhaseCheck, PhaseRewrite,
+	PhaseModules, PhaseResolve, PhaseGenerate, PhaseBundle,
+}
+
+// before returns true if phase p runs before phase q.
+func (p Phase) before(q Phase) bool {
+	index := func(phase Phase) int {
+		for i, each := range phaseOrder {
+			if each == phase {
+				return i
+			}
+		}
+		return len(phaseOrder)
+	}
+	return index(p) < index(q)
+}
+
+// Code identifies the kind of a diagnostic, so that tools can act on it
+// without matching the text of the message.
+type Code string
+
+const (
+	CodeUnreadable    Code = "unreadable"     // The source could not be read.
+	CodeInvalidToken  Code = "invalid-token"  // The tokenizer rejected the source.
+	CodeParseError    Code = "parse-error"    // The parser rejected the tokens.
+	CodeTrailingToken Code = "trailing-token" // An expression was followed by something other than a semicolon.
+	CodeSyntaxError   Code = "syntax-error"   // The checker rejected the tree.
+	CodeParserBug     Code = "parser-bug"     // The checker found a tree the parser should never produce.
+	CodeErrorNode     Code = "error-node"     // A later phase was given a tree with an error node left by the parser.
+	CodeRewriteError  Code = "rewrite-error"  // The rewriter could not be set up.
+	CodeModuleError   Code = "module-error"   // The modules of a project do not fit together.
+	CodeResolveError  Code = "resolve-error"  // An identifier could not be resolved.
+	CodeCodegenError  Code = "codegen-error"  // Code could not be generated.
+	CodeBundleError   Code = "bundle-error"   // The bundle could not be written.
+)
+
+// Diagnostic is a problem found while compiling. The span is zero when the
+// problem does not have a location, for example when a file cannot be read.
+type Diagnostic struct {
+	File     string      `json:"file,omitempty"`
+	Span     common.Span `json:"span"`
+	Severity Severity    `json:"severity"`
+	Phase    Phase       `json:"phase"`
+	Code     Code        `json:"code"`
+	Message  string      `json:"message"`
+}
+
+// String formats the diagnostic in the FILE:LINE:COLUMN style that editors
+// recognise, leaving out the parts that are not known.
+func (d Diagnostic) String() string {
+	location := make([]string, 0, 3)
+	if d.File != "" {
+		location = append(location, d.File)
+	}
+	if d.Span.StartLine > 0 {
+		location = append(location, strconv.Itoa(d.Span.StartLine), strconv.Itoa(d.Span.StartColumn))
+	}
+	prefix := ""
+	if len(location) > 0 {
+		prefix = strings.Join(location, ":") + ": "
+	}
+	return fmt.Sprintf("%s%s %s: %s", prefix, d.Phase, d.Severity, d.Message)
+}
+
+// WriteDiagnostics writes the diagnostics one per line.
+func WriteDiagnostics(w io.Writer, diagnostics []Diagnostic) {
+	for _, d := range diagnostics {
+		fmt.Fprintln(w, d)
+	}
+}
+
+// HasErrors returns true if any of the diagnostics is an error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// locationPattern matches a location in the text of an error, which is
+// written as either "line L, column C" or "line L, char C".
+var locationPattern = regexp.MustCompile(`line (\d+), (?:column|char) (\d+)`)
+
+// newError makes an error diagnostic from an error returned by a phase. The
+// span is that of the first error in the chain that has one, which is either
+// a common.SpanError or a parser.ParseError.
+func newError(file string, phase Phase, code Code, err error) Diagnostic {
+	d := Diagnostic{File: file, Severity: SeverityError, Phase: phase, Code: code, Message: err.Error()}
+	var spanErr *common.SpanError
+	var parseErr *parser.ParseError
+	switch {
+	case errors.As(err, &spanErr):
+		d.Span = spanErr.Span
+	case errors.As(err, &parseErr):
+		d.Span = parseErr.Span
+	default:
+		// The span is only taken from the text as a last resort, for an
+		// error that no phase attached a span to but that mentions where
+		// the problem is all the same.
+		if match := locationPattern.FindStringSubmatch(d.Message); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			column, _ := strconv.Atoi(match[2])
+			d.Span = common.Span{StartLine: line, StartColumn: column, EndLine: line, EndColumn: column}
+		}
+	}
+	return d
+}
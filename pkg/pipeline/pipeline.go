@@ -0,0 +1,451 @@
+// Package pipeline runs the phases of the Nutmeg compiler: tokenization,
+// parsing, syntax checking, rewriting, resolution, code generation and
+// bundling. It keeps the tree produced by each phase and reports problems as
+// diagnostics rather than printing them, so that the compiler can be embedded
+// in other tools. The command line tools are thin wrappers around it.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spicery/nutmeg-compiler/pkg/builtins"
+	"github.com/spicery/nutmeg-compiler/pkg/checker"
+	"github.com/spicery/nutmeg-compiler/pkg/codegen"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/parser"
+	"github.com/spicery/nutmeg-compiler/pkg/resolver"
+	"github.com/spicery/nutmeg-compiler/pkg/rewriter"
+	"github.com/spicery/nutmeg-compiler/pkg/tokenizer"
+)
+
+// Options configure a pipeline. The zero value compiles with the default
+// rules and builtins and does not write a bundle.
+type Options struct {
+	TokenRules    *tokenizer.TokenizerRules // The tokenizer rules, or nil for the defaults.
+	Trivia        bool                      // Attach the whitespace and comments to the tokens.
+	RewriteConfig *rewriter.RewriteConfig   // The rewrite rules, or nil for the defaults.
+	Builtins      *builtins.Registry        // The registry of builtins, or nil for the defaults.
+	SkipOptional  bool                      // Skip the optional rewrite passes.
+	NoOptimize    bool                      // Skip the peephole optimizer.
+	Symbolic      bool                      // Store functions with labels instead of assembling them.
+	Debug         bool                      // Report progress on stderr.
+	StopAfter     Phase                     // The last phase to run, or empty to run them all.
+	Bundle        string                    // The bundle that the units are added to, or empty for none.
+}
+
+// LoadFiles sets the tokenizer rules, rewrite rules and builtins from the
+// YAML files that configure them. Empty file names are skipped.
+func (o *Options) LoadFiles(tokenRulesFile, rewriteRulesFile, builtinsFile string) error {
+	if tokenRulesFile != "" {
+		rules, err := tokenizer.LoadRulesFile(tokenRulesFile)
+		if err != nil {
+			return fmt.Errorf("loading token rules file '%s': %w", tokenRulesFile, err)
+		}
+		o.TokenRules, err = tokenizer.ApplyRulesToDefaults(rules)
+		if err != nil {
+			return fmt.Errorf("applying token rules: %w", err)
+		}
+	}
+	if rewriteRulesFile != "" {
+		config, err := rewriter.LoadRewriteConfig(rewriteRulesFile)
+		if err != nil {
+			return fmt.Errorf("loading rewrite configuration file: %w", err)
+		}
+		o.RewriteConfig = config
+	}
+	if builtinsFile != "" {
+		registry, err := builtins.Load(builtinsFile)
+		if err != nil {
+			return fmt.Errorf("loading builtins file '%s': %w", builtinsFile, err)
+		}
+		o.Builtins = registry
+	}
+	return nil
+}
+
+// Pipeline holds the configuration shared by every file that is compiled.
+type Pipeline struct {
+	opts        Options
+	fingerprint string // A hash of the options, see sourceHash.
+}
+
+// New creates a pipeline, filling in the default rewrite rules and builtins
+// where the options do not give them.
+func New(opts Options) (*Pipeline, error) {
+	if opts.RewriteConfig == nil {
+		config, err := rewriter.LoadRewriteConfigFromString(rewriter.DefaultRewriteRules)
+		if err != nil {
+			return nil, fmt.Errorf("loading default rewrite rules: %w", err)
+		}
+		opts.RewriteConfig = config
+	}
+	if opts.Builtins == nil {
+		opts.Builtins = builtins.Default()
+	}
+	fp, err := fingerprint(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{opts: opts, fingerprint: fp}, nil
+}
+
+// Unit is a source file as it passes through the pipeline, with a copy of
+// the tree after each phase. The trees of phases that did not run are nil.
+type Unit struct {
+	Source    *SourceFile
+	Tokens    []*common.Token
+	Parsed    *common.Node // After parsing and syntax checking, with error nodes if parsing failed.
+	Rewritten *common.Node
+	Resolved  *common.Node
+	Generated *common.Node // Ready for bundling.
+	Failed    bool         // Whether any phase reported an error for this unit.
+	Skipped   bool         // Whether the unit was not compiled because it is already bundled.
+}
+
+// Result is the outcome of compiling a group of source files.
+type Result struct {
+	Units       []*Unit
+	Diagnostics []Diagnostic
+}
+
+// OK returns true if compilation succeeded, which it does unless there are
+// errors among the diagnostics.
+func (r *Result) OK() bool {
+	return !HasErrors(r.Diagnostics)
+}
+
+// Failed returns the number of units that failed to compile.
+func (r *Result) Failed() int {
+	failed := 0
+	for _, unit := range r.Units {
+		if unit.Failed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Skipped returns true if there were units and none of them was compiled,
+// because they were all bundled before.
+func (r *Result) Skipped() bool {
+	for _, unit := range r.Units {
+		if !unit.Skipped {
+			return false
+		}
+	}
+	return len(r.Units) > 0
+}
+
+// runs returns true if the options allow the phase to run.
+func (p *Pipeline) runs(phase Phase) bool {
+	return p.opts.StopAfter == "" || !p.opts.StopAfter.before(phase)
+}
+
+// Compile runs the sources through the pipeline. Every file is parsed and
+// rewritten, collecting the diagnostics of them all, before the files are
+// resolved against each other and code is generated for them. Nothing is
+// written to the bundle if any file fails to compile. The text of each
+// source is filled in as it is read.
+//
+// If every source has been bundled before from the same text and options
+// then none of them is compiled again and the units are marked as skipped.
+// The files are all compiled together otherwise, because each one is
+// resolved against the definitions of the others.
+func (p *Pipeline) Compile(sources []SourceFile) *Result {
+	result := &Result{Units: make([]*Unit, len(sources))}
+	report := func(unit *Unit, diagnostics []Diagnostic) {
+		result.Diagnostics = append(result.Diagnostics, diagnostics...)
+		if HasErrors(diagnostics) {
+			unit.Failed = true
+		}
+	}
+
+	for i := range sources {
+		unit := &Unit{Source: &sources[i]}
+		result.Units[i] = unit
+		report(unit, readSource(unit.Source))
+	}
+	if result.OK() && p.bundles() && p.unchanged(result.Units) {
+		for _, unit := range result.Units {
+			unit.Skipped = true
+		}
+		return result
+	}
+
+	// Phases 1 to 4: parse every file, collecting the failures.
+	for _, unit := range result.Units {
+		if !unit.Failed {
+			report(unit, p.parseUnit(unit))
+		}
+	}
+	if !result.OK() || !p.runs(PhaseModules) {
+		return result
+	}
+
+	// Phases 5 and 6: resolve and generate code once all the modules are known.
+	trees := make([]*common.Node, len(sources))
+	for i, unit := range result.Units {
+		trees[i] = unit.Rewritten
+	}
+	modules, err := LoadModules(sources, trees)
+	if err != nil {
+		result.Diagnostics = append(result.Diagnostics, newError("", PhaseModules, CodeModuleError, err))
+		return result
+	}
+	for _, unit := range result.Units {
+		report(unit, p.generateUnit(unit, modules))
+	}
+	if !result.OK() || !p.bundles() {
+		return result
+	}
+
+	// Phase 7: Bundling.
+	result.Diagnostics = append(result.Diagnostics, p.Bundle(result.Units)...)
+	return result
+}
+
+// bundles returns true if the units are to be added to a bundle.
+func (p *Pipeline) bundles() bool {
+	return p.opts.Bundle != "" && p.runs(PhaseBundle)
+}
+
+// readSource fills in the text of a source file, unless it was supplied.
+func readSource(source *SourceFile) []Diagnostic {
+	if source.Contents == "" && source.Path != "" {
+		contents, err := os.ReadFile(source.Path) // #nosec G304 - reads the source files it was given
+		if err != nil {
+			return []Diagnostic{newError(source.SrcPath, PhaseInput, CodeUnreadable, err)}
+		}
+		source.Contents = string(contents)
+	}
+	return nil
+}
+
+// parseUnit tokenizes, parses, checks and rewrites a unit, stopping at the
+// first phase that fails.
+func (p *Pipeline) parseUnit(unit *Unit) []Diagnostic {
+	source := unit.Source
+	tokens, diagnostics := p.Tokenize(source.SrcPath, source.Contents)
+	unit.Tokens = tokens
+	if HasErrors(diagnostics) || !p.runs(PhaseParse) {
+		return diagnostics
+	}
+	tree, diagnostics := p.Parse(source, tokens)
+	if p.runs(PhaseCheck) {
+		// The checker skips the error nodes that the parser leaves, so the
+		// syntax errors in the rest of the file are reported as well.
+		diagnostics = append(diagnostics, p.Check(tree)...)
+	}
+	unit.Parsed = tree
+	if HasErrors(diagnostics) || !p.runs(PhaseRewrite) {
+		return diagnostics
+	}
+	unit.Rewritten, diagnostics = p.Rewrite(tree.Clone())
+	return diagnostics
+}
+
+// generateUnit resolves and generates code for a unit that has been
+// rewritten, keeping a copy of the tree after each phase.
+func (p *Pipeline) generateUnit(unit *Unit, modules resolver.ModuleTable) []Diagnostic {
+	tree := unit.Rewritten.Clone()
+	if diagnostics := p.Resolve(tree, unit.Source.Module, modules); HasErrors(diagnostics) {
+		return diagnostics
+	}
+	unit.Resolved = tree
+	if !p.runs(PhaseGenerate) {
+		return nil
+	}
+	tree = tree.Clone()
+	if diagnostics := p.Generate(tree); HasErrors(diagnostics) {
+		return diagnostics
+	}
+	unit.Generated = tree
+	return nil
+}
+
+// Tokenize splits the text of a file into tokens. If the text cannot be
+// tokenized then the tokens up to the problem are returned, followed by an
+// exception token, along with an error.
+func (p *Pipeline) Tokenize(file string, text string) ([]*common.Token, []Diagnostic) {
+	var t *tokenizer.Tokenizer
+	if p.opts.TokenRules != nil {
+		t = tokenizer.NewTokenizerWithRules(text, p.opts.TokenRules)
+	} else {
+		t = tokenizer.NewTokenizer(text)
+	}
+	if p.opts.Trivia {
+		t.EnableTrivia()
+	}
+	tokens, err := t.Tokenize()
+	if err != nil {
+		return tokens, []Diagnostic{newError(file, PhaseTokenize, CodeInvalidToken, err)}
+	}
+	return tokens, nil
+}
+
+// Parse reads the tokens of a file into a unit node, whose children are the
+// top-level expressions. The parser recovers from errors, so every parse
+// error in the file is reported and the tree has an error node in place of
+// the tokens that were skipped after each one.
+func (p *Pipeline) Parse(source *SourceFile, tokens []*common.Token) (*common.Node, []Diagnostic) {
+	tree := &common.Node{
+		Name:     common.NameUnit,
+		Options:  map[string]string{},
+		Children: []*common.Node{},
+	}
+	if source.SrcPath != "" {
+		tree.Options[common.OptionSrc] = source.SrcPath
+	}
+	if source.Module != "" {
+		tree.Options[common.OptionModule] = source.Module
+	}
+
+	ps := parser.NewParserFromTokens(tokens, true)
+	ps.EnableRecovery()
+	// In recovery mode the errors are collected rather than returned.
+	nodes, _ := ps.ReadStatements()
+	for _, node := range nodes {
+		if len(tree.Children) == 0 {
+			tree.Span = node.Span
+		} else {
+			tree.Span = *tree.Span.ToSpan(&node.Span)
+		}
+		tree.Children = append(tree.Children, node)
+	}
+	var diagnostics []Diagnostic
+	for _, perr := range ps.Errors() {
+		diagnostics = append(diagnostics, parseDiagnostic(source.SrcPath, perr))
+	}
+	return tree, diagnostics
+}
+
+// parseDiagnostic makes a diagnostic from an error that the parser recovered
+// from.
+func parseDiagnostic(file string, perr *parser.ParseError) Diagnostic {
+	var trailing *parser.TrailingTokenError
+	if errors.As(perr, &trailing) {
+		return Diagnostic{
+			File:     file,
+			Span:     trailing.Token.Span,
+			Severity: SeverityError,
+			Phase:    PhaseParse,
+			Code:     CodeTrailingToken,
+			Message:  perr.Error(),
+		}
+	}
+	return newError(file, PhaseParse, CodeParseError, perr)
+}
+
+// Check validates a parsed tree against the syntactic rules that the parser
+// does not enforce. Each problem is a separate diagnostic.
+func (p *Pipeline) Check(tree *common.Node) []Diagnostic {
+	ch := checker.NewChecker()
+	if ch.Check(tree) {
+		return nil
+	}
+	file := tree.Options[common.OptionSrc]
+	diagnostics := make([]Diagnostic, 0, len(ch.Bugs)+len(ch.Issues))
+	add := func(code Code, message string, node *common.Node) {
+		d := Diagnostic{File: file, Severity: SeverityError, Phase: PhaseCheck, Code: code, Message: message}
+		// A bug can be reported for a missing node.
+		if node != nil {
+			d.Span = node.Span
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	for _, bug := range ch.Bugs {
+		add(CodeParserBug, bug.Message, bug.Node)
+	}
+	for _, issue := range ch.Issues {
+		add(CodeSyntaxError, issue.Message, issue.Node)
+	}
+	return diagnostics
+}
+
+// NewRewriter creates a rewriter from the rewrite rules and builtins of the
+// pipeline. Rewriters keep track of the passes they have completed, so each
+// tree needs a new one.
+func (p *Pipeline) NewRewriter() (*rewriter.Rewriter, error) {
+	return rewriter.NewRewriterWithBuiltins(p.opts.RewriteConfig, p.opts.Debug, p.opts.SkipOptional, p.opts.Builtins)
+}
+
+// Rewrite applies the rewrite rules to a checked tree, which may be changed
+// in place, and returns the rewritten tree.
+func (p *Pipeline) Rewrite(tree *common.Node) (*common.Node, []Diagnostic) {
+	r, err := p.NewRewriter()
+	if err != nil {
+		return nil, []Diagnostic{newError(tree.Options[common.OptionSrc], PhaseRewrite, CodeRewriteError, err)}
+	}
+	tree, _ = r.Rewrite(tree)
+	return tree, nil
+}
+
+// Resolve resolves the identifiers of a rewritten tree in place, against the
+// modules of the project. The module is empty and the modules table is nil
+// when compiling a lone file.
+func (p *Pipeline) Resolve(tree *common.Node, module string, modules resolver.ModuleTable) []Diagnostic {
+	if diagnostics := refuseErrorNodes(tree, PhaseResolve); diagnostics != nil {
+		return diagnostics
+	}
+	res := resolver.NewModuleResolverWithBuiltins(module, modules, p.opts.Builtins)
+	if err := res.Resolve(tree); err != nil {
+		return []Diagnostic{newError(tree.Options[common.OptionSrc], PhaseResolve, CodeResolveError, err)}
+	}
+	return nil
+}
+
+// Annotate resolves the identifiers of a rewritten tree in place, like
+// Resolve, but stops before closures are captured and lambdas are lifted, so
+// that the tree still matches the source. It is for tools such as editors
+// rather than code generation.
+func (p *Pipeline) Annotate(tree *common.Node, module string, modules resolver.ModuleTable) []Diagnostic {
+	if diagnostics := refuseErrorNodes(tree, PhaseResolve); diagnostics != nil {
+		return diagnostics
+	}
+	res := resolver.NewModuleResolverWithBuiltins(module, modules, p.opts.Builtins)
+	if err := res.Annotate(tree); err != nil {
+		return []Diagnostic{newError(tree.Options[common.OptionSrc], PhaseResolve, CodeResolveError, err)}
+	}
+	return nil
+}
+
+// Generate generates code for a resolved tree in place, ready for bundling.
+func (p *Pipeline) Generate(tree *common.Node) []Diagnostic {
+	if diagnostics := refuseErrorNodes(tree, PhaseGenerate); diagnostics != nil {
+		return diagnostics
+	}
+	cg := codegen.NewCodeGeneratorWithBuiltins(p.opts.Builtins)
+	cg.SetNoOptimize(p.opts.NoOptimize)
+	if err := cg.Generate(tree); err != nil {
+		return []Diagnostic{newError(tree.Options[common.OptionSrc], PhaseGenerate, CodeCodegenError, err)}
+	}
+	return nil
+}
+
+// refuseErrorNodes reports the error nodes that the parser left in a tree.
+// Rewriting passes error nodes through, but resolution and code generation
+// refuse a tree with them, so that no code is ever generated for a file with
+// parse errors, even when the tree has come through the command line tools.
+func refuseErrorNodes(tree *common.Node, phase Phase) []Diagnostic {
+	var diagnostics []Diagnostic
+	var walk func(node *common.Node)
+	walk = func(node *common.Node) {
+		if node.Name == common.NameError {
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     tree.Options[common.OptionSrc],
+				Span:     node.Span,
+				Severity: SeverityError,
+				Phase:    phase,
+				Code:     CodeErrorNode,
+				Message:  fmt.Sprintf("cannot continue after a parse error: %s", node.Options[common.OptionMessage]),
+			})
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(tree)
+	return diagnostics
+}
@@ -0,0 +1,462 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+const helloSource = "[main]\ndef hello() =>>\n    println(\"Hello, world!\")\nenddef\n"
+
+func newTestPipeline(t *testing.T, opts Options) *Pipeline {
+	t.Helper()
+	p, err := New(opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return p
+}
+
+func compileText(t *testing.T, opts Options, text string) *Result {
+	t.Helper()
+	return newTestPipeline(t, opts).Compile([]SourceFile{{SrcPath: "a.nutmeg", Contents: text}})
+}
+
+func checkDiagnostic(t *testing.T, d Diagnostic, phase Phase, code Code, line, column int) {
+	t.Helper()
+	if d.File != "a.nutmeg" || d.Severity != SeverityError || d.Phase != phase || d.Code != code {
+		t.Errorf("Expected a %s error %s in a.nutmeg, got %#v", phase, code, d)
+	}
+	if d.Span.StartLine != line || d.Span.StartColumn != column {
+		t.Errorf("Expected %s at line %d, column %d, got line %d, column %d", code, line, column, d.Span.StartLine, d.Span.StartColumn)
+	}
+}
+
+// writeProject writes the files of a project, given by their paths relative
+// to the project, and returns the folder of the project.
+func writeProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for path, text := range files {
+		full := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o750); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(text), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	return dir
+}
+
+// compileProject compiles every source file of a project into a bundle.
+func compileProject(t *testing.T, dir string, bundleFile string) *Result {
+	t.Helper()
+	sources, err := DiscoverProject(dir)
+	if err != nil {
+		t.Fatalf("DiscoverProject failed: %v", err)
+	}
+	return newTestPipeline(t, Options{Bundle: bundleFile}).Compile(sources)
+}
+
+// bundledNames returns the names of the bindings of a bundle.
+func bundledNames(t *testing.T, bundleFile string) []string {
+	t.Helper()
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		t.Fatalf("NewBundler failed: %v", err)
+	}
+	defer b.Close()
+	bindings, err := b.LoadBindings()
+	if err != nil {
+		t.Fatalf("LoadBindings failed: %v", err)
+	}
+	names := make([]string, 0, len(bindings))
+	for _, binding := range bindings {
+		names = append(names, binding.IdName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLiftedLambdasOfModulesAreKeptApart(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"a.mod/a.nutmeg": "def g() =>>\n    fn x =>> x + 1 endfn\nenddef\n",
+		"b.mod/b.nutmeg": "def g() =>>\n    fn x =>> x * 100 endfn\nenddef\n",
+	})
+	bundleFile := filepath.Join(t.TempDir(), "test.bundle")
+	if result := compileProject(t, dir, bundleFile); !result.OK() {
+		t.Fatalf("Expected compilation to succeed, got %v", result.Diagnostics)
+	}
+	expected := []string{"a::g", "a::tmp-2@a.mod/a.nutmeg", "b::g", "b::tmp-2@b.mod/b.nutmeg"}
+	if got := bundledNames(t, bundleFile); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestCompileBundlesUnits(t *testing.T) {
+	bundleFile := filepath.Join(t.TempDir(), "test.bundle")
+	result := compileText(t, Options{Bundle: bundleFile}, helloSource)
+	if !result.OK() {
+		t.Fatalf("Expected compilation to succeed, got %v", result.Diagnostics)
+	}
+
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		t.Fatalf("NewBundler failed: %v", err)
+	}
+	defer b.Close()
+	entryPoints, err := b.LoadEntryPoints()
+	if err != nil {
+		t.Fatalf("LoadEntryPoints failed: %v", err)
+	}
+	if len(entryPoints) != 1 || entryPoints[0].IdName != "hello" {
+		t.Errorf("Expected the entry point hello, got %v", entryPoints)
+	}
+}
+
+func TestUnchangedSourceIsSkipped(t *testing.T) {
+	bundleFile := filepath.Join(t.TempDir(), "test.bundle")
+	if result := compileText(t, Options{Bundle: bundleFile}, helloSource); !result.OK() || result.Units[0].Skipped {
+		t.Fatalf("Expected the first compilation to bundle the unit, got %v", result.Diagnostics)
+	}
+
+	result := compileText(t, Options{Bundle: bundleFile}, helloSource)
+	if !result.OK() || !result.Skipped() || result.Units[0].Tokens != nil {
+		t.Errorf("Expected the unchanged source to be skipped before it is tokenized")
+	}
+	if (&Result{}).Skipped() {
+		t.Errorf("Expected a result without units not to count as skipped")
+	}
+
+	// A change to the text or to the options is compiled again.
+	changed := strings.Replace(helloSource, "world", "there", 1)
+	if result := compileText(t, Options{Bundle: bundleFile}, changed); !result.OK() || result.Units[0].Skipped {
+		t.Errorf("Expected the changed source to be compiled")
+	}
+	if result := compileText(t, Options{Bundle: bundleFile, NoOptimize: true}, changed); !result.OK() || result.Units[0].Skipped {
+		t.Errorf("Expected the source to be compiled with the new options")
+	}
+	if result := compileText(t, Options{Bundle: bundleFile, NoOptimize: true}, changed); !result.OK() || !result.Units[0].Skipped {
+		t.Errorf("Expected the source to be skipped with the same options")
+	}
+}
+
+func TestDeletedProjectFileIsRemoved(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"a.mod/a.nutmeg": "def f() =>> 1 enddef\n",
+		"a.mod/b.nutmeg": "def g() =>> 2 enddef\n",
+	})
+	bundleFile := filepath.Join(t.TempDir(), "test.bundle")
+	if result := compileProject(t, dir, bundleFile); !result.OK() {
+		t.Fatalf("Expected compilation to succeed, got %v", result.Diagnostics)
+	}
+	// A lone file in the same bundle is not part of the project.
+	if result := compileText(t, Options{Bundle: bundleFile}, helloSource); !result.OK() {
+		t.Fatalf("Expected compilation to succeed, got %v", result.Diagnostics)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.mod", "b.nutmeg")); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	result := compileProject(t, dir, bundleFile)
+	if !result.OK() || result.Units[0].Skipped {
+		t.Fatalf("Expected the project to be compiled again, got %v", result.Diagnostics)
+	}
+	expected := []string{"a::f", "hello"}
+	if got := bundledNames(t, bundleFile); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		t.Fatalf("NewBundler failed: %v", err)
+	}
+	defer b.Close()
+	files, err := b.LoadSourceFileNames()
+	if err != nil {
+		t.Fatalf("LoadSourceFileNames failed: %v", err)
+	}
+	if len(files) != 2 || files[0] != "a.mod/a.nutmeg" {
+		t.Errorf("Expected a.mod/b.nutmeg to be removed, got %v", files)
+	}
+	if result := compileProject(t, dir, bundleFile); !result.OK() || !result.Units[0].Skipped {
+		t.Errorf("Expected the project to be skipped once the removal is bundled")
+	}
+}
+
+func TestLineTableOfInterpolatedExpression(t *testing.T) {
+	// The expressions of interpolated strings are tokenized by
+	// nutmeg-tokenizer, so it is built from this tree and put on the PATH.
+	binDir := t.TempDir()
+	build := exec.Command("go", "build", "-o", binDir, "../../cmd/nutmeg-tokenizer")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("Building nutmeg-tokenizer failed: %v\n%s", err, out)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	bundleFile := filepath.Join(t.TempDir(), "test.bundle")
+	text := "[main]\ndef main() =>>\n    println(\"v=\\(1 + 2)\")\nenddef\n"
+	if result := compileText(t, Options{Bundle: bundleFile}, text); !result.OK() {
+		t.Fatalf("Expected compilation to succeed, got %v", result.Diagnostics)
+	}
+
+	b, err := bundler.NewBundler(bundleFile)
+	if err != nil {
+		t.Fatalf("NewBundler failed: %v", err)
+	}
+	defer b.Close()
+	bindings, err := b.LoadBindings()
+	if err != nil || len(bindings) != 1 {
+		t.Fatalf("Expected one binding, got %v (%v)", bindings, err)
+	}
+	funcObj, err := bundler.DecodeFunctionObject(bindings[0].Value)
+	if err != nil {
+		t.Fatalf("DecodeFunctionObject failed: %v", err)
+	}
+	// The 1 is at line 3, column 18.
+	found := false
+	for i, inst := range funcObj.Instructions {
+		if inst.Type == common.NamePushInt && inst.IntValue != nil && *inst.IntValue == 1 {
+			found = true
+			if span, ok := funcObj.SpanAt(i); !ok || span.StartLine != 3 || span.StartColumn != 18 {
+				t.Errorf("Expected the 1 to be at line 3, column 18, got %v", span)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected an instruction to push 1, got %v", funcObj.Instructions)
+	}
+}
+
+func TestCompileKeepsTreeOfEachPhase(t *testing.T) {
+	result := compileText(t, Options{}, helloSource)
+	if !result.OK() {
+		t.Fatalf("Expected compilation to succeed, got %v", result.Diagnostics)
+	}
+	unit := result.Units[0]
+	if unit.Parsed == nil || unit.Rewritten == nil || unit.Resolved == nil || unit.Generated == nil {
+		t.Fatalf("Expected a tree for every phase, got %+v", unit)
+	}
+	// Later phases work on copies, so the parsed tree still has the
+	// brackets that the rewriter turns into annotations.
+	if got := unit.Parsed.Children[0].Name; got != common.NameDelimited {
+		t.Errorf("Expected the parsed tree to start with %s, got %s", common.NameDelimited, got)
+	}
+	if got := unit.Rewritten.Children[0].Name; got != common.NameAnnotations {
+		t.Errorf("Expected the rewritten tree to start with %s, got %s", common.NameAnnotations, got)
+	}
+	if unit.Resolved == unit.Generated || unit.Resolved.Children[1] == unit.Generated.Children[1] {
+		t.Errorf("Expected code generation to work on a copy of the resolved tree")
+	}
+}
+
+func TestStopAfterSkipsLaterPhases(t *testing.T) {
+	bundleFile := filepath.Join(t.TempDir(), "test.bundle")
+	result := compileText(t, Options{StopAfter: PhaseRewrite, Bundle: bundleFile}, helloSource)
+	if !result.OK() {
+		t.Fatalf("Expected compilation to succeed, got %v", result.Diagnostics)
+	}
+	unit := result.Units[0]
+	if unit.Rewritten == nil || unit.Resolved != nil || unit.Generated != nil {
+		t.Errorf("Expected to stop after rewriting, got %+v", unit)
+	}
+	if matches, _ := filepath.Glob(bundleFile); len(matches) != 0 {
+		t.Errorf("Expected no bundle to be written")
+	}
+}
+
+func TestSyntaxErrorsAreSeparateDiagnostics(t *testing.T) {
+	result := compileText(t, Options{}, "x = 1\n  y = 2\n")
+	if result.OK() || result.Failed() != 1 {
+		t.Fatalf("Expected the unit to fail, got %v", result.Diagnostics)
+	}
+	if len(result.Diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %v", result.Diagnostics)
+	}
+	checkDiagnostic(t, result.Diagnostics[0], PhaseCheck, CodeSyntaxError, 1, 1)
+	checkDiagnostic(t, result.Diagnostics[1], PhaseCheck, CodeSyntaxError, 2, 3)
+	if result.Units[0].Rewritten != nil {
+		t.Errorf("Expected compilation to stop after the syntax errors")
+	}
+}
+
+func TestParseErrorsHaveSpans(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		code   Code
+		line   int
+		column int
+	}{
+		{"missing =>>", "def f()\n    \"hi\"\nenddef\n", CodeParseError, 2, 5},
+		{"trailing token", "\n\n  a b\n", CodeTrailingToken, 3, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compileText(t, Options{}, tt.text)
+			if len(result.Diagnostics) != 1 {
+				t.Fatalf("Expected 1 diagnostic, got %v", result.Diagnostics)
+			}
+			checkDiagnostic(t, result.Diagnostics[0], PhaseParse, tt.code, tt.line, tt.column)
+		})
+	}
+}
+
+func TestParseRecoversFromErrors(t *testing.T) {
+	text := "def f(x) =>>\n    y := (1 +\n    z := 2\nenddef\n" +
+		"def g(x) =>>\n    if x then\n        1 +\n    endif\n    x x\nenddef\n" +
+		"h := 3 4\n" +
+		"w := 5\n"
+	result := compileText(t, Options{}, text)
+	if len(result.Diagnostics) != 4 {
+		t.Fatalf("Expected 4 diagnostics, got %v", result.Diagnostics)
+	}
+	checkDiagnostic(t, result.Diagnostics[0], PhaseParse, CodeParseError, 4, 1)
+	checkDiagnostic(t, result.Diagnostics[1], PhaseParse, CodeParseError, 7, 11)
+	checkDiagnostic(t, result.Diagnostics[2], PhaseParse, CodeParseError, 9, 7)
+	checkDiagnostic(t, result.Diagnostics[3], PhaseParse, CodeTrailingToken, 11, 8)
+
+	// Parsing carries on after each error, so every top-level expression
+	// is in the tree, with error nodes in place of what was skipped.
+	unit := result.Units[0]
+	if unit.Parsed == nil || len(unit.Parsed.Children) != 5 {
+		t.Fatalf("Expected 5 top-level expressions, got %+v", unit.Parsed)
+	}
+	if got := unit.Parsed.Children[3]; got.Name != common.NameError || got.Span.StartLine != 11 || got.Span.StartColumn != 8 {
+		t.Errorf("Expected an error node for the trailing token, got %+v", got)
+	}
+	body := unit.Parsed.Children[0].Children[1]
+	if len(body.Children) != 1 || body.Children[0].Name != common.NameError {
+		t.Fatalf("Expected the body of f to be an error node, got %+v", body)
+	}
+	// The error node covers the tokens from the start of the broken
+	// expression up to the end of the line before enddef.
+	expected := common.Span{StartLine: 2, StartColumn: 5, EndLine: 3, EndColumn: 11}
+	if got := body.Children[0].Span; got != expected {
+		t.Errorf("Expected the error node to span %v, got %v", expected, got)
+	}
+	if unit.Rewritten != nil {
+		t.Errorf("Expected compilation to stop after the parse errors")
+	}
+}
+
+func TestParseAndSyntaxErrorsAreReportedTogether(t *testing.T) {
+	result := compileText(t, Options{}, "def f(x) =>>\n  x +\nenddef\nx = 1\n")
+	if len(result.Diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %v", result.Diagnostics)
+	}
+	checkDiagnostic(t, result.Diagnostics[0], PhaseParse, CodeParseError, 2, 5)
+	checkDiagnostic(t, result.Diagnostics[1], PhaseCheck, CodeSyntaxError, 4, 1)
+}
+
+func TestLaterPhasesRefuseErrorNodes(t *testing.T) {
+	p := newTestPipeline(t, Options{})
+	tokens, _ := p.Tokenize("a.nutmeg", "def f(x) =>>\n  x +\nenddef\n")
+	tree, _ := p.Parse(&SourceFile{SrcPath: "a.nutmeg"}, tokens)
+	tree, diagnostics := p.Rewrite(tree)
+	if diagnostics != nil {
+		t.Fatalf("Expected rewriting to pass the error node through, got %v", diagnostics)
+	}
+	for phase, diagnostics := range map[Phase][]Diagnostic{
+		PhaseResolve:  p.Resolve(tree.Clone(), "", nil),
+		PhaseGenerate: p.Generate(tree.Clone()),
+	} {
+		if len(diagnostics) != 1 {
+			t.Fatalf("Expected 1 %s diagnostic, got %v", phase, diagnostics)
+		}
+		checkDiagnostic(t, diagnostics[0], phase, CodeErrorNode, 2, 3)
+	}
+}
+
+func TestUnreadableSource(t *testing.T) {
+	p := newTestPipeline(t, Options{})
+	result := p.Compile([]SourceFile{{Path: filepath.Join(t.TempDir(), "missing.nutmeg"), SrcPath: "a.nutmeg"}})
+	if len(result.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %v", result.Diagnostics)
+	}
+	checkDiagnostic(t, result.Diagnostics[0], PhaseInput, CodeUnreadable, 0, 0)
+}
+
+func TestDiagnosticSpanComesFromTheError(t *testing.T) {
+	span := common.Span{StartLine: 2, StartColumn: 5, EndLine: 2, EndColumn: 14}
+	tests := []struct {
+		name     string
+		err      error
+		expected common.Span
+	}{
+		{
+			"span error",
+			common.SpanErrorf(span, "found x at line 9, column 9"),
+			span,
+		},
+		{
+			"wrapped span error",
+			fmt.Errorf("failed: %w", common.SpanErrorf(span, "oops")),
+			span,
+		},
+		{
+			"no span",
+			errors.New("oops at line 4, char 2"),
+			common.Span{StartLine: 4, StartColumn: 2, EndLine: 4, EndColumn: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newError("a.nutmeg", PhaseResolve, CodeResolveError, tt.err).Span; got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestResolveErrorHasTheSpanOfItsNode(t *testing.T) {
+	result := compileText(t, Options{}, "def f() =>>\n    std::nope(1)\nenddef\n")
+	if len(result.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %v", result.Diagnostics)
+	}
+	expected := common.Span{StartLine: 2, StartColumn: 5, EndLine: 2, EndColumn: 14}
+	if got := result.Diagnostics[0].Span; got != expected {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	// The location is rendered from the span, so the message leaves it out.
+	if got := result.Diagnostics[0].String(); got != "a.nutmeg:2:5: resolution error: nope is not defined by module std" {
+		t.Errorf("Expected the location to be given once, got %q", got)
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	tests := []struct {
+		diagnostic Diagnostic
+		expected   string
+	}{
+		{
+			Diagnostic{File: "a.nutmeg", Span: common.Span{StartLine: 2, StartColumn: 5}, Severity: SeverityError, Phase: PhaseParse, Message: "oops"},
+			"a.nutmeg:2:5: parse error: oops",
+		},
+		{
+			Diagnostic{File: "a.nutmeg", Severity: SeverityWarning, Phase: PhaseInput, Message: "oops"},
+			"a.nutmeg: input warning: oops",
+		},
+		{
+			Diagnostic{Span: common.Span{StartLine: 1, StartColumn: 1}, Severity: SeverityError, Phase: PhaseCheck, Message: "oops"},
+			"1:1: syntax error: oops",
+		},
+		{
+			Diagnostic{Severity: SeverityError, Phase: PhaseModules, Message: "oops"},
+			"module error: oops",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.diagnostic.String(); got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
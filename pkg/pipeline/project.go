@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/nutport"
+	"github.com/spicery/nutmeg-compiler/pkg/resolver"
+)
+
+// ModuleSuffix marks a folder as a module, see docs/nutmeg-project-structure.md.
+const ModuleSuffix = ".mod"
+
+// SourceFileSuffix marks a file as Nutmeg source code.
+const SourceFileSuffix = ".nutmeg"
+
+// SourceFile is a single file to be compiled, together with the module it
+// belongs to (empty when compiling a lone file). The text can be supplied
+// directly, otherwise it is read from Path.
+type SourceFile struct {
+	Path      string // The path used to read the file.
+	SrcPath   string // The path recorded in the bundle, relative to the project.
+	Module    string // The name of the enclosing module.
+	ModuleDir string // The folder of the enclosing module.
+	Contents  string // The text of the file, read from Path if empty.
+}
+
+// DiscoverProject finds every .nutmeg file that lives inside a *.mod folder of
+// the project. Each file belongs to its nearest enclosing module, so nested
+// modules are kept separate from their parents. Files outside any module are
+// ignored. The result is in lexical order of path so builds are repeatable.
+func DiscoverProject(projectDir string) ([]SourceFile, error) {
+	sources := make([]SourceFile, 0)
+	err := filepath.WalkDir(projectDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), SourceFileSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(projectDir, path)
+		if err != nil {
+			return err
+		}
+		module := ModuleNameForPath(rel)
+		if module == "" {
+			return nil
+		}
+		sources = append(sources, SourceFile{
+			Path:      path,
+			SrcPath:   filepath.ToSlash(rel),
+			Module:    module,
+			ModuleDir: filepath.Join(projectDir, moduleDirForPath(rel)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// LoadModules builds the table of modules that the resolver needs in order to
+// resolve references between modules. Every module contributes the top-level
+// definitions of its units and the imports listed in its imports.nutport
+// file. A lone file does not belong to a module and gives a nil table.
+func LoadModules(sources []SourceFile, units []*common.Node) (resolver.ModuleTable, error) {
+	modules := make(resolver.ModuleTable)
+	for i, source := range sources {
+		if source.Module == "" {
+			return nil, nil
+		}
+		info, found := modules[source.Module]
+		if !found {
+			imports, err := nutport.ParseFile(filepath.Join(source.ModuleDir, nutport.FileName))
+			if err != nil {
+				return nil, err
+			}
+			info = resolver.NewModuleInfo(imports)
+			modules[source.Module] = info
+		}
+		info.AddDefinitions(units[i])
+	}
+	if err := modules.CheckImports(); err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+// moduleDirForPath returns the project-relative folder of the nearest module
+// that encloses a project-relative file path.
+func moduleDirForPath(rel string) string {
+	dir := filepath.Dir(rel)
+	for dir != "." && !strings.HasSuffix(filepath.Base(dir), ModuleSuffix) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// ModuleNameForPath returns the name of the module that a project-relative
+// file path belongs to, or the empty string if it is not inside a module.
+// The module name is the folder name without the .mod suffix and without any
+// visibility tag, so that `main.#pub.mod` is the module `main`. Nested
+// modules are named by their path of modules, e.g. `parser/advanced`.
+func ModuleNameForPath(rel string) string {
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/")
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if !strings.HasSuffix(part, ModuleSuffix) {
+			continue
+		}
+		name := strings.TrimSuffix(part, ModuleSuffix)
+		if i := strings.Index(name, ".#"); i >= 0 {
+			name = name[:i]
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, "/")
+}
@@ -0,0 +1,153 @@
+package resolver
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/nutport"
+)
+
+// QualifierSeparator separates a module qualifier from a name, as in std::println.
+const QualifierSeparator = "::"
+
+// ModuleInfo summarises a module for the purpose of resolving references to
+// the names it defines.
+type ModuleInfo struct {
+	Imports     []nutport.Import // The imports of the module.
+	Definitions map[string]bool  // The names defined at the top level of the module.
+}
+
+// ModuleTable maps module names to their summaries. It must describe every
+// module of a project before any unit of the project is resolved, because
+// the files of a module can refer to each other's definitions.
+type ModuleTable map[string]*ModuleInfo
+
+// NewModuleInfo creates the summary of a module with the given imports.
+func NewModuleInfo(imports []nutport.Import) *ModuleInfo {
+	return &ModuleInfo{
+		Imports:     imports,
+		Definitions: make(map[string]bool),
+	}
+}
+
+// AddDefinitions records the names bound at the top level of a unit.
+func (m *ModuleInfo) AddDefinitions(unit *common.Node) {
+	for _, child := range unit.Children {
+		if child.Name == common.NameBind && len(child.Children) > 0 && child.Children[0].Name == common.NameIdentifier {
+			if name := getIdentifierName(child.Children[0]); name != "" {
+				m.Definitions[name] = true
+			}
+		}
+	}
+}
+
+// find returns the module that really defines a name that is visible in the
+// given module, following includes. The visited set guards against include
+// cycles.
+func (t ModuleTable) find(module, name string, visited map[string]bool) (string, bool) {
+	info := t[module]
+	if info == nil || visited[module] {
+		return "", false
+	}
+	if info.Definitions[name] {
+		return module, true
+	}
+	visited[module] = true
+	for _, imp := range info.Imports {
+		if imp.Include {
+			if origin, found := t.find(imp.Module, name, visited); found {
+				return origin, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CheckImports reports imports of modules that are not part of the table. The
+// standard library is always available.
+func (t ModuleTable) CheckImports() error {
+	for _, module := range slices.Sorted(maps.Keys(t)) {
+		for _, imp := range t[module].Imports {
+			if t[imp.Module] == nil && imp.Module != common.StdModule {
+				return fmt.Errorf("module %s imports unknown module %s, at line %d of %s", module, imp.Module, imp.Line, nutport.FileName)
+			}
+		}
+	}
+	return nil
+}
+
+// Qualify returns the fully-qualified name of a global. Names from a lone
+// file, which does not belong to any module, are left unqualified.
+func Qualify(module, name string) string {
+	if module == "" {
+		return name
+	}
+	return module + QualifierSeparator + name
+}
+
+// splitQualifiedName splits alias::name into the qualifier and the name. The
+// qualifier is empty for an unqualified name.
+func splitQualifiedName(name string) (string, string) {
+	if i := strings.LastIndex(name, QualifierSeparator); i >= 0 {
+		return name[:i], name[i+len(QualifierSeparator):]
+	}
+	return "", name
+}
+
+// resolveGlobal works out which module defines a name that is not bound by
+// any enclosing scope. Qualified names are looked up via the alias of an
+// import (or the current module's own name). Unqualified names are looked up
+// in the current module, its includes and then its optional imports.
+func (r *Resolver) resolveGlobal(name string) (string, error) {
+	qualifier, short := splitQualifiedName(name)
+	if qualifier != "" {
+		module, err := r.moduleForQualifier(qualifier)
+		if err != nil {
+			return "", err
+		}
+		origin, found := r.modules.find(module, short, make(map[string]bool))
+		if !found {
+			return "", fmt.Errorf("%s is not defined by module %s", short, module)
+		}
+		return origin, nil
+	}
+
+	if origin, found := r.modules.find(r.module, name, make(map[string]bool)); found {
+		return origin, nil
+	}
+	origins := make([]string, 0)
+	for _, imp := range r.modules[r.module].Imports {
+		if imp.Optional && !imp.Include {
+			if origin, found := r.modules.find(imp.Module, name, make(map[string]bool)); found && !slices.Contains(origins, origin) {
+				origins = append(origins, origin)
+			}
+		}
+	}
+	switch {
+	case len(origins) == 1:
+		return origins[0], nil
+	case len(origins) > 1:
+		return "", fmt.Errorf("ambiguous identifier %s, it is defined by modules %s", name, strings.Join(origins, " and "))
+	case r.strict:
+		return "", fmt.Errorf("undefined identifier %s", name)
+	default:
+		// Without a full module table the name might be defined elsewhere.
+		return r.module, nil
+	}
+}
+
+// moduleForQualifier maps the qualifier of a name to a module name.
+func (r *Resolver) moduleForQualifier(qualifier string) (string, error) {
+	if qualifier == r.module {
+		return r.module, nil
+	}
+	for _, imp := range r.modules[r.module].Imports {
+		if !imp.Include && imp.Alias == qualifier {
+			return imp.Module, nil
+		}
+	}
+	return "", fmt.Errorf("unknown module qualifier %s", qualifier)
+}
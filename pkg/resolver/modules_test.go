@@ -0,0 +1,129 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/nutport"
+)
+
+// moduleInfo summarises a module from the text of its imports.nutport file,
+// which is read as the compiler reads it, and the names it defines.
+func moduleInfo(t *testing.T, imports string, definitions ...string) *ModuleInfo {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), nutport.FileName)
+	if err := os.WriteFile(path, []byte(imports), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	parsed, err := nutport.ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	info := NewModuleInfo(parsed)
+	for _, name := range definitions {
+		info.Definitions[name] = true
+	}
+	return info
+}
+
+// testModules is a project whose main module imports the others in every way
+// that a module can.
+func testModules(t *testing.T) ModuleTable {
+	return ModuleTable{
+		"main": moduleInfo(t, `
+import #pub from util as u
+import #pub from facade optionally as f
+import #pub from other optionally
+`, "own"),
+		"util":   moduleInfo(t, "", "twice"),
+		"facade": moduleInfo(t, "include #pub from json\n", "dup"),
+		"json":   moduleInfo(t, "include #pub from yaml\n", "parse"),
+		"yaml":   moduleInfo(t, "include #pub from facade\n", "deep"),
+		"other":  moduleInfo(t, "", "dup"),
+	}
+}
+
+func TestResolveGlobal(t *testing.T) {
+	tests := []struct {
+		name   string
+		origin string // The module expected to define the name.
+		err    string // Part of the error expected instead.
+	}{
+		{name: "own", origin: "main"},
+		{name: "main::own", origin: "main"},
+		{name: "u::twice", origin: "util"},
+		{name: "f::parse", origin: "json"},
+		{name: "f::deep", origin: "yaml"},
+		{name: "parse", origin: "json"},
+		{name: "println", origin: common.StdModule},
+		{name: "std::println", origin: common.StdModule},
+		{name: "twice", err: "undefined identifier twice"},
+		{name: "dup", err: "ambiguous identifier dup, it is defined by modules facade and other"},
+		{name: "x::twice", err: "unknown module qualifier x"},
+		{name: "u::nope", err: "nope is not defined by module util"},
+		{name: "f::nope", err: "nope is not defined by module facade"},
+	}
+	r := NewModuleResolver("main", testModules(t))
+	if err := r.prepareModules(&common.Node{Name: common.NameUnit}); err != nil {
+		t.Fatalf("prepareModules failed: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin, err := r.resolveGlobal(tt.name)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Expected %s to be defined by %s, got %v", tt.name, tt.origin, err)
+			case tt.err == "" && origin != tt.origin:
+				t.Errorf("Expected %s to be defined by %s, got %s", tt.name, tt.origin, origin)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Expected the error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestLoneFileAssumesUndefinedNamesAreGlobal(t *testing.T) {
+	r := NewResolver()
+	if err := r.prepareModules(&common.Node{Name: common.NameUnit}); err != nil {
+		t.Fatalf("prepareModules failed: %v", err)
+	}
+	if origin, err := r.resolveGlobal("elsewhere"); err != nil || origin != "" {
+		t.Errorf("Expected elsewhere to be a global of the file, got %q (%v)", origin, err)
+	}
+	if origin, err := r.resolveGlobal("println"); err != nil || origin != common.StdModule {
+		t.Errorf("Expected println to come from std, got %q (%v)", origin, err)
+	}
+}
+
+func TestCheckImports(t *testing.T) {
+	modules := testModules(t)
+	if err := modules.CheckImports(); err != nil {
+		t.Errorf("Expected the imports to be known, got %v", err)
+	}
+	modules["util"] = moduleInfo(t, "import #pub from missing\n")
+	err := modules.CheckImports()
+	if err == nil || !strings.Contains(err.Error(), "module util imports unknown module missing, at line 1") {
+		t.Errorf("Expected an unknown module error, got %v", err)
+	}
+}
+
+func TestQualifiedNames(t *testing.T) {
+	tests := []struct {
+		module, name, qualified string
+	}{
+		{"main", "f", "main::f"},
+		{"parser/advanced", "f", "parser/advanced::f"},
+		{"", "f", "f"},
+	}
+	for _, tt := range tests {
+		if got := Qualify(tt.module, tt.name); got != tt.qualified {
+			t.Errorf("Expected %s, got %s", tt.qualified, got)
+		}
+		if module, name := splitQualifiedName(tt.qualified); module != tt.module || name != tt.name {
+			t.Errorf("Expected %q and %q from %s, got %q and %q", tt.module, tt.name, tt.qualified, module, name)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package resolver
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
+	"github.com/spicery/nutmeg-compiler/pkg/builtins"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
+	"github.com/spicery/nutmeg-compiler/pkg/nutport"
 )
 
 const (
@@ -22,10 +25,30 @@ type Resolver struct {
 	globalScope  *Scope                     // The global scope.
 	idInfo       map[uint64]*IdentifierInfo // Metadata for each identifier name.
 	Closures     map[*Scope]bool            // Set of closure scopes encountered.
+	module       string                     // The module of the unit being resolved.
+	modules      ModuleTable                // The modules that free identifiers are resolved against.
+	strict       bool                       // Whether free identifiers must be defined by some module.
+	builtins     *builtins.Registry         // The builtins that make up the standard library.
 }
 
-// NewResolver creates a new resolver instance.
+// NewResolver creates a new resolver instance for a lone unit that does not
+// belong to a module. The unit implicitly has the default imports and free
+// identifiers that it does not define are assumed to be defined elsewhere.
 func NewResolver() *Resolver {
+	return NewModuleResolver("", nil)
+}
+
+// NewModuleResolver creates a resolver for a unit of the named module. The
+// table must describe all the modules of the project, and then every free
+// identifier must be defined by the module itself or one of its imports. If
+// the table is nil the resolver behaves like NewResolver.
+func NewModuleResolver(module string, modules ModuleTable) *Resolver {
+	return NewModuleResolverWithBuiltins(module, modules, builtins.Default())
+}
+
+// NewModuleResolverWithBuiltins creates a resolver like NewModuleResolver whose
+// standard library is made up of the functions of the given registry.
+func NewModuleResolverWithBuiltins(module string, modules ModuleTable, registry *builtins.Registry) *Resolver {
 	globalScope := &Scope{
 		Level:        0,
 		DynamicLevel: 0,
@@ -41,6 +64,10 @@ func NewResolver() *Resolver {
 		nextID:       0, // Start IDs at 0.
 		idInfo:       make(map[uint64]*IdentifierInfo),
 		Closures:     make(map[*Scope]bool),
+		module:       module,
+		modules:      modules,
+		strict:       modules != nil,
+		builtins:     registry,
 	}
 }
 
@@ -49,13 +76,7 @@ func NewResolver() *Resolver {
 // 1. First pass: Build scope structure, assign IDs, collect identifier metadata
 // 2. Second pass: Annotate all nodes with the complete resolution information
 func (r *Resolver) Resolve(root *common.Node) error {
-	// First pass: collect identifier information
-	if err := r.traverse(root); err != nil {
-		return err
-	}
-
-	// Second pass: annotate all nodes
-	if err := r.annotate(root); err != nil {
+	if err := r.Annotate(root); err != nil {
 		return err
 	}
 
@@ -68,6 +89,42 @@ func (r *Resolver) Resolve(root *common.Node) error {
 	return nil
 }
 
+// Annotate performs the first two passes of Resolve, giving every identifier
+// its serial number, scope and var/const information, but without capturing
+// closures or lifting lambdas. The tree keeps the shape of the source and
+// each identifier has the serial number of its definition, which is what
+// tools that navigate the source need.
+func (r *Resolver) Annotate(root *common.Node) error {
+	if err := r.prepareModules(root); err != nil {
+		return err
+	}
+
+	// First pass: collect identifier information
+	if err := r.traverse(root); err != nil {
+		return err
+	}
+
+	// Second pass: annotate all nodes
+	return r.annotate(root)
+}
+
+// prepareModules makes sure that the module table describes the current module
+// and the standard library.
+func (r *Resolver) prepareModules(root *common.Node) error {
+	if r.modules == nil {
+		info := NewModuleInfo(nutport.DefaultImports())
+		info.AddDefinitions(root)
+		r.modules = ModuleTable{r.module: info}
+	}
+	if r.modules[common.StdModule] == nil {
+		r.modules[common.StdModule] = &ModuleInfo{Definitions: r.builtins.Names(builtins.FunctionKind)}
+	}
+	if r.modules[r.module] == nil {
+		return fmt.Errorf("unknown module %s", r.module)
+	}
+	return nil
+}
+
 func (r *Resolver) liftLambdas(root *common.Node) {
 	// Collect the paths of all lambda nodes.
 	paths := collectLambdaPaths(root, nil)
@@ -77,7 +134,7 @@ func (r *Resolver) liftLambdas(root *common.Node) {
 
 	definitions := make([]*common.Node, 0)
 	for _, lambdaPath := range paths {
-		definitions = append(definitions, r.convertLambdaToDefinition(lambdaPath))
+		definitions = append(definitions, r.convertLambdaToDefinition(lambdaPath, root.Options[common.OptionSrc]))
 	}
 
 	// Stitch the definitions at the top level.
@@ -118,7 +175,19 @@ func isTopLevelPath(path *common.Path) bool {
 	return path.Others.Others == nil
 }
 
-func (r *Resolver) convertLambdaToDefinition(path *common.Path) *common.Node {
+// liftedName returns the global name of a lifted lambda. Serial numbers are
+// only unique within a unit, so the name is qualified by the module and
+// includes the source file, to keep apart the lambdas of different units
+// that are bundled together.
+func (r *Resolver) liftedName(serialNo string, src string) string {
+	name := "tmp-" + serialNo
+	if src != "" {
+		name += "@" + src
+	}
+	return Qualify(r.module, name)
+}
+
+func (r *Resolver) convertLambdaToDefinition(path *common.Path, src string) *common.Node {
 	// Get a new serial number for the binding-identifier.
 	serial_no := r.NewSerialNo()
 	serial_no_str := fmt.Sprintf("%d", serial_no)
@@ -126,7 +195,7 @@ func (r *Resolver) convertLambdaToDefinition(path *common.Path) *common.Node {
 		Name: common.NameIdentifier,
 		Options: map[string]string{
 			common.OptionSerialNo: serial_no_str,
-			common.OptionName:     fmt.Sprintf("tmp-%s", serial_no_str),
+			common.OptionName:     r.liftedName(serial_no_str, src),
 			common.OptionScope:    string(UnitScope),
 			common.OptionConst:    "true",
 			common.OptionVar:      "false",
@@ -243,11 +312,16 @@ func (r *Resolver) traverse(node *common.Node) error {
 		return r.handleBind(node)
 	case common.NameFn:
 		return r.handleFnScope(node)
-	case common.NameLet, common.NameIf, common.NameFor:
+	case common.NameFor:
+		return r.handleForScope(node)
+	case common.NameLet, common.NameIf:
 		return r.handleLexicalScope(node)
 	case common.NameIdentifier:
 		err := r.handleIdentifier(node)
 		return err
+	case common.NameAnnotations:
+		// Annotation names are tags rather than references to variables.
+		return nil
 	default:
 		// For other nodes, just traverse children.
 		for _, child := range node.Children {
@@ -264,7 +338,9 @@ func (r *Resolver) traverse(node *common.Node) error {
 func (r *Resolver) handleBind(node *common.Node) error {
 	if len(node.Children) > 0 && node.Children[0].Name == "id" {
 		// Define the identifier in the current scope.
-		r.defineIdentifier(node.Children[0])
+		if _, err := r.defineIdentifier(node.Children[0]); err != nil {
+			return err
+		}
 	}
 
 	// Traverse remaining children (the value expression).
@@ -293,7 +369,9 @@ func (r *Resolver) handleFnScope(node *common.Node) error {
 	}
 
 	for _, param := range params {
-		r.defineIdentifier(param)
+		if _, err := r.defineIdentifier(param); err != nil {
+			return err
+		}
 	}
 
 	err = r.traverse(node.Children[1])
@@ -344,6 +422,32 @@ func (r *Resolver) handleLexicalScope(node *common.Node) error {
 	return nil
 }
 
+// handleForScope processes a for loop: for(in(id, expression), body).
+// The expression is resolved in the enclosing scope, so that it cannot see
+// the loop variable, which is defined in a new lexical scope for the body.
+func (r *Resolver) handleForScope(node *common.Node) error {
+	if len(node.Children) != 2 || node.Children[0].Name != common.NameIn || len(node.Children[0].Children) != 2 {
+		return common.SpanErrorf(node.Span, "invalid for node structure")
+	}
+	query := node.Children[0]
+	if err := r.traverse(query.Children[1]); err != nil {
+		return err
+	}
+
+	// Enter a new lexical scope for the loop variable and the body.
+	r.currentScope = r.currentScope.NewChildScope(false, node)
+	if _, err := r.defineIdentifier(query.Children[0]); err != nil {
+		return err
+	}
+	if err := r.traverse(node.Children[1]); err != nil {
+		return err
+	}
+
+	// Restore the previous scope.
+	r.currentScope = r.currentScope.Parent
+	return nil
+}
+
 // handleIdentifier processes an identifier node (a use of an identifier).
 // First pass - records the usage for later analysis.
 func (r *Resolver) handleIdentifier(node *common.Node) error {
@@ -384,18 +488,21 @@ func (r *Resolver) NewGlobalIdentifierInfo(name string, origin *string) *Identif
 
 // defineIdentifier defines a new identifier in the current scope.
 // First pass only - collects information but does not annotate nodes.
-func (r *Resolver) defineIdentifier(node *common.Node) *IdentifierInfo {
+func (r *Resolver) defineIdentifier(node *common.Node) (*IdentifierInfo, error) {
 	if node.Name != "id" {
-		return nil
+		return nil, nil
 	}
 
 	name := getIdentifierName(node)
 	if name == "" {
-		return nil
+		return nil, nil
+	}
+	if strings.Contains(name, QualifierSeparator) {
+		return nil, common.SpanErrorf(node.Span, "cannot define qualified identifier: %s", name)
 	}
 
 	// Create and store metadata for this identifier.
-	origin := "unit" // This is a placeholder value for the current module name.
+	origin := r.module
 	info := r.NewIdentifierInfo(name, &origin)
 	q, ok := node.Options[VarOption]
 	if ok {
@@ -410,7 +517,7 @@ func (r *Resolver) defineIdentifier(node *common.Node) *IdentifierInfo {
 		info.IsProtected = (q == "true")
 	}
 	node.Options[common.OptionSerialNo] = fmt.Sprintf("%d", info.UniqueID)
-	return info
+	return info, nil
 }
 
 // annotate performs the second pass traversal to annotate all nodes with resolution information.
@@ -418,6 +525,13 @@ func (r *Resolver) defineIdentifier(node *common.Node) *IdentifierInfo {
 func (r *Resolver) annotate(node *common.Node) error {
 	// Downwards pass
 	switch node.Name {
+	case common.NameAnnotations:
+		return nil
+	case common.NameSysCall:
+		// The rewriter names syscalls after their operator.
+		if b, ok := r.builtins.Lookup(builtins.OperatorKind, node.Options[common.OptionSysFn]); ok {
+			node.Options[common.OptionSysFn] = b.SysFn
+		}
 	case common.NameIdentifier:
 		info := r.getIdentifierInfo(node)
 
@@ -429,15 +543,22 @@ func (r *Resolver) annotate(node *common.Node) error {
 			node.Options[LastOption] = "true"
 		}
 
-		if info.ScopeType == common.ValueGlobal {
-			if info.Origin == nil {
-				// Is it a built-in? This section is a placeholder for a proper module.
-				switch info.Name {
-				case "println":
-					node.Name = common.NameSysFn
-					node.Options[common.OptionSysFn] = node.Options[common.OptionName]
-					delete(node.Options, common.OptionName)
+		if info.ScopeType == common.ValueGlobal && info.Origin != nil {
+			_, short := splitQualifiedName(info.Name)
+			if *info.Origin != "" {
+				node.Options[common.OptionOrigin] = *info.Origin
+			}
+			if *info.Origin == common.StdModule {
+				// The standard library is currently made of system functions.
+				b, ok := r.builtins.Lookup(builtins.FunctionKind, short)
+				if !ok {
+					return common.SpanErrorf(node.Span, "%s is not a builtin function", short)
 				}
+				node.Name = common.NameSysFn
+				node.Options[common.OptionSysFn] = b.SysFn
+				delete(node.Options, common.OptionName)
+			} else {
+				node.Options[common.OptionName] = Qualify(*info.Origin, short)
 			}
 		}
 	}
@@ -463,13 +584,13 @@ func (r *Resolver) annotate(node *common.Node) error {
 					prior, found := s.Identifiers[info.Name]
 					if found && prior != nil {
 						if prior.IsProtected {
-							return fmt.Errorf("trying to re-declare protected identifier: %s, at line %d, column %d", info.Name, id.Span.StartLine, id.Span.StartColumn)
+							return common.SpanErrorf(id.Span, "trying to re-declare protected identifier: %s", info.Name)
 						}
 					}
 				}
 			}
 		} else {
-			return fmt.Errorf("invalid bind structure, at line %d, column %d", node.Span.StartLine, node.Span.StartColumn)
+			return common.SpanErrorf(node.Span, "invalid bind structure")
 		}
 	case common.NameAssign:
 		// Implement IsAssignable.
@@ -477,10 +598,10 @@ func (r *Resolver) annotate(node *common.Node) error {
 		if id.Name == common.NameIdentifier {
 			info := r.getIdentifierInfo(id)
 			if !info.IsAssignable {
-				return fmt.Errorf("assigning to non-assignable identifier: %s, at line %d, column %d", info.Name, id.Span.StartLine, id.Span.StartColumn)
+				return common.SpanErrorf(id.Span, "assigning to non-assignable identifier: %s", info.Name)
 			}
 		} else {
-			return fmt.Errorf("invalid assign node structure, at line %d, column %d", node.Span.StartLine, node.Span.StartColumn)
+			return common.SpanErrorf(node.Span, "invalid assign node structure")
 		}
 	case common.NameUpdate:
 		// TODO: Implement IsUpdatable. This requires an analysis of the
@@ -540,8 +661,12 @@ func (r *Resolver) lookupIdentifier(node *common.Node) (*IdentifierInfo, *Scope,
 		node.Options[common.OptionSerialNo] = fmt.Sprintf("%d", info.UniqueID)
 		return info, scope, nil
 	}
-	// Not found - treat as global undefined identifier.
-	info = r.NewGlobalIdentifierInfo(name, nil)
+	// Not found - resolve it against the modules.
+	origin, err := r.resolveGlobal(name)
+	if err != nil {
+		return nil, nil, common.SpanErrorf(node.Span, "%w", err)
+	}
+	info = r.NewGlobalIdentifierInfo(name, &origin)
 	node.Options[common.OptionSerialNo] = fmt.Sprintf("%d", info.UniqueID)
 	return info, r.globalScope, nil
 }
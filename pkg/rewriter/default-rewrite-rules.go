@@ -59,7 +59,7 @@ passes:
           self:
             name: operator
             key: name
-            value.regexp: "[-+*/<>]|==|<=|>=|\\.\\.[<=]"
+            value.builtin: operator
             count: 2
         action:
           replaceName:
@@ -123,6 +123,17 @@ passes:
           replaceName:
             with: update
 
+      - name: Change 'in' to in (for-loop query)
+        match:
+          self:
+            name: operator
+            key: name
+            value: "in"
+            count: 2
+        action:
+          replaceName:
+            with: in
+
       - name: Fuse let parts
         match:
           self:
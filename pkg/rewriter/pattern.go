@@ -5,21 +5,24 @@ import (
 	"os"
 	"regexp"
 
+	"github.com/spicery/nutmeg-compiler/pkg/builtins"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
 	"gopkg.in/yaml.v3"
 )
 
 type NodePattern struct {
-	Name              *string        `yaml:"name,omitempty"`
-	NameRegexpString  *string        `yaml:"name.regexp,omitempty"`
-	NameRegexp        *regexp.Regexp `yaml:"-"` // Compiled regexp, not marshaled.
-	Key               *string        `yaml:"key,omitempty"`
-	Value             *string        `yaml:"value,omitempty"`
-	ValueRegexpString *string        `yaml:"value.regexp,omitempty"`
-	ValueRegexp       *regexp.Regexp `yaml:"-"` // Compiled regexp, not marshaled.
-	Cmp               *bool          `yaml:"cmp,omitempty"`
-	Count             *int           `yaml:"count,omitempty"`
-	SiblingPosition   *int           `yaml:"siblingPosition,omitempty"`
+	Name              *string         `yaml:"name,omitempty"`
+	NameRegexpString  *string         `yaml:"name.regexp,omitempty"`
+	NameRegexp        *regexp.Regexp  `yaml:"-"` // Compiled regexp, not marshaled.
+	Key               *string         `yaml:"key,omitempty"`
+	Value             *string         `yaml:"value,omitempty"`
+	ValueRegexpString *string         `yaml:"value.regexp,omitempty"`
+	ValueRegexp       *regexp.Regexp  `yaml:"-"` // Compiled regexp, not marshaled.
+	ValueBuiltin      *string         `yaml:"value.builtin,omitempty"`
+	ValueBuiltinNames map[string]bool `yaml:"-"` // Names of builtins of that kind, set by UseBuiltins.
+	Cmp               *bool           `yaml:"cmp,omitempty"`
+	Count             *int            `yaml:"count,omitempty"`
+	SiblingPosition   *int            `yaml:"siblingPosition,omitempty"`
 }
 
 // UnmarshalYAML implements custom YAML unmarshaling with validation.
@@ -97,6 +100,9 @@ func (np *NodePattern) Matches(node *common.Node, path *common.Path) bool {
 				return false
 			}
 		}
+		if np.ValueBuiltin != nil && np.ValueBuiltinNames[val] != np.GetCmp() {
+			return false
+		}
 	}
 	if np.Count != nil && len(node.Children) != *np.Count {
 		return false
@@ -166,6 +172,24 @@ func (p *Pattern) Matches(node *common.Node, path *common.Path) (bool, int) {
 	return true, childPosition
 }
 
+// UseBuiltins looks up the names of the builtins needed by any value.builtin
+// conditions of the pattern.
+func (p *Pattern) UseBuiltins(registry *builtins.Registry) error {
+	for _, np := range []*NodePattern{p.Parent, p.Self, p.Child, p.PreviousChild, p.NextChild} {
+		if np == nil || np.ValueBuiltin == nil {
+			continue
+		}
+		kind := builtins.Kind(*np.ValueBuiltin)
+		switch kind {
+		case builtins.FunctionKind, builtins.OperatorKind, builtins.InternalKind:
+			np.ValueBuiltinNames = registry.Names(kind)
+		default:
+			return fmt.Errorf("unknown kind of builtin in 'value.builtin': %s", kind)
+		}
+	}
+	return nil
+}
+
 func (p *Pattern) Validate(name string) error {
 	if p == nil {
 		return fmt.Errorf("pattern is nil")
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/spicery/nutmeg-compiler/pkg/builtins"
 	"github.com/spicery/nutmeg-compiler/pkg/common"
 )
 
@@ -46,6 +47,12 @@ func NewRewriterWithDebug(rewriteConfig *RewriteConfig, debug bool) (*Rewriter,
 
 // NewRewriterWithOptions creates a new Rewriter instance with optional debug output and skip-optional flag.
 func NewRewriterWithOptions(rewriteConfig *RewriteConfig, debug bool, skipOptional bool) (*Rewriter, error) {
+	return NewRewriterWithBuiltins(rewriteConfig, debug, skipOptional, builtins.Default())
+}
+
+// NewRewriterWithBuiltins creates a new Rewriter instance whose value.builtin
+// conditions consult the given registry of builtins.
+func NewRewriterWithBuiltins(rewriteConfig *RewriteConfig, debug bool, skipOptional bool, registry *builtins.Registry) (*Rewriter, error) {
 	rewriter := &Rewriter{
 		Name:   rewriteConfig.Name,
 		Passes: []RewriterPass{},
@@ -73,6 +80,9 @@ func NewRewriterWithOptions(rewriteConfig *RewriteConfig, debug bool, skipOption
 			if e := down.Match.Validate(down.Name); e != nil {
 				return nil, fmt.Errorf("error in downwards rule \"%s/%s\": %w", passConfig.Name, down.Name, e)
 			}
+			if e := down.Match.UseBuiltins(registry); e != nil {
+				return nil, fmt.Errorf("error in downwards rule \"%s/%s\": %w", passConfig.Name, down.Name, e)
+			}
 			downAction, err := down.Action.ToAction()
 			if err != nil {
 				return nil, fmt.Errorf("error in downwards rule \"%s/%s\": %w", passConfig.Name, down.Name, err)
@@ -113,6 +123,9 @@ func NewRewriterWithOptions(rewriteConfig *RewriteConfig, debug bool, skipOption
 			if e := up.Match.Validate(up.Name); e != nil {
 				return nil, fmt.Errorf("error in upwards rule %s: %w", passConfig.Name, e)
 			}
+			if e := up.Match.UseBuiltins(registry); e != nil {
+				return nil, fmt.Errorf("error in upwards rule %s: %w", passConfig.Name, e)
+			}
 			upAction, err := up.Action.ToAction()
 			if err != nil {
 				return nil, fmt.Errorf("error in upwards rule %s: %w", passConfig.Name, err)
@@ -1,7 +1,6 @@
 package tokenizer
 
 import (
-	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -53,14 +52,14 @@ func (t *Tokenizer) matchRawString() (*common.Token, error) {
 			return token, terr
 		}
 		if token.Specifier != nil && tagText != "" && *token.Specifier != tagText {
-			return nil, fmt.Errorf("tag specifier '%s' does not match existing specifier '%s' at line %d, column %d", tagText, *token.Specifier, t.line, t.column)
+			return nil, common.SpanErrorf(at(t.line, t.column), "tag specifier '%s' does not match existing specifier '%s'", tagText, *token.Specifier)
 		}
 		if tagText != "" {
 			token.Specifier = &tagText
 		}
 		return token, nil
 	} else {
-		return nil, fmt.Errorf("expected string after @ at line %d, column %d", t.line, t.column)
+		return nil, common.SpanErrorf(at(t.line, t.column), "expected string after @")
 	}
 }
 
@@ -94,7 +93,7 @@ func (t *Tokenizer) readString(unquoted bool, default_quote rune) (*common.Token
 
 	for {
 		if !t.hasMoreInput() {
-			return nil, fmt.Errorf("unterminated string at line %d, column %d", startLine, startCol)
+			return nil, common.SpanErrorf(at(startLine, startCol), "unterminated string")
 		}
 		beforeBackSlash := Position{t.line, t.column}
 		r := t.consume()
@@ -132,7 +131,7 @@ func (t *Tokenizer) readString(unquoted bool, default_quote rune) (*common.Token
 				}
 				break
 			}
-			return nil, fmt.Errorf("line break in string, at line %d, column %d", startLine, startCol)
+			return nil, common.SpanErrorf(at(startLine, startCol), "line break in string")
 		} else {
 			value.WriteRune(r)
 		}
@@ -180,7 +179,7 @@ func (t *Tokenizer) readStringInterpolation() (*common.Token, error) {
 
 	for {
 		if !t.hasMoreInput() {
-			return nil, fmt.Errorf("unterminated interpolation, at line %d, Column: %d", span.StartLine, span.StartColumn)
+			return nil, common.SpanErrorf(at(span.StartLine, span.StartColumn), "unterminated interpolation")
 		}
 		r := t.consume()
 		switch state {
@@ -200,13 +199,13 @@ func (t *Tokenizer) readStringInterpolation() (*common.Token, error) {
 						return token, nil
 					}
 				} else {
-					return nil, fmt.Errorf("mismatched bracket, at line %d, Column: %d", span.StartLine, span.StartColumn)
+					return nil, common.SpanErrorf(at(span.StartLine, span.StartColumn), "mismatched bracket")
 				}
 			case '"', '\'', '`', '«': // Enter string state
 				stack = append(stack, getMatchingCloseQuote(r))
 				state = 1
 			case 'r', '\n': // Line breaks are not allowed
-				return nil, fmt.Errorf("line break in interpolation, at line %d, Column: %d", t.line, t.column)
+				return nil, common.SpanErrorf(at(t.line, t.column), "line break in interpolation")
 			}
 		case 1: // Inside string
 			switch r {
@@ -222,7 +221,7 @@ func (t *Tokenizer) readStringInterpolation() (*common.Token, error) {
 						handleEscapeSequence(t)
 					}
 				} else {
-					return nil, fmt.Errorf("unterminated escape sequence, at line %d, Column: %d", span.StartLine, span.StartColumn)
+					return nil, common.SpanErrorf(at(span.StartLine, span.StartColumn), "unterminated escape sequence")
 				}
 			case stack[len(stack)-1]: // Matching closing quote
 				stack = stack[:len(stack)-1] // Pop stack
@@ -328,8 +327,10 @@ func (t *Tokenizer) readMultilineString(rawFlag bool) (*common.Token, error) {
 				}
 			}
 		} else {
+			// A blank line, which findClosingIndent has checked is empty.
 			tok = common.NewStringToken("", "", common.Span{StartLine: t.line, StartColumn: t.column, EndLine: t.line, EndColumn: t.column})
 			tok.SetQuote(openingQuote)
+			t.readRestOfLine()
 		}
 		subTokens = append(subTokens, tok)
 	}
@@ -359,7 +360,7 @@ func (t *Tokenizer) findClosingIndent() (rune, string, string, int, error) {
 	// Validate and consume the opening triple quotes
 	opening_quote, ok := t.tryReadTripleOpeningQuotes()
 	if !ok {
-		return 0, "", "", 0, fmt.Errorf("malformed opening triple quotes at line %d, column %d", t.line, t.column)
+		return 0, "", "", 0, common.SpanErrorf(at(t.line, t.column), "malformed opening triple quotes")
 	}
 	closing_quote := getMatchingCloseQuote(opening_quote) // Get the matching closing quote
 
@@ -384,7 +385,7 @@ func (t *Tokenizer) findClosingIndent() (rune, string, string, int, error) {
 	}
 
 	if !match {
-		return 0, "", "", 0, fmt.Errorf("closing triple quote not found at line %d, column %d", t.line, t.column)
+		return 0, "", "", 0, common.SpanErrorf(at(t.line, t.column), "closing triple quote not found")
 	}
 
 	for i, line := range lines {
@@ -394,7 +395,7 @@ func (t *Tokenizer) findClosingIndent() (rune, string, string, int, error) {
 		}
 		// Check if the line starts with the closing indent
 		if !strings.HasPrefix(line, closingIndent) {
-			return 0, "", "", 0, fmt.Errorf("not indented consistently with the closing triple quote at line %d, column %d", startLine+i, startCol)
+			return 0, "", "", 0, common.SpanErrorf(at(startLine+i, startCol), "not indented consistently with the closing triple quote")
 		}
 	}
 
@@ -426,14 +427,14 @@ func (t *Tokenizer) readSpecifier() (string, error) {
 	}
 	strtext := strings.TrimSpace(text.String())
 	if strings.Contains(strtext, " ") {
-		return "", fmt.Errorf("spaces inside code-fence specifier at line %d, column %d", t.line, t.column)
+		return "", common.SpanErrorf(at(t.line, t.column), "spaces inside code-fence specifier")
 	}
 	//  Check the specifier matches the regex ^\w*$. This reserves wriggle room
 	//  for future expansion.
 	if len(strtext) > 0 {
 		m, e := regexp.MatchString(`^[a-zA-Z_]\w*$`, strtext)
 		if !m || e != nil {
-			return "", fmt.Errorf("invalid code-fence specifier at line %d, column %d", t.line, t.column)
+			return "", common.SpanErrorf(at(t.line, t.column), "invalid code-fence specifier")
 		}
 	}
 	return strtext, nil
@@ -450,7 +451,7 @@ func (t *Tokenizer) readRawString(unquoted bool, default_quote rune) (*common.To
 
 	for {
 		if !t.hasMoreInput() {
-			return nil, fmt.Errorf("unterminated raw string at line %d, column %d", startLine, startCol)
+			return nil, common.SpanErrorf(at(startLine, startCol), "unterminated raw string")
 		}
 		r := t.consume()
 		if r == quote { // Closing quote found
@@ -462,7 +463,7 @@ func (t *Tokenizer) readRawString(unquoted bool, default_quote rune) (*common.To
 				}
 				break
 			}
-			return nil, fmt.Errorf("line break in raw string at line %d, column %d", startLine, startCol)
+			return nil, common.SpanErrorf(at(startLine, startCol), "line break in raw string")
 		}
 		// Backslashes are treated as normal characters in raw strings
 		text.WriteRune(r)
@@ -239,13 +239,18 @@ func getDefaultOperatorPrecedences() map[string][3]int {
 	updateOperatorPrecedence(m, "<=")
 	updateOperatorPrecedence(m, ">=")
 	updateOperatorPrecedence(m, "==")
-	updateOperatorPrecedence(m, "..<")
-	updateOperatorPrecedence(m, "..=")
+	updateOperatorPrecedence(m, "=")
 	updateOperatorPrecedence(m, "=>")
 	updateOperatorPrecedence(m, ":=")
 	updateOperatorPrecedence(m, "<-")
 	updateOperatorPrecedence(m, "<--")
-	m["in"] = [3]int{0, 3000, 0}
+	// Ranges bind more loosely than arithmetic but more tightly than
+	// comparisons, so that `0 ..< n + 1` means `0 ..< (n + 1)`.
+	m["..<"] = [3]int{0, 3095, 0}
+	m["..="] = [3]int{0, 3095, 0}
+	// The `in` of a for-loop is looser than every other infix operator, so
+	// that `for x in E` takes all of E.
+	m["in"] = [3]int{0, 3200, 0}
 	return m
 }
 
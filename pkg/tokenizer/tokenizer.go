@@ -30,11 +30,14 @@ type Tokenizer struct {
 	tokens         []*common.Token
 	expectingStack [][]string      // Stack of expecting arrays for context tracking
 	rules          *TokenizerRules // Custom rules for this tokenizer instance
+	trivia         bool            // Whether to attach trivia to the tokens
+	leading        []common.Trivia // Trivia read since the last token, which leads the next
 }
 
 // Regular expressions for token matching
 var (
 	identifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
+	qualifiedRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(?:::[a-zA-Z_][a-zA-Z0-9_]*)+`)
 	operatorRegex   = regexp.MustCompile(`^[.\*/%\+\-<>~!&^|?=:]+`)
 	radixRegex      = regexp.MustCompile(`^(\d+[xobtr])([0-9A-Z]+(?:_[0-9A-Z]+)*)(\.[0-9A-Z]*(?:_[0-9A-Z]+)*)?(?:e([+-]?\d+))?`)
 	decimalRegex    = regexp.MustCompile(`^(\d+(?:_\d+)*)(\.\d*(?:_\d+)*)?(?:e([+-]?\d+))?`)
@@ -135,14 +138,16 @@ func (t *Tokenizer) getCurrentlyExpected() []string {
 
 // addTokenAndManageStack adds a token to the tokens slice and manages the expecting stack.
 func (t *Tokenizer) addTokenAndManageStack(token *common.Token) error {
+	token.LeadingTrivia, t.leading = t.leading, nil
+
 	// Check if numeric token is valid before adding it
 	if token.Type == common.NumericLiteralTokenType {
 		if valid, reason := token.IsValidNumber(); !valid {
 			// Replace the token with an exception token
 			exceptionToken := common.NewExceptionToken(token.Text, "invalid numeric literal: "+reason, token.Span)
+			exceptionToken.LeadingTrivia = token.LeadingTrivia
 			t.tokens = append(t.tokens, exceptionToken)
-			return fmt.Errorf("tokenisation error at line %d, column %d: %s",
-				exceptionToken.Span.StartLine, exceptionToken.Span.StartColumn, *exceptionToken.Reason)
+			return common.SpanErrorf(exceptionToken.Span, "tokenisation error: %s", *exceptionToken.Reason)
 		}
 	}
 
@@ -162,8 +167,7 @@ func (t *Tokenizer) addTokenAndManageStack(token *common.Token) error {
 
 	// If this is an exception token, stop processing
 	if token.Type == common.ExceptionTokenType {
-		return fmt.Errorf("tokenisation error at line %d, column %d: %s",
-			token.Span.StartLine, token.Span.StartColumn, *token.Reason)
+		return common.SpanErrorf(token.Span, "tokenisation error: %s", *token.Reason)
 	}
 
 	// Manage the expecting stack based on token type and text
@@ -192,20 +196,29 @@ func (t *Tokenizer) addTokenAndManageStack(token *common.Token) error {
 	return nil
 }
 
-// Tokenize processes the input and returns the list of tokens.
+// Tokenize processes the input and returns the list of tokens. If trivia is
+// enabled then the list ends with an end-of-input token, whose leading
+// trivia is whatever follows the line of the last token.
 func (t *Tokenizer) Tokenize() ([]*common.Token, error) {
 	for t.position < len(t.input) {
 		if err := t.nextToken(); err != nil {
 			return t.tokens, err
 		}
 	}
+	if t.trivia {
+		t.tokens = append(t.tokens, t.endOfInput())
+	}
 	return t.tokens, nil
 }
 
 // nextToken processes the next token from the input.
 func (t *Tokenizer) nextToken() error {
 	// Skip whitespace and comments, tracking if we saw a newline
+	skipped := t.position
 	sawNewlineBefore := t.skipWhitespaceAndComments()
+	if t.trivia {
+		t.takeTrivia(t.input[skipped:t.position])
+	}
 
 	if t.position >= len(t.input) {
 		return nil
@@ -570,11 +583,15 @@ func (t *Tokenizer) lookupText(entry *CustomRuleEntry, span common.Span, text st
 }
 
 // nextIdOrOp is a helper function that attempts to match an identifier or operator token.
-// It returns three values:
+// A module-qualified identifier such as `std::println` is matched as a single
+// identifier. It returns three values:
 // - A boolean indicating if the matched token is an identifier.
 // - The matched text.
 // - A boolean indicating if a match was found.
 func nextIdOrOp(t *Tokenizer) (bool, string, bool) {
+	if match := qualifiedRegex.FindString(t.input[t.position:]); match != "" {
+		return true, match, true
+	}
 	if match := identifierRegex.FindString(t.input[t.position:]); match != "" {
 		text := match
 		return true, text, true
@@ -863,13 +880,18 @@ func (t *Tokenizer) skipSpacesUpToNewline() {
 	}
 }
 
+// at returns the span of a single position, for errors about it.
+func at(line, column int) common.Span {
+	return common.Span{StartLine: line, StartColumn: column, EndLine: line, EndColumn: column}
+}
+
 func (t *Tokenizer) consumeTripleClosingQuotes(quote rune) error {
 	r, b := t.tryReadTripleClosingQuotes()
 	if !b {
-		return fmt.Errorf("missing triple quotes at line %d, column %d", t.line, t.column)
+		return common.SpanErrorf(at(t.line, t.column), "missing triple quotes")
 	}
 	if r != quote {
-		return fmt.Errorf("expected %c, but found %c at line %d, column %d", quote, r, t.line, t.column)
+		return common.SpanErrorf(at(t.line, t.column), "expected %c, but found %c", quote, r)
 	}
 	return nil
 }
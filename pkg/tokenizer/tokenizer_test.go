@@ -3,6 +3,8 @@ package tokenizer
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/spicery/nutmeg-compiler/pkg/common"
@@ -90,6 +92,27 @@ func TestStringTokens(t *testing.T) {
 	}
 }
 
+func TestMultilineStringWithBlankLine(t *testing.T) {
+	input := "\"\"\"\n  a\n\n  b\n  \"\"\"\n"
+	tokens, err := NewTokenizer(input).Tokenize()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tokens) != 1 {
+		t.Fatalf("Expected 1 token, got %d", len(tokens))
+	}
+	lines := tokens[0].Subtokens
+	expected := []string{"a", "", "b"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		if line.Value == nil || *line.Value != expected[i] {
+			t.Errorf("Line %d: expected %q, got %v", i, expected[i], line.Value)
+		}
+	}
+}
+
 func TestNumericTokens(t *testing.T) {
 	// Helper function to create int pointers
 	intPtr := func(i int) *int { return &i }
@@ -586,6 +609,7 @@ func TestOperatorTokens(t *testing.T) {
 		{"-", [3]int{1090, 3090, 0}}, // - has base precedence 50, both prefix (50) and infix (50+2000=2050) enabled
 		{"*", [3]int{0, 3050, 0}},    // * has base precedence 10, only infix enabled (10+2000=2010)
 		{"==", [3]int{0, 3179, 0}},   // = has base precedence 140, repeated so 139, only infix enabled (139+2000=2139)
+		{"=", [3]int{0, 3180, 0}},    // = only named annotation arguments, so just infix
 	}
 
 	for _, tt := range tests {
@@ -709,6 +733,8 @@ func TestKeywordClassification(t *testing.T) {
 		// Variable tokens (V) - should default to this for unknown identifiers
 		{"myVariable", common.VariableTokenType},
 		{"unknown", common.VariableTokenType},
+		{"std::println", common.VariableTokenType},
+		{"parser::advanced::readExpr", common.VariableTokenType},
 	}
 
 	for _, tt := range tests {
@@ -1189,6 +1215,112 @@ func TestNewlineJSONSerialization(t *testing.T) {
 	}
 }
 
+func TestDetokenizeRoundTrip(t *testing.T) {
+	inputs := []string{
+		"",
+		"   ",
+		"### only a comment",
+		"\r\n\r\n",
+		"x",
+		"a ### one\r\n\tb\n\n### two\n",
+		"def f(x) =>>\n    x + 1 ### add\nenddef\n\n",
+		"s := \"a\\nb\" + 'c'\u00a0+ 0x1F\n",
+		"```\n  line one\n\n  line two\n  ```\n",
+	}
+	snippets, err := filepath.Glob("../../snippets/*.nutmeg")
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	for _, snippet := range snippets {
+		data, err := os.ReadFile(snippet)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		inputs = append(inputs, string(data))
+	}
+
+	for _, input := range inputs {
+		tokenizer := NewTokenizer(input)
+		tokenizer.EnableTrivia()
+		tokens, err := tokenizer.Tokenize()
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", input, err)
+			continue
+		}
+		if len(tokens) == 0 || tokens[len(tokens)-1].Type != common.EndOfInputTokenType {
+			t.Errorf("Expected the tokens of %q to end with an end-of-input token", input)
+			continue
+		}
+		if output := Detokenize(tokens); output != input {
+			t.Errorf("Expected Detokenize to give %q, got %q", input, output)
+		}
+	}
+}
+
+func TestTriviaAttachment(t *testing.T) {
+	tokenizer := NewTokenizer("### head\nx := 1   ### one\n\n  y\r\n")
+	tokenizer.EnableTrivia()
+	tokens, err := tokenizer.Tokenize()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	whitespace := func(text string) common.Trivia { return common.Trivia{Kind: common.WhitespaceTrivia, Text: text} }
+	newline := func(text string) common.Trivia { return common.Trivia{Kind: common.NewlineTrivia, Text: text} }
+	comment := func(text string) common.Trivia { return common.Trivia{Kind: common.CommentTrivia, Text: text} }
+	expected := []struct {
+		text     string
+		leading  []common.Trivia
+		trailing []common.Trivia
+	}{
+		{"x", []common.Trivia{comment("### head"), newline("\n")}, []common.Trivia{whitespace(" ")}},
+		{":=", nil, []common.Trivia{whitespace(" ")}},
+		{"1", nil, []common.Trivia{whitespace("   "), comment("### one")}},
+		{"y", []common.Trivia{newline("\n"), newline("\n"), whitespace("  ")}, nil},
+		{"", []common.Trivia{newline("\r\n")}, nil},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, token := range tokens {
+		if token.Text != expected[i].text {
+			t.Errorf("Token %d: expected %q, got %q", i, expected[i].text, token.Text)
+		}
+		if !slices.Equal(token.LeadingTrivia, expected[i].leading) {
+			t.Errorf("Token %d: expected leading trivia %v, got %v", i, expected[i].leading, token.LeadingTrivia)
+		}
+		if !slices.Equal(token.TrailingTrivia, expected[i].trailing) {
+			t.Errorf("Token %d: expected trailing trivia %v, got %v", i, expected[i].trailing, token.TrailingTrivia)
+		}
+	}
+
+	// Without trivia the tokens are as before.
+	tokens, err = NewTokenizer("x ### one\n").Tokenize()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].TrailingTrivia != nil {
+		t.Errorf("Expected a single token without trivia, got %+v", tokens)
+	}
+}
+
+func TestTriviaJSONSerialization(t *testing.T) {
+	tokenizer := NewTokenizer("a ### c")
+	tokenizer.EnableTrivia()
+	tokens, err := tokenizer.Tokenize()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	jsonBytes, err := json.Marshal(tokens[0])
+	if err != nil {
+		t.Fatalf("Failed to marshal token to JSON: %v", err)
+	}
+	expected := `{"text":"a","span":[1,1,1,2],"type":"V","ln_after":true,"trailing_trivia":[{"kind":"whitespace","text":" "},{"kind":"comment","text":"### c"}]}`
+	if string(jsonBytes) != expected {
+		t.Errorf("Expected %s, got %s", expected, jsonBytes)
+	}
+}
+
 // Helper function to create bool pointers for test expectations
 func boolPtr(b bool) *bool {
 	return &b
@@ -0,0 +1,96 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spicery/nutmeg-compiler/pkg/common"
+)
+
+// EnableTrivia makes the tokenizer keep the whitespace and comments that it
+// skips, attaching them to the tokens as trivia so that Detokenize can
+// reproduce the input exactly. A token's trailing trivia runs to the end of
+// its line and the next token's leading trivia starts with the line break.
+func (t *Tokenizer) EnableTrivia() {
+	t.trivia = true
+}
+
+// takeTrivia shares out the text skipped before a token: the part on the
+// line of the previous token trails it and the rest leads the next token.
+func (t *Tokenizer) takeTrivia(text string) {
+	trivia := splitTrivia(text)
+	if len(t.tokens) > 0 {
+		previous := t.tokens[len(t.tokens)-1]
+		i := 0
+		for i < len(trivia) && trivia[i].Kind != common.NewlineTrivia {
+			i++
+		}
+		previous.TrailingTrivia = append(previous.TrailingTrivia, trivia[:i]...)
+		trivia = trivia[i:]
+	}
+	t.leading = append(t.leading, trivia...)
+}
+
+// endOfInput returns the token that marks the end of the input, which holds
+// the trivia after the last line of tokens.
+func (t *Tokenizer) endOfInput() *common.Token {
+	span := common.Span{StartLine: t.line, StartColumn: t.column, EndLine: t.line, EndColumn: t.column}
+	token := common.NewToken("", common.EndOfInputTokenType, span)
+	token.LeadingTrivia, t.leading = t.leading, nil
+	return token
+}
+
+// splitTrivia splits text that holds only whitespace and comments into
+// pieces of trivia.
+func splitTrivia(text string) []common.Trivia {
+	var trivia []common.Trivia
+	for len(text) > 0 {
+		var kind common.TriviaKind
+		var n int
+		switch {
+		case strings.HasPrefix(text, "\r\n"):
+			kind, n = common.NewlineTrivia, 2
+		case text[0] == '\n' || text[0] == '\r':
+			kind, n = common.NewlineTrivia, 1
+		case strings.HasPrefix(text, "###"):
+			kind, n = common.CommentTrivia, len(text)
+			if i := strings.IndexAny(text, "\r\n"); i >= 0 {
+				n = i
+			}
+		default:
+			kind = common.WhitespaceTrivia
+			for n < len(text) && text[n] != '\n' && text[n] != '\r' {
+				r, size := utf8.DecodeRuneInString(text[n:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				n += size
+			}
+			// The text holds nothing else, but a stray character is kept
+			// rather than looping forever.
+			if n == 0 {
+				_, n = utf8.DecodeRuneInString(text)
+			}
+		}
+		trivia = append(trivia, common.Trivia{Kind: kind, Text: text[:n]})
+		text = text[n:]
+	}
+	return trivia
+}
+
+// Detokenize reproduces the source of tokens that were read with trivia
+// enabled, byte for byte.
+func Detokenize(tokens []*common.Token) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		for _, trivia := range token.LeadingTrivia {
+			b.WriteString(trivia.Text)
+		}
+		b.WriteString(token.Text)
+		for _, trivia := range token.TrailingTrivia {
+			b.WriteString(trivia.Text)
+		}
+	}
+	return b.String()
+}
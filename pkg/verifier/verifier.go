@@ -0,0 +1,390 @@
+// Package verifier checks that the function objects stored in a bundle are
+// well formed, so that mistakes in codegen or the bundler are caught before
+// the runtime trips over them.
+package verifier
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+)
+
+// Violation is a single problem found in a function object. Instruction is
+// the index of the offending instruction, or -1 if the problem concerns the
+// function object as a whole.
+type Violation struct {
+	Binding     string
+	Instruction int
+	Message     string
+}
+
+func (v Violation) String() string {
+	if v.Instruction < 0 {
+		return fmt.Sprintf("binding %s: %s", v.Binding, v.Message)
+	}
+	return fmt.Sprintf("binding %s, instruction %d: %s", v.Binding, v.Instruction, v.Message)
+}
+
+// VerifyBindings verifies the function object of every binding, returning
+// the violations ordered by binding name and then instruction index.
+func VerifyBindings(bindings []bundler.Binding) []Violation {
+	violations := make([]Violation, 0)
+	for _, binding := range bindings {
+		funcObj, err := bundler.DecodeFunctionObject(binding.Value)
+		if err != nil {
+			violations = append(violations, Violation{Binding: binding.IdName, Instruction: -1, Message: err.Error()})
+			continue
+		}
+		violations = append(violations, VerifyFunctionObject(binding.IdName, funcObj)...)
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Binding != violations[j].Binding {
+			return violations[i].Binding < violations[j].Binding
+		}
+		return violations[i].Instruction < violations[j].Instruction
+	})
+	return violations
+}
+
+// VerifyFunctionObject checks a single function object, which may be either
+// symbolic or assembled. It checks that:
+//
+//   - every instruction is known and has the operands it needs,
+//   - every jump goes to a label or index that exists,
+//   - every local is written before it is read, and the locals used by
+//     counted instructions were written by stack.length,
+//   - control cannot run off the end without a return, and
+//   - every in.progress is matched by a done for the same global before the
+//     function returns.
+func VerifyFunctionObject(name string, funcObj *bundler.FunctionObject) []Violation {
+	v := &verification{
+		name:       name,
+		funcObj:    funcObj,
+		violations: make([]Violation, 0),
+	}
+	v.check()
+	return v.violations
+}
+
+// slotState is what is known about a local variable at some point in the
+// function, on every path that reaches it.
+type slotState int
+
+const (
+	unset       slotState = iota // Not written on at least one path.
+	value                        // Holds an ordinary value.
+	stackLength                  // Holds a length recorded by stack.length.
+	written                      // Written, but with different kinds on different paths.
+)
+
+// meetSlot combines what is known about a local on two paths.
+func meetSlot(a, b slotState) slotState {
+	switch {
+	case a == b:
+		return a
+	case a == unset || b == unset:
+		return unset
+	default:
+		return written
+	}
+}
+
+// flowState is the state of the locals and of lazy initialisation on entry
+// to an instruction. inProgress names the global that is being initialised,
+// if any, and conflict records that paths disagree about it.
+type flowState struct {
+	slots      []slotState
+	inProgress string
+	conflict   bool
+}
+
+func (s *flowState) copy() *flowState {
+	c := *s
+	c.slots = append([]slotState{}, s.slots...)
+	return &c
+}
+
+// meet merges other into s, reporting whether s changed.
+func (s *flowState) meet(other *flowState) bool {
+	changed := false
+	for i := range s.slots {
+		if m := meetSlot(s.slots[i], other.slots[i]); m != s.slots[i] {
+			s.slots[i] = m
+			changed = true
+		}
+	}
+	if !s.conflict && (other.conflict || s.inProgress != other.inProgress) {
+		s.conflict = true
+		changed = true
+	}
+	return changed
+}
+
+type verification struct {
+	name       string
+	funcObj    *bundler.FunctionObject
+	labels     map[string]int // Positions of the labels of a symbolic function.
+	violations []Violation
+}
+
+func (v *verification) report(i int, format string, args ...any) {
+	v.violations = append(v.violations, Violation{Binding: v.name, Instruction: i, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *verification) check() {
+	obj := v.funcObj
+	if obj.Version != bundler.SymbolicVersion && obj.Version != bundler.AssembledVersion {
+		v.report(-1, "unsupported function object version %d", obj.Version)
+		return
+	}
+	if obj.NParams < 0 || obj.NLocals < obj.NParams {
+		v.report(-1, "%d parameters do not fit in %d locals", obj.NParams, obj.NLocals)
+		return
+	}
+	if !obj.IsAssembled() {
+		v.collectLabels()
+	}
+
+	// Operand errors are reported once here, and instructions with bad
+	// operands are then skipped by the dataflow analysis.
+	ok := make([]bool, len(obj.Instructions))
+	for i, inst := range obj.Instructions {
+		if err := v.checkOperands(inst); err != nil {
+			v.report(i, "%s: %v", inst.Type, err)
+			continue
+		}
+		ok[i] = true
+	}
+
+	states := v.analyse(ok)
+	for i, inst := range obj.Instructions {
+		if states[i] != nil && ok[i] {
+			v.checkFlow(i, inst, states[i])
+		}
+	}
+}
+
+// collectLabels records where each label of a symbolic function object is,
+// reporting labels that are defined more than once.
+func (v *verification) collectLabels() {
+	v.labels = make(map[string]int)
+	for i, inst := range v.funcObj.Instructions {
+		if inst.Type != "label" || inst.StrValue == nil {
+			continue
+		}
+		if _, exists := v.labels[*inst.StrValue]; exists {
+			v.report(i, "duplicate label %s", *inst.StrValue)
+			continue
+		}
+		v.labels[*inst.StrValue] = i
+	}
+}
+
+// checkOperands checks that an instruction is known and that it has the
+// operands it needs, which are within range.
+func (v *verification) checkOperands(inst bundler.Instruction) error {
+	nlocals := v.funcObj.NLocals
+	needIndex := func() error {
+		if inst.Index == nil {
+			return fmt.Errorf("missing index")
+		}
+		if *inst.Index < 0 || *inst.Index >= nlocals {
+			return fmt.Errorf("index %d out of range for %d locals", *inst.Index, nlocals)
+		}
+		return nil
+	}
+	needName := func() error {
+		if inst.Name == nil {
+			return fmt.Errorf("missing name")
+		}
+		return nil
+	}
+	needCount := func() error {
+		if inst.IntValue == nil {
+			return fmt.Errorf("missing ivalue")
+		}
+		if *inst.IntValue < 0 {
+			return fmt.Errorf("negative argument count %d", *inst.IntValue)
+		}
+		return nil
+	}
+	needTarget := func(label *string, target *int) error {
+		if !v.funcObj.IsAssembled() {
+			if label == nil {
+				return fmt.Errorf("missing label")
+			}
+			if _, ok := v.labels[*label]; !ok {
+				return fmt.Errorf("undefined label %s", *label)
+			}
+			return nil
+		}
+		if target == nil {
+			return fmt.Errorf("missing target")
+		}
+		if *target < 0 || *target > len(v.funcObj.Instructions) {
+			return fmt.Errorf("target %d out of range for %d instructions", *target, len(v.funcObj.Instructions))
+		}
+		return nil
+	}
+	switch inst.Type {
+	case "push.int":
+		if inst.IntValue == nil {
+			return fmt.Errorf("missing ivalue")
+		}
+	case "push.bool":
+		if inst.StrValue == nil || (*inst.StrValue != "true" && *inst.StrValue != "false") {
+			return fmt.Errorf("invalid boolean value")
+		}
+	case "push.string", "push.bigint", "push.rational", "push.float":
+		if inst.StrValue == nil {
+			return fmt.Errorf("missing value")
+		}
+	case "push.local", "pop.local", "stack.length", "check.bool", "check.one", "call.counted":
+		return needIndex()
+	case "push.global", "in.progress":
+		return needName()
+	case "syscall.counted", "call.global.counted", "done":
+		if err := needName(); err != nil {
+			return err
+		}
+		return needIndex()
+	case "syscall.fixed", "call.global.fixed":
+		if err := needName(); err != nil {
+			return err
+		}
+		return needCount()
+	case "goto", "if.not", "if.so":
+		return needTarget(inst.StrValue, inst.Target)
+	case "if.then.else":
+		if err := needTarget(inst.Name, inst.Target); err != nil {
+			return err
+		}
+		return needTarget(inst.StrValue, inst.ElseTarget)
+	case "label":
+		if v.funcObj.IsAssembled() {
+			return fmt.Errorf("label in an assembled function object")
+		}
+		if inst.StrValue == nil {
+			return fmt.Errorf("label without a name")
+		}
+	case "return", "erase", "if.so.return", "if.not.return":
+	default:
+		return fmt.Errorf("unknown instruction")
+	}
+	return nil
+}
+
+// successors returns the indices of the instructions that control can pass
+// to after instruction i. An index equal to the number of instructions means
+// that control runs off the end.
+func (v *verification) successors(i int, inst bundler.Instruction) []int {
+	target := func(label *string, index *int) int {
+		if v.funcObj.IsAssembled() {
+			return *index
+		}
+		return v.labels[*label]
+	}
+	switch inst.Type {
+	case "goto":
+		return []int{target(inst.StrValue, inst.Target)}
+	case "if.not", "if.so":
+		return []int{target(inst.StrValue, inst.Target), i + 1}
+	case "if.then.else":
+		return []int{target(inst.Name, inst.Target), target(inst.StrValue, inst.ElseTarget)}
+	case "return":
+		return nil
+	}
+	return []int{i + 1}
+}
+
+// analyse works out the state on entry to each instruction that can be
+// reached, by propagating states forward until nothing changes. Unreachable
+// instructions are left with a nil state.
+func (v *verification) analyse(ok []bool) []*flowState {
+	n := len(v.funcObj.Instructions)
+	// There is an extra state for running off the end.
+	states := make([]*flowState, n+1)
+	entry := &flowState{slots: make([]slotState, v.funcObj.NLocals)}
+	for i := 0; i < v.funcObj.NParams; i++ {
+		entry.slots[i] = value
+	}
+	states[0] = entry
+
+	// The instructions after which control runs off the end.
+	fallsOff := make(map[int]bool)
+	worklist := []int{0}
+	for len(worklist) > 0 {
+		i := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		if i == n || !ok[i] {
+			continue
+		}
+		inst := v.funcObj.Instructions[i]
+		out := transfer(inst, states[i])
+		for _, next := range v.successors(i, inst) {
+			if next == n {
+				fallsOff[i] = true
+			}
+			if states[next] == nil {
+				states[next] = out.copy()
+				worklist = append(worklist, next)
+			} else if states[next].meet(out) {
+				worklist = append(worklist, next)
+			}
+		}
+	}
+
+	for i := range fallsOff {
+		v.report(i, "control reaches the end of the function without a return")
+	}
+	return states[:n]
+}
+
+// transfer returns the state after executing inst in the given state.
+func transfer(inst bundler.Instruction, in *flowState) *flowState {
+	out := in.copy()
+	switch inst.Type {
+	case "pop.local":
+		out.slots[*inst.Index] = value
+	case "stack.length":
+		out.slots[*inst.Index] = stackLength
+	case "in.progress":
+		out.inProgress = *inst.Name
+	case "done":
+		out.inProgress = ""
+	}
+	return out
+}
+
+// checkFlow checks a reachable instruction against the state on entry to it.
+func (v *verification) checkFlow(i int, inst bundler.Instruction, in *flowState) {
+	if in.conflict {
+		v.report(i, "paths disagree about which global is in progress")
+		return
+	}
+	switch inst.Type {
+	case "push.local":
+		if in.slots[*inst.Index] == unset {
+			v.report(i, "local %d may be read before it is written", *inst.Index)
+		}
+	case "check.bool", "check.one", "syscall.counted", "call.global.counted", "call.counted", "done":
+		if in.slots[*inst.Index] != stackLength {
+			v.report(i, "local %d may not hold a length recorded by stack.length", *inst.Index)
+		}
+	}
+	switch inst.Type {
+	case "in.progress":
+		if in.inProgress != "" {
+			v.report(i, "in.progress %s while %s is still in progress", *inst.Name, in.inProgress)
+		}
+	case "done":
+		if in.inProgress != *inst.Name {
+			v.report(i, "done %s without a matching in.progress", *inst.Name)
+		}
+	case "return", "if.so.return", "if.not.return":
+		if in.inProgress != "" {
+			v.report(i, "return while %s is still in progress", in.inProgress)
+		}
+	}
+}
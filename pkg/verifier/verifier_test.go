@@ -0,0 +1,145 @@
+package verifier
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spicery/nutmeg-compiler/pkg/bundler"
+)
+
+func function(nparams int, nlocals int, instructions ...bundler.Instruction) *bundler.FunctionObject {
+	return &bundler.FunctionObject{NParams: nparams, NLocals: nlocals, Instructions: instructions}
+}
+
+// expectViolation checks that there is exactly one violation, at the given
+// instruction, whose message contains the given text.
+func expectViolation(t *testing.T, violations []Violation, instruction int, text string) {
+	t.Helper()
+	if len(violations) != 1 {
+		t.Fatalf("Expected 1 violation, got %d: %v", len(violations), violations)
+	}
+	if violations[0].Instruction != instruction || !strings.Contains(violations[0].Message, text) {
+		t.Errorf("Expected %q at instruction %d, got %s", text, instruction, violations[0])
+	}
+}
+
+func TestWellFormedFunctionsVerify(t *testing.T) {
+	// if x then 1 else 2 endif, in both forms.
+	symbolic := function(1, 1,
+		bundler.NewPushLocal(0),
+		bundler.NewIfNot("L0"),
+		bundler.NewPushInt(1),
+		bundler.NewGoto("L1"),
+		bundler.NewLabel("L0"),
+		bundler.NewPushInt(2),
+		bundler.NewLabel("L1"),
+		bundler.NewReturn(),
+	)
+	if violations := VerifyFunctionObject("f", symbolic); len(violations) != 0 {
+		t.Errorf("Expected no violations in the symbolic form, got %v", violations)
+	}
+	assembled, err := bundler.Assemble(symbolic)
+	if err != nil {
+		t.Fatalf("Assemble failed: %v", err)
+	}
+	if violations := VerifyFunctionObject("f", assembled); len(violations) != 0 {
+		t.Errorf("Expected no violations in the assembled form, got %v", violations)
+	}
+}
+
+func TestLocalOutOfRange(t *testing.T) {
+	violations := VerifyFunctionObject("f", function(0, 1, bundler.NewPushInt(1), bundler.NewPopLocal(1), bundler.NewReturn()))
+	expectViolation(t, violations, 1, "index 1 out of range for 1 locals")
+}
+
+func TestLocalReadBeforeWritten(t *testing.T) {
+	// The local is only written on one branch.
+	violations := VerifyFunctionObject("f", function(1, 2,
+		bundler.NewPushLocal(0),
+		bundler.NewIfNot("L0"),
+		bundler.NewPushInt(1),
+		bundler.NewPopLocal(1),
+		bundler.NewLabel("L0"),
+		bundler.NewPushLocal(1),
+		bundler.NewReturn(),
+	))
+	expectViolation(t, violations, 5, "local 1 may be read before it is written")
+}
+
+func TestCountedInstructionNeedsStackLength(t *testing.T) {
+	violations := VerifyFunctionObject("f", function(1, 1,
+		bundler.NewSyscallCounted("println", 0),
+		bundler.NewReturn(),
+	))
+	expectViolation(t, violations, 0, "may not hold a length recorded by stack.length")
+}
+
+func TestUndefinedLabel(t *testing.T) {
+	violations := VerifyFunctionObject("f", function(0, 0, bundler.NewGoto("L9"), bundler.NewReturn()))
+	expectViolation(t, violations, 0, "undefined label L9")
+}
+
+func TestTargetOutOfRange(t *testing.T) {
+	target := 7
+	jump := bundler.Instruction{Type: "goto", Target: &target}
+	funcObj := function(0, 0, jump, bundler.NewReturn())
+	funcObj.Version = bundler.AssembledVersion
+	expectViolation(t, VerifyFunctionObject("f", funcObj), 0, "target 7 out of range")
+}
+
+func TestMissingReturn(t *testing.T) {
+	violations := VerifyFunctionObject("f", function(0, 0, bundler.NewPushInt(1)))
+	expectViolation(t, violations, 0, "without a return")
+}
+
+func TestUnreachableCodeNeedNotReturn(t *testing.T) {
+	violations := VerifyFunctionObject("f", function(0, 0, bundler.NewReturn(), bundler.NewPushInt(1)))
+	if len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+}
+
+func TestInProgressPairedWithDone(t *testing.T) {
+	lazy := function(0, 1,
+		bundler.NewInProgress("x"),
+		bundler.NewStackLength(0),
+		bundler.NewPushInt(1),
+		bundler.NewDone("x", 0),
+		bundler.NewReturn(),
+	)
+	if violations := VerifyFunctionObject("x", lazy); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+
+	unfinished := function(0, 1, bundler.NewInProgress("x"), bundler.NewReturn())
+	expectViolation(t, VerifyFunctionObject("x", unfinished), 1, "return while x is still in progress")
+
+	unstarted := function(0, 1, bundler.NewStackLength(0), bundler.NewDone("x", 0), bundler.NewReturn())
+	expectViolation(t, VerifyFunctionObject("x", unstarted), 1, "done x without a matching in.progress")
+}
+
+func TestVerifyBindingsNamesTheBinding(t *testing.T) {
+	good, err := json.Marshal(function(0, 0, bundler.NewReturn()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := json.Marshal(function(0, 0, bundler.Instruction{Type: "frobnicate"}, bundler.NewReturn()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations := VerifyBindings([]bundler.Binding{
+		{IdName: "good", Value: string(good)},
+		{IdName: "bad", Value: string(bad)},
+		{IdName: "broken", Value: "{"},
+	})
+	if len(violations) != 2 {
+		t.Fatalf("Expected 2 violations, got %v", violations)
+	}
+	if got := violations[0].String(); got != "binding bad, instruction 0: frobnicate: unknown instruction" {
+		t.Errorf("Unexpected violation: %s", got)
+	}
+	if violations[1].Binding != "broken" || violations[1].Instruction != -1 {
+		t.Errorf("Expected the undecodable binding to be reported, got %s", violations[1])
+	}
+}